@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -43,6 +44,15 @@ func createTables() error {
 			group_name TEXT,
 			active INTEGER DEFAULT 1,
 			on_demand INTEGER DEFAULT 1,
+			slow_client_policy TEXT DEFAULT '',
+			transcode_profile TEXT DEFAULT '',
+			abr_ladder TEXT DEFAULT '',
+			priority INTEGER DEFAULT 0,
+			delivery_mode TEXT DEFAULT 'ffmpeg-remux',
+			health_status TEXT DEFAULT '',
+			health_checked_at DATETIME,
+			timeshift_hours INTEGER DEFAULT 0,
+			tvg_id TEXT DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
 		)`,
@@ -67,7 +77,8 @@ func createTables() error {
 			activated_at DATETIME,
 			expires_at DATETIME,
 			last_login DATETIME,
-			notes TEXT
+			notes TEXT,
+			transcode_profile TEXT DEFAULT ''
 		)`,
 		`CREATE TABLE IF NOT EXISTS user_sessions (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -106,6 +117,108 @@ func createTables() error {
 			category TEXT DEFAULT 'system',
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS channel_metadata (
+			channel_id INTEGER PRIMARY KEY,
+			video_codec TEXT DEFAULT '',
+			width INTEGER DEFAULT 0,
+			height INTEGER DEFAULT 0,
+			audio_codecs TEXT DEFAULT '',
+			audio_tracks INTEGER DEFAULT 0,
+			languages TEXT DEFAULT '',
+			bitrate_kbps REAL DEFAULT 0,
+			cc_errors INTEGER DEFAULT 0,
+			pcr_jitter_ms REAL DEFAULT 0,
+			streams TEXT DEFAULT '[]',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_health (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			latency_ms INTEGER DEFAULT 0,
+			format TEXT DEFAULT '',
+			error TEXT DEFAULT '',
+			source TEXT DEFAULT 'probe',
+			checked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_channel_health_channel ON channel_health(channel_id, checked_at)`,
+		`CREATE TABLE IF NOT EXISTS recordings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'scheduled',
+			format TEXT NOT NULL DEFAULT 'ts',
+			start_time DATETIME NOT NULL,
+			duration_seconds INTEGER NOT NULL,
+			repeat_rule TEXT DEFAULT '',
+			file_path TEXT DEFAULT '',
+			file_size INTEGER DEFAULT 0,
+			error TEXT DEFAULT '',
+			started_at DATETIME,
+			finished_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_recordings_status ON recordings(status, start_time)`,
+		`CREATE TABLE IF NOT EXISTS epg_sources (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			url TEXT DEFAULT '',
+			file_path TEXT DEFAULT '',
+			active INTEGER DEFAULT 1,
+			refresh_hours INTEGER DEFAULT 24,
+			status TEXT DEFAULT '',
+			error TEXT DEFAULT '',
+			channel_count INTEGER DEFAULT 0,
+			programme_count INTEGER DEFAULT 0,
+			imported_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS epg_channels (
+			source_id INTEGER NOT NULL,
+			channel_id TEXT NOT NULL COLLATE NOCASE,
+			display_name TEXT DEFAULT '',
+			icon TEXT DEFAULT '',
+			PRIMARY KEY (source_id, channel_id),
+			FOREIGN KEY (source_id) REFERENCES epg_sources(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS epg_programmes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_id INTEGER NOT NULL,
+			channel_id TEXT NOT NULL COLLATE NOCASE,
+			start_time INTEGER NOT NULL,
+			stop_time INTEGER NOT NULL,
+			title TEXT DEFAULT '',
+			sub_title TEXT DEFAULT '',
+			description TEXT DEFAULT '',
+			category TEXT DEFAULT '',
+			icon TEXT DEFAULT '',
+			episode_num TEXT DEFAULT '',
+			FOREIGN KEY (source_id) REFERENCES epg_sources(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_epg_programmes_channel ON epg_programmes(channel_id, source_id, start_time)`,
+		`CREATE INDEX IF NOT EXISTS idx_epg_programmes_stop ON epg_programmes(stop_time)`,
+		`CREATE TABLE IF NOT EXISTS mag_devices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			mac TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			user_id INTEGER,
+			token TEXT DEFAULT '',
+			stb_type TEXT DEFAULT '',
+			serial TEXT DEFAULT '',
+			last_ip TEXT DEFAULT '',
+			last_seen DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS transcode_profiles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			config TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, query := range queries {
@@ -161,6 +274,11 @@ func createDefaultSettings() {
 			"max_streams":            "100",
 			"enable_hls":             "true",
 			"hls_segment_duration":   "6",
+			"admission_policy":        "refuse",
+			"admission_queue_timeout": "10",
+			"ffmpeg_nice":             "0",
+			"ffmpeg_cpu_affinity":     "",
+			"ffmpeg_memory_limit_mb":  "0",
 		},
 		"stream": {
 			"auto_start":         "true",
@@ -168,6 +286,17 @@ func createDefaultSettings() {
 			"max_bitrate":        "8000",
 			"enable_transcode":   "false",
 			"default_format":     "mpegts",
+			"default_profile":    "passthrough",
+			"slow_client_policy":     "skip",
+			"slow_client_max_lag_kb": "4096",
+			"slow_client_max_drops":  "3",
+			"health_check_interval":    "600",
+			"health_check_concurrency": "5",
+			"health_check_method":      "get",
+			"health_check_timeout":     "10",
+			"timeshift_quota_mb":       "10240",
+			"connection_limit_policy":  "reject",
+			"connection_limit_slate":   "false",
 		},
 	}
 
@@ -184,15 +313,41 @@ func createDefaultSettings() {
 
 func runMigrations() {
 	// Migration: Add on_demand column to channels table if not exists
+	addColumnIfMissing("channels", "on_demand", "INTEGER DEFAULT 1")
+	// Migration: Per-channel slow client policy ('' = use stream settings)
+	addColumnIfMissing("channels", "slow_client_policy", "TEXT DEFAULT ''")
+	// Migration: Transcoding profile per channel and per user package ('' = inherit)
+	addColumnIfMissing("channels", "transcode_profile", "TEXT DEFAULT ''")
+	addColumnIfMissing("users", "transcode_profile", "TEXT DEFAULT ''")
+	// Migration: ABR ladder per channel (comma separated profile names)
+	addColumnIfMissing("channels", "abr_ladder", "TEXT DEFAULT ''")
+	// Migration: Admission priority per channel (higher takes slots from lower)
+	addColumnIfMissing("channels", "priority", "INTEGER DEFAULT 0")
+	// Migration: Delivery engine per channel (ffmpeg-remux, go-passthrough, redirect)
+	addColumnIfMissing("channels", "delivery_mode", "TEXT DEFAULT 'ffmpeg-remux'")
+	// Migration: Result of the last background health check ('' = not checked yet)
+	addColumnIfMissing("channels", "health_status", "TEXT DEFAULT ''")
+	addColumnIfMissing("channels", "health_checked_at", "DATETIME")
+	// Migration: Rewind window per channel in hours (0 = no timeshift)
+	addColumnIfMissing("channels", "timeshift_hours", "INTEGER DEFAULT 0")
+	// Migration: XMLTV channel id from the M3U tvg-id attribute, links EPG data
+	addColumnIfMissing("channels", "tvg_id", "TEXT DEFAULT ''")
+	// Migration: Playback session id and user agent of each connection
+	addColumnIfMissing("user_connections", "session_id", "TEXT DEFAULT ''")
+	addColumnIfMissing("user_connections", "user_agent", "TEXT DEFAULT ''")
+}
+
+// addColumnIfMissing adds a column to an existing table
+func addColumnIfMissing(table, column, definition string) {
 	var columnExists int
 	err := DB.QueryRow(`
-		SELECT COUNT(*) FROM pragma_table_info('channels') WHERE name='on_demand'
-	`).Scan(&columnExists)
-	
+		SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?
+	`, table, column).Scan(&columnExists)
+
 	if err == nil && columnExists == 0 {
-		_, err = DB.Exec("ALTER TABLE channels ADD COLUMN on_demand INTEGER DEFAULT 1")
+		_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 		if err == nil {
-			log.Println("✅ Migration: Added on_demand column to channels table")
+			log.Printf("✅ Migration: Added %s column to %s table", column, table)
 		} else {
 			log.Printf("⚠️  Migration failed: %v", err)
 		}
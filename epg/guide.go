@@ -0,0 +1,169 @@
+package epg
+
+import (
+	"encoding/xml"
+	"io"
+	"iptv-panel/database"
+	"time"
+)
+
+const programmeColumns = `channel_id, start_time, stop_time, title, sub_title, description, category, icon, episode_num`
+
+// programmeSource picks the source a tvg-id is served from, when several
+// have it the active one with the lowest id that has programmes for it
+const programmeSource = `source_id = (
+	SELECT p.source_id FROM epg_programmes p
+	JOIN epg_sources s ON s.id = p.source_id
+	WHERE p.channel_id = ? AND s.active = 1
+	ORDER BY p.source_id LIMIT 1
+)`
+
+func scanProgramme(row interface{ Scan(...interface{}) error }) (Programme, error) {
+	var p Programme
+	var start, stop int64
+	err := row.Scan(&p.ChannelID, &start, &stop, &p.Title, &p.SubTitle, &p.Description, &p.Category, &p.Icon, &p.EpisodeNum)
+	p.Start, p.Stop = time.Unix(start, 0).UTC(), time.Unix(stop, 0).UTC()
+	return p, err
+}
+
+// Programmes returns the programmes of a tvg-id airing between from and to
+func Programmes(tvgID string, from, to time.Time) ([]Programme, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+programmeColumns+` FROM epg_programmes
+		WHERE channel_id = ? AND `+programmeSource+`
+		AND stop_time > ? AND start_time < ?
+		ORDER BY start_time
+	`, tvgID, tvgID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	programmes := []Programme{}
+	for rows.Next() {
+		if p, err := scanProgramme(rows); err == nil {
+			programmes = append(programmes, p)
+		}
+	}
+	return programmes, rows.Err()
+}
+
+// NowNext returns the programme airing at the given time and the one after
+// it, nil where the guide has none
+func NowNext(tvgID string, at time.Time) (now, next *Programme, err error) {
+	rows, err := database.DB.Query(`
+		SELECT `+programmeColumns+` FROM epg_programmes
+		WHERE channel_id = ? AND `+programmeSource+`
+		AND stop_time > ?
+		ORDER BY start_time
+		LIMIT 2
+	`, tvgID, tvgID, at.Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var found []Programme
+	for rows.Next() {
+		if p, err := scanProgramme(rows); err == nil {
+			found = append(found, p)
+		}
+	}
+	if len(found) > 0 && !found[0].Start.After(at) {
+		now, found = &found[0], found[1:]
+	}
+	if len(found) > 0 {
+		next = &found[0]
+	}
+	return now, next, rows.Err()
+}
+
+// ExportChannel is a panel channel written to an XMLTV export
+type ExportChannel struct {
+	ID    string // Channel id in the export, matches tvg-id in the playlist
+	Name  string
+	Icon  string
+	TvgID string // Channel id in the imported guides
+}
+
+type exportIcon struct {
+	Src string `xml:"src,attr"`
+}
+
+type exportChannel struct {
+	XMLName     xml.Name    `xml:"channel"`
+	ID          string      `xml:"id,attr"`
+	DisplayName string      `xml:"display-name"`
+	Icon        *exportIcon `xml:"icon,omitempty"`
+}
+
+type exportProgramme struct {
+	XMLName    xml.Name    `xml:"programme"`
+	Start      string      `xml:"start,attr"`
+	Stop       string      `xml:"stop,attr"`
+	Channel    string      `xml:"channel,attr"`
+	Title      string      `xml:"title"`
+	SubTitle   string      `xml:"sub-title,omitempty"`
+	Desc       string      `xml:"desc,omitempty"`
+	Category   string      `xml:"category,omitempty"`
+	EpisodeNum string      `xml:"episode-num,omitempty"`
+	Icon       *exportIcon `xml:"icon,omitempty"`
+}
+
+// xmltvTime is the XMLTV date format
+const xmltvTime = "20060102150405 -0700"
+
+// WriteXMLTV writes an XMLTV guide of the channels with their programmes
+// airing between from and to. Channels without a tvg-id are listed with
+// no programmes.
+func WriteXMLTV(w io.Writer, channels []ExportChannel, from, to time.Time) error {
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE tv SYSTEM "xmltv.dtd">`+"\n"+`<tv generator-info-name="IPTV Panel">`+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("  ", "  ")
+
+	for _, ch := range channels {
+		element := exportChannel{ID: ch.ID, DisplayName: ch.Name}
+		if ch.Icon != "" {
+			element.Icon = &exportIcon{Src: ch.Icon}
+		}
+		if err := encoder.Encode(element); err != nil {
+			return err
+		}
+	}
+
+	for _, ch := range channels {
+		if ch.TvgID == "" {
+			continue
+		}
+		programmes, err := Programmes(ch.TvgID, from, to)
+		if err != nil {
+			return err
+		}
+		for _, p := range programmes {
+			element := exportProgramme{
+				Start:      p.Start.Format(xmltvTime),
+				Stop:       p.Stop.Format(xmltvTime),
+				Channel:    ch.ID,
+				Title:      p.Title,
+				SubTitle:   p.SubTitle,
+				Desc:       p.Description,
+				Category:   p.Category,
+				EpisodeNum: p.EpisodeNum,
+			}
+			if p.Icon != "" {
+				element.Icon = &exportIcon{Src: p.Icon}
+			}
+			if err := encoder.Encode(element); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n</tv>\n")
+	return err
+}
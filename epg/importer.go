@@ -0,0 +1,453 @@
+package epg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Import states, stored in epg_sources.status
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+const (
+	// checkInterval is how often sources are checked for a due import
+	checkInterval = 5 * time.Minute
+	// retryInterval is the wait before a failed import is tried again,
+	// unless the source refreshes more often
+	retryInterval = time.Hour
+	// fetchTimeout limits downloading and importing one source
+	fetchTimeout = 10 * time.Minute
+	// defaultDuration closes programmes without a stop time that have no
+	// next programme either
+	defaultDuration = time.Hour
+)
+
+// pastRetention is how long programmes are kept after they ended, so the
+// guide still covers the longest timeshift window
+var pastRetention = time.Duration(streaming.MaxTimeshiftHours) * time.Hour
+
+// Source is a row of epg_sources
+type Source struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	URL            string     `json:"url"`
+	FilePath       string     `json:"file_path"`
+	Active         bool       `json:"active"`
+	RefreshHours   int        `json:"refresh_hours"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error"`
+	ChannelCount   int        `json:"channel_count"`
+	ProgrammeCount int        `json:"programme_count"`
+	ImportedAt     *time.Time `json:"imported_at"`
+	Importing      bool       `json:"importing"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Importer imports the EPG sources in the background when they are due
+type Importer struct {
+	mux       sync.Mutex
+	dir       string
+	importing map[int]bool // Sources being imported right now
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	importer     *Importer
+	importerOnce sync.Once
+)
+
+// GetImporter returns the EPG importer
+func GetImporter() *Importer {
+	importerOnce.Do(func() {
+		importer = &Importer{
+			dir:       "./epg_guides",
+			importing: make(map[int]bool),
+		}
+	})
+	return importer
+}
+
+// Init sets the directory uploaded guide files are stored in
+func Init(dir string) error {
+	im := GetImporter()
+	im.mux.Lock()
+	im.dir = dir
+	im.mux.Unlock()
+	return os.MkdirAll(dir, 0755)
+}
+
+func (im *Importer) directory() string {
+	im.mux.Lock()
+	defer im.mux.Unlock()
+	return im.dir
+}
+
+// SaveUpload stores an uploaded guide file of a source and returns its path
+func SaveUpload(sourceID int, src io.Reader) (string, error) {
+	path := filepath.Join(GetImporter().directory(), fmt.Sprintf("source_%d.xml", sourceID))
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// Start imports due sources in the background until Stop is called
+func (im *Importer) Start() {
+	im.mux.Lock()
+	defer im.mux.Unlock()
+	if im.cancel != nil {
+		return
+	}
+	im.ctx, im.cancel = context.WithCancel(context.Background())
+	im.done = make(chan struct{})
+	go im.loop(im.ctx)
+}
+
+// Stop ends the background imports, cancelling those in progress
+func (im *Importer) Stop() {
+	im.mux.Lock()
+	cancel, done := im.cancel, im.done
+	im.cancel = nil
+	im.mux.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Trigger imports a source in the background now. Returns false if it is
+// already being imported or the importer is not running.
+func (im *Importer) Trigger(sourceID int) bool {
+	im.mux.Lock()
+	if im.cancel == nil || im.importing[sourceID] {
+		im.mux.Unlock()
+		return false
+	}
+	im.importing[sourceID] = true
+	ctx := im.ctx
+	im.mux.Unlock()
+
+	go im.run(ctx, sourceID)
+	return true
+}
+
+// Importing reports whether a source is being imported right now
+func (im *Importer) Importing(sourceID int) bool {
+	im.mux.Lock()
+	defer im.mux.Unlock()
+	return im.importing[sourceID]
+}
+
+func (im *Importer) loop(ctx context.Context) {
+	defer close(im.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		im.importDue(ctx)
+		pruneProgrammes()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// importDue imports, one after the other, the active sources whose last
+// import is older than their refresh interval
+func (im *Importer) importDue(ctx context.Context) {
+	sources, err := ListSources()
+	if err != nil {
+		log.Printf("⚠️  EPG failed to list sources: %v", err)
+		return
+	}
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return
+		}
+		if !source.Active || !source.due() {
+			continue
+		}
+		im.mux.Lock()
+		if im.importing[source.ID] {
+			im.mux.Unlock()
+			continue
+		}
+		im.importing[source.ID] = true
+		im.mux.Unlock()
+		im.run(ctx, source.ID)
+	}
+}
+
+// due reports whether the source should be imported again
+func (s Source) due() bool {
+	if s.ImportedAt == nil {
+		return true
+	}
+	wait := time.Duration(s.RefreshHours) * time.Hour
+	if s.Status == StatusFailed && retryInterval < wait {
+		wait = retryInterval
+	}
+	return time.Since(*s.ImportedAt) >= wait
+}
+
+// run imports a source marked as importing and records the outcome
+func (im *Importer) run(ctx context.Context, sourceID int) {
+	defer func() {
+		im.mux.Lock()
+		delete(im.importing, sourceID)
+		im.mux.Unlock()
+	}()
+
+	source, err := GetSource(sourceID)
+	if err != nil {
+		return
+	}
+	started := time.Now()
+	channels, programmes, err := Import(ctx, source)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		log.Printf("❌ EPG import of %s failed: %v", source.Name, err)
+		database.DB.Exec("UPDATE epg_sources SET status = ?, error = ?, imported_at = ? WHERE id = ?",
+			StatusFailed, err.Error(), time.Now().UTC(), sourceID)
+		return
+	}
+	log.Printf("📅 EPG import of %s: %d channels, %d programmes in %v",
+		source.Name, channels, programmes, time.Since(started).Round(time.Millisecond))
+	database.DB.Exec(`
+		UPDATE epg_sources SET status = ?, error = '', channel_count = ?, programme_count = ?, imported_at = ?
+		WHERE id = ?
+	`, StatusOK, channels, programmes, time.Now().UTC(), sourceID)
+}
+
+// Import replaces the channels and programmes of a source with those of
+// its guide. Programmes that ended before the retention window are left
+// out. The old data is kept if the guide cannot be read.
+func Import(ctx context.Context, source Source) (channels, programmes int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	// Downloaded first, the database stays writable while it transfers
+	body, err := source.open(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer body.Close()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	tx.Exec("DELETE FROM epg_channels WHERE source_id = ?", source.ID)
+	tx.Exec("DELETE FROM epg_programmes WHERE source_id = ?", source.ID)
+
+	insertChannel, err := tx.Prepare(`
+		INSERT OR REPLACE INTO epg_channels (source_id, channel_id, display_name, icon)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insertChannel.Close()
+	insertProgramme, err := tx.Prepare(`
+		INSERT INTO epg_programmes (source_id, channel_id, start_time, stop_time, title, sub_title, description, category, icon, episode_num)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insertProgramme.Close()
+
+	oldest := time.Now().Add(-pastRetention)
+	err = Parse(body, func(ch Channel) error {
+		if _, err := insertChannel.Exec(source.ID, ch.ID, ch.DisplayName, ch.Icon); err != nil {
+			return err
+		}
+		channels++
+		return ctx.Err()
+	}, func(p Programme) error {
+		if !p.Stop.IsZero() && p.Stop.Before(oldest) {
+			return nil
+		}
+		var stop int64
+		if !p.Stop.IsZero() {
+			stop = p.Stop.Unix()
+		}
+		if _, err := insertProgramme.Exec(source.ID, p.ChannelID, p.Start.Unix(), stop,
+			p.Title, p.SubTitle, p.Description, p.Category, p.Icon, p.EpisodeNum); err != nil {
+			return err
+		}
+		programmes++
+		return ctx.Err()
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Programmes without a stop time end when the next one starts
+	_, err = tx.Exec(`
+		UPDATE epg_programmes SET stop_time = COALESCE((
+			SELECT MIN(next.start_time) FROM epg_programmes next
+			WHERE next.source_id = epg_programmes.source_id
+			AND next.channel_id = epg_programmes.channel_id
+			AND next.start_time > epg_programmes.start_time
+		), start_time + ?)
+		WHERE source_id = ? AND stop_time = 0
+	`, int64(defaultDuration.Seconds()), source.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return channels, programmes, nil
+}
+
+// open returns the guide of a source, its uploaded file or a temporary
+// copy of its URL that is removed on Close
+func (s Source) open(ctx context.Context) (io.ReadCloser, error) {
+	if s.URL == "" {
+		if s.FilePath == "" {
+			return nil, fmt.Errorf("source has no URL or uploaded file")
+		}
+		return os.Open(s.FilePath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	}
+
+	file, err := os.CreateTemp(GetImporter().directory(), fmt.Sprintf("source_%d_*.download", s.ID))
+	if err != nil {
+		return nil, err
+	}
+	download := &tempFile{file}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		download.Close()
+		return nil, fmt.Errorf("download failed: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		download.Close()
+		return nil, err
+	}
+	return download, nil
+}
+
+// tempFile deletes the file when it is closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// pruneProgrammes deletes programmes that ended before the retention
+// window and those of deleted sources
+func pruneProgrammes() {
+	database.DB.Exec("DELETE FROM epg_programmes WHERE stop_time < ?", time.Now().Add(-pastRetention).Unix())
+	database.DB.Exec("DELETE FROM epg_programmes WHERE source_id NOT IN (SELECT id FROM epg_sources)")
+	database.DB.Exec("DELETE FROM epg_channels WHERE source_id NOT IN (SELECT id FROM epg_sources)")
+}
+
+const sourceColumns = `id, name, url, file_path, active, refresh_hours, status, error,
+	channel_count, programme_count, imported_at, created_at`
+
+func scanSource(row interface{ Scan(...interface{}) error }) (Source, error) {
+	var s Source
+	var importedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.Name, &s.URL, &s.FilePath, &s.Active, &s.RefreshHours, &s.Status, &s.Error,
+		&s.ChannelCount, &s.ProgrammeCount, &importedAt, &s.CreatedAt)
+	if importedAt.Valid {
+		s.ImportedAt = &importedAt.Time
+	}
+	s.Importing = GetImporter().Importing(s.ID)
+	return s, err
+}
+
+// ListSources returns every EPG source
+func ListSources() ([]Source, error) {
+	rows, err := database.DB.Query("SELECT " + sourceColumns + " FROM epg_sources ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []Source{}
+	for rows.Next() {
+		if source, err := scanSource(rows); err == nil {
+			sources = append(sources, source)
+		}
+	}
+	return sources, rows.Err()
+}
+
+// GetSource returns an EPG source, sql.ErrNoRows if there is none
+func GetSource(id int) (Source, error) {
+	return scanSource(database.DB.QueryRow("SELECT "+sourceColumns+" FROM epg_sources WHERE id = ?", id))
+}
+
+// DeleteSource deletes a source with its guide data and uploaded file
+func DeleteSource(id int) error {
+	source, err := GetSource(id)
+	if err != nil {
+		return err
+	}
+	if _, err := database.DB.Exec("DELETE FROM epg_sources WHERE id = ?", id); err != nil {
+		return err
+	}
+	database.DB.Exec("DELETE FROM epg_channels WHERE source_id = ?", id)
+	database.DB.Exec("DELETE FROM epg_programmes WHERE source_id = ?", id)
+	if source.FilePath != "" {
+		os.Remove(source.FilePath)
+	}
+	return nil
+}
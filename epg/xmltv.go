@@ -0,0 +1,236 @@
+package epg
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Channel is a <channel> of an XMLTV guide
+type Channel struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Icon        string `json:"icon"`
+}
+
+// Programme is a <programme> of an XMLTV guide
+type Programme struct {
+	ChannelID   string    `json:"channel_id"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+	Title       string    `json:"title"`
+	SubTitle    string    `json:"sub_title"`
+	Description string    `json:"description"`
+	Category    string    `json:"category"`
+	Icon        string    `json:"icon"`
+	EpisodeNum  string    `json:"episode_num"`
+}
+
+// xmltvChannel and xmltvProgramme mirror the XMLTV elements. Elements that
+// may repeat in several languages keep every value, the first one is used.
+type xmltvChannel struct {
+	ID          string   `xml:"id,attr"`
+	DisplayName []string `xml:"display-name"`
+	Icon        []struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+}
+
+type xmltvProgramme struct {
+	Start      string   `xml:"start,attr"`
+	Stop       string   `xml:"stop,attr"`
+	Channel    string   `xml:"channel,attr"`
+	Title      []string `xml:"title"`
+	SubTitle   []string `xml:"sub-title"`
+	Desc       []string `xml:"desc"`
+	Category   []string `xml:"category"`
+	EpisodeNum []string `xml:"episode-num"`
+	Icon       []struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+}
+
+// Parse reads an XMLTV guide, plain or gzip compressed, and calls the
+// callbacks for every channel and programme in document order. Programmes
+// without a valid start time are skipped. Parsing stops at the first
+// callback error.
+func Parse(r io.Reader, onChannel func(Channel) error, onProgramme func(Programme) error) error {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("invalid gzip data: %v", err)
+		}
+		defer gz.Close()
+		buffered = bufio.NewReader(gz)
+	}
+
+	decoder := xml.NewDecoder(buffered)
+	decoder.Strict = false
+	decoder.CharsetReader = charsetReader
+
+	sawGuide := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid XMLTV data: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "tv":
+			sawGuide = true
+		case "channel":
+			var ch xmltvChannel
+			if err := decoder.DecodeElement(&ch, &start); err != nil {
+				return fmt.Errorf("invalid channel element: %v", err)
+			}
+			if ch.ID == "" {
+				continue
+			}
+			channel := Channel{ID: strings.TrimSpace(ch.ID), DisplayName: first(ch.DisplayName)}
+			if len(ch.Icon) > 0 {
+				channel.Icon = ch.Icon[0].Src
+			}
+			if err := onChannel(channel); err != nil {
+				return err
+			}
+		case "programme":
+			var p xmltvProgramme
+			if err := decoder.DecodeElement(&p, &start); err != nil {
+				return fmt.Errorf("invalid programme element: %v", err)
+			}
+			programme, ok := p.programme()
+			if !ok {
+				continue
+			}
+			if err := onProgramme(programme); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !sawGuide {
+		return fmt.Errorf("not an XMLTV guide: no <tv> element")
+	}
+	return nil
+}
+
+// programme converts the element, ok is false when it has no channel or
+// no valid start time. A missing stop time is left zero, the importer
+// closes it at the start of the next programme.
+func (p xmltvProgramme) programme() (Programme, bool) {
+	start, err := parseTime(p.Start)
+	if err != nil || p.Channel == "" {
+		return Programme{}, false
+	}
+	programme := Programme{
+		ChannelID:   strings.TrimSpace(p.Channel),
+		Start:       start,
+		Title:       first(p.Title),
+		SubTitle:    first(p.SubTitle),
+		Description: first(p.Desc),
+		Category:    first(p.Category),
+		EpisodeNum:  first(p.EpisodeNum),
+	}
+	if stop, err := parseTime(p.Stop); err == nil && stop.After(start) {
+		programme.Stop = stop
+	}
+	if len(p.Icon) > 0 {
+		programme.Icon = p.Icon[0].Src
+	}
+	return programme, true
+}
+
+// parseTime parses an XMLTV date like "20240101200000 +0100". The seconds,
+// minutes and hours may be left out, a missing offset means UTC.
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty time")
+	}
+	digits, offset := value, ""
+	if i := strings.IndexAny(value, " +-"); i != -1 {
+		digits, offset = value[:i], strings.TrimSpace(value[i:])
+	}
+	if len(digits) < 8 || len(digits) > 14 || len(digits)%2 != 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	// Pad to full seconds precision
+	digits += "000000"[:14-len(digits)]
+
+	if offset == "" {
+		return time.ParseInLocation("20060102150405", digits, time.UTC)
+	}
+	t, err := time.Parse("20060102150405 -0700", digits+" "+offset)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+// charsetReader decodes the single byte charsets used by some providers,
+// UTF-8 compatible ones are read as they are
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "latin-1", "iso8859-1", "windows-1252", "cp1252":
+		return &latin1Reader{src: bufio.NewReader(input)}, nil
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	}
+	return nil, fmt.Errorf("unsupported charset %s", charset)
+}
+
+// latin1Reader converts ISO-8859-1 bytes to UTF-8
+type latin1Reader struct {
+	src     *bufio.Reader
+	pending []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+		b, err := l.src.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		var buf [2]byte
+		l.pending = buf[:utf8.EncodeRune(buf[:], rune(b))]
+	}
+	return n, nil
+}
+
+// first returns the first non-empty value, trimmed
+func first(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package epg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"20240101200000 +0000", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), true},
+		{"20240101200000 +0700", time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC), true},
+		{"20240101200000 -0130", time.Date(2024, 1, 1, 21, 30, 0, 0, time.UTC), true},
+		{"20240101200000+0100", time.Date(2024, 1, 1, 19, 0, 0, 0, time.UTC), true},
+		{"20240101200000", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), true},
+		{"202401012000", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), true},
+		{"20240101", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{" 20240101200000 +0000 ", time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"2024010", time.Time{}, false},
+		{"2024010120000", time.Time{}, false},
+		{"20241301200000", time.Time{}, false},
+		{"20240101200000 CET", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTime(tt.value)
+		if (err == nil) != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q) = %v, %v; want %v, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func gzipped(s string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.String()
+}
+
+const sampleGuide = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE tv SYSTEM "xmltv.dtd">
+<tv generator-info-name="test">
+  <channel id=" rcti.id ">
+    <display-name lang="id"> </display-name>
+    <display-name lang="en">RCTI</display-name>
+    <icon src="http://logo/rcti.png"/>
+  </channel>
+  <channel><display-name>No id</display-name></channel>
+  <programme start="20240101200000 +0700" stop="20240101210000 +0700" channel="rcti.id">
+    <title lang="id">Berita Malam</title>
+    <title lang="en">Evening News</title>
+    <sub-title>Edisi 1</sub-title>
+    <desc>Berita &amp; cuaca</desc>
+    <category>News</category>
+    <episode-num system="xmltv_ns">0.0.</episode-num>
+    <icon src="http://img/news.png"/>
+  </programme>
+  <programme start="20240101210000 +0700" stop="20240101200000 +0700" channel="rcti.id">
+    <title>Stop before start</title>
+  </programme>
+  <programme start="later" channel="rcti.id"><title>No start</title></programme>
+  <programme start="20240101220000 +0700"><title>No channel</title></programme>
+</tv>`
+
+func TestParse(t *testing.T) {
+	news := Programme{
+		ChannelID:   "rcti.id",
+		Start:       time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC),
+		Stop:        time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC),
+		Title:       "Berita Malam",
+		SubTitle:    "Edisi 1",
+		Description: "Berita & cuaca",
+		Category:    "News",
+		Icon:        "http://img/news.png",
+		EpisodeNum:  "0.0.",
+	}
+	openEnded := Programme{
+		ChannelID: "rcti.id",
+		Start:     time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC),
+		Title:     "Stop before start",
+	}
+	rcti := Channel{ID: "rcti.id", DisplayName: "RCTI", Icon: "http://logo/rcti.png"}
+
+	tests := []struct {
+		name       string
+		data       string
+		channels   []Channel
+		programmes []Programme
+		err        string
+	}{
+		{
+			name:       "plain guide",
+			data:       sampleGuide,
+			channels:   []Channel{rcti},
+			programmes: []Programme{news, openEnded},
+		},
+		{
+			name:       "gzip compressed guide",
+			data:       gzipped(sampleGuide),
+			channels:   []Channel{rcti},
+			programmes: []Programme{news, openEnded},
+		},
+		{
+			name:     "latin-1 encoded guide",
+			data:     "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><tv><channel id=\"tv5\"><display-name>T\xe9l\xe9</display-name></channel></tv>",
+			channels: []Channel{{ID: "tv5", DisplayName: "Télé"}},
+		},
+		{
+			name: "empty guide",
+			data: "<tv></tv>",
+		},
+		{
+			name: "not a guide",
+			data: "<html><body>Not found</body></html>",
+			err:  "not an XMLTV guide",
+		},
+		{
+			name: "broken gzip",
+			data: "\x1f\x8bnot gzip",
+			err:  "invalid gzip data",
+		},
+		{
+			name: "unsupported charset",
+			data: `<?xml version="1.0" encoding="KOI8-R"?><tv></tv>`,
+			err:  "unsupported charset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var channels []Channel
+			var programmes []Programme
+			err := Parse(strings.NewReader(tt.data),
+				func(c Channel) error { channels = append(channels, c); return nil },
+				func(p Programme) error { programmes = append(programmes, p); return nil })
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(channels, tt.channels) {
+				t.Errorf("channels:\n got %+v\nwant %+v", channels, tt.channels)
+			}
+			if len(programmes) != len(tt.programmes) {
+				t.Fatalf("got %d programmes, want %d: %+v", len(programmes), len(tt.programmes), programmes)
+			}
+			for i, p := range programmes {
+				want := tt.programmes[i]
+				if !p.Start.Equal(want.Start) || !p.Stop.Equal(want.Stop) {
+					t.Errorf("programme %d runs %v - %v, want %v - %v", i, p.Start, p.Stop, want.Start, want.Stop)
+				}
+				p.Start, p.Stop, want.Start, want.Stop = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+				if p != want {
+					t.Errorf("programme %d:\n got %+v\nwant %+v", i, p, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStopsOnCallbackError(t *testing.T) {
+	errFull := errors.New("database full")
+	count := 0
+	err := Parse(strings.NewReader(sampleGuide),
+		func(Channel) error { return nil },
+		func(Programme) error { count++; return errFull })
+	if err != errFull || count != 1 {
+		t.Errorf("got %v after %d programmes, want %v after 1", err, count, errFull)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"iptv-panel/streaming"
+	"net/http"
+	"strconv"
+)
+
+// maxChannelPriority bounds the admission priority of a channel
+const maxChannelPriority = 10
+
+// admitStream reserves an FFmpeg slot for sessionID before its session is
+// started. Replies 503 with Retry-After and returns false when the server
+// is running max_streams streams.
+func admitStream(w http.ResponseWriter, r *http.Request, sessionID string, priority int) bool {
+	err := streaming.GetFFmpegManager().Admit(r.Context(), sessionID, priority)
+	if err == nil {
+		return true
+	}
+
+	var admissionErr *streaming.AdmissionError
+	if errors.As(err, &admissionErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(admissionErr.RetryAfter.Seconds())))
+		http.Error(w, "Server busy: "+err.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+	return false
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/settings"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hlsSessionParam is the query parameter carrying the session id of an HLS
+// player, which counts as one connection however often it polls
+const hlsSessionParam = "sid"
+
+// hlsWrapperBandwidth is advertised for the single variant of the playlist
+// that hands a new HLS player its session id. Players only need it present.
+const hlsWrapperBandwidth = 5000000
+
+// viewerIDPattern matches the ids made by streaming.NewViewerID
+var viewerIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// viewerContextKey holds the viewer admitted by trackStream in the context
+// of the request it returns
+type viewerContextKey struct{}
+
+// newViewer returns a viewer for the player making r
+func newViewer(r *http.Request, userID, channelID int, hls bool) *streaming.Viewer {
+	viewer := streaming.NewViewer(userID, channelID, r.RemoteAddr, hls)
+	viewer.UserAgent = r.UserAgent()
+	return viewer
+}
+
+// playbackID returns the client id of the player making r in the sessions it
+// reads: its viewer id, its HLS session id, or a new id for a request that is
+// not counted against a user
+func playbackID(r *http.Request) string {
+	if viewer, ok := r.Context().Value(viewerContextKey{}).(*streaming.Viewer); ok {
+		return viewer.ID
+	}
+	if sid := r.URL.Query().Get(hlsSessionParam); viewerIDPattern.MatchString(sid) {
+		return sid
+	}
+	return streaming.NewViewerID()
+}
+
+// admitViewer counts v against its user's max_connections with the
+// connection limit policy and records it in user_connections under its id.
+// The row is closed when the viewer ends or is kicked.
+func admitViewer(v *streaming.Viewer) error {
+	var limit int
+	if err := database.DB.QueryRow("SELECT max_connections FROM users WHERE id = ?", v.UserID).Scan(&limit); err != nil {
+		return err
+	}
+
+	var channelID interface{}
+	if v.ChannelID > 0 {
+		channelID = v.ChannelID
+	}
+	result, err := database.DB.Exec(`
+		INSERT INTO user_connections (user_id, channel_id, ip_address, user_agent, session_id, connected_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, v.UserID, channelID, v.RemoteAddr, v.UserAgent, v.ID)
+	if err != nil {
+		return err
+	}
+	v.ConnectionID, _ = result.LastInsertId()
+	v.OnClose(func() {
+		database.DB.Exec("UPDATE user_connections SET disconnected_at = CURRENT_TIMESTAMP WHERE id = ?", v.ConnectionID)
+	})
+
+	if err := streaming.GetViewerRegistry().Admit(v, limit, settings.Get().ConnectionLimitPolicy); err != nil {
+		database.DB.Exec("DELETE FROM user_connections WHERE id = ?", v.ConnectionID)
+		log.Printf("⛔ Refused stream of user %d from %s: %v", v.UserID, v.RemoteAddr, err)
+		return err
+	}
+	return nil
+}
+
+// viewerWriter counts the bytes written to a viewer's response
+type viewerWriter struct {
+	http.ResponseWriter
+	viewer *streaming.Viewer
+}
+
+func (w *viewerWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.viewer.AddBytes(n)
+	return n, err
+}
+
+// Flush sends buffered data to the client
+func (w *viewerWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// watchViewer returns w and r for streaming to viewer: the writer counts the
+// bytes sent and the request, which carries the viewer, ends when it is
+// kicked. cancel releases the request.
+func watchViewer(w http.ResponseWriter, r *http.Request, viewer *streaming.Viewer) (http.ResponseWriter, *http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), viewerContextKey{}, viewer))
+	go func() {
+		select {
+		case <-viewer.Kicked():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return &viewerWriter{ResponseWriter: w, viewer: viewer}, r.WithContext(ctx), cancel
+}
+
+// trackStream admits an MPEG-TS viewer of channelID (0 = not a channel). It
+// returns the writer and request to stream with, see watchViewer, and a func
+// to call once streaming is over. ok is false when the stream was refused and
+// the response written.
+func trackStream(w http.ResponseWriter, r *http.Request, userID, channelID int) (http.ResponseWriter, *http.Request, func(), bool) {
+	viewer := newViewer(r, userID, channelID, false)
+	if err := admitViewer(viewer); err != nil {
+		var limitErr *streaming.ConnectionLimitError
+		if !errors.As(err, &limitErr) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if settings.Get().ConnectionLimitSlate {
+			serveSlate(w, r, userID, channelID, streaming.StreamTooManyDevicesVideo)
+		} else {
+			http.Error(w, "Too many devices: "+err.Error(), http.StatusForbidden)
+		}
+		return nil, nil, nil, false
+	}
+
+	streamW, streamReq, cancel := watchViewer(w, r, viewer)
+	done := func() {
+		cancel()
+		streaming.GetViewerRegistry().Remove(viewer)
+		// A player kicked for a newer stream that is still connected is shown why
+		if viewer.KickReason() == streaming.KickConnectionLimit && r.Context().Err() == nil &&
+			settings.Get().ConnectionLimitSlate && streaming.TooManyDevicesVideoAvailable() {
+			serveSlate(w, r, userID, channelID, streaming.StreamTooManyDevicesVideo)
+		}
+	}
+	return streamW, streamReq, done, true
+}
+
+// serveSlate streams a notification video to a player of userID that may
+// not watch channelID. The player is listed as a slate viewer until it
+// disconnects or is kicked.
+func serveSlate(w http.ResponseWriter, r *http.Request, userID, channelID int, slate func(http.ResponseWriter, *http.Request)) {
+	viewer := newViewer(r, userID, channelID, false)
+	streaming.GetViewerRegistry().Add(viewer)
+	defer streaming.GetViewerRegistry().Remove(viewer)
+
+	slateW, slateReq, cancel := watchViewer(w, r, viewer)
+	defer cancel()
+	slate(slateW, slateReq)
+}
+
+// relayChannelID returns the channel a relay path channel-{id} serves, 0 for
+// other relays
+func relayChannelID(path string) int {
+	id, _ := strconv.Atoi(strings.TrimPrefix(path, "channel-"))
+	return id
+}
+
+// kickedMessage explains to a player why its session was ended
+func kickedMessage(reason string) string {
+	if reason == streaming.KickAdmin {
+		return "Disconnected by administrator"
+	}
+	return "Too many devices: this player was disconnected for a newer stream"
+}
+
+// trackHLSViewer counts an HLS playlist request by its session id. A request
+// without one starts a new viewer and, unless master is set, is answered with
+// a one-variant master playlist pointing back at the same URL with the sid,
+// which the player then polls. A master playlist gets the sid added to the
+// request so serveHLSPlaylist passes it on to the variants. ok is false when
+// the response has been written. release ends a viewer admitted by this
+// request; the handler calls it when it fails to serve the playlist after all,
+// so a refused start does not hold one of the user's connections.
+func trackHLSViewer(w http.ResponseWriter, r *http.Request, userID, channelID int, master bool) (release func(), ok bool) {
+	release = func() {}
+	query := r.URL.Query()
+	if sid := query.Get(hlsSessionParam); sid != "" {
+		viewer, kickReason := streaming.GetViewerRegistry().Touch(sid)
+		switch {
+		case kickReason != "":
+			http.Error(w, kickedMessage(kickReason), http.StatusForbidden)
+			return release, false
+		case viewer != nil && viewer.UserID != userID, viewer == nil && !viewerIDPattern.MatchString(sid):
+			http.Error(w, "Invalid session", http.StatusBadRequest)
+			return release, false
+		case viewer != nil:
+			return release, true
+		}
+
+		// A player that paused past the idle timeout, or polls across a
+		// restart, comes back under its own sid
+		viewer = streaming.NewViewerWithID(sid, userID, channelID, r.RemoteAddr, true)
+		viewer.UserAgent = r.UserAgent()
+		if err := admitViewer(viewer); err != nil {
+			refuseHLSViewer(w, err)
+			return release, false
+		}
+		return func() { streaming.GetViewerRegistry().Remove(viewer) }, true
+	}
+
+	viewer := newViewer(r, userID, channelID, true)
+	if err := admitViewer(viewer); err != nil {
+		refuseHLSViewer(w, err)
+		return release, false
+	}
+	query.Set(hlsSessionParam, viewer.ID)
+	if master {
+		r.URL.RawQuery = query.Encode()
+		return func() { streaming.GetViewerRegistry().Remove(viewer) }, true
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s?%s\n", hlsWrapperBandwidth, path.Base(r.URL.Path), query.Encode())
+	return release, false
+}
+
+// refuseHLSViewer answers a playlist request that could not be admitted
+func refuseHLSViewer(w http.ResponseWriter, err error) {
+	var limitErr *streaming.ConnectionLimitError
+	if errors.As(err, &limitErr) {
+		http.Error(w, "Too many devices: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHLSViewerReleasedWhenStreamRefused(t *testing.T) {
+	setupTestPanel(t)
+	mustExec(t, `INSERT INTO playlists (id, name, url, type) VALUES (1, 'source', 'http://source', 'm3u')`)
+	mustExec(t, `INSERT INTO channels (id, playlist_id, name, url, abr_ladder) VALUES
+		(1, 1, 'RCTI', 'http://127.0.0.1:1/rcti', ''),
+		(2, 1, 'SCTV', 'http://127.0.0.1:1/sctv', '720p-2500k,480p-mobile')`)
+	mustExec(t, `INSERT INTO relays (name, source_urls, output_path, active) VALUES ('News', '["http://127.0.0.1:1/news"]', 'news', 1)`)
+	mustExec(t, `UPDATE settings SET value = 'true' WHERE key = 'enable_transcode'`)
+	// md5("secret"), one connection
+	mustExec(t, `INSERT INTO users (id, username, password, max_connections) VALUES (7, 'budi', '5ebe2294ecd0e0f08eab7690d2a6ee69', 1)`)
+
+	// The only FFmpeg slot is taken by another stream
+	streaming.Configure(streaming.Config{MaxStreams: 1})
+	t.Cleanup(func() { streaming.Configure(streaming.Config{}) })
+	if err := streaming.GetFFmpegManager().Admit(context.Background(), "busy", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	const sid = "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		url     string
+		vars    map[string]string
+	}{
+		{
+			name:    "channel proxy",
+			handler: ProxyChannelHLS,
+			url:     "/api/proxy/channel/1/hls?username=budi&password=secret&sid=" + sid,
+			vars:    map[string]string{"id": "1"},
+		},
+		{
+			name:    "channel proxy with an ABR ladder",
+			handler: ProxyChannelHLS,
+			url:     "/api/proxy/channel/2/hls?username=budi&password=secret",
+			vars:    map[string]string{"id": "2"},
+		},
+		{
+			name:    "relay",
+			handler: StreamRelayHLS,
+			url:     "/stream/news/hls?username=budi&password=secret&sid=" + sid,
+			vars:    map[string]string{"path": "news"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A player retrying after the refusal must not be held back by
+			// the viewer of its first attempt
+			for attempt := 1; attempt <= 2; attempt++ {
+				rec := httptest.NewRecorder()
+				req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, tt.url, nil), tt.vars)
+				tt.handler(rec, req)
+				if rec.Code != http.StatusServiceUnavailable {
+					t.Fatalf("attempt %d: status %d (%s), want 503", attempt, rec.Code, rec.Body)
+				}
+
+				if n := streaming.GetViewerRegistry().Count(7); n != 0 {
+					t.Errorf("attempt %d: %d live viewers, want 0", attempt, n)
+				}
+				var open int
+				database.DB.QueryRow("SELECT COUNT(*) FROM user_connections WHERE user_id = 7 AND disconnected_at IS NULL").Scan(&open)
+				if open != 0 {
+					t.Errorf("attempt %d: %d open connection rows, want 0", attempt, open)
+				}
+			}
+		})
+	}
+}
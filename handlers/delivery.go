@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+)
+
+// streamSession is a shared MPEG-TS stream clients attach to, served by
+// FFmpeg or by the Go passthrough engine
+type streamSession interface {
+	AddClient(clientID, remoteAddr, userAgent string) (*streaming.StreamClient, error)
+	RemoveClient(clientID string)
+}
+
+// channelSession is the shared session serving a channel's unmodified
+// stream, which background consumers like timeshift and recordings read
+type channelSession interface {
+	streamSession
+	SetTimeshift(archive *streaming.TimeshiftArchive)
+	SetOnDemand(onDemand bool)
+	IsActive() bool
+	Start()
+}
+
+// sourceSession returns the session channel_{id} that viewers without a
+// transcode profile share, so background consumers add no upstream pull.
+// Redirected channels are never pulled by the panel, so they are read with
+// the Go passthrough engine. The session is not started.
+func sourceSession(ctx context.Context, channelID int, url string, priority int, deliveryMode string) (channelSession, error) {
+	sessionID := fmt.Sprintf("channel_%d", channelID)
+	passthrough := streaming.BuiltinProfiles[streaming.PassthroughProfile]
+
+	if deliveryEngine(deliveryMode, passthrough) != streaming.DeliveryFFmpeg {
+		session := streaming.GetManager().GetOrCreateSession(sessionID, []string{url})
+		session.SetChannelID(channelID)
+		return session, nil
+	}
+
+	if err := streaming.GetFFmpegManager().Admit(ctx, sessionID, priority); err != nil {
+		return nil, err
+	}
+	session := streaming.GetFFmpegManager().GetOrCreateFFmpegSession(sessionID, []string{url}, "mpegts")
+	session.SetPriority(priority)
+	session.SetChannelID(channelID)
+	if session.IsBlacklisted() {
+		return nil, fmt.Errorf("channel is offline or unavailable")
+	}
+	return session, nil
+}
+
+// deliveryEngine returns the engine serving a channel with the given
+// delivery mode. Transcoding needs FFmpeg, so a channel resolving to a
+// transcode profile always uses it.
+func deliveryEngine(mode string, profile streaming.TranscodeProfile) string {
+	if mode == "" || mode == streaming.DeliveryFFmpeg {
+		return streaming.DeliveryFFmpeg
+	}
+	if !profile.IsPassthrough() {
+		log.Printf("⚠️  Delivery mode %s cannot apply transcode profile %s, using FFmpeg", mode, profile.Name)
+		return streaming.DeliveryFFmpeg
+	}
+	return mode
+}
+
+// servePassthrough serves sessionID from the Go passthrough engine, with the
+// channel's on-demand and slow client settings. channelID (0 = none) gets the
+// metadata found by inspecting the stream.
+func servePassthrough(w http.ResponseWriter, r *http.Request, sessionID string, urls []string, channelID int, onDemand bool, slowPolicy string) {
+	session := streaming.GetManager().GetOrCreateSession(sessionID, urls)
+	if channelID > 0 {
+		session.SetChannelID(channelID)
+	}
+	session.SetOnDemand(onDemand)
+	session.SetSlowClientPolicy(slowClientPolicy(slowPolicy))
+	serveMPEGTS(w, r, session)
+}
+
+// serveMPEGTS attaches the request to session and sends the stream until the
+// client disconnects or the stream stops
+func serveMPEGTS(w http.ResponseWriter, r *http.Request, session streamSession) {
+	// Add client with its own read cursor on the session buffer
+	clientID := playbackID(r)
+	client, err := session.AddClient(clientID, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer session.RemoveClient(clientID)
+
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Send data to client until it disconnects or the stream stops
+	for {
+		data, err := client.Next(r.Context())
+		if err != nil {
+			return
+		}
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
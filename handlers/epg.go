@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/epg"
+	"iptv-panel/models"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxEPGUpload bounds an uploaded guide file
+	maxEPGUpload = 512 << 20
+	// maxGuideRange bounds the time range of one guide request
+	maxGuideRange = 14 * 24 * time.Hour
+	// userGuidePast is how far back the XMLTV export of a user goes
+	userGuidePast = 24 * time.Hour
+)
+
+// epgSourceRequest is the body of CreateEPGSource and UpdateEPGSource
+type epgSourceRequest struct {
+	Name         *string `json:"name"`
+	URL          *string `json:"url"`
+	RefreshHours *int    `json:"refresh_hours"`
+	Active       *bool   `json:"active"`
+}
+
+// validate checks the fields that are set
+func (req epgSourceRequest) validate() string {
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		return "name is required"
+	}
+	if req.URL != nil && *req.URL != "" && !strings.HasPrefix(*req.URL, "http://") && !strings.HasPrefix(*req.URL, "https://") {
+		return "url must be an http or https URL"
+	}
+	if req.RefreshHours != nil && (*req.RefreshHours < 1 || *req.RefreshHours > 168) {
+		return "refresh_hours must be between 1 and 168"
+	}
+	return ""
+}
+
+// writeEPGError sends a validation error
+func writeEPGError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    1,
+		"message": message,
+	})
+}
+
+// GetEPGSources returns the EPG sources and the state of their last import
+func GetEPGSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := epg.ListSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": sources,
+	})
+}
+
+// CreateEPGSource adds an XMLTV source by URL and imports it right away
+func CreateEPGSource(w http.ResponseWriter, r *http.Request) {
+	var req epgSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeEPGError(w, "Invalid request: "+err.Error())
+		return
+	}
+	message := req.validate()
+	if message == "" && (req.Name == nil || req.URL == nil || *req.URL == "") {
+		message = "name and url are required"
+	}
+	if message != "" {
+		writeEPGError(w, message)
+		return
+	}
+
+	refreshHours, active := 24, true
+	if req.RefreshHours != nil {
+		refreshHours = *req.RefreshHours
+	}
+	if req.Active != nil {
+		active = *req.Active
+	}
+	result, err := database.DB.Exec("INSERT INTO epg_sources (name, url, refresh_hours, active) VALUES (?, ?, ?, ?)",
+		strings.TrimSpace(*req.Name), *req.URL, refreshHours, active)
+	if err != nil {
+		writeEPGError(w, "Failed to create EPG source: "+err.Error())
+		return
+	}
+	id, _ := result.LastInsertId()
+	if active {
+		epg.GetImporter().Trigger(int(id))
+	}
+
+	source, _ := epg.GetSource(int(id))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    source,
+		"message": "EPG source created, import started",
+	})
+}
+
+// UploadEPGSource stores an uploaded XMLTV file, plain or gzip, and imports
+// it. Without an id in the path a new source is created from the name form
+// field, otherwise the file of that source is replaced.
+func UploadEPGSource(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEPGUpload)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeEPGError(w, "file is required: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	var id int
+	if value, ok := mux.Vars(r)["id"]; ok {
+		if id, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid EPG source ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := epg.GetSource(id); err == sql.ErrNoRows {
+			http.Error(w, "EPG source not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			writeEPGError(w, "name is required")
+			return
+		}
+		// Uploaded guides are not refreshed, the interval only retries failures
+		result, err := database.DB.Exec("INSERT INTO epg_sources (name, refresh_hours) VALUES (?, 168)", name)
+		if err != nil {
+			writeEPGError(w, "Failed to create EPG source: "+err.Error())
+			return
+		}
+		lastID, _ := result.LastInsertId()
+		id = int(lastID)
+	}
+
+	path, err := epg.SaveUpload(id, file)
+	if err != nil {
+		writeEPGError(w, "Failed to save EPG file: "+err.Error())
+		return
+	}
+	database.DB.Exec("UPDATE epg_sources SET file_path = ?, url = '' WHERE id = ?", path, id)
+	epg.GetImporter().Trigger(id)
+
+	source, _ := epg.GetSource(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    source,
+		"message": "EPG file uploaded, import started",
+	})
+}
+
+// UpdateEPGSource changes the name, URL, refresh interval or state of a source
+func UpdateEPGSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid EPG source ID", http.StatusBadRequest)
+		return
+	}
+	source, err := epg.GetSource(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "EPG source not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req epgSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeEPGError(w, "Invalid request: "+err.Error())
+		return
+	}
+	message := req.validate()
+	if message == "" && req.URL != nil && *req.URL == "" && source.FilePath == "" {
+		message = "url is required for a source without an uploaded file"
+	}
+	if message != "" {
+		writeEPGError(w, message)
+		return
+	}
+
+	if req.Name != nil {
+		source.Name = strings.TrimSpace(*req.Name)
+	}
+	urlChanged := req.URL != nil && *req.URL != source.URL
+	if req.URL != nil {
+		source.URL = *req.URL
+	}
+	if req.RefreshHours != nil {
+		source.RefreshHours = *req.RefreshHours
+	}
+	if req.Active != nil {
+		source.Active = *req.Active
+	}
+	_, err = database.DB.Exec("UPDATE epg_sources SET name = ?, url = ?, refresh_hours = ?, active = ? WHERE id = ?",
+		source.Name, source.URL, source.RefreshHours, source.Active, id)
+	if err != nil {
+		writeEPGError(w, "Failed to update EPG source: "+err.Error())
+		return
+	}
+	if urlChanged && source.Active {
+		epg.GetImporter().Trigger(id)
+	}
+
+	source, _ = epg.GetSource(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    source,
+		"message": "EPG source updated",
+	})
+}
+
+// DeleteEPGSource deletes a source and its guide data
+func DeleteEPGSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid EPG source ID", http.StatusBadRequest)
+		return
+	}
+	if err := epg.DeleteSource(id); err == sql.ErrNoRows {
+		http.Error(w, "EPG source not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "EPG source deleted",
+	})
+}
+
+// ImportEPGSource imports a source now
+func ImportEPGSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid EPG source ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := epg.GetSource(id); err == sql.ErrNoRows {
+		http.Error(w, "EPG source not found", http.StatusNotFound)
+		return
+	}
+	if !epg.GetImporter().Trigger(id) {
+		writeEPGError(w, "This source is already being imported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "EPG import started",
+	})
+}
+
+// GetEPGChannels lists the guide channels, to pick the tvg-id of a panel
+// channel. search matches the id or display name.
+func GetEPGChannels(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT e.channel_id, e.display_name, e.icon, e.source_id, s.name,
+			(SELECT COUNT(*) FROM channels c WHERE c.tvg_id = e.channel_id COLLATE NOCASE)
+		FROM epg_channels e
+		JOIN epg_sources s ON s.id = e.source_id
+	`
+	var args []interface{}
+	if search := strings.TrimSpace(r.URL.Query().Get("search")); search != "" {
+		query += " WHERE e.channel_id LIKE ? OR e.display_name LIKE ?"
+		args = append(args, "%"+search+"%", "%"+search+"%")
+	}
+	query += " ORDER BY e.display_name LIMIT 500"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := []map[string]interface{}{}
+	for rows.Next() {
+		var id, name, icon, sourceName string
+		var sourceID, mapped int
+		if err := rows.Scan(&id, &name, &icon, &sourceID, &sourceName, &mapped); err != nil {
+			continue
+		}
+		channels = append(channels, map[string]interface{}{
+			"tvg_id":          id,
+			"display_name":    name,
+			"icon":            icon,
+			"source_id":       sourceID,
+			"source_name":     sourceName,
+			"mapped_channels": mapped,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": channels,
+	})
+}
+
+// channelTvgID returns the tvg-id of a channel, ok is false if the channel
+// does not exist
+func channelTvgID(channelID int) (tvgID string, ok bool, err error) {
+	err = database.DB.QueryRow("SELECT COALESCE(tvg_id, '') FROM channels WHERE id = ?", channelID).Scan(&tvgID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return tvgID, err == nil, err
+}
+
+// epgNowNext describes the current and next programme of a tvg-id, nil
+// when the channel has no tvg-id
+func epgNowNext(tvgID string) (interface{}, error) {
+	if tvgID == "" {
+		return nil, nil
+	}
+	now, next, err := epg.NowNext(tvgID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"tvg_id": tvgID,
+		"now":    now,
+		"next":   next,
+	}, nil
+}
+
+// GetChannelNowNext returns the programme airing on a channel and the one
+// after it
+func GetChannelNowNext(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	tvgID, ok, err := channelTvgID(channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if tvgID == "" {
+		writeEPGError(w, "Channel has no tvg_id, set one to link it to the EPG")
+		return
+	}
+
+	nowNext, err := epgNowNext(tvgID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": nowNext,
+	})
+}
+
+// guideTime parses a from/to parameter, unix seconds or RFC3339
+func guideTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetChannelGuide returns the programmes of a channel airing between from
+// and to (unix seconds or RFC3339), by default the next 24 hours
+func GetChannelGuide(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := guideTime(query.Get("from"), time.Now())
+	if err != nil {
+		writeEPGError(w, "from must be unix seconds or RFC3339")
+		return
+	}
+	to, err := guideTime(query.Get("to"), from.Add(24*time.Hour))
+	if err != nil {
+		writeEPGError(w, "to must be unix seconds or RFC3339")
+		return
+	}
+	if !to.After(from) || to.Sub(from) > maxGuideRange {
+		writeEPGError(w, fmt.Sprintf("to must be after from and at most %d days later", int(maxGuideRange.Hours()/24)))
+		return
+	}
+
+	tvgID, ok, err := channelTvgID(channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if tvgID == "" {
+		writeEPGError(w, "Channel has no tvg_id, set one to link it to the EPG")
+		return
+	}
+
+	programmes, err := epg.Programmes(tvgID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"channel_id": channelID,
+			"tvg_id":     tvgID,
+			"from":       from.UTC(),
+			"to":         to.UTC(),
+			"programmes": programmes,
+		},
+	})
+}
+
+// ServeUserEPG serves the XMLTV guide of the channels in a user's
+// generated playlist: /epg/{user}.xml
+func ServeUserEPG(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["user"]
+	channels, err := userChannels(username)
+	if os.IsNotExist(err) {
+		http.Error(w, "Playlist not found. Please generate playlist first.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveUserGuide(w, username, channels)
+}
+
+// serveUserGuide writes the XMLTV guide of a user's channels. Channels are
+// identified by their panel ids, like the tvg-id attributes of the user's
+// playlists.
+func serveUserGuide(w http.ResponseWriter, username string, channels []models.Channel) {
+	export := make([]epg.ExportChannel, 0, len(channels))
+	for _, ch := range channels {
+		export = append(export, epg.ExportChannel{
+			ID:    strconv.Itoa(ch.ID),
+			Name:  ch.Name,
+			Icon:  ch.Logo,
+			TvgID: ch.TvgID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=epg-%s.xml", username))
+	now := time.Now()
+	if err := epg.WriteXMLTV(w, export, now.Add(-userGuidePast), now.Add(maxGuideRange)); err != nil {
+		log.Printf("⚠️  Failed to write EPG of %s: %v", username, err)
+	}
+}
@@ -92,8 +92,8 @@ func ImportPlaylist(w http.ResponseWriter, r *http.Request) {
 
 	// Insert channels
 	for _, ch := range channels {
-		_, err := tx.Exec("INSERT INTO channels (playlist_id, name, url, logo, group_name) VALUES (?, ?, ?, ?, ?)",
-			playlistID, ch.Name, ch.URL, ch.Logo, ch.Group)
+		_, err := tx.Exec("INSERT INTO channels (playlist_id, name, url, logo, group_name, tvg_id) VALUES (?, ?, ?, ?, ?, ?)",
+			playlistID, ch.Name, ch.URL, ch.Logo, ch.Group, ch.TvgID)
 		if err != nil {
 			log.Printf("Failed to insert channel: %v", err)
 		}
@@ -242,8 +242,8 @@ func RefreshPlaylist(w http.ResponseWriter, r *http.Request) {
 	// Insert new channels
 	channelCount := 0
 	for _, ch := range channels {
-		_, err := tx.Exec("INSERT INTO channels (playlist_id, name, url, logo, group_name) VALUES (?, ?, ?, ?, ?)",
-			playlistID, ch.Name, ch.URL, ch.Logo, ch.Group)
+		_, err := tx.Exec("INSERT INTO channels (playlist_id, name, url, logo, group_name, tvg_id) VALUES (?, ?, ?, ?, ?, ?)",
+			playlistID, ch.Name, ch.URL, ch.Logo, ch.Group, ch.TvgID)
 		if err != nil {
 			log.Printf("Failed to insert channel: %v", err)
 		} else {
@@ -438,12 +438,12 @@ func StreamRelay(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !isActive {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, relayChannelID(path), streaming.StreamExpiredVideo)
 		return
 	}
 
 	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, relayChannelID(path), streaming.StreamExpiredVideo)
 		return
 	}
 
@@ -468,77 +468,72 @@ func StreamRelay(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Track user connection
-	var connectionID int64
-	if channelID.Valid {
-		result, err := database.DB.Exec(`
-			INSERT INTO user_connections (user_id, channel_id, ip_address, connected_at) 
-			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
-		`, userID, channelID.Int64, r.RemoteAddr)
-		if err == nil {
-			connectionID, _ = result.LastInsertId()
-		}
+	// Track user connection, within the user's max_connections
+	streamW, streamReq, done, ok := trackStream(w, r, userID, int(channelID.Int64))
+	if !ok {
+		return
 	}
+	defer done()
+	w, r = streamW, streamReq
 
-	// Defer closing connection
-	defer func() {
-		if connectionID > 0 {
-			database.DB.Exec("UPDATE user_connections SET disconnected_at = CURRENT_TIMESTAMP WHERE id = ?", connectionID)
-		}
-	}()
-
-	// Use FFmpeg manager for better compatibility and transcoding
-	ffmpegManager := streaming.GetFFmpegManager()
-	session := ffmpegManager.GetOrCreateFFmpegSession(path, urls, "mpegts")
-
-	// Apply per-channel on_demand flag when this relay represents a channel.
+	// Apply per-channel on_demand flag, slow client policy, transcoding
+	// profile and delivery mode when this relay represents a channel.
+	onDemandInt := -1
+	priority := 0
+	slowPolicy, channelProfile, deliveryMode := "", "", ""
 	if channelID.Valid {
-		var onDemandInt int
-		if err := database.DB.QueryRow("SELECT on_demand FROM channels WHERE id = ?", channelID.Int64).Scan(&onDemandInt); err == nil {
-			session.SetOnDemand(onDemandInt == 1)
-		}
+		database.DB.QueryRow("SELECT on_demand, slow_client_policy, transcode_profile, priority, delivery_mode FROM channels WHERE id = ?", channelID.Int64).Scan(&onDemandInt, &slowPolicy, &channelProfile, &priority, &deliveryMode)
 	}
 
-	// Generate unique client ID
-	clientID := fmt.Sprintf("%x", md5.Sum([]byte(r.RemoteAddr+r.UserAgent())))
-
-	// Add client and get data channel
-	dataChan, err := session.AddClient(clientID, r.RemoteAddr)
+	// Rewind: serve the channel's timeshift archive instead of the live stream
+	position, rewind, err := timeshiftPosition(r)
 	if err != nil {
-		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer session.RemoveClient(clientID)
-
-	// Set headers
-	w.Header().Set("Content-Type", "video/MP2T")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Stream to this client
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+	if rewind {
+		if !channelID.Valid {
+			http.Error(w, "Timeshift is only available for channels", http.StatusNotFound)
+			return
+		}
+		serveTimeshift(w, r, int(channelID.Int64), position)
 		return
 	}
 
-	// Send data to client
-	for {
-		select {
-		case data, ok := <-dataChan:
-			if !ok {
-				// Channel closed
-				return
-			}
-			if _, err := w.Write(data); err != nil {
-				return
-			}
-			flusher.Flush()
-		case <-r.Context().Done():
+	profile := resolveTranscodeProfile(userID, channelProfile)
+
+	switch deliveryEngine(deliveryMode, profile) {
+	case streaming.DeliveryRedirect:
+		if len(urls) == 0 {
+			http.Error(w, "Relay has no source", http.StatusNotFound)
 			return
 		}
+		http.Redirect(w, r, urls[0], http.StatusFound)
+		return
+	case streaming.DeliveryPassthrough:
+		servePassthrough(w, r, path, urls, int(channelID.Int64), onDemandInt != 0, slowPolicy)
+		return
+	}
+
+	// Wait for a free FFmpeg slot when the server is at max_streams
+	if !admitStream(w, r, streaming.ProfileSessionID(path, profile.Name), priority) {
+		return
+	}
+
+	// Use FFmpeg manager for better compatibility and transcoding
+	ffmpegManager := streaming.GetFFmpegManager()
+	session := ffmpegManager.GetOrCreateTranscodeSession(path, urls, "mpegts", profile)
+	if onDemandInt >= 0 {
+		session.SetOnDemand(onDemandInt == 1)
+	}
+	session.SetSlowClientPolicy(slowClientPolicy(slowPolicy))
+	session.SetPriority(priority)
+	// Only the unmodified stream describes the channel's source
+	if channelID.Valid && profile.IsPassthrough() {
+		session.SetChannelID(int(channelID.Int64))
 	}
+
+	serveMPEGTS(w, r, session)
 }
 
 // ExportM3U generates M3U playlist from database with panel proxy URLs
@@ -546,7 +541,7 @@ func ExportM3U(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	playlistID := vars["id"]
 
-	rows, err := database.DB.Query("SELECT id, name, url, logo, group_name FROM channels WHERE playlist_id = ? AND active = 1", playlistID)
+	rows, err := database.DB.Query("SELECT id, name, url, logo, group_name, tvg_id FROM channels WHERE playlist_id = ? AND active = 1", playlistID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -562,12 +557,15 @@ func ExportM3U(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var channelID int
-		var name, url, logo, group string
-		if err := rows.Scan(&channelID, &name, &url, &logo, &group); err != nil {
+		var name, url, logo, group, tvgID string
+		if err := rows.Scan(&channelID, &name, &url, &logo, &group, &tvgID); err != nil {
 			continue
 		}
 
 		info := "#EXTINF:-1"
+		if tvgID != "" {
+			info += " tvg-id=\"" + tvgID + "\""
+		}
 		if logo != "" {
 			info += " tvg-logo=\"" + logo + "\""
 		}
@@ -803,9 +801,11 @@ func SearchChannels(w http.ResponseWriter, r *http.Request) {
 	if query == "" {
 		// If no query, return all active channels with playlist info
 		rows, err = database.DB.Query(`
-			SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.created_at, p.name as playlist_name
+			SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.slow_client_policy, c.transcode_profile, c.abr_ladder, c.priority, c.delivery_mode, c.timeshift_hours, c.tvg_id, c.created_at, p.name as playlist_name,
+				m.video_codec, m.width, m.height, m.audio_tracks, m.languages, c.health_status, c.health_checked_at
 			FROM channels c
 			LEFT JOIN playlists p ON c.playlist_id = p.id
+			LEFT JOIN channel_metadata m ON m.channel_id = c.id
 			WHERE c.active = 1 
 			ORDER BY c.created_at DESC 
 			LIMIT 5000
@@ -813,9 +813,11 @@ func SearchChannels(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// If query provided, search by name
 		rows, err = database.DB.Query(`
-			SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.created_at, p.name as playlist_name
+			SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.slow_client_policy, c.transcode_profile, c.abr_ladder, c.priority, c.delivery_mode, c.timeshift_hours, c.tvg_id, c.created_at, p.name as playlist_name,
+				m.video_codec, m.width, m.height, m.audio_tracks, m.languages, c.health_status, c.health_checked_at
 			FROM channels c
 			LEFT JOIN playlists p ON c.playlist_id = p.id
+			LEFT JOIN channel_metadata m ON m.channel_id = c.id
 			WHERE c.name LIKE ? AND c.active = 1 
 			ORDER BY c.created_at DESC 
 			LIMIT 5000
@@ -832,28 +834,53 @@ func SearchChannels(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var c models.Channel
 		var playlistName sql.NullString
-		if err := rows.Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.CreatedAt, &playlistName); err != nil {
+		var videoCodec, languages sql.NullString
+		var width, height, audioTracks sql.NullInt64
+		var healthStatus sql.NullString
+		var healthCheckedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.SlowClientPolicy, &c.TranscodeProfile, &c.ABRLadder, &c.Priority, &c.DeliveryMode, &c.TimeshiftHours, &c.TvgID, &c.CreatedAt, &playlistName,
+			&videoCodec, &width, &height, &audioTracks, &languages, &healthStatus, &healthCheckedAt); err != nil {
 			continue
 		}
 
 		channel := map[string]interface{}{
-			"id":            c.ID,
-			"playlist_id":   c.PlaylistID,
-			"name":          c.Name,
-			"url":           c.URL,
-			"logo":          c.Logo,
-			"category":      c.Group,
-			"group_name":    c.Group,
-			"enabled":       c.Active,
-			"active":        c.Active,
-			"on_demand":     c.OnDemand,
-			"created_at":    c.CreatedAt,
-			"playlist_name": "",
+			"id":                 c.ID,
+			"playlist_id":        c.PlaylistID,
+			"name":               c.Name,
+			"url":                c.URL,
+			"logo":               c.Logo,
+			"category":           c.Group,
+			"group_name":         c.Group,
+			"enabled":            c.Active,
+			"active":             c.Active,
+			"on_demand":          c.OnDemand,
+			"slow_client_policy": c.SlowClientPolicy,
+			"transcode_profile":  c.TranscodeProfile,
+			"abr_ladder":         c.ABRLadder,
+			"priority":           c.Priority,
+			"delivery_mode":      c.DeliveryMode,
+			"timeshift_hours":    c.TimeshiftHours,
+			"tvg_id":             c.TvgID,
+			"created_at":         c.CreatedAt,
+			"playlist_name":      "",
+			"video_codec":        videoCodec.String,
+			"resolution":         "",
+			"audio_tracks":       audioTracks.Int64,
+			"languages":          languages.String,
+			"health_status":      healthStatus.String,
+			"dead":               healthStatus.String == "down",
+			"health_checked_at":  nil,
 		}
 
 		if playlistName.Valid {
 			channel["playlist_name"] = playlistName.String
 		}
+		if healthCheckedAt.Valid {
+			channel["health_checked_at"] = healthCheckedAt.Time
+		}
+		if width.Int64 > 0 && height.Int64 > 0 {
+			channel["resolution"] = fmt.Sprintf("%dx%d", width.Int64, height.Int64)
+		}
 
 		channels = append(channels, channel)
 	}
@@ -868,12 +895,19 @@ func SearchChannels(w http.ResponseWriter, r *http.Request) {
 // CreateChannel creates a new channel
 func CreateChannel(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		PlaylistID int    `json:"playlist_id"`
-		Name       string `json:"name"`
-		URL        string `json:"url"`
-		Logo       string `json:"logo"`
-		GroupName  string `json:"group_name"`
-		OnDemand   *bool  `json:"on_demand"`
+		PlaylistID       int    `json:"playlist_id"`
+		Name             string `json:"name"`
+		URL              string `json:"url"`
+		Logo             string `json:"logo"`
+		GroupName        string `json:"group_name"`
+		OnDemand         *bool  `json:"on_demand"`
+		SlowClientPolicy string `json:"slow_client_policy"`
+		TranscodeProfile string `json:"transcode_profile"`
+		ABRLadder        string `json:"abr_ladder"`
+		Priority         int    `json:"priority"`
+		DeliveryMode     string `json:"delivery_mode"`
+		TimeshiftHours   int    `json:"timeshift_hours"`
+		TvgID            string `json:"tvg_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -894,6 +928,64 @@ func CreateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.SlowClientPolicy != "" && !streaming.ValidSlowClientMode(req.SlowClientPolicy) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "slow_client_policy must be skip, disconnect or backlog",
+		})
+		return
+	}
+
+	if req.TranscodeProfile != "" && !transcodeProfileExists(req.TranscodeProfile) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Unknown transcode_profile: " + req.TranscodeProfile,
+		})
+		return
+	}
+
+	if req.ABRLadder != "" {
+		if _, err := loadABRLadder(req.ABRLadder); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Invalid abr_ladder: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.Priority < 0 || req.Priority > maxChannelPriority {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": fmt.Sprintf("priority must be between 0 and %d", maxChannelPriority),
+		})
+		return
+	}
+
+	if req.DeliveryMode == "" {
+		req.DeliveryMode = streaming.DeliveryFFmpeg
+	} else if !streaming.ValidDeliveryMode(req.DeliveryMode) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "delivery_mode must be ffmpeg-remux, go-passthrough or redirect",
+		})
+		return
+	}
+
+	if req.TimeshiftHours < 0 || req.TimeshiftHours > streaming.MaxTimeshiftHours {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": fmt.Sprintf("timeshift_hours must be between 0 and %d", streaming.MaxTimeshiftHours),
+		})
+		return
+	}
+
 	// Default on_demand to true if not specified
 	onDemand := 1
 	if req.OnDemand != nil && !*req.OnDemand {
@@ -901,8 +993,8 @@ func CreateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	result, err := database.DB.Exec(
-		"INSERT INTO channels (playlist_id, name, url, logo, group_name, active, on_demand) VALUES (?, ?, ?, ?, ?, 1, ?)",
-		req.PlaylistID, req.Name, req.URL, req.Logo, req.GroupName, onDemand,
+		"INSERT INTO channels (playlist_id, name, url, logo, group_name, active, on_demand, slow_client_policy, transcode_profile, abr_ladder, priority, delivery_mode, timeshift_hours, tvg_id) VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?)",
+		req.PlaylistID, req.Name, req.URL, req.Logo, req.GroupName, onDemand, req.SlowClientPolicy, req.TranscodeProfile, req.ABRLadder, req.Priority, req.DeliveryMode, req.TimeshiftHours, strings.TrimSpace(req.TvgID),
 	)
 
 	if err != nil {
@@ -920,11 +1012,11 @@ func CreateChannel(w http.ResponseWriter, r *http.Request) {
 	var c models.Channel
 	var playlistName sql.NullString
 	err = database.DB.QueryRow(`
-		SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.created_at, p.name as playlist_name
+		SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.slow_client_policy, c.transcode_profile, c.abr_ladder, c.priority, c.delivery_mode, c.timeshift_hours, c.tvg_id, c.created_at, p.name as playlist_name
 		FROM channels c
 		LEFT JOIN playlists p ON c.playlist_id = p.id
 		WHERE c.id = ?
-	`, channelID).Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.CreatedAt, &playlistName)
+	`, channelID).Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.SlowClientPolicy, &c.TranscodeProfile, &c.ABRLadder, &c.Priority, &c.DeliveryMode, &c.TimeshiftHours, &c.TvgID, &c.CreatedAt, &playlistName)
 
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -936,18 +1028,25 @@ func CreateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channel := map[string]interface{}{
-		"id":            c.ID,
-		"playlist_id":   c.PlaylistID,
-		"name":          c.Name,
-		"url":           c.URL,
-		"logo":          c.Logo,
-		"category":      c.Group,
-		"group_name":    c.Group,
-		"enabled":       c.Active,
-		"active":        c.Active,
-		"on_demand":     c.OnDemand,
-		"created_at":    c.CreatedAt,
-		"playlist_name": "",
+		"id":                 c.ID,
+		"playlist_id":        c.PlaylistID,
+		"name":               c.Name,
+		"url":                c.URL,
+		"logo":               c.Logo,
+		"category":           c.Group,
+		"group_name":         c.Group,
+		"enabled":            c.Active,
+		"active":             c.Active,
+		"on_demand":          c.OnDemand,
+		"slow_client_policy": c.SlowClientPolicy,
+		"transcode_profile":  c.TranscodeProfile,
+		"abr_ladder":         c.ABRLadder,
+		"priority":           c.Priority,
+		"delivery_mode":      c.DeliveryMode,
+		"timeshift_hours":    c.TimeshiftHours,
+		"tvg_id":             c.TvgID,
+		"created_at":         c.CreatedAt,
+		"playlist_name":      "",
 	}
 
 	if playlistName.Valid {
@@ -968,11 +1067,18 @@ func UpdateChannel(w http.ResponseWriter, r *http.Request) {
 	channelID := vars["id"]
 
 	var req struct {
-		Name      string `json:"name"`
-		URL       string `json:"url"`
-		Logo      string `json:"logo"`
-		GroupName string `json:"group_name"`
-		OnDemand  *bool  `json:"on_demand"`
+		Name             string  `json:"name"`
+		URL              string  `json:"url"`
+		Logo             string  `json:"logo"`
+		GroupName        string  `json:"group_name"`
+		OnDemand         *bool   `json:"on_demand"`
+		SlowClientPolicy *string `json:"slow_client_policy"`
+		TranscodeProfile *string `json:"transcode_profile"`
+		ABRLadder        *string `json:"abr_ladder"`
+		Priority         *int    `json:"priority"`
+		DeliveryMode     *string `json:"delivery_mode"`
+		TimeshiftHours   *int    `json:"timeshift_hours"`
+		TvgID            *string `json:"tvg_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -993,6 +1099,62 @@ func UpdateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.SlowClientPolicy != nil && *req.SlowClientPolicy != "" && !streaming.ValidSlowClientMode(*req.SlowClientPolicy) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "slow_client_policy must be skip, disconnect or backlog",
+		})
+		return
+	}
+
+	if req.TranscodeProfile != nil && *req.TranscodeProfile != "" && !transcodeProfileExists(*req.TranscodeProfile) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Unknown transcode_profile: " + *req.TranscodeProfile,
+		})
+		return
+	}
+
+	if req.ABRLadder != nil && *req.ABRLadder != "" {
+		if _, err := loadABRLadder(*req.ABRLadder); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Invalid abr_ladder: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.Priority != nil && (*req.Priority < 0 || *req.Priority > maxChannelPriority) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": fmt.Sprintf("priority must be between 0 and %d", maxChannelPriority),
+		})
+		return
+	}
+
+	if req.DeliveryMode != nil && !streaming.ValidDeliveryMode(*req.DeliveryMode) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "delivery_mode must be ffmpeg-remux, go-passthrough or redirect",
+		})
+		return
+	}
+
+	if req.TimeshiftHours != nil && (*req.TimeshiftHours < 0 || *req.TimeshiftHours > streaming.MaxTimeshiftHours) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": fmt.Sprintf("timeshift_hours must be between 0 and %d", streaming.MaxTimeshiftHours),
+		})
+		return
+	}
+
 	// Build update query
 	if req.OnDemand != nil {
 		onDemand := 0
@@ -1026,15 +1188,92 @@ func UpdateChannel(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if req.SlowClientPolicy != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET slow_client_policy = ? WHERE id = ?", *req.SlowClientPolicy, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TranscodeProfile != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET transcode_profile = ? WHERE id = ?", *req.TranscodeProfile, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.ABRLadder != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET abr_ladder = ? WHERE id = ?", *req.ABRLadder, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.Priority != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET priority = ? WHERE id = ?", *req.Priority, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.DeliveryMode != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET delivery_mode = ? WHERE id = ?", *req.DeliveryMode, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TimeshiftHours != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET timeshift_hours = ? WHERE id = ?", *req.TimeshiftHours, channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TvgID != nil {
+		if _, err := database.DB.Exec("UPDATE channels SET tvg_id = ? WHERE id = ?", strings.TrimSpace(*req.TvgID), channelID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update channel: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	// Get the updated channel with playlist info
 	var c models.Channel
 	var playlistName sql.NullString
 	err := database.DB.QueryRow(`
-		SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.created_at, p.name as playlist_name
+		SELECT c.id, c.playlist_id, c.name, c.url, c.logo, c.group_name, c.active, c.on_demand, c.slow_client_policy, c.transcode_profile, c.abr_ladder, c.priority, c.delivery_mode, c.timeshift_hours, c.tvg_id, c.created_at, p.name as playlist_name
 		FROM channels c
 		LEFT JOIN playlists p ON c.playlist_id = p.id
 		WHERE c.id = ?
-	`, channelID).Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.CreatedAt, &playlistName)
+	`, channelID).Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand, &c.SlowClientPolicy, &c.TranscodeProfile, &c.ABRLadder, &c.Priority, &c.DeliveryMode, &c.TimeshiftHours, &c.TvgID, &c.CreatedAt, &playlistName)
 
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -1045,36 +1284,68 @@ func UpdateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If on_demand was updated, apply it immediately to any active stream sessions.
-	if req.OnDemand != nil {
-		ffmpegManager := streaming.GetFFmpegManager()
-		if session := ffmpegManager.GetSession(fmt.Sprintf("channel_%d", c.ID)); session != nil {
+	// Apply on_demand, slow client policy and priority changes immediately to any active
+	// stream sessions, including those encoding the channel with a profile.
+	// A new transcode_profile, abr_ladder or delivery_mode applies to clients
+	// connecting afterwards.
+	ffmpegManager := streaming.GetFFmpegManager()
+	var sessions []*streaming.FFmpegSession
+	for _, sessionID := range []string{
+		fmt.Sprintf("channel_%d", c.ID),
+		fmt.Sprintf("channel_%d_hls", c.ID),
+		fmt.Sprintf("channel-%d", c.ID),
+		fmt.Sprintf("channel-%d_hls", c.ID),
+	} {
+		sessions = append(sessions, ffmpegManager.GetSessionVariants(sessionID)...)
+	}
+	for _, session := range sessions {
+		if req.OnDemand != nil {
 			session.SetOnDemand(*req.OnDemand)
 		}
-		if session := ffmpegManager.GetSession(fmt.Sprintf("channel_%d_hls", c.ID)); session != nil {
-			session.SetOnDemand(*req.OnDemand)
+		if req.SlowClientPolicy != nil {
+			session.SetSlowClientPolicy(slowClientPolicy(*req.SlowClientPolicy))
 		}
-		if session := ffmpegManager.GetSession(fmt.Sprintf("channel-%d", c.ID)); session != nil {
-			session.SetOnDemand(*req.OnDemand)
+		if req.Priority != nil {
+			session.SetPriority(*req.Priority)
+		}
+	}
+	for _, sessionID := range []string{fmt.Sprintf("channel_%d", c.ID), fmt.Sprintf("channel-%d", c.ID)} {
+		session := streaming.GetManager().GetSession(sessionID)
+		if session == nil {
+			continue
 		}
-		if session := ffmpegManager.GetSession(fmt.Sprintf("channel-%d_hls", c.ID)); session != nil {
+		if req.OnDemand != nil {
 			session.SetOnDemand(*req.OnDemand)
 		}
+		if req.SlowClientPolicy != nil {
+			session.SetSlowClientPolicy(slowClientPolicy(*req.SlowClientPolicy))
+		}
 	}
 
+	// Start or stop recording the channel's timeshift archive, and keep a
+	// recorded session running despite on_demand
+	SyncTimeshift()
+
 	channel := map[string]interface{}{
-		"id":            c.ID,
-		"playlist_id":   c.PlaylistID,
-		"name":          c.Name,
-		"url":           c.URL,
-		"logo":          c.Logo,
-		"category":      c.Group,
-		"group_name":    c.Group,
-		"enabled":       c.Active,
-		"active":        c.Active,
-		"on_demand":     c.OnDemand,
-		"created_at":    c.CreatedAt,
-		"playlist_name": "",
+		"id":                 c.ID,
+		"playlist_id":        c.PlaylistID,
+		"name":               c.Name,
+		"url":                c.URL,
+		"logo":               c.Logo,
+		"category":           c.Group,
+		"group_name":         c.Group,
+		"enabled":            c.Active,
+		"active":             c.Active,
+		"on_demand":          c.OnDemand,
+		"slow_client_policy": c.SlowClientPolicy,
+		"transcode_profile":  c.TranscodeProfile,
+		"abr_ladder":         c.ABRLadder,
+		"priority":           c.Priority,
+		"delivery_mode":      c.DeliveryMode,
+		"timeshift_hours":    c.TimeshiftHours,
+		"tvg_id":             c.TvgID,
+		"created_at":         c.CreatedAt,
+		"playlist_name":      "",
 	}
 
 	if playlistName.Valid {
@@ -1299,19 +1570,22 @@ func ProxyChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !isActive {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, channelID, streaming.StreamExpiredVideo)
 		return
 	}
 
 	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, channelID, streaming.StreamExpiredVideo)
 		return
 	}
 
 	var url string
 	var active int
 	var onDemandInt int
-	err = database.DB.QueryRow("SELECT url, active, on_demand FROM channels WHERE id = ?", channelID).Scan(&url, &active, &onDemandInt)
+	var priority int
+	var timeshiftHours int
+	var slowPolicy, channelProfile, deliveryMode string
+	err = database.DB.QueryRow("SELECT url, active, on_demand, slow_client_policy, transcode_profile, priority, delivery_mode, timeshift_hours FROM channels WHERE id = ?", channelID).Scan(&url, &active, &onDemandInt, &slowPolicy, &channelProfile, &priority, &deliveryMode, &timeshiftHours)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Channel not found", http.StatusNotFound)
 		return
@@ -1325,48 +1599,57 @@ func ProxyChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use FFmpeg manager for consistent proxying
-	ffmpegManager := streaming.GetFFmpegManager()
-	sessionID := fmt.Sprintf("channel_%d", channelID)
-	session := ffmpegManager.GetOrCreateFFmpegSession(sessionID, []string{url}, "mpegts")
-	session.SetOnDemand(onDemandInt == 1)
+	// Track user connection, within the user's max_connections
+	streamW, streamReq, done, ok := trackStream(w, r, userID, channelID)
+	if !ok {
+		return
+	}
+	defer done()
+	w, r = streamW, streamReq
 
-	clientID := fmt.Sprintf("%x", md5.Sum([]byte(r.RemoteAddr+r.UserAgent())))
-	dataChan, err := session.AddClient(clientID, r.RemoteAddr)
+	// Rewind: serve from the timeshift archive instead of the live stream
+	position, rewind, err := timeshiftPosition(r)
 	if err != nil {
-		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rewind {
+		serveTimeshift(w, r, channelID, position)
 		return
 	}
-	defer session.RemoveClient(clientID)
 
-	w.Header().Set("Content-Type", "video/MP2T")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	sessionID := fmt.Sprintf("channel_%d", channelID)
+	profile := resolveTranscodeProfile(userID, channelProfile)
+	// The unmodified stream keeps running while it records the archive
+	onDemand := onDemandInt == 1 && (timeshiftHours == 0 || !profile.IsPassthrough())
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+	switch deliveryEngine(deliveryMode, profile) {
+	case streaming.DeliveryRedirect:
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	case streaming.DeliveryPassthrough:
+		servePassthrough(w, r, sessionID, []string{url}, channelID, onDemand, slowPolicy)
 		return
 	}
 
-	for {
-		select {
-		case data, ok := <-dataChan:
-			if !ok {
-				return
-			}
-			if _, err := w.Write(data); err != nil {
-				return
-			}
-			flusher.Flush()
-		case <-r.Context().Done():
-			return
-		}
+	// Use FFmpeg manager for consistent proxying
+	ffmpegManager := streaming.GetFFmpegManager()
+	if !admitStream(w, r, streaming.ProfileSessionID(sessionID, profile.Name), priority) {
+		return
+	}
+	session := ffmpegManager.GetOrCreateTranscodeSession(sessionID, []string{url}, "mpegts", profile)
+	session.SetOnDemand(onDemand)
+	session.SetSlowClientPolicy(slowClientPolicy(slowPolicy))
+	session.SetPriority(priority)
+	// Only the unmodified stream describes the channel's source
+	if profile.IsPassthrough() {
+		session.SetChannelID(channelID)
 	}
+
+	serveMPEGTS(w, r, session)
 }
 
-// StreamRelayHLS serves HLS stream for relay via FFmpeg transcoding
+// StreamRelayHLS serves the HLS playlist for a relay, produced by a shared FFmpeg HLS session
 func StreamRelayHLS(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	path := vars["path"]
@@ -1401,12 +1684,12 @@ func StreamRelayHLS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !isActive {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, relayChannelID(path), streaming.StreamExpiredVideo)
 		return
 	}
 
 	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, relayChannelID(path), streaming.StreamExpiredVideo)
 		return
 	}
 
@@ -1428,111 +1711,86 @@ func StreamRelayHLS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use FFmpeg to transcode to HLS format
-	ffmpegManager := streaming.GetFFmpegManager()
-	sessionID := path + "_hls"
-	session := ffmpegManager.GetOrCreateFFmpegSession(sessionID, urls, "hls")
-
-	// Apply per-channel on_demand flag when this relay represents a channel.
+	// Apply per-channel on_demand flag, transcoding profile and ABR ladder when this relay represents a channel.
+	onDemandInt := -1
+	priority := 0
+	channelID := 0
+	channelProfile, channelLadder := "", ""
 	if strings.HasPrefix(path, "channel-") {
 		if id, err := strconv.Atoi(strings.TrimPrefix(path, "channel-")); err == nil {
-			var onDemandInt int
-			if err := database.DB.QueryRow("SELECT on_demand FROM channels WHERE id = ?", id).Scan(&onDemandInt); err == nil {
-				session.SetOnDemand(onDemandInt == 1)
-			}
+			channelID = id
+			database.DB.QueryRow("SELECT on_demand, transcode_profile, abr_ladder, priority FROM channels WHERE id = ?", id).Scan(&onDemandInt, &channelProfile, &channelLadder, &priority)
 		}
 	}
+	ladder := resolveABRLadder(userID, channelLadder)
 
-	clientID := fmt.Sprintf("%x", md5.Sum([]byte(r.RemoteAddr+r.UserAgent())))
-	dataChan, err := session.AddClient(clientID, r.RemoteAddr)
-	if err != nil {
-		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
-		return
-	}
-	defer session.RemoveClient(clientID)
-
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	flusher, ok := w.(http.Flusher)
+	// Count the player against the user's max_connections by its session id
+	release, ok := trackHLSViewer(w, r, userID, channelID, ladder != nil)
 	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
-	for {
-		select {
-		case data, ok := <-dataChan:
-			if !ok {
-				return
-			}
-			if _, err := w.Write(data); err != nil {
-				return
-			}
-			flusher.Flush()
-		case <-r.Context().Done():
+	// Use FFmpeg to transcode to HLS format. A channel with an ABR ladder is
+	// served a master playlist from one FFmpeg shared by all its viewers.
+	ffmpegManager := streaming.GetFFmpegManager()
+	sessionID := path + "_hls"
+	var session *streaming.FFmpegSession
+	profileName := ""
+	if ladder != nil {
+		if !admitStream(w, r, streaming.ProfileSessionID(sessionID, streaming.ABRProfile), priority) {
+			release()
+			return
+		}
+		session = ffmpegManager.GetOrCreateABRSession(sessionID, urls, ladder)
+	} else {
+		profile := resolveTranscodeProfile(userID, channelProfile)
+		if !admitStream(w, r, streaming.ProfileSessionID(sessionID, profile.Name), priority) {
+			release()
 			return
 		}
+		session = ffmpegManager.GetOrCreateTranscodeSession(sessionID, urls, "hls", profile)
+		profileName = profile.Name
+	}
+	if onDemandInt >= 0 {
+		session.SetOnDemand(onDemandInt == 1)
+	}
+	session.SetPriority(priority)
+
+	clientID := playbackID(r)
+	if err := session.TouchClient(clientID, r.RemoteAddr, r.UserAgent()); err != nil {
+		release()
+		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+
+	serveHLSPlaylist(w, r, session, "/stream/"+path+"/hls/", profileName)
 }
 
-// StreamRelayHLSSegment serves HLS segments (currently redirects to source)
+// StreamRelayHLSSegment serves HLS segments written by the relay's FFmpeg HLS session
 func StreamRelayHLSSegment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	path := vars["path"]
 	segment := vars["segment"]
 
-	// Get relay source URLs
-	var sourceURLs string
-	err := database.DB.QueryRow("SELECT source_urls FROM relays WHERE output_path = ? AND active = 1", path).Scan(&sourceURLs)
-	if err != nil {
-		http.Error(w, "Relay not found", http.StatusNotFound)
+	viewer, ok := checkHLSViewer(w, r)
+	if !ok {
 		return
 	}
 
-	var urls []string
-	json.Unmarshal([]byte(sourceURLs), &urls)
-
-	if len(urls) > 0 {
-		// Try to construct segment URL from base URL
-		baseURL := urls[0]
-		lastSlash := len(baseURL) - 1
-		for i := len(baseURL) - 1; i >= 0; i-- {
-			if baseURL[i] == '/' {
-				lastSlash = i
-				break
-			}
-		}
-		segmentURL := baseURL[:lastSlash+1] + segment
-
-		// Proxy the segment
-		resp, err := http.Get(segmentURL)
-		if err != nil {
-			http.Error(w, "Failed to fetch segment", http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
-
-		w.Header().Set("Content-Type", "video/MP2T")
-		w.Header().Set("Cache-Control", "max-age=10")
-		w.WriteHeader(resp.StatusCode)
-
-		buffer := make([]byte, 32*1024)
-		for {
-			n, err := resp.Body.Read(buffer)
-			if n > 0 {
-				w.Write(buffer[:n])
-			}
-			if err != nil {
-				break
-			}
-		}
+	session := streaming.GetFFmpegManager().GetSession(streaming.ProfileSessionID(path+"_hls", r.URL.Query().Get("profile")))
+	if session == nil {
+		http.Error(w, "Stream not found or inactive", http.StatusNotFound)
 		return
 	}
 
-	http.Error(w, "Segment not found", http.StatusNotFound)
+	serveHLSSegment(w, r, session, segment, viewer)
+}
+
+// StreamRelayHLSVariant serves the playlist and segments of one ABR variant
+// listed in the relay's master playlist
+func StreamRelayHLSVariant(w http.ResponseWriter, r *http.Request) {
+	path := mux.Vars(r)["path"]
+	serveHLSVariant(w, r, path+"_hls", "/stream/"+path+"/hls/")
 }
 
 // GetStreamStatus returns status of all active streams (FFmpeg sessions)
@@ -1562,6 +1820,26 @@ func GetStreamStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Channels delivered by the Go passthrough engine
+	for _, session := range streaming.GetManager().GetAllSessions() {
+		stats := session.GetStats()
+		if session.IsActive() && (session.GetClientCount() > 0 || !session.IsOnDemand()) {
+			status = append(status, stats)
+			totalBytesRead += stats["bytes_read"].(uint64)
+			totalBytesWritten += stats["bytes_written"].(uint64)
+		}
+	}
+
+	// Relays segmented into HLS in Go
+	for _, session := range streaming.GetHLSManager().GetAllSessions() {
+		if session.GetClientCount() > 0 {
+			stats := session.GetStats()
+			status = append(status, stats)
+			totalBytesRead += stats["bytes_read"].(uint64)
+			totalBytesWritten += stats["bytes_written"].(uint64)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"code": 0,
@@ -1570,6 +1848,7 @@ func GetStreamStatus(w http.ResponseWriter, r *http.Request) {
 			"streams":           status,
 			"total_bytes_read":  totalBytesRead,
 			"total_bytes_write": totalBytesWritten,
+			"admission":         ffmpegManager.AdmissionStats(),
 		},
 	})
 }
@@ -1580,18 +1859,65 @@ func GetStreamStatusByID(w http.ResponseWriter, r *http.Request) {
 	streamID := vars["id"]
 
 	ffmpegManager := streaming.GetFFmpegManager()
-	session := ffmpegManager.GetSession(streamID)
+	if session := ffmpegManager.GetSession(streamID); session != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session.GetStats())
+		return
+	}
+
+	if session := streaming.GetManager().GetSession(streamID); session != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session.GetStats())
+		return
+	}
+
+	http.Error(w, "Stream not found or inactive", http.StatusNotFound)
+}
 
+// GetBlacklistedStreams lists sessions refusing clients because all sources failed
+func GetBlacklistedStreams(w http.ResponseWriter, r *http.Request) {
+	sessions := streaming.GetFFmpegManager().GetBlacklistedSessions()
+
+	blacklisted := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		blacklisted = append(blacklisted, session.GetBlacklistInfo())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": blacklisted,
+	})
+}
+
+// UnblacklistStream clears the blacklist of a stream so clients can connect again
+func UnblacklistStream(w http.ResponseWriter, r *http.Request) {
+	streamID := mux.Vars(r)["id"]
+
+	session := streaming.GetFFmpegManager().GetSession(streamID)
 	if session == nil {
 		http.Error(w, "Stream not found or inactive", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(session.GetStats())
+	if !session.Unblacklist() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Stream is not blacklisted",
+		})
+		return
+	}
+
+	log.Printf("🔓 Admin cleared blacklist for stream %s", streamID)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "Stream unblacklisted",
+	})
 }
 
-// ProxyChannelHLS serves channel as HLS stream via FFmpeg
+// ProxyChannelHLS serves the HLS playlist for a channel, produced by a shared FFmpeg HLS session,
+// or the master playlist of its ABR ladder
 func ProxyChannelHLS(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	channelIDStr := vars["id"]
@@ -1631,19 +1957,21 @@ func ProxyChannelHLS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !isActive {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, channelID, streaming.StreamExpiredVideo)
 		return
 	}
 
 	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
-		ServeExpiredImage(w, r)
+		serveSlate(w, r, userID, channelID, streaming.StreamExpiredVideo)
 		return
 	}
 
 	var url string
 	var active int
 	var onDemandInt int
-	err = database.DB.QueryRow("SELECT url, active, on_demand FROM channels WHERE id = ?", channelID).Scan(&url, &active, &onDemandInt)
+	var priority int
+	var channelProfile, channelLadder string
+	err = database.DB.QueryRow("SELECT url, active, on_demand, transcode_profile, abr_ladder, priority FROM channels WHERE id = ?", channelID).Scan(&url, &active, &onDemandInt, &channelProfile, &channelLadder, &priority)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Channel not found", http.StatusNotFound)
 		return
@@ -1657,45 +1985,46 @@ func ProxyChannelHLS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use FFmpeg to transcode to HLS format
-	ffmpegManager := streaming.GetFFmpegManager()
-	sessionID := fmt.Sprintf("channel_%d_hls", channelID)
-	session := ffmpegManager.GetOrCreateFFmpegSession(sessionID, []string{url}, "hls")
-	session.SetOnDemand(onDemandInt == 1)
-
-	clientID := fmt.Sprintf("%x", md5.Sum([]byte(r.RemoteAddr+r.UserAgent())))
-	dataChan, err := session.AddClient(clientID, r.RemoteAddr)
-	if err != nil {
-		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
-		return
-	}
-	defer session.RemoveClient(clientID)
-
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	ladder := resolveABRLadder(userID, channelLadder)
 
-	flusher, ok := w.(http.Flusher)
+	// Count the player against the user's max_connections by its session id
+	release, ok := trackHLSViewer(w, r, userID, channelID, ladder != nil)
 	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
-	for {
-		select {
-		case data, ok := <-dataChan:
-			if !ok {
-				return
-			}
-			if _, err := w.Write(data); err != nil {
-				return
-			}
-			flusher.Flush()
-		case <-r.Context().Done():
+	// Use FFmpeg to transcode to HLS format. A channel with an ABR ladder is
+	// served a master playlist from one FFmpeg shared by all its viewers.
+	ffmpegManager := streaming.GetFFmpegManager()
+	sessionID := fmt.Sprintf("channel_%d_hls", channelID)
+	var session *streaming.FFmpegSession
+	profileName := ""
+	if ladder != nil {
+		if !admitStream(w, r, streaming.ProfileSessionID(sessionID, streaming.ABRProfile), priority) {
+			release()
 			return
 		}
+		session = ffmpegManager.GetOrCreateABRSession(sessionID, []string{url}, ladder)
+	} else {
+		profile := resolveTranscodeProfile(userID, channelProfile)
+		if !admitStream(w, r, streaming.ProfileSessionID(sessionID, profile.Name), priority) {
+			release()
+			return
+		}
+		session = ffmpegManager.GetOrCreateTranscodeSession(sessionID, []string{url}, "hls", profile)
+		profileName = profile.Name
+	}
+	session.SetOnDemand(onDemandInt == 1)
+	session.SetPriority(priority)
+
+	clientID := playbackID(r)
+	if err := session.TouchClient(clientID, r.RemoteAddr, r.UserAgent()); err != nil {
+		release()
+		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+
+	serveHLSPlaylist(w, r, session, fmt.Sprintf("/api/proxy/channel/%d/hls/", channelID), profileName)
 }
 
 // SaveGeneratedPlaylist saves a generated M3U playlist to static/playlists directory
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"iptv-panel/database"
+	"iptv-panel/health"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// uptimeColumns counts the checks of the last 24 hours and 7 days and how
+// many of them found the channel up
+const uptimeColumns = `
+	COALESCE(SUM(CASE WHEN h.checked_at >= datetime('now', '-1 day') THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN h.checked_at >= datetime('now', '-1 day') AND h.status = 'up' THEN 1 ELSE 0 END), 0),
+	COUNT(h.id),
+	COALESCE(SUM(CASE WHEN h.status = 'up' THEN 1 ELSE 0 END), 0)`
+
+// uptime returns the percentage of checks that were up, nil without checks
+func uptime(up, total int) interface{} {
+	if total == 0 {
+		return nil
+	}
+	return float64(up*10000/total) / 100
+}
+
+// GetChannelsHealth returns the health and 24h/7d uptime of every active channel
+func GetChannelsHealth(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.DB.Query(`
+		SELECT c.id, c.name, c.group_name, c.health_status, c.health_checked_at,` + uptimeColumns + `
+		FROM channels c
+		LEFT JOIN channel_health h ON h.channel_id = c.id AND h.checked_at >= datetime('now', '-7 days')
+		WHERE c.active = 1
+		GROUP BY c.id
+		ORDER BY c.name
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := []map[string]interface{}{}
+	summary := map[string]int{health.StatusUp: 0, health.StatusDown: 0, "unchecked": 0}
+	for rows.Next() {
+		var id, checks24h, up24h, checks7d, up7d int
+		var name string
+		var group, status sql.NullString
+		var checkedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &group, &status, &checkedAt, &checks24h, &up24h, &checks7d, &up7d); err != nil {
+			continue
+		}
+
+		channel := map[string]interface{}{
+			"id":            id,
+			"name":          name,
+			"group_name":    group.String,
+			"health_status": status.String,
+			"checked_at":    nil,
+			"uptime_24h":    uptime(up24h, checks24h),
+			"uptime_7d":     uptime(up7d, checks7d),
+			"checks_24h":    checks24h,
+			"checks_7d":     checks7d,
+		}
+		if checkedAt.Valid {
+			channel["checked_at"] = checkedAt.Time
+		}
+		if status.String == "" {
+			summary["unchecked"]++
+		} else {
+			summary[status.String]++
+		}
+		channels = append(channels, channel)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"checker":  health.GetChecker().Status(),
+			"summary":  summary,
+			"channels": channels,
+		},
+	})
+}
+
+// GetChannelHealth returns the uptime and recent check results of a channel
+func GetChannelHealth(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 2000 {
+		limit = n
+	}
+
+	var name string
+	var status sql.NullString
+	var checks24h, up24h, checks7d, up7d int
+	err = database.DB.QueryRow(`
+		SELECT c.name, c.health_status,`+uptimeColumns+`
+		FROM channels c
+		LEFT JOIN channel_health h ON h.channel_id = c.id AND h.checked_at >= datetime('now', '-7 days')
+		WHERE c.id = ?
+		GROUP BY c.id
+	`, channelID).Scan(&name, &status, &checks24h, &up24h, &checks7d, &up7d)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT status, latency_ms, format, error, source, checked_at
+		FROM channel_health
+		WHERE channel_id = ?
+		ORDER BY checked_at DESC, id DESC
+		LIMIT ?
+	`, channelID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []health.Result{}
+	for rows.Next() {
+		result := health.Result{ChannelID: channelID}
+		if err := rows.Scan(&result.Status, &result.LatencyMs, &result.Format, &result.Error, &result.Source, &result.CheckedAt); err != nil {
+			continue
+		}
+		history = append(history, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"id":            channelID,
+			"name":          name,
+			"health_status": status.String,
+			"uptime_24h":    uptime(up24h, checks24h),
+			"uptime_7d":     uptime(up7d, checks7d),
+			"checks_24h":    checks24h,
+			"checks_7d":     checks7d,
+			"history":       history,
+		},
+	})
+}
+
+// RunHealthCheck starts a health check round now
+func RunHealthCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !health.GetChecker().Trigger() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "A health check is already running",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "Health check started",
+	})
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// hlsPlaylistTimeout is how long a playlist request waits for FFmpeg to
+// produce the first segment of a freshly started session.
+const hlsPlaylistTimeout = 15 * time.Second
+
+// lookupStreamUser verifies stream credentials (password already hashed) and
+// reports whether the account is currently allowed to watch.
+// Returns sql.ErrNoRows when the credentials are invalid.
+func lookupStreamUser(username, passwordHash string) (int, bool, error) {
+	var userID int
+	var isActive bool
+	var expiresAt sql.NullTime
+
+	err := database.DB.QueryRow(`
+		SELECT id, is_active, expires_at
+		FROM users
+		WHERE username = ? AND password = ?
+	`, username, passwordHash).Scan(&userID, &isActive, &expiresAt)
+	if err != nil {
+		return 0, false, err
+	}
+
+	allowed := isActive && !(expiresAt.Valid && expiresAt.Time.Before(time.Now()))
+	return userID, allowed, nil
+}
+
+// checkHLSUser authenticates an HLS playlist request and returns the user's
+// id. A session id, when present, must not belong to a kicked player or to
+// another user.
+func checkHLSUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	username := r.URL.Query().Get("username")
+	password := r.URL.Query().Get("password")
+	if username == "" || password == "" {
+		http.Error(w, "Authentication required: username and password parameters missing", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	passwordHash := fmt.Sprintf("%x", md5.Sum([]byte(password)))
+	userID, allowed, err := lookupStreamUser(username, passwordHash)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return 0, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !allowed {
+		http.Error(w, "Subscription inactive or expired", http.StatusForbidden)
+		return 0, false
+	}
+	if sid := r.URL.Query().Get(hlsSessionParam); sid != "" {
+		viewer, kickReason := streaming.GetViewerRegistry().Touch(sid)
+		if kickReason != "" {
+			http.Error(w, kickedMessage(kickReason), http.StatusForbidden)
+			return 0, false
+		}
+		if viewer != nil && viewer.UserID != userID {
+			http.Error(w, "Invalid session", http.StatusBadRequest)
+			return 0, false
+		}
+	}
+	return userID, true
+}
+
+// checkHLSViewer authenticates a segment or variant playlist request. It
+// must carry the session id its playlist handed out, of a live viewer of the
+// authenticated user.
+func checkHLSViewer(w http.ResponseWriter, r *http.Request) (*streaming.Viewer, bool) {
+	userID, ok := checkHLSUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	viewer := streaming.GetViewerRegistry().Get(r.URL.Query().Get(hlsSessionParam))
+	if viewer == nil || viewer.UserID != userID {
+		http.Error(w, "No active session: reload the playlist", http.StatusForbidden)
+		return nil, false
+	}
+	return viewer, true
+}
+
+// hlsSession is implemented by the FFmpeg and Go-segmenter HLS sessions
+type hlsSession interface {
+	TouchClient(clientID, remoteAddr, userAgent string) error
+	WaitForPlaylist(timeout time.Duration) ([]byte, error)
+	GetSegmentPath(name string) (string, error)
+	AddBytesWritten(clientID string, n int64)
+}
+
+// serveHLSPlaylist waits for the session playlist and writes it with every
+// segment URI rewritten to segmentBase + name, carrying the caller's credentials
+// and the transcoding profile that selects the session.
+func serveHLSPlaylist(w http.ResponseWriter, r *http.Request, session hlsSession, segmentBase, profile string) {
+	playlist, err := session.WaitForPlaylist(hlsPlaylistTimeout)
+	if err != nil {
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	auth := url.Values{}
+	auth.Set("username", r.URL.Query().Get("username"))
+	auth.Set("password", r.URL.Query().Get("password"))
+	if sid := r.URL.Query().Get(hlsSessionParam); sid != "" {
+		auth.Set(hlsSessionParam, sid)
+	}
+	if profile != "" && profile != streaming.PassthroughProfile {
+		auth.Set("profile", profile)
+	}
+	query := auth.Encode()
+
+	var out strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			out.WriteString(line)
+			out.WriteString("\n")
+			continue
+		}
+		out.WriteString(segmentBase)
+		out.WriteString(line)
+		out.WriteString("?")
+		out.WriteString(query)
+		out.WriteString("\n")
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	io.WriteString(w, out.String())
+}
+
+// serveHLSSegment serves a segment file written by an HLS session to viewer
+func serveHLSSegment(w http.ResponseWriter, r *http.Request, session hlsSession, name string, viewer *streaming.Viewer) {
+	segmentPath, err := session.GetSegmentPath(name)
+	if err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	session.TouchClient(viewer.ID, r.RemoteAddr, r.UserAgent())
+
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Cache-Control", "max-age=10")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	n, _ := io.Copy(w, f)
+	session.AddBytesWritten(viewer.ID, n)
+	viewer.AddBytes(int(n))
+}
+
+// ProxyChannelHLSSegment serves segments of a channel HLS session
+func ProxyChannelHLSSegment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	viewer, ok := checkHLSViewer(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID := streaming.ProfileSessionID(fmt.Sprintf("channel_%d_hls", channelID), r.URL.Query().Get("profile"))
+	session := streaming.GetFFmpegManager().GetSession(sessionID)
+	if session == nil {
+		http.Error(w, "Stream not found or inactive", http.StatusNotFound)
+		return
+	}
+
+	serveHLSSegment(w, r, session, vars["segment"], viewer)
+}
+
+// ProxyChannelHLSVariant serves the playlist and segments of one ABR variant
+// listed in the channel's master playlist
+func ProxyChannelHLSVariant(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	serveHLSVariant(w, r, fmt.Sprintf("channel_%d_hls", channelID), fmt.Sprintf("/api/proxy/channel/%d/hls/", channelID))
+}
+
+// serveHLSVariant serves the {variant}/{file} playlist or segment of the ABR
+// session of sessionID, whose master playlist is served under base
+func serveHLSVariant(w http.ResponseWriter, r *http.Request, sessionID, base string) {
+	vars := mux.Vars(r)
+
+	viewer, ok := checkHLSViewer(w, r)
+	if !ok {
+		return
+	}
+
+	session := streaming.GetFFmpegManager().GetSession(streaming.ProfileSessionID(sessionID, streaming.ABRProfile))
+	if session == nil {
+		http.Error(w, "Stream not found or inactive", http.StatusNotFound)
+		return
+	}
+	variant, err := session.Variant(vars["variant"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if vars["file"] == "playlist.m3u8" {
+		if err := session.TouchClient(viewer.ID, r.RemoteAddr, r.UserAgent()); err != nil {
+			http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		serveHLSPlaylist(w, r, variant, base+vars["variant"]+"/", "")
+		return
+	}
+	serveHLSSegment(w, r, variant, vars["file"], viewer)
+}
+
+// StreamRelayPassthroughHLS serves HLS for a relay whose MPEG-TS source is
+// segmented in Go, without running an FFmpeg process.
+func StreamRelayPassthroughHLS(w http.ResponseWriter, r *http.Request) {
+	path := mux.Vars(r)["path"]
+
+	userID, ok := checkHLSUser(w, r)
+	if !ok {
+		return
+	}
+
+	var sourceURLs string
+	err := database.DB.QueryRow("SELECT source_urls FROM relays WHERE output_path = ? AND active = 1", path).Scan(&sourceURLs)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Relay not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var urls []string
+	json.Unmarshal([]byte(sourceURLs), &urls)
+	if len(urls) == 0 {
+		http.Error(w, "No source URLs configured", http.StatusInternalServerError)
+		return
+	}
+
+	// Count the player against the user's max_connections by its session id
+	release, ok := trackHLSViewer(w, r, userID, relayChannelID(path), false)
+	if !ok {
+		return
+	}
+
+	session := streaming.GetHLSManager().GetOrCreateHLSSession(path, urls)
+
+	clientID := playbackID(r)
+	if err := session.TouchClient(clientID, r.RemoteAddr, r.UserAgent()); err != nil {
+		release()
+		http.Error(w, "Channel temporarily unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	serveHLSPlaylist(w, r, session, "/stream/"+path+"/passthrough/", "")
+}
+
+// StreamRelayPassthroughSegment serves segments of a Go-segmented relay
+func StreamRelayPassthroughSegment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	viewer, ok := checkHLSViewer(w, r)
+	if !ok {
+		return
+	}
+
+	session := streaming.GetHLSManager().GetSession(vars["path"])
+	if session == nil {
+		http.Error(w, "Stream not found or inactive", http.StatusNotFound)
+		return
+	}
+
+	serveHLSSegment(w, r, session, vars["segment"], viewer)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"iptv-panel/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// magDeviceRequest is the body of CreateMAGDevice and UpdateMAGDevice. A
+// zero or missing user_id leaves the device unbound.
+type magDeviceRequest struct {
+	MAC    string `json:"mac"`
+	UserID int    `json:"user_id"`
+}
+
+// writeMAGDeviceError sends a validation error
+func writeMAGDeviceError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    1,
+		"message": message,
+	})
+}
+
+// bindableUser checks that userID is 0 or an existing user
+func bindableUser(userID int) (bool, error) {
+	if userID == 0 {
+		return true, nil
+	}
+	var id int
+	err := database.DB.QueryRow("SELECT id FROM users WHERE id = ?", userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nullUserID stores 0 as NULL
+func nullUserID(userID int) interface{} {
+	if userID == 0 {
+		return nil
+	}
+	return userID
+}
+
+// GetMAGDevices returns the MAG boxes known to the portal, bound or not
+func GetMAGDevices(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.DB.Query(`
+		SELECT d.id, d.mac, COALESCE(u.id, 0), COALESCE(u.username, ''), d.stb_type, d.serial, d.last_ip,
+			d.last_seen, d.created_at
+		FROM mag_devices d
+		LEFT JOIN users u ON u.id = d.user_id
+		ORDER BY d.last_seen DESC, d.id DESC
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	devices := []map[string]interface{}{}
+	for rows.Next() {
+		var id, userID int
+		var mac, username, stbType, serial, lastIP string
+		var lastSeen sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(&id, &mac, &userID, &username, &stbType, &serial, &lastIP, &lastSeen, &createdAt); err != nil {
+			continue
+		}
+		device := map[string]interface{}{
+			"id":         id,
+			"mac":        mac,
+			"user_id":    userID,
+			"username":   username,
+			"bound":      userID != 0,
+			"stb_type":   stbType,
+			"serial":     serial,
+			"last_ip":    lastIP,
+			"last_seen":  nil,
+			"created_at": createdAt,
+		}
+		if lastSeen.Valid {
+			device["last_seen"] = lastSeen.Time
+		}
+		devices = append(devices, device)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": devices,
+	})
+}
+
+// CreateMAGDevice binds a MAC to a user, registering the MAC if the box has
+// not connected yet
+func CreateMAGDevice(w http.ResponseWriter, r *http.Request) {
+	var req magDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMAGDeviceError(w, "Invalid request: "+err.Error())
+		return
+	}
+	mac := normalizeMAC(req.MAC)
+	if mac == "" {
+		writeMAGDeviceError(w, "mac must look like 00:1A:79:00:00:00")
+		return
+	}
+	if ok, err := bindableUser(req.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		writeMAGDeviceError(w, "User not found")
+		return
+	}
+
+	// Rebinding drops the token so the box starts a new session
+	result, err := database.DB.Exec("UPDATE mag_devices SET user_id = ?, token = '' WHERE mac = ?", nullUserID(req.UserID), mac)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := database.DB.Exec("INSERT INTO mag_devices (mac, user_id) VALUES (?, ?)", mac, nullUserID(req.UserID)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var id int
+	database.DB.QueryRow("SELECT id FROM mag_devices WHERE mac = ?", mac).Scan(&id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    map[string]interface{}{"id": id, "mac": mac},
+		"message": "MAG device saved",
+	})
+}
+
+// UpdateMAGDevice binds a device to another user, or unbinds it
+func UpdateMAGDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	var req magDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMAGDeviceError(w, "Invalid request: "+err.Error())
+		return
+	}
+	if ok, err := bindableUser(req.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		writeMAGDeviceError(w, "User not found")
+		return
+	}
+
+	result, err := database.DB.Exec("UPDATE mag_devices SET user_id = ?, token = '' WHERE id = ?", nullUserID(req.UserID), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "MAG device updated",
+	})
+}
+
+// DeleteMAGDevice forgets a device; it is registered again, unbound, on its
+// next handshake
+func DeleteMAGDevice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM mag_devices WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "MAG device deleted",
+	})
+}
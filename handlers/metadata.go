@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/mpegts"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SaveChannelMetadata stores what inspecting a channel's stream found: video
+// codec and resolution, audio tracks, languages and stream quality
+func SaveChannelMetadata(channelID int, info mpegts.StreamInfo) {
+	var videoCodec string
+	var width, height int
+	if video, ok := info.Video(); ok {
+		videoCodec, width, height = video.Codec, video.Width, video.Height
+	}
+
+	audio := info.Audio()
+	audioCodecs := make([]string, 0, len(audio))
+	var languages []string
+	seen := make(map[string]bool)
+	for _, track := range audio {
+		audioCodecs = append(audioCodecs, track.Codec)
+		if track.Language != "" && !seen[track.Language] {
+			seen[track.Language] = true
+			languages = append(languages, track.Language)
+		}
+	}
+
+	streams, _ := json.Marshal(info.Streams)
+	_, err := database.DB.Exec(`
+		INSERT OR REPLACE INTO channel_metadata
+			(channel_id, video_codec, width, height, audio_codecs, audio_tracks, languages, bitrate_kbps, cc_errors, pcr_jitter_ms, streams, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, channelID, videoCodec, width, height, strings.Join(audioCodecs, ","), len(audio), strings.Join(languages, ","),
+		info.BitrateKbps, info.CCErrors, info.PCRJitterMs, string(streams))
+	if err != nil {
+		log.Printf("⚠️  Failed to save metadata of channel %d: %v", channelID, err)
+	}
+}
+
+// GetChannelDetail returns a channel with its stored stream metadata, the
+// live inspection of its running sessions and its timeshift archive
+func GetChannelDetail(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var name, url, deliveryMode, tvgID string
+	var logo, group sql.NullString
+	var active, onDemand bool
+	err = database.DB.QueryRow("SELECT name, url, logo, group_name, active, on_demand, delivery_mode, COALESCE(tvg_id, '') FROM channels WHERE id = ?", channelID).
+		Scan(&name, &url, &logo, &group, &active, &onDemand, &deliveryMode, &tvgID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	detail := map[string]interface{}{
+		"id":            channelID,
+		"name":          name,
+		"url":           url,
+		"logo":          logo.String,
+		"group_name":    group.String,
+		"active":        active,
+		"on_demand":     onDemand,
+		"delivery_mode": deliveryMode,
+		"metadata":      nil,
+		"live":          liveInspections(channelID),
+		"timeshift":     timeshiftInfo(channelID),
+		"tvg_id":        tvgID,
+		"epg":           nil,
+	}
+	if nowNext, err := epgNowNext(tvgID); err == nil {
+		detail["epg"] = nowNext
+	}
+
+	var m struct {
+		videoCodec, audioCodecs, languages, streams string
+		width, height, audioTracks                  int
+		bitrate, jitter                             float64
+		ccErrors                                    int64
+		updatedAt                                   time.Time
+	}
+	err = database.DB.QueryRow(`
+		SELECT video_codec, width, height, audio_codecs, audio_tracks, languages, bitrate_kbps, cc_errors, pcr_jitter_ms, streams, updated_at
+		FROM channel_metadata WHERE channel_id = ?
+	`, channelID).Scan(&m.videoCodec, &m.width, &m.height, &m.audioCodecs, &m.audioTracks, &m.languages, &m.bitrate, &m.ccErrors, &m.jitter, &m.streams, &m.updatedAt)
+	if err == nil {
+		var streams []mpegts.StreamStats
+		json.Unmarshal([]byte(m.streams), &streams)
+		resolution := ""
+		if m.width > 0 && m.height > 0 {
+			resolution = fmt.Sprintf("%dx%d", m.width, m.height)
+		}
+		detail["metadata"] = map[string]interface{}{
+			"video_codec":   m.videoCodec,
+			"width":         m.width,
+			"height":        m.height,
+			"resolution":    resolution,
+			"audio_codecs":  splitList(m.audioCodecs),
+			"audio_tracks":  m.audioTracks,
+			"languages":     splitList(m.languages),
+			"bitrate_kbps":  m.bitrate,
+			"cc_errors":     m.ccErrors,
+			"pcr_jitter_ms": m.jitter,
+			"streams":       streams,
+			"updated_at":    m.updatedAt,
+		}
+	} else if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": detail,
+	})
+}
+
+// liveInspections returns the inspection of every running MPEG-TS session
+// serving the channel, keyed by session ID
+func liveInspections(channelID int) map[string]mpegts.StreamInfo {
+	live := make(map[string]mpegts.StreamInfo)
+	for _, id := range []string{fmt.Sprintf("channel_%d", channelID), fmt.Sprintf("channel-%d", channelID)} {
+		for _, session := range streaming.GetFFmpegManager().GetSessionVariants(id) {
+			if session.OutputFormat != "hls" && session.IsActive() {
+				live[session.ID] = session.Inspection()
+			}
+		}
+		if session := streaming.GetManager().GetSession(id); session != nil && session.IsActive() {
+			live[id] = session.Inspection()
+		}
+	}
+	return live
+}
+
+// splitList splits a comma separated column into its values
+func splitList(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"iptv-panel/streaming"
+	"net/http"
+)
+
+// GetProcesses returns the table of child processes owned by the supervisor
+func GetProcesses(w http.ResponseWriter, r *http.Request) {
+	supervisor := streaming.GetSupervisor()
+	processes := supervisor.Processes()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": map[string]interface{}{
+			"total":      len(processes),
+			"processes":  processes,
+			"state_file": supervisor.StateFile(),
+		},
+	})
+}
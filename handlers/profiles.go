@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// loadTranscodeProfile returns a builtin or stored profile by name
+func loadTranscodeProfile(name string) (streaming.TranscodeProfile, error) {
+	if profile, ok := streaming.BuiltinProfiles[name]; ok {
+		return profile, nil
+	}
+
+	var config string
+	if err := database.DB.QueryRow("SELECT config FROM transcode_profiles WHERE name = ?", name).Scan(&config); err != nil {
+		return streaming.TranscodeProfile{}, err
+	}
+
+	var profile streaming.TranscodeProfile
+	if err := json.Unmarshal([]byte(config), &profile); err != nil {
+		return streaming.TranscodeProfile{}, fmt.Errorf("invalid profile %s: %v", name, err)
+	}
+	profile.Name = name
+	profile.Builtin = false
+	return profile, nil
+}
+
+// transcodeProfileExists reports whether name can be assigned to a channel or user
+func transcodeProfileExists(name string) bool {
+	_, err := loadTranscodeProfile(name)
+	return err == nil
+}
+
+// resolveTranscodeProfile picks the profile for a user watching a channel:
+// the user package profile, then the channel profile, then default_profile
+// from the stream settings. Transcoding is only done when enable_transcode is
+// on, and the video bitrate is capped at max_bitrate.
+func resolveTranscodeProfile(userID int, channelProfile string) streaming.TranscodeProfile {
+	passthrough := streaming.BuiltinProfiles[streaming.PassthroughProfile]
+
+	settings := transcodeSettings()
+	if settings["enable_transcode"] != "true" {
+		return passthrough
+	}
+
+	for _, name := range []string{userTranscodeProfile(userID), channelProfile, settings["default_profile"]} {
+		if name == "" {
+			continue
+		}
+		profile, err := loadTranscodeProfile(name)
+		if err != nil {
+			log.Printf("⚠️  Transcode profile %q not usable: %v", name, err)
+			continue
+		}
+		maxBitrate, _ := strconv.Atoi(settings["max_bitrate"])
+		return profile.WithBitrateCap(maxBitrate)
+	}
+	return passthrough
+}
+
+// transcodeSettings reads the stream settings that control transcoding
+func transcodeSettings() map[string]string {
+	settings := map[string]string{}
+	rows, err := database.DB.Query("SELECT key, value FROM settings WHERE key IN ('enable_transcode', 'default_profile', 'max_bitrate')")
+	if err != nil {
+		return settings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err == nil {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// userTranscodeProfile returns the profile of the user's package, or ""
+func userTranscodeProfile(userID int) string {
+	var profile string
+	database.DB.QueryRow("SELECT COALESCE(transcode_profile, '') FROM users WHERE id = ?", userID).Scan(&profile)
+	return profile
+}
+
+// loadABRLadder loads a comma separated list of profile names as an ABR ladder
+func loadABRLadder(spec string) ([]streaming.TranscodeProfile, error) {
+	var ladder []streaming.TranscodeProfile
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		profile, err := loadTranscodeProfile(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown profile %s", name)
+		}
+		ladder = append(ladder, profile)
+	}
+	if err := streaming.ValidateLadder(ladder); err != nil {
+		return nil, err
+	}
+	return ladder, nil
+}
+
+// resolveABRLadder returns the ABR ladder a user gets for a channel, or nil
+// to serve a single profile. Users whose package pins a profile, and all
+// users while enable_transcode is off, get a single profile.
+func resolveABRLadder(userID int, channelLadder string) []streaming.TranscodeProfile {
+	if channelLadder == "" {
+		return nil
+	}
+	settings := transcodeSettings()
+	if settings["enable_transcode"] != "true" || userTranscodeProfile(userID) != "" {
+		return nil
+	}
+
+	ladder, err := loadABRLadder(channelLadder)
+	if err != nil {
+		log.Printf("⚠️  ABR ladder %q not usable: %v", channelLadder, err)
+		return nil
+	}
+	maxBitrate, _ := strconv.Atoi(settings["max_bitrate"])
+	for idx := range ladder {
+		ladder[idx] = ladder[idx].WithBitrateCap(maxBitrate)
+	}
+	return ladder
+}
+
+// GetTranscodeProfiles returns the builtin and custom transcoding profiles
+func GetTranscodeProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles := []streaming.TranscodeProfile{}
+	for _, profile := range streaming.BuiltinProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+
+	rows, err := database.DB.Query("SELECT name FROM transcode_profiles ORDER BY name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range names {
+		if profile, err := loadTranscodeProfile(name); err == nil {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": profiles,
+	})
+}
+
+// decodeTranscodeProfile reads and validates a profile from the request body
+func decodeTranscodeProfile(w http.ResponseWriter, r *http.Request, name string) (streaming.TranscodeProfile, bool) {
+	var profile streaming.TranscodeProfile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return profile, false
+	}
+	if name != "" {
+		profile.Name = name
+	}
+	profile.Builtin = false
+
+	if _, builtin := streaming.BuiltinProfiles[profile.Name]; builtin {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Builtin profiles cannot be changed",
+		})
+		return profile, false
+	}
+	if err := profile.Validate(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return profile, false
+	}
+	return profile, true
+}
+
+// CreateTranscodeProfile stores a new custom profile
+func CreateTranscodeProfile(w http.ResponseWriter, r *http.Request) {
+	profile, ok := decodeTranscodeProfile(w, r, "")
+	if !ok {
+		return
+	}
+
+	config, _ := json.Marshal(profile)
+	if _, err := database.DB.Exec("INSERT INTO transcode_profiles (name, config) VALUES (?, ?)", profile.Name, string(config)); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Failed to create profile: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    profile,
+		"message": "Profile created successfully",
+	})
+}
+
+// UpdateTranscodeProfile replaces a custom profile. Running sessions keep
+// their settings until they restart.
+func UpdateTranscodeProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	profile, ok := decodeTranscodeProfile(w, r, name)
+	if !ok {
+		return
+	}
+
+	config, _ := json.Marshal(profile)
+	result, err := database.DB.Exec("UPDATE transcode_profiles SET config = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?", string(config), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    profile,
+		"message": "Profile updated successfully",
+	})
+}
+
+// DeleteTranscodeProfile deletes a custom profile. Channels and users that
+// use it fall back to the next profile in line.
+func DeleteTranscodeProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if _, builtin := streaming.BuiltinProfiles[name]; builtin {
+		http.Error(w, "Builtin profiles cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM transcode_profiles WHERE name = ?", name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "Profile deleted successfully",
+	})
+}
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recording states, stored in recordings.status
+const (
+	RecordingScheduled = "scheduled"
+	RecordingActive    = "recording"
+	RecordingCompleted = "completed"
+	RecordingFailed    = "failed"
+	RecordingCancelled = "cancelled"
+)
+
+// Repeat rules of scheduled recordings
+const (
+	RepeatNone     = ""
+	RepeatDaily    = "daily"
+	RepeatWeekdays = "weekdays"
+	RepeatWeekly   = "weekly"
+)
+
+const (
+	// recorderInterval is how often the scheduler looks for due recordings
+	recorderInterval = 10 * time.Second
+	// recordingStallTimeout is how long the source may send nothing before
+	// the recording reconnects
+	recordingStallTimeout = 30 * time.Second
+	// recordingRetryDelay is the pause between reconnect attempts
+	recordingRetryDelay = 5 * time.Second
+	// recordingGiveUp fails a recording whose source stays down this long
+	recordingGiveUp = 5 * time.Minute
+	// recordingSaveInterval is how often the file size is written to the row
+	recordingSaveInterval = 10 * time.Second
+)
+
+// Why a recording job was stopped before its end
+const (
+	stopByAdmin    = "stopped"
+	stopByDelete   = "deleted"
+	stopByShutdown = "shutdown"
+)
+
+// recordingJob writes one running recording to disk
+type recordingJob struct {
+	id        int
+	channelID int
+	format    string
+	end       time.Time
+	path      string // MPEG-TS file being written
+
+	mux        sync.Mutex
+	size       int64
+	stopReason string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// recordingScheduler starts scheduled recordings and runs the recording jobs
+type recordingScheduler struct {
+	mux      sync.Mutex
+	dir      string
+	jobs     map[int]*recordingJob
+	stopping bool
+	wake     chan struct{}
+}
+
+var recorder = &recordingScheduler{
+	dir:  "./recordings",
+	jobs: make(map[int]*recordingJob),
+	wake: make(chan struct{}, 1),
+}
+
+// StartRecorder sets the recordings directory, resumes the recordings that
+// were running when the panel stopped and starts the scheduler. Returns the
+// number of resumed recordings.
+func StartRecorder(dir string) (int, error) {
+	recorder.mux.Lock()
+	recorder.dir = dir
+	recorder.mux.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	resumed := recorder.resume()
+	go recorder.loop()
+	return resumed, nil
+}
+
+// StopRecorder stops the running recordings without finishing them, so
+// they resume when the panel starts again
+func StopRecorder() {
+	recorder.mux.Lock()
+	recorder.stopping = true
+	jobs := make([]*recordingJob, 0, len(recorder.jobs))
+	for _, job := range recorder.jobs {
+		jobs = append(jobs, job)
+	}
+	recorder.mux.Unlock()
+
+	for _, job := range jobs {
+		job.stop(stopByShutdown)
+	}
+}
+
+// wakeRecorder makes the scheduler check for due recordings now
+func wakeRecorder() {
+	select {
+	case recorder.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (rec *recordingScheduler) loop() {
+	ticker := time.NewTicker(recorderInterval)
+	defer ticker.Stop()
+	for {
+		rec.startDue()
+		select {
+		case <-ticker.C:
+		case <-rec.wake:
+		}
+	}
+}
+
+// scheduledRecording is a recordings row the scheduler works with
+type scheduledRecording struct {
+	id, channelID, duration int
+	title, format, repeat   string
+	start                   time.Time
+	path                    string
+}
+
+func (r scheduledRecording) end() time.Time {
+	return r.start.Add(time.Duration(r.duration) * time.Second)
+}
+
+// resume restarts the recordings that were running when the panel stopped.
+// Those whose end has passed meanwhile are cut off and marked failed; the
+// partial file is kept.
+func (rec *recordingScheduler) resume() int {
+	recordings := queryRecordings(RecordingActive)
+	resumed := 0
+	for _, r := range recordings {
+		if r.end().After(time.Now()) && r.path != "" {
+			log.Printf("⏺️  Resuming recording %d (%s)", r.id, r.title)
+			rec.run(r)
+			resumed++
+			continue
+		}
+		job := &recordingJob{id: r.id, channelID: r.channelID, format: r.format, path: r.path}
+		if info, err := os.Stat(r.path); err == nil {
+			job.size = info.Size()
+		}
+		job.finish(RecordingFailed, "panel was stopped before the recording ended")
+	}
+	return resumed
+}
+
+// startDue starts the scheduled recordings whose start time has come. Those
+// that ended while the panel was not running are marked failed.
+func (rec *recordingScheduler) startDue() {
+	for _, r := range queryRecordings(RecordingScheduled) {
+		if r.start.After(time.Now()) {
+			continue
+		}
+		scheduleNext(r)
+		if !r.end().After(time.Now()) {
+			setRecordingStatus(r.id, RecordingFailed, "missed: the panel was not running at the scheduled time")
+			continue
+		}
+
+		rec.mux.Lock()
+		dir := rec.dir
+		rec.mux.Unlock()
+		r.path = filepath.Join(dir, fmt.Sprintf("%d_ch%d_%s.ts", r.id, r.channelID, r.start.Local().Format("20060102-150405")))
+		_, err := database.DB.Exec("UPDATE recordings SET status = ?, file_path = ?, started_at = ?, error = '' WHERE id = ?",
+			RecordingActive, r.path, time.Now().UTC(), r.id)
+		if err != nil {
+			log.Printf("⚠️  Failed to start recording %d: %v", r.id, err)
+			continue
+		}
+		log.Printf("⏺️  Recording %d started: %s until %s", r.id, r.title, r.end().Local().Format("15:04"))
+		rec.run(r)
+	}
+}
+
+// run starts the job of a recording row
+func (rec *recordingScheduler) run(r scheduledRecording) {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	if rec.stopping || rec.jobs[r.id] != nil {
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), r.end())
+	job := &recordingJob{
+		id:        r.id,
+		channelID: r.channelID,
+		format:    r.format,
+		end:       r.end(),
+		path:      r.path,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	rec.jobs[r.id] = job
+	go func() {
+		job.run(ctx)
+		rec.mux.Lock()
+		delete(rec.jobs, r.id)
+		rec.mux.Unlock()
+	}()
+}
+
+// job returns the running job of a recording, nil if it is not running
+func (rec *recordingScheduler) job(id int) *recordingJob {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	return rec.jobs[id]
+}
+
+// queryRecordings returns the recordings in a state
+func queryRecordings(status string) []scheduledRecording {
+	rows, err := database.DB.Query(`
+		SELECT id, channel_id, title, format, start_time, duration_seconds, repeat_rule, file_path
+		FROM recordings WHERE status = ?
+	`, status)
+	if err != nil {
+		log.Printf("⚠️  Failed to list %s recordings: %v", status, err)
+		return nil
+	}
+	defer rows.Close()
+
+	var recordings []scheduledRecording
+	for rows.Next() {
+		var r scheduledRecording
+		if err := rows.Scan(&r.id, &r.channelID, &r.title, &r.format, &r.start, &r.duration, &r.repeat, &r.path); err == nil {
+			recordings = append(recordings, r)
+		}
+	}
+	return recordings
+}
+
+// scheduleNext adds the next occurrence of a repeating recording that has
+// not ended yet
+func scheduleNext(r scheduledRecording) {
+	if r.repeat == RepeatNone {
+		return
+	}
+	next := nextOccurrence(r.start, r.repeat)
+	for !next.Add(time.Duration(r.duration) * time.Second).After(time.Now()) {
+		next = nextOccurrence(next, r.repeat)
+	}
+	_, err := database.DB.Exec(`
+		INSERT INTO recordings (channel_id, title, status, format, start_time, duration_seconds, repeat_rule)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, r.channelID, r.title, RecordingScheduled, r.format, next.UTC(), r.duration, r.repeat)
+	if err != nil {
+		log.Printf("⚠️  Failed to schedule next %s recording of %s: %v", r.repeat, r.title, err)
+	}
+}
+
+// nextOccurrence returns the start following start under a repeat rule, in
+// local time so that recordings keep their wall clock time across DST
+func nextOccurrence(start time.Time, repeat string) time.Time {
+	local := start.Local()
+	switch repeat {
+	case RepeatWeekly:
+		return local.AddDate(0, 0, 7)
+	case RepeatWeekdays:
+		next := local.AddDate(0, 0, 1)
+		for next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	default:
+		return local.AddDate(0, 0, 1)
+	}
+}
+
+// validRepeat reports whether rule is a known repeat rule
+func validRepeat(rule string) bool {
+	switch rule {
+	case RepeatNone, RepeatDaily, RepeatWeekdays, RepeatWeekly:
+		return true
+	}
+	return false
+}
+
+// setRecordingStatus updates the state of a recording that is not running
+func setRecordingStatus(id int, status, errMsg string) {
+	database.DB.Exec("UPDATE recordings SET status = ?, error = ?, finished_at = ? WHERE id = ?", status, errMsg, time.Now().UTC(), id)
+}
+
+// stop ends the job early and waits for it to finish
+func (j *recordingJob) stop(reason string) {
+	j.mux.Lock()
+	if j.stopReason == "" {
+		j.stopReason = reason
+	}
+	j.mux.Unlock()
+	j.cancel()
+	<-j.done
+}
+
+// Size returns the number of bytes recorded
+func (j *recordingJob) Size() int64 {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.size
+}
+
+// run records the channel until the end of the recording, reconnecting
+// when the source drops, then finishes the recording
+func (j *recordingJob) run(ctx context.Context) {
+	defer close(j.done)
+	defer j.cancel()
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		j.finish(RecordingFailed, err.Error())
+		return
+	}
+	if info, err := file.Stat(); err == nil {
+		j.mux.Lock()
+		j.size = info.Size()
+		j.mux.Unlock()
+	}
+
+	var downSince time.Time
+	var failure string
+	for ctx.Err() == nil {
+		wrote, err := j.capture(ctx, file)
+		if ctx.Err() != nil {
+			break
+		}
+		if wrote {
+			downSince = time.Time{}
+		}
+		if err == errRecordingWrite {
+			failure = err.Error()
+			break
+		}
+		if downSince.IsZero() {
+			downSince = time.Now()
+			log.Printf("⚠️  Recording %d lost its source: %v, reconnecting", j.id, err)
+		} else if time.Since(downSince) > recordingGiveUp {
+			failure = fmt.Sprintf("source down for %v: %v", recordingGiveUp, err)
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(recordingRetryDelay):
+		}
+	}
+	file.Close()
+
+	j.mux.Lock()
+	reason := j.stopReason
+	j.mux.Unlock()
+	switch {
+	case reason == stopByShutdown:
+		// Resumed on the next start
+		database.DB.Exec("UPDATE recordings SET file_size = ? WHERE id = ?", j.Size(), j.id)
+	case reason == stopByDelete:
+	case failure != "":
+		j.finish(RecordingFailed, failure)
+	default:
+		j.finish(RecordingCompleted, "")
+	}
+}
+
+// errRecordingWrite means the file could not be written, retrying won't help
+var errRecordingWrite = fmt.Errorf("failed to write recording file")
+
+// capture attaches to the channel's shared session, starting it if needed,
+// and writes the stream to file until the source drops or ctx ends. wrote
+// reports whether any data was received.
+func (j *recordingJob) capture(ctx context.Context, file *os.File) (wrote bool, err error) {
+	var url, deliveryMode string
+	var priority int
+	err = database.DB.QueryRow("SELECT url, priority, delivery_mode FROM channels WHERE id = ? AND active = 1", j.channelID).
+		Scan(&url, &priority, &deliveryMode)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("channel is deleted or disabled")
+	} else if err != nil {
+		return false, err
+	}
+
+	session, err := sourceSession(ctx, j.channelID, url, priority, deliveryMode)
+	if err != nil {
+		return false, err
+	}
+	clientID := fmt.Sprintf("recording_%d", j.id)
+	client, err := session.AddClient(clientID, "recording", "")
+	if err != nil {
+		return false, err
+	}
+	defer session.RemoveClient(clientID)
+
+	lastSave := time.Now()
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, recordingStallTimeout)
+		data, err := client.Next(readCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && readCtx.Err() != nil {
+				err = fmt.Errorf("no data for %v", recordingStallTimeout)
+			}
+			return wrote, err
+		}
+		if _, err := file.Write(data); err != nil {
+			log.Printf("❌ Recording %d: %v", j.id, err)
+			return wrote, errRecordingWrite
+		}
+		wrote = true
+
+		j.mux.Lock()
+		j.size += int64(len(data))
+		j.mux.Unlock()
+		if time.Since(lastSave) > recordingSaveInterval {
+			database.DB.Exec("UPDATE recordings SET file_size = ? WHERE id = ?", j.Size(), j.id)
+			lastSave = time.Now()
+		}
+	}
+}
+
+// finish marks the recording done, converting it to MP4 if requested. A
+// recording without any data is failed.
+func (j *recordingJob) finish(status, errMsg string) {
+	path, format, size := j.path, j.format, j.Size()
+	if size == 0 && status == RecordingCompleted {
+		status, errMsg = RecordingFailed, "no data was recorded"
+	}
+
+	if format == "mp4" && size > 0 {
+		mp4Path := strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		err := streaming.RemuxMP4(ctx, path, mp4Path)
+		cancel()
+		if err != nil {
+			// Keep the MPEG-TS file, it plays as well
+			log.Printf("⚠️  Recording %d kept as MPEG-TS: %v", j.id, err)
+			os.Remove(mp4Path)
+			format = "ts"
+			if errMsg == "" {
+				errMsg = "MP4 conversion failed, kept MPEG-TS: " + err.Error()
+			}
+		} else {
+			os.Remove(path)
+			path = mp4Path
+			if info, err := os.Stat(path); err == nil {
+				size = info.Size()
+			}
+		}
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE recordings SET status = ?, error = ?, format = ?, file_path = ?, file_size = ?, finished_at = ?
+		WHERE id = ?
+	`, status, errMsg, format, path, size, time.Now().UTC(), j.id)
+	if err != nil {
+		log.Printf("⚠️  Failed to finish recording %d: %v", j.id, err)
+		return
+	}
+	if status == RecordingCompleted {
+		log.Printf("✅ Recording %d completed (%d MB)", j.id, size/1024/1024)
+	} else {
+		log.Printf("❌ Recording %d failed: %s", j.id, errMsg)
+	}
+}
@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRecordingMinutes bounds the duration of one recording
+const maxRecordingMinutes = 24 * 60
+
+// recordingColumns are the columns scanned by scanRecording
+const recordingColumns = `
+	r.id, r.channel_id, COALESCE(c.name, ''), r.title, r.status, r.format, r.start_time, r.duration_seconds,
+	r.repeat_rule, r.file_path, r.file_size, r.error, r.started_at, r.finished_at, r.created_at`
+
+// scanRecording reads a recording row selected with recordingColumns
+func scanRecording(row interface{ Scan(...interface{}) error }) (map[string]interface{}, error) {
+	var id, channelID, duration int
+	var size int64
+	var channelName, title, status, format, repeat, path, errMsg string
+	var start, createdAt time.Time
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&id, &channelID, &channelName, &title, &status, &format, &start, &duration,
+		&repeat, &path, &size, &errMsg, &startedAt, &finishedAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	recording := map[string]interface{}{
+		"id":               id,
+		"channel_id":       channelID,
+		"channel_name":     channelName,
+		"title":            title,
+		"status":           status,
+		"format":           format,
+		"start_time":       start,
+		"end_time":         start.Add(time.Duration(duration) * time.Second),
+		"duration_minutes": duration / 60,
+		"repeat":           repeat,
+		"file_name":        filepath.Base(path),
+		"file_size":        size,
+		"error":            errMsg,
+		"started_at":       nil,
+		"finished_at":      nil,
+		"created_at":       createdAt,
+	}
+	if path == "" {
+		recording["file_name"] = ""
+	}
+	if startedAt.Valid {
+		recording["started_at"] = startedAt.Time
+	}
+	if finishedAt.Valid {
+		recording["finished_at"] = finishedAt.Time
+	}
+	if job := recorder.job(id); job != nil {
+		recording["file_size"] = job.Size()
+	}
+	return recording, nil
+}
+
+// GetRecordings lists the recordings, optionally filtered by status or
+// channel_id, newest first
+func GetRecordings(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT " + recordingColumns + " FROM recordings r LEFT JOIN channels c ON c.id = r.channel_id WHERE 1 = 1"
+	var args []interface{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " AND r.status = ?"
+		args = append(args, status)
+	}
+	if channelID, err := strconv.Atoi(r.URL.Query().Get("channel_id")); err == nil {
+		query += " AND r.channel_id = ?"
+		args = append(args, channelID)
+	}
+	query += " ORDER BY r.start_time DESC LIMIT 1000"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	recordings := []map[string]interface{}{}
+	for rows.Next() {
+		if recording, err := scanRecording(rows); err == nil {
+			recordings = append(recordings, recording)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": recordings,
+	})
+}
+
+// getRecording returns a recording by ID, nil if it does not exist
+func getRecording(id int) (map[string]interface{}, error) {
+	row := database.DB.QueryRow("SELECT "+recordingColumns+" FROM recordings r LEFT JOIN channels c ON c.id = r.channel_id WHERE r.id = ?", id)
+	recording, err := scanRecording(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return recording, err
+}
+
+// GetRecording returns one recording
+func GetRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+	recording, err := getRecording(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recording == nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": recording,
+	})
+}
+
+// recordingRequest is the body of CreateRecording and UpdateRecording
+type recordingRequest struct {
+	ChannelID       int     `json:"channel_id"`
+	Title           *string `json:"title"`
+	StartTime       string  `json:"start_time"` // RFC3339, empty = now
+	DurationMinutes int     `json:"duration_minutes"`
+	Repeat          *string `json:"repeat"`
+	Format          *string `json:"format"`
+}
+
+// validate checks the schedule fields that are set
+func (req recordingRequest) validate() string {
+	if req.DurationMinutes < 0 || req.DurationMinutes > maxRecordingMinutes {
+		return fmt.Sprintf("duration_minutes must be between 1 and %d", maxRecordingMinutes)
+	}
+	if req.Repeat != nil && !validRepeat(*req.Repeat) {
+		return "repeat must be empty, daily, weekdays or weekly"
+	}
+	if req.Format != nil && *req.Format != "ts" && *req.Format != "mp4" {
+		return "format must be ts or mp4"
+	}
+	if req.StartTime != "" {
+		if _, err := time.Parse(time.RFC3339, req.StartTime); err != nil {
+			return "start_time must be RFC3339, e.g. 2024-01-01T20:00:00+07:00"
+		}
+	}
+	return ""
+}
+
+// CreateRecording records a channel now (without start_time) or schedules
+// a recording, optionally repeating
+func CreateRecording(w http.ResponseWriter, r *http.Request) {
+	var req recordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	message := req.validate()
+	if message == "" && req.DurationMinutes == 0 {
+		message = "duration_minutes is required"
+	}
+	var channelName string
+	if message == "" {
+		err := database.DB.QueryRow("SELECT name FROM channels WHERE id = ?", req.ChannelID).Scan(&channelName)
+		if err == sql.ErrNoRows {
+			message = "Channel not found"
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if message != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": message,
+		})
+		return
+	}
+
+	start := time.Now()
+	if req.StartTime != "" {
+		start, _ = time.Parse(time.RFC3339, req.StartTime)
+	}
+	title := fmt.Sprintf("%s %s", channelName, start.Local().Format("2006-01-02 15:04"))
+	if req.Title != nil && *req.Title != "" {
+		title = *req.Title
+	}
+	repeat, format := RepeatNone, "ts"
+	if req.Repeat != nil {
+		repeat = *req.Repeat
+	}
+	if req.Format != nil {
+		format = *req.Format
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO recordings (channel_id, title, status, format, start_time, duration_seconds, repeat_rule)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, req.ChannelID, title, RecordingScheduled, format, start.UTC(), req.DurationMinutes*60, repeat)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Failed to create recording: " + err.Error(),
+		})
+		return
+	}
+	id, _ := result.LastInsertId()
+	wakeRecorder()
+
+	recording, _ := getRecording(int(id))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    recording,
+		"message": "Recording scheduled",
+	})
+}
+
+// UpdateRecording renames a recording or changes the schedule of one that
+// has not started yet
+func UpdateRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	var req recordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	var status string
+	err = database.DB.QueryRow("SELECT status FROM recordings WHERE id = ?", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	message := req.validate()
+	reschedule := req.StartTime != "" || req.DurationMinutes > 0 || req.Repeat != nil || req.Format != nil
+	if message == "" && reschedule && status != RecordingScheduled {
+		message = "Only the title of a recording that has started can be changed"
+	}
+	if message != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": message,
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Title != nil && *req.Title != "" {
+		updates["title"] = *req.Title
+	}
+	if req.StartTime != "" {
+		start, _ := time.Parse(time.RFC3339, req.StartTime)
+		updates["start_time"] = start.UTC()
+	}
+	if req.DurationMinutes > 0 {
+		updates["duration_seconds"] = req.DurationMinutes * 60
+	}
+	if req.Repeat != nil {
+		updates["repeat_rule"] = *req.Repeat
+	}
+	if req.Format != nil {
+		updates["format"] = *req.Format
+	}
+	for column, value := range updates {
+		if _, err := database.DB.Exec("UPDATE recordings SET "+column+" = ? WHERE id = ?", value, id); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Failed to update recording: " + err.Error(),
+			})
+			return
+		}
+	}
+	wakeRecorder()
+
+	recording, _ := getRecording(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    recording,
+		"message": "Recording updated",
+	})
+}
+
+// StopRecording ends a running recording early, keeping what was recorded,
+// or cancels a scheduled one
+func StopRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if job := recorder.job(id); job != nil {
+		job.stop(stopByAdmin)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    0,
+			"message": "Recording stopped",
+		})
+		return
+	}
+
+	result, err := database.DB.Exec("UPDATE recordings SET status = ?, finished_at = ? WHERE id = ? AND status = ?",
+		RecordingCancelled, time.Now().UTC(), id, RecordingScheduled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    1,
+			"message": "Recording is not running or scheduled",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "Recording cancelled",
+	})
+}
+
+// DeleteRecording stops a recording if it is running and deletes it with
+// its file
+func DeleteRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	if job := recorder.job(id); job != nil {
+		job.stop(stopByDelete)
+	}
+
+	var path string
+	err = database.DB.QueryRow("SELECT file_path FROM recordings WHERE id = ?", id).Scan(&path)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.DB.Exec("DELETE FROM recordings WHERE id = ?", id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if path != "" {
+		os.Remove(path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    map[string]bool{"success": true},
+		"message": "Recording deleted successfully",
+	})
+}
+
+// PlayRecording serves a recording file to the panel, with HTTP Range
+// support for seeking
+func PlayRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+	serveRecording(w, r, id, "", 0)
+}
+
+// ProxyRecording serves a recording to a user that may watch its channel,
+// with HTTP Range support for seeking
+func ProxyRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	password := r.URL.Query().Get("password")
+	if username == "" || password == "" {
+		http.Error(w, "Authentication required: username and password parameters missing", http.StatusUnauthorized)
+		return
+	}
+
+	passwordHash := fmt.Sprintf("%x", md5.Sum([]byte(password)))
+	userID, allowed, err := lookupStreamUser(username, passwordHash)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		serveSlate(w, r, userID, 0, streaming.StreamExpiredVideo)
+		return
+	}
+
+	serveRecording(w, r, id, username, userID)
+}
+
+// serveRecording serves the file of a recording. With a username, only
+// recordings of channels in the user's playlist are served and the playback
+// counts against the user's max_connections.
+func serveRecording(w http.ResponseWriter, r *http.Request, id int, username string, userID int) {
+	var channelID int
+	var path string
+	err := database.DB.QueryRow("SELECT channel_id, file_path FROM recordings WHERE id = ?", id).Scan(&channelID, &path)
+	if err == sql.ErrNoRows || (err == nil && path == "") {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if username != "" && !userHasChannel(username, channelID) {
+		http.Error(w, "This channel is not in your subscription", http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Recording file not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if username != "" {
+		streamW, streamReq, done, ok := trackStream(w, r, userID, channelID)
+		if !ok {
+			return
+		}
+		defer done()
+		w, r = streamW, streamReq
+	}
+
+	contentType := "video/MP2T"
+	if filepath.Ext(path) == ".mp4" {
+		contentType = "video/mp4"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+}
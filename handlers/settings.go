@@ -3,6 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"iptv-panel/database"
+	"iptv-panel/settings"
+	"iptv-panel/streaming"
 	"log"
 	"net/http"
 	"os/exec"
@@ -70,7 +72,8 @@ func UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update each setting
+	// Convert and validate every value before writing any of them
+	values := make(map[string]string, len(req.Settings))
 	for key, value := range req.Settings {
 		var strValue string
 		switch v := value.(type) {
@@ -84,6 +87,19 @@ func UpdateSettings(w http.ResponseWriter, r *http.Request) {
 			strValue = ""
 		}
 
+		if err := settings.Validate(key, strValue); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    1,
+				"message": "Invalid setting " + err.Error(),
+			})
+			return
+		}
+		values[key] = strValue
+	}
+
+	// Update each setting
+	for key, strValue := range values {
 		_, err := database.DB.Exec(
 			"UPDATE settings SET value = ?, updated_at = CURRENT_TIMESTAMP WHERE key = ? AND category = ?",
 			strValue, key, req.Category,
@@ -93,6 +109,11 @@ func UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Running managers pick up the new values through the settings service
+	if err := settings.Load(); err != nil {
+		log.Printf("⚠️  Failed to reload settings: %v", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"code":    0,
@@ -100,17 +121,26 @@ func UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// TestFFmpeg tests if FFmpeg is installed and working
-func TestFFmpeg(w http.ResponseWriter, r *http.Request) {
-	// Get FFmpeg path from settings
-	var ffmpegPath string
-	err := database.DB.QueryRow("SELECT value FROM settings WHERE key = 'ffmpeg_path'").Scan(&ffmpegPath)
-	if err != nil {
-		ffmpegPath = "/usr/bin/ffmpeg"
+// slowClientPolicy resolves the slow client policy for a channel: its own
+// mode if set, otherwise the mode and limits from the stream settings.
+func slowClientPolicy(channelMode string) streaming.SlowClientPolicy {
+	cfg := settings.Get()
+	policy := streaming.SlowClientPolicy{
+		Mode:        cfg.SlowClientPolicy,
+		MaxLagBytes: uint64(cfg.SlowClientMaxLagKB) * 1024,
+		MaxDrops:    cfg.SlowClientMaxDrops,
 	}
 
-	// Test FFmpeg
-	cmd := exec.Command(ffmpegPath, "-version")
+	if p, err := policy.WithMode(channelMode); err == nil {
+		policy = p
+	}
+	return policy
+}
+
+// TestFFmpeg tests if FFmpeg is installed and working
+func TestFFmpeg(w http.ResponseWriter, r *http.Request) {
+	// Test the FFmpeg the streaming managers run
+	cmd := exec.Command(settings.Get().FFmpegPath, "-version")
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/epg"
+	"iptv-panel/models"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile states reported to the set-top box by get_profile
+const (
+	stalkerStatusOK           = 0
+	stalkerStatusAuthRequired = 2 // MAC not bound to a user yet, the box asks for a login
+)
+
+// stalkerPageItems is the page size of itv get_ordered_list
+const stalkerPageItems = 14
+
+// macPattern matches a MAC address as sent by MAG boxes
+var macPattern = regexp.MustCompile(`^([0-9A-F]{2}:){5}[0-9A-F]{2}$`)
+
+// stalkerChannelCmd matches the placeholder cmd of a channel in the list,
+// resolved to a stream URL by create_link
+var stalkerChannelCmd = regexp.MustCompile(`/ch/([0-9]+)`)
+
+// stalkerDevice is a MAG box that completed the handshake
+type stalkerDevice struct {
+	ID   int
+	MAC  string
+	User *models.User // nil while the MAC is not bound; Password is the md5 hash
+}
+
+// normalizeMAC returns the MAC in upper case, or "" when it is not valid
+func normalizeMAC(mac string) string {
+	mac = strings.ToUpper(strings.TrimSpace(mac))
+	if !macPattern.MatchString(mac) {
+		return ""
+	}
+	return mac
+}
+
+// stalkerMAC reads the MAC of the box from the mac cookie, or from the query
+// for clients that do not send cookies
+func stalkerMAC(r *http.Request) string {
+	if c, err := r.Cookie("mac"); err == nil {
+		if mac, err := url.QueryUnescape(c.Value); err == nil {
+			return normalizeMAC(mac)
+		}
+	}
+	return normalizeMAC(r.FormValue("mac"))
+}
+
+// writeStalkerJSON writes a portal response, which wraps the payload in "js"
+func writeStalkerJSON(w http.ResponseWriter, js interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"js": js})
+}
+
+// stalkerAuth looks up the device of the Bearer token issued by the
+// handshake. It returns nil when the token is unknown or belongs to a
+// different MAC.
+func stalkerAuth(r *http.Request) (*stalkerDevice, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		return nil, nil
+	}
+
+	var device stalkerDevice
+	var userID sql.NullInt64
+	var username, password, fullName sql.NullString
+	var maxConnections sql.NullInt64
+	var isActive sql.NullBool
+	var expiresAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT d.id, d.mac, u.id, u.username, u.password, u.full_name, u.max_connections, u.is_active, u.expires_at
+		FROM mag_devices d
+		LEFT JOIN users u ON u.id = d.user_id
+		WHERE d.token = ?
+	`, token).Scan(&device.ID, &device.MAC, &userID, &username, &password, &fullName, &maxConnections, &isActive, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if mac := stalkerMAC(r); mac != "" && mac != device.MAC {
+		return nil, nil
+	}
+
+	if userID.Valid {
+		device.User = &models.User{
+			ID:             int(userID.Int64),
+			Username:       username.String,
+			Password:       password.String,
+			FullName:       fullName.String,
+			MaxConnections: int(maxConnections.Int64),
+			IsActive:       isActive.Bool,
+		}
+		if expiresAt.Valid {
+			device.User.ExpiresAt = &expiresAt.Time
+		}
+	}
+
+	database.DB.Exec("UPDATE mag_devices SET last_ip = ?, last_seen = ? WHERE id = ?",
+		r.RemoteAddr, time.Now().UTC(), device.ID)
+	return &device, nil
+}
+
+// StalkerPortal emulates the Stalker middleware API (server/load.php) used
+// by MAG set-top boxes. A box is identified by its MAC and must be bound to
+// a user, by an admin or with the user's login on the box, before it gets
+// channels. Channels are those in the user's generated playlist, grouped by
+// group into genres, and create_link hands out the user's /stream URL so
+// playback follows the same rules as any other user stream.
+func StalkerPortal(w http.ResponseWriter, r *http.Request) {
+	kind, action := r.FormValue("type"), r.FormValue("action")
+
+	if kind == "stb" && action == "handshake" {
+		stalkerHandshake(w, r)
+		return
+	}
+
+	device, err := stalkerAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if device == nil {
+		http.Error(w, "Authorization failed.", http.StatusUnauthorized)
+		return
+	}
+
+	switch kind + "." + action {
+	case "stb.get_profile":
+		stalkerProfile(w, r, device)
+	case "stb.do_auth":
+		stalkerDoAuth(w, r, device)
+	case "account_info.get_main_info":
+		stalkerMainInfo(w, device)
+	case "watchdog.get_events":
+		writeStalkerJSON(w, map[string]interface{}{
+			"data": map[string]interface{}{"msgs": 0, "additional_services_on": "1"},
+		})
+	case "itv.get_genres", "itv.get_all_channels", "itv.get_ordered_list", "itv.create_link", "itv.get_short_epg":
+		stalkerITV(w, r, device, action)
+	case "stb.get_localization", "stb.get_modules", "stb.log", "stb.set_modern_portal",
+		"itv.set_fav", "itv.set_last_id", "itv.set_played":
+		writeStalkerJSON(w, true)
+	case "itv.get_fav_ids":
+		writeStalkerJSON(w, []interface{}{})
+	default:
+		// VOD, radio and the other modules this panel does not offer
+		writeStalkerJSON(w, []interface{}{})
+	}
+}
+
+// stalkerHandshake issues a new token for the MAC of the box. A MAC seen for
+// the first time is registered unbound, so it can be bound to a user.
+func stalkerHandshake(w http.ResponseWriter, r *http.Request) {
+	mac := stalkerMAC(r)
+	if mac == "" {
+		http.Error(w, "Authorization failed.", http.StatusUnauthorized)
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := strings.ToUpper(hex.EncodeToString(buf))
+
+	now := time.Now().UTC()
+	result, err := database.DB.Exec("UPDATE mag_devices SET token = ?, last_ip = ?, last_seen = ? WHERE mac = ?",
+		token, r.RemoteAddr, now, mac)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		_, err = database.DB.Exec("INSERT INTO mag_devices (mac, token, last_ip, last_seen) VALUES (?, ?, ?, ?)",
+			mac, token, r.RemoteAddr, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("📺 New MAG device %s from %s, waiting to be bound to a user", mac, r.RemoteAddr)
+	}
+
+	writeStalkerJSON(w, map[string]interface{}{"token": token})
+}
+
+// stalkerProfile answers get_profile and records the box model and serial
+func stalkerProfile(w http.ResponseWriter, r *http.Request, device *stalkerDevice) {
+	if stbType, serial := r.FormValue("stb_type"), r.FormValue("sn"); stbType != "" || serial != "" {
+		database.DB.Exec("UPDATE mag_devices SET stb_type = ?, serial = ? WHERE id = ?", stbType, serial, device.ID)
+	}
+
+	profile := map[string]interface{}{
+		"id":                device.ID,
+		"name":              device.MAC,
+		"mac":               device.MAC,
+		"login":             "",
+		"fname":             "",
+		"status":            stalkerStatusAuthRequired,
+		"blocked":           "0",
+		"parent_password":   "0000",
+		"settings_password": "0000",
+		"fav_itv_on":        0,
+		"watchdog_timeout":  120,
+		"timeslot":          60,
+		"storages":          map[string]interface{}{},
+		"locale":            "en_GB.utf8",
+	}
+	if device.User != nil {
+		profile["login"] = device.User.Username
+		profile["fname"] = device.User.FullName
+		profile["status"] = stalkerStatusOK
+		profile["expire_billing_date"] = stalkerEndDate(device.User)
+	}
+	writeStalkerJSON(w, profile)
+}
+
+// stalkerDoAuth binds the box to the user whose login is entered on it
+func stalkerDoAuth(w http.ResponseWriter, r *http.Request, device *stalkerDevice) {
+	username, password := r.FormValue("login"), r.FormValue("password")
+	if username == "" || password == "" {
+		writeStalkerJSON(w, false)
+		return
+	}
+	passwordHash := fmt.Sprintf("%x", md5.Sum([]byte(password)))
+
+	var userID int
+	err := database.DB.QueryRow("SELECT id FROM users WHERE username = ? AND password = ?", username, passwordHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		writeStalkerJSON(w, false)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := database.DB.Exec("UPDATE mag_devices SET user_id = ? WHERE id = ?", userID, device.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("📺 MAG device %s bound to user %s", device.MAC, username)
+	writeStalkerJSON(w, true)
+}
+
+// stalkerEndDate is the subscription end shown on the box
+func stalkerEndDate(user *models.User) string {
+	if user.ExpiresAt == nil {
+		return "unlimited"
+	}
+	return user.ExpiresAt.Local().Format("02.01.2006")
+}
+
+// stalkerMainInfo answers account_info get_main_info
+func stalkerMainInfo(w http.ResponseWriter, device *stalkerDevice) {
+	info := map[string]interface{}{
+		"mac":      device.MAC,
+		"phone":    "",
+		"fname":    "",
+		"end_date": "",
+	}
+	if device.User != nil {
+		info["fname"] = device.User.FullName
+		info["end_date"] = stalkerEndDate(device.User)
+	}
+	writeStalkerJSON(w, info)
+}
+
+// stalkerITV answers the itv module: genres, channel lists, links and EPG
+func stalkerITV(w http.ResponseWriter, r *http.Request, device *stalkerDevice, action string) {
+	channels := []models.Channel{}
+	if device.User != nil {
+		var err error
+		channels, err = userChannels(device.User.Username)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch action {
+	case "get_genres":
+		genres := []map[string]interface{}{{"id": "*", "title": "All", "alias": "all", "censored": 0}}
+		seen := map[string]bool{}
+		for _, ch := range channels {
+			if seen[ch.Group] {
+				continue
+			}
+			seen[ch.Group] = true
+			genres = append(genres, map[string]interface{}{
+				"id":       xtreamCategoryID(ch.Group),
+				"title":    ch.Group,
+				"alias":    strings.ToLower(ch.Group),
+				"censored": 0,
+			})
+		}
+		writeStalkerJSON(w, genres)
+
+	case "get_all_channels", "get_ordered_list":
+		data := []map[string]interface{}{}
+		genre := r.FormValue("genre")
+		for i, ch := range channels {
+			if action == "get_ordered_list" && genre != "" && genre != "*" && genre != xtreamCategoryID(ch.Group) {
+				continue
+			}
+			data = append(data, stalkerChannel(ch, i+1))
+		}
+		list := map[string]interface{}{
+			"total_items":    len(data),
+			"max_page_items": len(data),
+			"selected_item":  0,
+			"cur_page":       0,
+		}
+		if action == "get_ordered_list" {
+			page, _ := strconv.Atoi(r.FormValue("p"))
+			if page < 1 {
+				page = 1
+			}
+			start := (page - 1) * stalkerPageItems
+			if start > len(data) {
+				start = len(data)
+			}
+			end := start + stalkerPageItems
+			if end > len(data) {
+				end = len(data)
+			}
+			list["max_page_items"] = stalkerPageItems
+			list["cur_page"] = page
+			data = data[start:end]
+		}
+		list["data"] = data
+		writeStalkerJSON(w, list)
+
+	case "create_link":
+		link := map[string]interface{}{"id": 0, "cmd": "", "streamer_id": 0, "link_id": 0, "load": 0, "error": "nothing_to_play"}
+		m := stalkerChannelCmd.FindStringSubmatch(r.FormValue("cmd"))
+		if m == nil {
+			writeStalkerJSON(w, link)
+			return
+		}
+		channelID, _ := strconv.Atoi(m[1])
+		for _, ch := range channels {
+			if ch.ID != channelID {
+				continue
+			}
+			relayPath, err := ensureChannelRelay(ch.ID, ch.Name, ch.URL)
+			if err != nil {
+				log.Printf("Failed to create relay for channel %d: %v", ch.ID, err)
+				link["error"] = "link_fault"
+				break
+			}
+			link["id"] = ch.ID
+			link["cmd"] = fmt.Sprintf("ffmpeg %s/stream/%s?username=%s&password=%s",
+				publicBaseURL(r), relayPath, url.QueryEscape(device.User.Username), device.User.Password)
+			link["error"] = ""
+			break
+		}
+		writeStalkerJSON(w, link)
+
+	case "get_short_epg":
+		listings := []map[string]interface{}{}
+		channelID, _ := strconv.Atoi(r.FormValue("ch_id"))
+		size, err := strconv.Atoi(r.FormValue("size"))
+		if err != nil || size <= 0 {
+			size = 4
+		}
+		for _, ch := range channels {
+			if ch.ID != channelID || ch.TvgID == "" {
+				continue
+			}
+			now := time.Now()
+			programmes, err := epg.Programmes(ch.TvgID, now, now.Add(maxGuideRange))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, p := range programmes {
+				if len(listings) == size {
+					break
+				}
+				listings = append(listings, stalkerProgramme(ch, p))
+			}
+		}
+		writeStalkerJSON(w, listings)
+	}
+}
+
+// stalkerChannel describes a channel in the itv list. The cmd is a
+// placeholder that the box resolves through create_link.
+func stalkerChannel(ch models.Channel, number int) map[string]interface{} {
+	cmd := fmt.Sprintf("ffrt http://localhost/ch/%d", ch.ID)
+	return map[string]interface{}{
+		"id":                strconv.Itoa(ch.ID),
+		"name":              ch.Name,
+		"number":            strconv.Itoa(number),
+		"cmd":               cmd,
+		"cmds":              []map[string]interface{}{{"id": strconv.Itoa(ch.ID), "ch_id": strconv.Itoa(ch.ID), "url": cmd}},
+		"logo":              ch.Logo,
+		"tv_genre_id":       xtreamCategoryID(ch.Group),
+		"xmltv_id":          xtreamEPGChannelID(ch),
+		"use_http_tmp_link": 1,
+		"censored":          0,
+		"status":            1,
+		"fav":               0,
+		"lock":              0,
+		"archive":           0,
+	}
+}
+
+// stalkerProgramme describes a programme in get_short_epg
+func stalkerProgramme(ch models.Channel, p epg.Programme) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              strconv.FormatInt(p.Start.Unix(), 10),
+		"ch_id":           strconv.Itoa(ch.ID),
+		"time":            p.Start.Local().Format(xtreamTimeLayout),
+		"time_to":         p.Stop.Local().Format(xtreamTimeLayout),
+		"duration":        int(p.Stop.Sub(p.Start).Seconds()),
+		"name":            p.Title,
+		"descr":           p.Description,
+		"real_id":         fmt.Sprintf("%d_%d", ch.ID, p.Start.Unix()),
+		"t_time":          p.Start.Local().Format("15:04"),
+		"t_time_to":       p.Stop.Local().Format("15:04"),
+		"start_timestamp": p.Start.Unix(),
+		"stop_timestamp":  p.Stop.Unix(),
+		"mark_archive":    0,
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		{"00:1A:79:00:00:01", "00:1A:79:00:00:01"},
+		{" 00:1a:79:ab:cd:ef ", "00:1A:79:AB:CD:EF"},
+		{"00-1A-79-00-00-01", ""},
+		{"001A79000001", ""},
+		{"00:1A:79:00:00", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeMAC(tt.mac); got != tt.want {
+			t.Errorf("normalizeMAC(%q) = %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+}
+
+// stalkerRequest calls the portal as a MAG box with the given MAC cookie
+// and token, and returns the status and the decoded "js" payload
+func stalkerRequest(t *testing.T, query, mac, token string) (int, interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/stalker_portal/server/load.php?"+query, nil)
+	if mac != "" {
+		req.AddCookie(&http.Cookie{Name: "mac", Value: mac})
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	StalkerPortal(rec, req)
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+
+	var resp struct {
+		JS interface{} `json:"js"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v", rec.Body, err)
+	}
+	return rec.Code, resp.JS
+}
+
+func TestStalkerPortal(t *testing.T) {
+	setupTestPanel(t)
+	t.Setenv("PUBLIC_BASE_URL", "http://panel.example")
+	mustExec(t, `INSERT INTO playlists (id, name, url, type) VALUES (1, 'source', 'http://source', 'm3u')`)
+	ids := []int{}
+	for i := 1; i <= 16; i++ {
+		mustExec(t, `INSERT INTO channels (id, playlist_id, name, url, group_name) VALUES (?, 1, ?, ?, 'Nasional')`,
+			i, fmt.Sprintf("Channel %02d", i), fmt.Sprintf("http://source/%d", i))
+		ids = append(ids, i)
+	}
+	mustExec(t, `INSERT INTO channels (id, playlist_id, name, url, group_name, tvg_id) VALUES (17, 1, 'Radio', 'http://source/17', 'Radio', 'radio.id')`)
+	// md5("secret")
+	mustExec(t, `INSERT INTO users (username, password, full_name) VALUES ('budi', '5ebe2294ecd0e0f08eab7690d2a6ee69', 'Budi Santoso')`)
+	writeTestPlaylist(t, "budi", append(ids, 17)...)
+
+	const mac = "00%3A1a%3A79%3A00%3A00%3A01"
+	if status, _ := stalkerRequest(t, "type=stb&action=handshake", "", ""); status != http.StatusUnauthorized {
+		t.Fatalf("handshake without MAC: status %d", status)
+	}
+	_, js := stalkerRequest(t, "type=stb&action=handshake", mac, "")
+	token, _ := js.(map[string]interface{})["token"].(string)
+	if len(token) != 32 {
+		t.Fatalf("handshake returned %v", js)
+	}
+
+	nasional, radio := xtreamCategoryID("Nasional"), xtreamCategoryID("Radio")
+	tests := []struct {
+		name   string
+		query  string
+		mac    string
+		token  string
+		status int
+		want   interface{} // The whole payload, or the fields to check of an object
+	}{
+		{
+			name:   "unknown token",
+			query:  "type=stb&action=get_profile",
+			mac:    mac,
+			token:  "F00",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "token of another MAC",
+			query:  "type=stb&action=get_profile",
+			mac:    "00%3A1A%3A79%3A00%3A00%3A02",
+			token:  token,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:  "unbound box is asked to log in",
+			query: "type=stb&action=get_profile&stb_type=MAG250&sn=123",
+			want:  map[string]interface{}{"mac": "00:1A:79:00:00:01", "login": "", "status": float64(stalkerStatusAuthRequired)},
+		},
+		{
+			name:  "unbound box has no channels",
+			query: "type=itv&action=get_all_channels",
+			want:  map[string]interface{}{"total_items": 0.0, "data": []interface{}{}},
+		},
+		{
+			name:  "wrong login",
+			query: "type=stb&action=do_auth&login=budi&password=wrong",
+			want:  false,
+		},
+		{
+			name:  "login binds the box",
+			query: "type=stb&action=do_auth&login=budi&password=secret",
+			want:  true,
+		},
+		{
+			name:  "bound profile",
+			query: "type=stb&action=get_profile",
+			want:  map[string]interface{}{"login": "budi", "fname": "Budi Santoso", "status": float64(stalkerStatusOK), "expire_billing_date": "unlimited"},
+		},
+		{
+			name:  "account info",
+			query: "type=account_info&action=get_main_info",
+			want:  map[string]interface{}{"mac": "00:1A:79:00:00:01", "fname": "Budi Santoso", "end_date": "unlimited"},
+		},
+		{
+			name:  "genres",
+			query: "type=itv&action=get_genres",
+			want: []interface{}{
+				map[string]interface{}{"id": "*", "title": "All", "alias": "all", "censored": 0.0},
+				map[string]interface{}{"id": nasional, "title": "Nasional", "alias": "nasional", "censored": 0.0},
+				map[string]interface{}{"id": radio, "title": "Radio", "alias": "radio", "censored": 0.0},
+			},
+		},
+		{
+			name:  "all channels in one page",
+			query: "type=itv&action=get_all_channels",
+			want:  map[string]interface{}{"total_items": 17.0, "max_page_items": 17.0, "cur_page": 0.0},
+		},
+		{
+			name:  "first page of the ordered list",
+			query: "type=itv&action=get_ordered_list&genre=*",
+			want:  map[string]interface{}{"total_items": 17.0, "max_page_items": float64(stalkerPageItems), "cur_page": 1.0},
+		},
+		{
+			name:  "ordered list of a genre",
+			query: "type=itv&action=get_ordered_list&genre=" + radio + "&p=1",
+			want:  map[string]interface{}{"total_items": 1.0, "cur_page": 1.0},
+		},
+		{
+			name:  "link to a channel",
+			query: "type=itv&action=create_link&cmd=ffrt+http://localhost/ch/17",
+			want: map[string]interface{}{
+				"id":    17.0,
+				"cmd":   "ffmpeg http://panel.example/stream/channel-17?username=budi&password=5ebe2294ecd0e0f08eab7690d2a6ee69",
+				"error": "",
+			},
+		},
+		{
+			name:  "link to a channel outside the playlist",
+			query: "type=itv&action=create_link&cmd=ffrt+http://localhost/ch/99",
+			want:  map[string]interface{}{"cmd": "", "error": "nothing_to_play"},
+		},
+		{
+			name:  "short EPG without programmes",
+			query: "type=itv&action=get_short_epg&ch_id=17",
+			want:  []interface{}{},
+		},
+		{
+			name:  "modules that are not offered",
+			query: "type=vod&action=get_categories",
+			want:  []interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqMAC, reqToken := tt.mac, tt.token
+			if reqMAC == "" {
+				reqMAC, reqToken = mac, token
+			}
+			status, js := stalkerRequest(t, tt.query, reqMAC, reqToken)
+			if tt.status == 0 {
+				tt.status = http.StatusOK
+			}
+			if status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if fields, ok := tt.want.(map[string]interface{}); ok {
+				obj, _ := js.(map[string]interface{})
+				for key, want := range fields {
+					if !reflect.DeepEqual(obj[key], want) {
+						t.Errorf("%s = %#v, want %#v", key, obj[key], want)
+					}
+				}
+			} else if !reflect.DeepEqual(js, tt.want) {
+				t.Errorf("got %#v, want %#v", js, tt.want)
+			}
+		})
+	}
+
+	t.Run("pages of the ordered list", func(t *testing.T) {
+		for page, names := range map[string][]string{
+			"1": {"Channel 01", "Channel 14"},
+			"2": {"Channel 15", "Radio"},
+			"3": nil,
+		} {
+			_, js := stalkerRequest(t, "type=itv&action=get_ordered_list&p="+page, mac, token)
+			data, _ := js.(map[string]interface{})["data"].([]interface{})
+			var first, last interface{}
+			if len(data) > 0 {
+				first, last = data[0].(map[string]interface{})["name"], data[len(data)-1].(map[string]interface{})["name"]
+			}
+			if names == nil && len(data) != 0 || names != nil && (first != names[0] || last != names[1]) {
+				t.Errorf("page %s: %d channels from %v to %v, want %v", page, len(data), first, last, names)
+			}
+		}
+	})
+
+	t.Run("channel fields", func(t *testing.T) {
+		_, js := stalkerRequest(t, "type=itv&action=get_ordered_list&genre="+radio, mac, token)
+		data, _ := js.(map[string]interface{})["data"].([]interface{})
+		if len(data) != 1 {
+			t.Fatalf("got %v", js)
+		}
+		ch := data[0].(map[string]interface{})
+		for key, want := range map[string]interface{}{
+			"id": "17", "name": "Radio", "number": "17", "cmd": "ffrt http://localhost/ch/17",
+			"tv_genre_id": radio, "xmltv_id": "17", "use_http_tmp_link": 1.0,
+		} {
+			if ch[key] != want {
+				t.Errorf("%s = %#v, want %#v", key, ch[key], want)
+			}
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// timeshiftSyncInterval is how often the recording sessions are checked,
+// restarting those that stopped
+const timeshiftSyncInterval = 30 * time.Second
+
+var (
+	timeshiftOnce sync.Once
+	timeshiftSync = make(chan struct{}, 1)
+)
+
+// StartTimeshift keeps a recording session running for every channel with
+// timeshift enabled. Channels are recorded from the unmodified stream of
+// their shared session, so viewers of that session add no upstream pull.
+func StartTimeshift() {
+	timeshiftOnce.Do(func() {
+		go func() {
+			recording := make(map[int]channelSession)
+			ticker := time.NewTicker(timeshiftSyncInterval)
+			defer ticker.Stop()
+			for {
+				syncTimeshift(recording)
+				select {
+				case <-ticker.C:
+				case <-timeshiftSync:
+				}
+			}
+		}()
+	})
+}
+
+// SyncTimeshift applies changed channel timeshift settings right away
+func SyncTimeshift() {
+	select {
+	case timeshiftSync <- struct{}{}:
+	default:
+	}
+}
+
+// syncTimeshift starts recording channels that have timeshift enabled and
+// stops recording and deletes the archives of those that no longer do
+func syncTimeshift(recording map[int]channelSession) {
+	rows, err := database.DB.Query(`
+		SELECT id, url, on_demand, priority, delivery_mode, timeshift_hours
+		FROM channels
+		WHERE active = 1 AND timeshift_hours > 0
+	`)
+	if err != nil {
+		log.Printf("⚠️  Timeshift failed to list channels: %v", err)
+		return
+	}
+	type channel struct {
+		id, priority, hours int
+		url, deliveryMode   string
+		onDemand            bool
+	}
+	var channels []channel
+	for rows.Next() {
+		var ch channel
+		if err := rows.Scan(&ch.id, &ch.url, &ch.onDemand, &ch.priority, &ch.deliveryMode, &ch.hours); err == nil {
+			channels = append(channels, ch)
+		}
+	}
+	rows.Close()
+
+	store := streaming.GetTimeshiftStore()
+	enabled := make(map[int]bool)
+	for _, ch := range channels {
+		enabled[ch.id] = true
+		ctx, cancel := context.WithTimeout(context.Background(), timeshiftSyncInterval)
+		session, err := sourceSession(ctx, ch.id, ch.url, ch.priority, ch.deliveryMode)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️  Cannot record timeshift of channel %d: %v", ch.id, err)
+			continue
+		}
+		if !session.IsActive() {
+			go session.Start()
+		}
+		if old := recording[ch.id]; old != nil && old != session {
+			// The delivery mode changed, hand recording to the new engine
+			old.SetTimeshift(nil)
+			old.SetOnDemand(ch.onDemand)
+		}
+		session.SetOnDemand(false)
+		session.SetTimeshift(store.Archive(ch.id, ch.hours))
+		recording[ch.id] = session
+	}
+
+	for id, session := range recording {
+		if enabled[id] {
+			continue
+		}
+		session.SetTimeshift(nil)
+		onDemand := true
+		database.DB.QueryRow("SELECT on_demand FROM channels WHERE id = ?", id).Scan(&onDemand)
+		session.SetOnDemand(onDemand)
+		delete(recording, id)
+	}
+	// Also drops archives left on disk for channels since deleted
+	for _, id := range store.ChannelIDs() {
+		if !enabled[id] {
+			store.Remove(id)
+		}
+	}
+}
+
+// timeshiftPosition returns the point in time requested with the start
+// (unix seconds or RFC3339) or offset (seconds or a duration like 90m)
+// query parameter. ok is false when the request is for the live stream.
+func timeshiftPosition(r *http.Request) (position time.Time, ok bool, err error) {
+	query := r.URL.Query()
+	if start := query.Get("start"); start != "" {
+		if seconds, err := strconv.ParseInt(start, 10, 64); err == nil {
+			return time.Unix(seconds, 0), true, nil
+		}
+		position, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("start must be unix seconds or RFC3339")
+		}
+		return position, true, nil
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		back, err := time.ParseDuration(offset)
+		if seconds, convErr := strconv.Atoi(offset); convErr == nil {
+			back, err = time.Duration(seconds)*time.Second, nil
+		}
+		if err != nil || back < 0 {
+			return time.Time{}, false, fmt.Errorf("offset must be seconds or a duration before now")
+		}
+		return time.Now().Add(-back), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// serveTimeshift plays a channel's archive from position on, clamped to the
+// oldest recorded segment, and follows the recording until the client
+// disconnects
+func serveTimeshift(w http.ResponseWriter, r *http.Request, channelID int, position time.Time) {
+	archive := streaming.GetTimeshiftStore().Get(channelID)
+	if archive == nil {
+		http.Error(w, "Timeshift is not enabled for this channel", http.StatusNotFound)
+		return
+	}
+	from, _, ok := archive.Range()
+	if !ok {
+		http.Error(w, "Timeshift archive is still empty", http.StatusNotFound)
+		return
+	}
+	if position.Before(from) {
+		position = from
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	log.Printf("⏪ Timeshift playback of channel %d from %s for %s", channelID, position.Format(time.RFC3339), r.RemoteAddr)
+	archive.Serve(r.Context(), w, position, flusher.Flush)
+}
+
+// GetTimeshiftArchives returns the timeshift archives and their disk usage
+func GetTimeshiftArchives(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": streaming.GetTimeshiftStore().Stats(),
+	})
+}
+
+// timeshiftInfo describes the archive of a channel, nil if it has none
+func timeshiftInfo(channelID int) interface{} {
+	if archive := streaming.GetTimeshiftStore().Get(channelID); archive != nil {
+		return archive.Info()
+	}
+	return nil
+}
@@ -21,7 +21,7 @@ import (
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	rows, err := database.DB.Query(`
 		SELECT id, username, full_name, email, max_connections, is_active, 
-		       created_at, activated_at, expires_at, last_login, notes
+		       created_at, activated_at, expires_at, last_login, notes, transcode_profile
 		FROM users ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -35,7 +35,7 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		var user models.User
 		err := rows.Scan(&user.ID, &user.Username, &user.FullName, &user.Email,
 			&user.MaxConnections, &user.IsActive, &user.CreatedAt,
-			&user.ActivatedAt, &user.ExpiresAt, &user.LastLogin, &user.Notes)
+			&user.ActivatedAt, &user.ExpiresAt, &user.LastLogin, &user.Notes, &user.TranscodeProfile)
 		if err != nil {
 			continue
 		}
@@ -60,13 +60,14 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 // CreateUser creates a new user
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Username       string `json:"username"`
-		Password       string `json:"password"`
-		FullName       string `json:"full_name"`
-		Email          string `json:"email"`
-		MaxConnections int    `json:"max_connections"`
-		DurationDays   int    `json:"duration_days"`
-		Notes          string `json:"notes"`
+		Username         string `json:"username"`
+		Password         string `json:"password"`
+		FullName         string `json:"full_name"`
+		Email            string `json:"email"`
+		MaxConnections   int    `json:"max_connections"`
+		DurationDays     int    `json:"duration_days"`
+		Notes            string `json:"notes"`
+		TranscodeProfile string `json:"transcode_profile"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -83,6 +84,11 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		req.MaxConnections = 1
 	}
 
+	if req.TranscodeProfile != "" && !transcodeProfileExists(req.TranscodeProfile) {
+		http.Error(w, "Unknown transcode_profile: "+req.TranscodeProfile, http.StatusBadRequest)
+		return
+	}
+
 	// Hash password
 	passwordHash := fmt.Sprintf("%x", md5.Sum([]byte(req.Password)))
 
@@ -96,9 +102,9 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	result, err := database.DB.Exec(`
 		INSERT INTO users (username, password, full_name, email, max_connections, 
-		                   is_active, activated_at, expires_at, notes)
-		VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?)
-	`, req.Username, passwordHash, req.FullName, req.Email, req.MaxConnections, now, expiresAt, req.Notes)
+		                   is_active, activated_at, expires_at, notes, transcode_profile)
+		VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?)
+	`, req.Username, passwordHash, req.FullName, req.Email, req.MaxConnections, now, expiresAt, req.Notes, req.TranscodeProfile)
 
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -121,18 +127,19 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Return created user data
 	user := map[string]interface{}{
-		"id":              id,
-		"username":        req.Username,
-		"full_name":       req.FullName,
-		"email":           req.Email,
-		"max_connections": req.MaxConnections,
-		"is_active":       true,
-		"created_at":      now,
-		"activated_at":    now,
-		"expires_at":      expiresAt,
-		"notes":           req.Notes,
-		"days_remaining":  daysRemaining,
-		"is_expired":      false,
+		"id":                id,
+		"username":          req.Username,
+		"full_name":         req.FullName,
+		"email":             req.Email,
+		"max_connections":   req.MaxConnections,
+		"is_active":         true,
+		"created_at":        now,
+		"activated_at":      now,
+		"expires_at":        expiresAt,
+		"notes":             req.Notes,
+		"transcode_profile": req.TranscodeProfile,
+		"days_remaining":    daysRemaining,
+		"is_expired":        false,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -149,12 +156,13 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	userID := vars["id"]
 
 	var req struct {
-		FullName       string `json:"full_name"`
-		Email          string `json:"email"`
-		MaxConnections int    `json:"max_connections"`
-		IsActive       bool   `json:"is_active"`
-		ExtendDays     int    `json:"extend_days"`
-		Notes          string `json:"notes"`
+		FullName         string  `json:"full_name"`
+		Email            string  `json:"email"`
+		MaxConnections   int     `json:"max_connections"`
+		IsActive         bool    `json:"is_active"`
+		ExtendDays       int     `json:"extend_days"`
+		Notes            string  `json:"notes"`
+		TranscodeProfile *string `json:"transcode_profile"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -162,6 +170,11 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.TranscodeProfile != nil && *req.TranscodeProfile != "" && !transcodeProfileExists(*req.TranscodeProfile) {
+		http.Error(w, "Unknown transcode_profile: "+*req.TranscodeProfile, http.StatusBadRequest)
+		return
+	}
+
 	// Get current user data
 	var currentExpiresAt sql.NullTime
 	err := database.DB.QueryRow("SELECT expires_at FROM users WHERE id = ?", userID).Scan(&currentExpiresAt)
@@ -189,6 +202,11 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		args = append(args, newExpiresAt)
 	}
 
+	if req.TranscodeProfile != nil {
+		query += `, transcode_profile = ?`
+		args = append(args, *req.TranscodeProfile)
+	}
+
 	query += ` WHERE id = ?`
 	args = append(args, userID)
 
@@ -322,7 +340,8 @@ func GetUserConnections(w http.ResponseWriter, r *http.Request) {
 	userID := vars["id"]
 
 	rows, err := database.DB.Query(`
-		SELECT uc.id, uc.user_id, uc.channel_id, uc.ip_address, uc.connected_at, c.name as channel_name
+		SELECT uc.id, uc.user_id, uc.channel_id, uc.ip_address, uc.connected_at, c.name as channel_name,
+			COALESCE(uc.session_id, ''), COALESCE(uc.user_agent, '')
 		FROM user_connections uc
 		LEFT JOIN channels c ON uc.channel_id = c.id
 		WHERE uc.user_id = ? AND uc.disconnected_at IS NULL
@@ -338,15 +357,18 @@ func GetUserConnections(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var id, userID, channelID sql.NullInt64
 		var ipAddress, channelName sql.NullString
+		var sessionID, userAgent string
 		var connectedAt time.Time
 
-		rows.Scan(&id, &userID, &channelID, &ipAddress, &connectedAt, &channelName)
+		rows.Scan(&id, &userID, &channelID, &ipAddress, &connectedAt, &channelName, &sessionID, &userAgent)
 
 		conn := map[string]interface{}{
 			"id":           id.Int64,
 			"channel_id":   channelID.Int64,
 			"channel_name": channelName.String,
 			"ip_address":   ipAddress.String,
+			"session_id":   sessionID,
+			"user_agent":   userAgent,
 			"connected_at": connectedAt,
 			"duration":     time.Since(connectedAt).Minutes(),
 		}
@@ -502,11 +524,11 @@ func GetUserDetail(w http.ResponseWriter, r *http.Request) {
 	var password string
 	err := database.DB.QueryRow(`
 		SELECT id, username, password, full_name, email, max_connections, is_active, 
-		       created_at, activated_at, expires_at, last_login, notes
+		       created_at, activated_at, expires_at, last_login, notes, transcode_profile
 		FROM users WHERE id = ?
 	`, userID).Scan(&user.ID, &user.Username, &password, &user.FullName, &user.Email,
 		&user.MaxConnections, &user.IsActive, &user.CreatedAt,
-		&user.ActivatedAt, &user.ExpiresAt, &user.LastLogin, &user.Notes)
+		&user.ActivatedAt, &user.ExpiresAt, &user.LastLogin, &user.Notes, &user.TranscodeProfile)
 
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -539,23 +561,7 @@ func GetUserDetail(w http.ResponseWriter, r *http.Request) {
 
 		// Parse M3U file to get channel IDs and count
 		if content, err := os.ReadFile(playlistPath); err == nil {
-			lines := strings.Split(string(content), "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "#EXTINF") {
-					totalChannels++
-					// Extract tvg-id from #EXTINF line
-					// Format: #EXTINF:-1 tvg-id="123" tvg-name="..." ...
-					if idx := strings.Index(line, `tvg-id="`); idx != -1 {
-						idStr := line[idx+8:]
-						if endIdx := strings.Index(idStr, `"`); endIdx != -1 {
-							idStr = idStr[:endIdx]
-							if id, err := strconv.Atoi(idStr); err == nil {
-								userChannelIDs = append(userChannelIDs, id)
-							}
-						}
-					}
-				}
-			}
+			totalChannels, userChannelIDs = playlistChannelIDs(string(content))
 		}
 	}
 
@@ -625,20 +631,21 @@ func GetUserDetail(w http.ResponseWriter, r *http.Request) {
 
 	// Create response with password included
 	userResponse := map[string]interface{}{
-		"id":              user.ID,
-		"username":        user.Username,
-		"password":        password,
-		"full_name":       user.FullName,
-		"email":           user.Email,
-		"max_connections": user.MaxConnections,
-		"is_active":       user.IsActive,
-		"created_at":      user.CreatedAt,
-		"activated_at":    user.ActivatedAt,
-		"expires_at":      user.ExpiresAt,
-		"last_login":      user.LastLogin,
-		"notes":           user.Notes,
-		"days_remaining":  user.DaysRemaining,
-		"is_expired":      user.IsExpired,
+		"id":                user.ID,
+		"username":          user.Username,
+		"password":          password,
+		"full_name":         user.FullName,
+		"email":             user.Email,
+		"max_connections":   user.MaxConnections,
+		"is_active":         user.IsActive,
+		"created_at":        user.CreatedAt,
+		"activated_at":      user.ActivatedAt,
+		"expires_at":        user.ExpiresAt,
+		"last_login":        user.LastLogin,
+		"notes":             user.Notes,
+		"transcode_profile": user.TranscodeProfile,
+		"days_remaining":    user.DaysRemaining,
+		"is_expired":        user.IsExpired,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -778,28 +785,17 @@ func GenerateUserPlaylist(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build M3U content
-	m3uContent := "#EXTM3U\n"
 	baseURL := publicBaseURL(r)
+	// The guide export uses the same channel ids as the tvg-id attributes
+	m3uContent := fmt.Sprintf("#EXTM3U url-tvg=\"%s/epg/%s.xml\"\n", baseURL, user.Username)
 
 	channelCount := 0
 	for _, ch := range channelsData {
 		// Create or get relay for this channel
-		relayPath := fmt.Sprintf("channel-%d", ch.ID)
-
-		// Check if relay exists, if not create it
-		var relayID int
-		err := database.DB.QueryRow("SELECT id FROM relays WHERE output_path = ?", relayPath).Scan(&relayID)
-		if err == sql.ErrNoRows {
-			// Create new relay
-			sourceURLsJSON := fmt.Sprintf("[\"%s\"]", ch.SourceURL)
-			_, err = database.DB.Exec(
-				"INSERT INTO relays (name, source_urls, output_path, active) VALUES (?, ?, ?, 1)",
-				ch.Name, sourceURLsJSON, relayPath,
-			)
-			if err != nil {
-				log.Printf("Failed to create relay for channel %d: %v", ch.ID, err)
-				continue
-			}
+		relayPath, err := ensureChannelRelay(ch.ID, ch.Name, ch.SourceURL)
+		if err != nil {
+			log.Printf("Failed to create relay for channel %d: %v", ch.ID, err)
+			continue
 		}
 
 		m3uContent += fmt.Sprintf("#EXTINF:-1 tvg-id=\"%d\" tvg-name=\"%s\" tvg-logo=\"%s\" group-title=\"%s\",%s\n",
@@ -848,6 +844,25 @@ func GenerateUserPlaylist(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ensureChannelRelay returns the path of the channel-{id} relay that user
+// stream URLs point at, creating the relay if it does not exist
+func ensureChannelRelay(channelID int, name, sourceURL string) (string, error) {
+	relayPath := fmt.Sprintf("channel-%d", channelID)
+
+	// Check if relay exists, if not create it
+	var relayID int
+	err := database.DB.QueryRow("SELECT id FROM relays WHERE output_path = ?", relayPath).Scan(&relayID)
+	if err == sql.ErrNoRows {
+		sourceURLsJSON := fmt.Sprintf("[\"%s\"]", sourceURL)
+		_, err = database.DB.Exec(
+			"INSERT INTO relays (name, source_urls, output_path, active) VALUES (?, ?, ?, 1)",
+			name, sourceURLsJSON, relayPath,
+		)
+		return relayPath, err
+	}
+	return relayPath, nil
+}
+
 // CheckUser checks if user exists and validates credentials
 func CheckUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -939,3 +954,81 @@ func CheckUser(w http.ResponseWriter, r *http.Request) {
 		"message": "User found",
 	})
 }
+
+// playlistChannelIDs counts the entries of a generated playlist and returns
+// the channel IDs found in their tvg-id attributes
+func playlistChannelIDs(content string) (total int, ids []int) {
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, "#EXTINF") {
+			continue
+		}
+		total++
+		// Format: #EXTINF:-1 tvg-id="123" tvg-name="..." ...
+		if idx := strings.Index(line, `tvg-id="`); idx != -1 {
+			idStr := line[idx+8:]
+			if endIdx := strings.Index(idStr, `"`); endIdx != -1 {
+				if id, err := strconv.Atoi(idStr[:endIdx]); err == nil {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return total, ids
+}
+
+// userChannels returns the active channels in the user's generated
+// playlist, ordered like the playlist
+func userChannels(username string) ([]models.Channel, error) {
+	content, err := os.ReadFile(fmt.Sprintf("./generated_playlists/playlist-%s.m3u", username))
+	if err != nil {
+		return nil, err
+	}
+	_, ids := playlistChannelIDs(string(content))
+	channels := []models.Channel{}
+	if len(ids) == 0 {
+		return channels, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	rows, err := database.DB.Query(`
+		SELECT id, playlist_id, name, url, COALESCE(logo, ''), COALESCE(group_name, ''), active, on_demand,
+			timeshift_hours, COALESCE(tvg_id, ''), created_at
+		FROM channels
+		WHERE id IN (`+strings.Join(placeholders, ",")+`) AND active = 1
+		ORDER BY group_name, name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Channel
+		if err := rows.Scan(&c.ID, &c.PlaylistID, &c.Name, &c.URL, &c.Logo, &c.Group, &c.Active, &c.OnDemand,
+			&c.TimeshiftHours, &c.TvgID, &c.CreatedAt); err == nil {
+			channels = append(channels, c)
+		}
+	}
+	return channels, rows.Err()
+}
+
+// userHasChannel reports whether a channel is in the user's generated
+// playlist, which is what the user is entitled to watch
+func userHasChannel(username string, channelID int) bool {
+	content, err := os.ReadFile(fmt.Sprintf("./generated_playlists/playlist-%s.m3u", username))
+	if err != nil {
+		return false
+	}
+	_, ids := playlistChannelIDs(string(content))
+	for _, id := range ids {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// viewerKind names how a viewer is served in the viewers API
+func viewerKind(v *streaming.Viewer) string {
+	switch {
+	case v.Slate:
+		return "slate"
+	case v.HLS:
+		return "hls"
+	default:
+		return "mpegts"
+	}
+}
+
+// GetViewers lists the live viewers of every engine, optionally only those
+// of ?user_id= or ?channel_id=
+func GetViewers(w http.ResponseWriter, r *http.Request) {
+	userFilter, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	channelFilter, _ := strconv.Atoi(r.URL.Query().Get("channel_id"))
+
+	usernames := map[int]string{}
+	channelNames := map[int]string{}
+	viewers := []map[string]interface{}{}
+	for _, v := range streaming.GetViewerRegistry().List() {
+		if (userFilter != 0 && v.UserID != userFilter) || (channelFilter != 0 && v.ChannelID != channelFilter) {
+			continue
+		}
+
+		username, ok := usernames[v.UserID]
+		if !ok {
+			database.DB.QueryRow("SELECT username FROM users WHERE id = ?", v.UserID).Scan(&username)
+			usernames[v.UserID] = username
+		}
+		channelName, ok := channelNames[v.ChannelID]
+		if !ok && v.ChannelID != 0 {
+			database.DB.QueryRow("SELECT name FROM channels WHERE id = ?", v.ChannelID).Scan(&channelName)
+			channelNames[v.ChannelID] = channelName
+		}
+
+		viewers = append(viewers, map[string]interface{}{
+			"id":               v.ID,
+			"type":             viewerKind(v),
+			"user_id":          v.UserID,
+			"username":         username,
+			"channel_id":       v.ChannelID,
+			"channel_name":     channelName,
+			"remote_addr":      v.RemoteAddr,
+			"user_agent":       v.UserAgent,
+			"connection_id":    v.ConnectionID,
+			"started_at":       v.StartedAt,
+			"duration_seconds": int(time.Since(v.StartedAt).Seconds()),
+			"bytes_sent":       v.BytesSent(),
+			"throughput_mbps":  v.Throughput(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": 0,
+		"data": viewers,
+	})
+}
+
+// KickViewer disconnects one viewer
+func KickViewer(w http.ResponseWriter, r *http.Request) {
+	if !streaming.GetViewerRegistry().Kick(mux.Vars(r)["id"], streaming.KickAdmin) {
+		http.Error(w, "Viewer not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": "Viewer disconnected",
+	})
+}
+
+// KickUserViewers disconnects every viewer of a user
+func KickUserViewers(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	writeKicked(w, streaming.GetViewerRegistry().KickUser(userID, streaming.KickAdmin))
+}
+
+// KickChannelViewers disconnects every viewer of a channel
+func KickChannelViewers(w http.ResponseWriter, r *http.Request) {
+	channelID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	writeKicked(w, streaming.GetViewerRegistry().KickChannel(channelID, streaming.KickAdmin))
+}
+
+// writeKicked reports how many viewers were disconnected
+func writeKicked(w http.ResponseWriter, kicked int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"data":    map[string]interface{}{"kicked": kicked},
+		"message": strconv.Itoa(kicked) + " viewer(s) disconnected",
+	})
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"iptv-panel/database"
+	"iptv-panel/epg"
+	"iptv-panel/models"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Xtream Codes account states reported in user_info.status
+const (
+	xtreamActive   = "Active"
+	xtreamExpired  = "Expired"
+	xtreamDisabled = "Disabled"
+)
+
+// xtreamTimeLayout is the date format of Xtream EPG listings and the
+// timeshift start parameter, in the server's time zone
+const xtreamTimeLayout = "2006-01-02 15:04:05"
+
+// xtreamAccount is a user authenticated with the Xtream Codes API
+type xtreamAccount struct {
+	models.User
+	password string // As given by the client, echoed back in URLs
+}
+
+// status returns the account state in Xtream's terms
+func (a xtreamAccount) status() string {
+	if !a.IsActive {
+		return xtreamDisabled
+	}
+	if a.ExpiresAt != nil && a.ExpiresAt.Before(time.Now()) {
+		return xtreamExpired
+	}
+	return xtreamActive
+}
+
+// xtreamLogin authenticates the username and password parameters, sent in
+// the query or as a form by Xtream clients. ok is false when the
+// credentials are invalid.
+func xtreamLogin(r *http.Request) (account xtreamAccount, ok bool, err error) {
+	username, password := r.FormValue("username"), r.FormValue("password")
+	if username == "" || password == "" {
+		return account, false, nil
+	}
+	passwordHash := fmt.Sprintf("%x", md5.Sum([]byte(password)))
+
+	var expiresAt sql.NullTime
+	var createdAt sql.NullTime
+	err = database.DB.QueryRow(`
+		SELECT id, username, max_connections, is_active, created_at, expires_at
+		FROM users
+		WHERE username = ? AND password = ?
+	`, username, passwordHash).Scan(&account.ID, &account.Username, &account.MaxConnections, &account.IsActive, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return account, false, nil
+	} else if err != nil {
+		return account, false, err
+	}
+	if expiresAt.Valid {
+		account.ExpiresAt = &expiresAt.Time
+	}
+	account.CreatedAt = createdAt.Time
+	account.password = password
+	return account, true, nil
+}
+
+// writeXtreamJSON writes an Xtream API response
+func writeXtreamJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(v)
+}
+
+// xtreamCategoryID turns a channel group into a stable numeric category id
+func xtreamCategoryID(group string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(group))), 10)
+}
+
+// xtreamEPGChannelID is the guide id of a channel in xmltv.php, empty when
+// the channel is not linked to the EPG
+func xtreamEPGChannelID(ch models.Channel) string {
+	if ch.TvgID == "" {
+		return ""
+	}
+	return strconv.Itoa(ch.ID)
+}
+
+// PlayerAPI implements the Xtream Codes player_api.php. Without an action
+// it returns the account and server info, otherwise the live categories,
+// live streams or short EPG of the channels in the user's playlist. VOD
+// and series are not offered.
+func PlayerAPI(w http.ResponseWriter, r *http.Request) {
+	account, ok, err := xtreamLogin(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeXtreamJSON(w, map[string]interface{}{"user_info": map[string]interface{}{"auth": 0}})
+		return
+	}
+
+	action := r.FormValue("action")
+	if action == "" {
+		writeXtreamJSON(w, map[string]interface{}{
+			"user_info":   xtreamUserInfo(account),
+			"server_info": xtreamServerInfo(r),
+		})
+		return
+	}
+	if account.status() != xtreamActive {
+		writeXtreamJSON(w, []interface{}{})
+		return
+	}
+
+	channels, err := userChannels(account.Username)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch action {
+	case "get_live_categories":
+		categories := []map[string]interface{}{}
+		seen := make(map[string]bool)
+		for _, ch := range channels {
+			if seen[ch.Group] {
+				continue
+			}
+			seen[ch.Group] = true
+			name := ch.Group
+			if name == "" {
+				name = "Uncategorized"
+			}
+			categories = append(categories, map[string]interface{}{
+				"category_id":   xtreamCategoryID(ch.Group),
+				"category_name": name,
+				"parent_id":     0,
+			})
+		}
+		writeXtreamJSON(w, categories)
+
+	case "get_live_streams":
+		categoryID := r.FormValue("category_id")
+		streams := []map[string]interface{}{}
+		for i, ch := range channels {
+			if categoryID != "" && categoryID != xtreamCategoryID(ch.Group) {
+				continue
+			}
+			archiveDays := (ch.TimeshiftHours + 23) / 24
+			streams = append(streams, map[string]interface{}{
+				"num":                 i + 1,
+				"name":                ch.Name,
+				"stream_type":         "live",
+				"stream_id":           ch.ID,
+				"stream_icon":         ch.Logo,
+				"epg_channel_id":      xtreamEPGChannelID(ch),
+				"added":               strconv.FormatInt(ch.CreatedAt.Unix(), 10),
+				"category_id":         xtreamCategoryID(ch.Group),
+				"custom_sid":          "",
+				"tv_archive":          boolInt(ch.TimeshiftHours > 0),
+				"direct_source":       "",
+				"tv_archive_duration": archiveDays,
+			})
+		}
+		writeXtreamJSON(w, streams)
+
+	case "get_short_epg", "get_simple_data_table":
+		streamID, _ := strconv.Atoi(r.FormValue("stream_id"))
+		var channel *models.Channel
+		for i := range channels {
+			if channels[i].ID == streamID {
+				channel = &channels[i]
+			}
+		}
+		listings := []map[string]interface{}{}
+		if channel != nil && channel.TvgID != "" {
+			now := time.Now()
+			from, to := now, now.Add(maxGuideRange)
+			limit := 4
+			if action == "get_simple_data_table" {
+				from, limit = now.Add(-time.Duration(channel.TimeshiftHours)*time.Hour), 0
+			} else if n, err := strconv.Atoi(r.FormValue("limit")); err == nil && n > 0 {
+				limit = n
+			}
+			programmes, err := epg.Programmes(channel.TvgID, from, to)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, p := range programmes {
+				if limit > 0 && len(listings) == limit {
+					break
+				}
+				listings = append(listings, xtreamListing(*channel, p, now))
+			}
+		}
+		writeXtreamJSON(w, map[string]interface{}{"epg_listings": listings})
+
+	default:
+		// VOD and series actions, and those this panel does not know
+		writeXtreamJSON(w, []interface{}{})
+	}
+}
+
+// xtreamUserInfo describes the account in Xtream's user_info shape
+func xtreamUserInfo(account xtreamAccount) map[string]interface{} {
+	var activeConnections int
+	database.DB.QueryRow("SELECT COUNT(*) FROM user_connections WHERE user_id = ? AND disconnected_at IS NULL", account.ID).
+		Scan(&activeConnections)
+
+	var expDate interface{}
+	if account.ExpiresAt != nil {
+		expDate = strconv.FormatInt(account.ExpiresAt.Unix(), 10)
+	}
+	return map[string]interface{}{
+		"username":               account.Username,
+		"password":               account.password,
+		"message":                "",
+		"auth":                   1,
+		"status":                 account.status(),
+		"exp_date":               expDate,
+		"is_trial":               "0",
+		"active_cons":            strconv.Itoa(activeConnections),
+		"created_at":             strconv.FormatInt(account.CreatedAt.Unix(), 10),
+		"max_connections":        strconv.Itoa(account.MaxConnections),
+		"allowed_output_formats": []string{"m3u8", "ts"},
+	}
+}
+
+// xtreamServerInfo describes the panel in Xtream's server_info shape
+func xtreamServerInfo(r *http.Request) map[string]interface{} {
+	base, _ := url.Parse(publicBaseURL(r))
+	port := base.Port()
+	if port == "" {
+		port = "80"
+		if base.Scheme == "https" {
+			port = "443"
+		}
+	}
+	httpsPort := ""
+	if base.Scheme == "https" {
+		httpsPort = port
+	}
+	timezone := time.Local.String()
+	if timezone == "Local" {
+		timezone = "UTC"
+	}
+	now := time.Now()
+	return map[string]interface{}{
+		"url":             base.Hostname(),
+		"port":            port,
+		"https_port":      httpsPort,
+		"server_protocol": base.Scheme,
+		"rtmp_port":       "",
+		"timezone":        timezone,
+		"timestamp_now":   now.Unix(),
+		"time_now":        now.Format(xtreamTimeLayout),
+		"process":         true,
+	}
+}
+
+// xtreamListing converts a programme to an Xtream EPG listing, whose title
+// and description are base64 encoded
+func xtreamListing(ch models.Channel, p epg.Programme, now time.Time) map[string]interface{} {
+	archiveFrom := now.Add(-time.Duration(ch.TimeshiftHours) * time.Hour)
+	return map[string]interface{}{
+		"id":              strconv.FormatInt(p.Start.Unix(), 10),
+		"epg_id":          strconv.Itoa(ch.ID),
+		"title":           base64.StdEncoding.EncodeToString([]byte(p.Title)),
+		"lang":            "",
+		"start":           p.Start.Local().Format(xtreamTimeLayout),
+		"end":             p.Stop.Local().Format(xtreamTimeLayout),
+		"description":     base64.StdEncoding.EncodeToString([]byte(p.Description)),
+		"channel_id":      xtreamEPGChannelID(ch),
+		"start_timestamp": strconv.FormatInt(p.Start.Unix(), 10),
+		"stop_timestamp":  strconv.FormatInt(p.Stop.Unix(), 10),
+		"now_playing":     boolInt(!p.Start.After(now) && p.Stop.After(now)),
+		"has_archive":     boolInt(ch.TimeshiftHours > 0 && p.Stop.Before(now) && p.Stop.After(archiveFrom)),
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// XtreamPlaylist implements get.php: the user's channels as an M3U with
+// Xtream stream URLs. type=m3u_plus adds the tvg attributes, output=m3u8
+// (or hls) links HLS instead of MPEG-TS.
+func XtreamPlaylist(w http.ResponseWriter, r *http.Request) {
+	account, ok, err := xtreamLogin(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if account.status() != xtreamActive {
+		http.Error(w, "Subscription inactive or expired", http.StatusForbidden)
+		return
+	}
+
+	channels, err := userChannels(account.Username)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	extension := "ts"
+	if output := r.FormValue("output"); output == "m3u8" || output == "hls" {
+		extension = "m3u8"
+	}
+	plus := r.FormValue("type") != "m3u"
+	baseURL := publicBaseURL(r)
+	user, pass := url.PathEscape(account.Username), url.PathEscape(account.password)
+
+	var out strings.Builder
+	if plus {
+		fmt.Fprintf(&out, "#EXTM3U url-tvg=\"%s/xmltv.php?username=%s&password=%s\"\n",
+			baseURL, url.QueryEscape(account.Username), url.QueryEscape(account.password))
+	} else {
+		out.WriteString("#EXTM3U\n")
+	}
+	for _, ch := range channels {
+		if plus {
+			fmt.Fprintf(&out, "#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" tvg-logo=\"%s\" group-title=\"%s\",%s\n",
+				xtreamEPGChannelID(ch), ch.Name, ch.Logo, ch.Group, ch.Name)
+		} else {
+			fmt.Fprintf(&out, "#EXTINF:-1,%s\n", ch.Name)
+		}
+		fmt.Fprintf(&out, "%s/live/%s/%s/%d.%s\n", baseURL, user, pass, ch.ID, extension)
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=playlist-%s.m3u", account.Username))
+	w.Write([]byte(out.String()))
+}
+
+// XtreamGuide implements xmltv.php, the XMLTV guide of the user's channels
+func XtreamGuide(w http.ResponseWriter, r *http.Request) {
+	account, ok, err := xtreamLogin(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if account.status() != xtreamActive {
+		http.Error(w, "Subscription inactive or expired", http.StatusForbidden)
+		return
+	}
+
+	channels, err := userChannels(account.Username)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveUserGuide(w, account.Username, channels)
+}
+
+// xtreamStream checks the path credentials of an Xtream stream URL and
+// that the channel is in the user's playlist, then rewrites the request
+// for the panel's own channel handlers
+func xtreamStream(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	query := r.URL.Query()
+	query.Set("username", vars["username"])
+	query.Set("password", vars["password"])
+	r.URL.RawQuery = query.Encode()
+	r.Form = nil
+
+	// Inactive and expired accounts are handled by the channel handlers
+	if _, ok, err := xtreamLogin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	} else if !ok {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !userHasChannel(vars["username"], channelID) {
+		http.Error(w, "This channel is not in your subscription", http.StatusForbidden)
+		return nil, false
+	}
+	return mux.SetURLVars(r, map[string]string{"id": vars["id"]}), true
+}
+
+// XtreamLive serves /live/{username}/{password}/{id}.ts|.m3u8 through the
+// channel proxy, MPEG-TS or HLS
+func XtreamLive(w http.ResponseWriter, r *http.Request) {
+	extension := mux.Vars(r)["ext"]
+	r, ok := xtreamStream(w, r)
+	if !ok {
+		return
+	}
+	if extension == "m3u8" {
+		ProxyChannelHLS(w, r)
+		return
+	}
+	ProxyChannel(w, r)
+}
+
+// XtreamTimeshift serves /timeshift/{username}/{password}/{duration}/{start}/{id}.ts
+// from the channel's timeshift archive. start is YYYY-MM-DD:HH-MM in the
+// server's time zone, the duration in minutes is left to the client.
+func XtreamTimeshift(w http.ResponseWriter, r *http.Request) {
+	start, err := time.ParseInLocation("2006-01-02:15-04", mux.Vars(r)["start"], time.Local)
+	if err != nil {
+		http.Error(w, "start must be YYYY-MM-DD:HH-MM", http.StatusBadRequest)
+		return
+	}
+	r, ok := xtreamStream(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	query.Set("start", strconv.FormatInt(start.Unix(), 10))
+	r.URL.RawQuery = query.Encode()
+	ProxyChannel(w, r)
+}
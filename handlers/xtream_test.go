@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"iptv-panel/database"
+	"iptv-panel/epg"
+	"iptv-panel/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setupTestPanel opens a fresh database and runs the test in an empty
+// working directory, where generated playlists are written
+func setupTestPanel(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := database.InitDB(filepath.Join(dir, "panel.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Close() })
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir("generated_playlists", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustExec runs a statement against the test database
+func mustExec(t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := database.DB.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// writeTestPlaylist generates the playlist of a user with the given channels
+func writeTestPlaylist(t *testing.T, username string, channelIDs ...int) {
+	t.Helper()
+	content := "#EXTM3U\n"
+	for _, id := range channelIDs {
+		content += "#EXTINF:-1 tvg-id=\"" + strconv.Itoa(id) + "\",Channel\nhttp://panel/ch\n"
+	}
+	if err := os.WriteFile(filepath.Join("generated_playlists", "playlist-"+username+".m3u"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXtreamAccountStatus(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		user   models.User
+		status string
+	}{
+		{"active without expiry", models.User{IsActive: true}, xtreamActive},
+		{"active until later", models.User{IsActive: true, ExpiresAt: &future}, xtreamActive},
+		{"expired", models.User{IsActive: true, ExpiresAt: &past}, xtreamExpired},
+		{"disabled", models.User{IsActive: false}, xtreamDisabled},
+		{"disabled wins over expired", models.User{IsActive: false, ExpiresAt: &past}, xtreamDisabled},
+	}
+
+	for _, tt := range tests {
+		if got := (xtreamAccount{User: tt.user}).status(); got != tt.status {
+			t.Errorf("%s: status %q, want %q", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestXtreamListing(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	programme := func(startHours, stopHours int) epg.Programme {
+		return epg.Programme{
+			Title:       "Berita",
+			Description: "Malam ini",
+			Start:       now.Add(time.Duration(startHours) * time.Hour),
+			Stop:        now.Add(time.Duration(stopHours) * time.Hour),
+		}
+	}
+	archived := models.Channel{ID: 7, TvgID: "rcti.id", TimeshiftHours: 24}
+	live := models.Channel{ID: 7, TvgID: "rcti.id"}
+
+	tests := []struct {
+		name       string
+		channel    models.Channel
+		programme  epg.Programme
+		nowPlaying int
+		hasArchive int
+	}{
+		{"now playing", archived, programme(-1, 1), 1, 0},
+		{"upcoming", archived, programme(1, 2), 0, 0},
+		{"in the archive", archived, programme(-3, -2), 0, 1},
+		{"older than the archive", archived, programme(-30, -25), 0, 0},
+		{"ended without timeshift", live, programme(-3, -2), 0, 0},
+		{"starts now", live, programme(0, 1), 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := xtreamListing(tt.channel, tt.programme, now)
+			if l["now_playing"] != tt.nowPlaying || l["has_archive"] != tt.hasArchive {
+				t.Errorf("now_playing %v has_archive %v, want %d %d", l["now_playing"], l["has_archive"], tt.nowPlaying, tt.hasArchive)
+			}
+			// Titles and descriptions are base64, ids are strings
+			if l["title"] != "QmVyaXRh" || l["description"] != "TWFsYW0gaW5p" {
+				t.Errorf("title %v description %v", l["title"], l["description"])
+			}
+			if l["epg_id"] != "7" || l["channel_id"] != "7" || l["start_timestamp"] != strconv.FormatInt(tt.programme.Start.Unix(), 10) {
+				t.Errorf("epg_id %v channel_id %v start_timestamp %v", l["epg_id"], l["channel_id"], l["start_timestamp"])
+			}
+		})
+	}
+}
+
+func TestPlayerAPI(t *testing.T) {
+	setupTestPanel(t)
+	mustExec(t, `INSERT INTO playlists (id, name, url, type) VALUES (1, 'source', 'http://source', 'm3u')`)
+	mustExec(t, `INSERT INTO channels (id, playlist_id, name, url, logo, group_name, timeshift_hours, tvg_id) VALUES
+		(1, 1, 'RCTI', 'http://source/1', 'http://logo/1', 'Nasional', 48, 'rcti.id'),
+		(2, 1, 'SCTV', 'http://source/2', '', 'Nasional', 0, ''),
+		(3, 1, 'Radio', 'http://source/3', '', '', 0, '')`)
+	// md5("secret")
+	mustExec(t, `INSERT INTO users (username, password, max_connections, is_active) VALUES
+		('budi', '5ebe2294ecd0e0f08eab7690d2a6ee69', 2, 1),
+		('off', '5ebe2294ecd0e0f08eab7690d2a6ee69', 1, 0)`)
+	writeTestPlaylist(t, "budi", 1, 2, 3)
+	writeTestPlaylist(t, "off", 1)
+
+	nasional, uncategorized := xtreamCategoryID("Nasional"), xtreamCategoryID("")
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{
+			name:  "wrong password",
+			query: "username=budi&password=wrong",
+			want:  map[string]interface{}{"user_info": map[string]interface{}{"auth": 0.0}},
+		},
+		{
+			name:  "no credentials",
+			query: "action=get_live_streams",
+			want:  map[string]interface{}{"user_info": map[string]interface{}{"auth": 0.0}},
+		},
+		{
+			name:  "live categories in channel order",
+			query: "username=budi&password=secret&action=get_live_categories",
+			want: []interface{}{
+				map[string]interface{}{"category_id": uncategorized, "category_name": "Uncategorized", "parent_id": 0.0},
+				map[string]interface{}{"category_id": nasional, "category_name": "Nasional", "parent_id": 0.0},
+			},
+		},
+		{
+			name:  "disabled accounts get no streams",
+			query: "username=off&password=secret&action=get_live_streams",
+			want:  []interface{}{},
+		},
+		{
+			name:  "VOD is not offered",
+			query: "username=budi&password=secret&action=get_vod_streams",
+			want:  []interface{}{},
+		},
+		{
+			name:  "short EPG of a channel without guide",
+			query: "username=budi&password=secret&action=get_short_epg&stream_id=2",
+			want:  map[string]interface{}{"epg_listings": []interface{}{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			PlayerAPI(rec, httptest.NewRequest(http.MethodGet, "/player_api.php?"+tt.query, nil))
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("content type %q: %s", ct, rec.Body)
+			}
+			var got interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %s\nwant %v", rec.Body, tt.want)
+			}
+		})
+	}
+
+	t.Run("account and server info", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://panel.example:8080/player_api.php?username=budi&password=secret", nil)
+		req.Header.Set("X-Forwarded-Host", "panel.example:8080")
+		PlayerAPI(rec, req)
+
+		var got struct {
+			UserInfo   map[string]interface{} `json:"user_info"`
+			ServerInfo map[string]interface{} `json:"server_info"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]interface{}{
+			"username": "budi", "password": "secret", "auth": 1.0, "status": xtreamActive,
+			"exp_date": nil, "active_cons": "0", "max_connections": "2",
+		} {
+			if got.UserInfo[key] != want {
+				t.Errorf("user_info.%s = %#v, want %#v", key, got.UserInfo[key], want)
+			}
+		}
+		for key, want := range map[string]interface{}{
+			"url": "panel.example", "port": "8080", "https_port": "", "server_protocol": "http",
+		} {
+			if got.ServerInfo[key] != want {
+				t.Errorf("server_info.%s = %#v, want %#v", key, got.ServerInfo[key], want)
+			}
+		}
+	})
+
+	liveStreams := func(t *testing.T, query string) []map[string]interface{} {
+		rec := httptest.NewRecorder()
+		PlayerAPI(rec, httptest.NewRequest(http.MethodGet, "/player_api.php?username=budi&password=secret&action=get_live_streams"+query, nil))
+		var streams []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &streams); err != nil {
+			t.Fatal(err)
+		}
+		return streams
+	}
+
+	t.Run("live stream fields", func(t *testing.T) {
+		streams := liveStreams(t, "")
+		if len(streams) != 3 {
+			t.Fatalf("got %d streams, want 3: %v", len(streams), streams)
+		}
+		// Channels are listed by group, then name
+		rcti := streams[1]
+		for key, want := range map[string]interface{}{
+			"num": 2.0, "name": "RCTI", "stream_type": "live", "stream_id": 1.0, "stream_icon": "http://logo/1",
+			"epg_channel_id": "1", "category_id": nasional, "tv_archive": 1.0, "tv_archive_duration": 2.0,
+		} {
+			if rcti[key] != want {
+				t.Errorf("%s = %#v, want %#v", key, rcti[key], want)
+			}
+		}
+		if sctv := streams[2]; sctv["epg_channel_id"] != "" || sctv["tv_archive"] != 0.0 {
+			t.Errorf("channel without guide or archive: %v", sctv)
+		}
+	})
+
+	t.Run("live streams of a category", func(t *testing.T) {
+		streams := liveStreams(t, "&category_id="+uncategorized)
+		if len(streams) != 1 || streams[0]["name"] != "Radio" || streams[0]["num"] != 1.0 {
+			t.Errorf("got %v, want only Radio", streams)
+		}
+	})
+}
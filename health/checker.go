@@ -0,0 +1,344 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"iptv-panel/database"
+	"iptv-panel/streaming"
+	"log"
+	"sync"
+	"time"
+)
+
+// Channel health states, stored in channels.health_status
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Where a result came from
+const (
+	SourceProbe   = "probe"   // The checker probed the channel URL
+	SourceSession = "session" // A running session already reads the channel
+)
+
+// historyRetention is how long check results are kept, the longest
+// uptime window
+const historyRetention = 7 * 24 * time.Hour
+
+// Config holds the checker settings, pushed by the settings service
+type Config struct {
+	Interval    time.Duration // Between check rounds, 0 = disabled
+	Concurrency int           // Channels probed at the same time
+	Method      string        // head, get or ffprobe
+	Timeout     time.Duration // Per channel probe
+}
+
+// Result is the outcome of checking one channel
+type Result struct {
+	ChannelID int       `json:"channel_id"`
+	Status    string    `json:"status"`
+	LatencyMs int64     `json:"latency_ms"`
+	Format    string    `json:"format"`
+	Error     string    `json:"error,omitempty"`
+	Source    string    `json:"source"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker probes every active channel in the background and records the
+// results in channel_health
+type Checker struct {
+	mux      sync.Mutex
+	cfg      Config
+	changed  chan struct{} // Wakes the loop when the config changes
+	runNow   chan struct{} // Starts a round right away
+	running  bool          // A round is in progress
+	lastRun  time.Time
+	nextRun  time.Time
+	lastStat map[string]int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	checker     *Checker
+	checkerOnce sync.Once
+)
+
+// GetChecker returns the health checker
+func GetChecker() *Checker {
+	checkerOnce.Do(func() {
+		checker = &Checker{
+			cfg: Config{
+				Interval:    10 * time.Minute,
+				Concurrency: 5,
+				Method:      MethodGet,
+				Timeout:     10 * time.Second,
+			},
+			changed: make(chan struct{}, 1),
+			runNow:  make(chan struct{}, 1),
+		}
+	})
+	return checker
+}
+
+// Configure applies new settings. A new interval applies from now on.
+func Configure(cfg Config) {
+	c := GetChecker()
+	c.mux.Lock()
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = c.cfg.Concurrency
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = c.cfg.Timeout
+	}
+	if cfg.Method == "" {
+		cfg.Method = c.cfg.Method
+	}
+	c.cfg = cfg
+	c.mux.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs check rounds in the background until Stop is called
+func (c *Checker) Start() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.loop(ctx)
+}
+
+// Stop ends the background checks, cancelling a round in progress
+func (c *Checker) Stop() {
+	c.mux.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel = nil
+	c.mux.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Trigger starts a round in the background now. Returns false if one is
+// already in progress.
+func (c *Checker) Trigger() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.running || c.cancel == nil {
+		return false
+	}
+	select {
+	case c.runNow <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (c *Checker) loop(ctx context.Context) {
+	defer close(c.done)
+	// The first round runs one interval after startup. A shortened interval
+	// counts from the last round, so it may start one right away.
+	last := time.Now()
+	for {
+		c.mux.Lock()
+		var wait <-chan time.Time
+		if c.cfg.Interval > 0 {
+			c.nextRun = last.Add(c.cfg.Interval)
+			wait = time.After(time.Until(c.nextRun))
+		} else {
+			c.nextRun = time.Time{}
+		}
+		c.mux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+			continue
+		case <-c.runNow:
+		case <-wait:
+		}
+		c.RunOnce(ctx)
+		last = time.Now()
+	}
+}
+
+// RunOnce checks every active channel now and returns the results. It
+// returns nil if a round is already in progress.
+func (c *Checker) RunOnce(ctx context.Context) []Result {
+	c.mux.Lock()
+	if c.running {
+		c.mux.Unlock()
+		return nil
+	}
+	c.running = true
+	cfg := c.cfg
+	c.mux.Unlock()
+
+	started := time.Now()
+	results := c.checkAll(ctx, cfg)
+
+	stats := map[string]int{StatusUp: 0, StatusDown: 0, SourceSession: 0}
+	for _, result := range results {
+		stats[result.Status]++
+		if result.Source == SourceSession {
+			stats[SourceSession]++
+		}
+	}
+	if ctx.Err() == nil {
+		pruneHistory()
+		log.Printf("🩺 Health check: %d up, %d down (%d from running sessions) in %v",
+			stats[StatusUp], stats[StatusDown], stats[SourceSession], time.Since(started).Round(time.Millisecond))
+	}
+
+	c.mux.Lock()
+	c.running = false
+	c.lastRun = started
+	c.lastStat = stats
+	c.mux.Unlock()
+	return results
+}
+
+// checkAll checks the active channels with up to cfg.Concurrency probes
+// running at once
+func (c *Checker) checkAll(ctx context.Context, cfg Config) []Result {
+	rows, err := database.DB.Query("SELECT id, url FROM channels WHERE active = 1")
+	if err != nil {
+		log.Printf("⚠️  Health check failed to list channels: %v", err)
+		return nil
+	}
+	type channel struct {
+		id  int
+		url string
+	}
+	var channels []channel
+	for rows.Next() {
+		var ch channel
+		if err := rows.Scan(&ch.id, &ch.url); err == nil {
+			channels = append(channels, ch)
+		}
+	}
+	rows.Close()
+
+	var (
+		results    []Result
+		resultsMux sync.Mutex
+		wg         sync.WaitGroup
+	)
+	slots := make(chan struct{}, cfg.Concurrency)
+	for _, ch := range channels {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(ch channel) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, ok := CheckChannel(ctx, cfg, ch.id, ch.url)
+			if !ok || ctx.Err() != nil {
+				return
+			}
+			record(result)
+			resultsMux.Lock()
+			results = append(results, result)
+			resultsMux.Unlock()
+		}(ch)
+	}
+	wg.Wait()
+	return results
+}
+
+// CheckChannel checks one channel. A channel with a running session is not
+// probed, the session's state is used instead. ok is false when that
+// session is still starting and there is nothing to record yet.
+func CheckChannel(ctx context.Context, cfg Config, channelID int, url string) (result Result, ok bool) {
+	result = Result{ChannelID: channelID, CheckedAt: time.Now()}
+
+	if live, running := streaming.ChannelLiveStatus(channelID); running {
+		if live.State == streaming.LiveStarting {
+			return result, false
+		}
+		result.Source = SourceSession
+		result.Status = StatusDown
+		if live.State == streaming.LiveUp {
+			result.Status = StatusUp
+		}
+		result.Format, result.Error = live.Format, live.Error
+		return result, true
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	latency, format, err := Probe(probeCtx, cfg.Method, url)
+
+	result.Source = SourceProbe
+	result.LatencyMs = latency.Milliseconds()
+	result.Format = format
+	result.Status = StatusUp
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result, true
+}
+
+// record stores a result in the history and on the channel row
+func record(result Result) {
+	_, err := database.DB.Exec(`
+		INSERT INTO channel_health (channel_id, status, latency_ms, format, error, source)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, result.ChannelID, result.Status, result.LatencyMs, result.Format, result.Error, result.Source)
+	if err != nil {
+		log.Printf("⚠️  Failed to record health of channel %d: %v", result.ChannelID, err)
+		return
+	}
+	database.DB.Exec("UPDATE channels SET health_status = ?, health_checked_at = CURRENT_TIMESTAMP WHERE id = ?", result.Status, result.ChannelID)
+}
+
+// pruneHistory deletes results older than historyRetention and those of
+// deleted channels
+func pruneHistory() {
+	database.DB.Exec("DELETE FROM channel_health WHERE checked_at < datetime('now', ?)", fmt.Sprintf("-%d seconds", int(historyRetention.Seconds())))
+	database.DB.Exec("DELETE FROM channel_health WHERE channel_id NOT IN (SELECT id FROM channels)")
+}
+
+// Status describes the checker for the panel
+func (c *Checker) Status() map[string]interface{} {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	status := map[string]interface{}{
+		"enabled":          c.cfg.Interval > 0,
+		"interval_seconds": int(c.cfg.Interval.Seconds()),
+		"concurrency":      c.cfg.Concurrency,
+		"method":           c.cfg.Method,
+		"timeout_seconds":  int(c.cfg.Timeout.Seconds()),
+		"running":          c.running,
+		"last_round":       c.lastStat,
+	}
+	if !c.lastRun.IsZero() {
+		status["last_run"] = c.lastRun
+	}
+	if !c.nextRun.IsZero() {
+		status["next_run"] = c.nextRun
+	}
+	return status
+}
@@ -0,0 +1,139 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"iptv-panel/streaming"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Probe methods
+const (
+	MethodHead    = "head"
+	MethodGet     = "get"
+	MethodFFprobe = "ffprobe"
+)
+
+// sniffSize is how much of a GET response is read to detect the format
+const sniffSize = 2 * 188
+
+// probeClient does not follow the default client's unlimited redirects
+// and keeps no idle connections to the probed servers
+var probeClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		return nil
+	},
+}
+
+// Probe checks whether a source URL is up and returns its latency and
+// detected format. Sources other than HTTP are always opened with ffprobe.
+func Probe(ctx context.Context, method, sourceURL string) (latency time.Duration, format string, err error) {
+	started := time.Now()
+	isHTTP := strings.HasPrefix(sourceURL, "http://") || strings.HasPrefix(sourceURL, "https://")
+	switch {
+	case method == MethodFFprobe || !isHTTP:
+		format, err = streaming.ProbeFormat(ctx, sourceURL)
+	case method == MethodHead:
+		format, err = probeHead(ctx, sourceURL)
+		if err == errHeadUnsupported {
+			started = time.Now()
+			format, err = probeGet(ctx, sourceURL)
+		}
+	default:
+		format, err = probeGet(ctx, sourceURL)
+	}
+	return time.Since(started), format, err
+}
+
+// errHeadUnsupported means the server refused HEAD, so GET is tried instead
+var errHeadUnsupported = fmt.Errorf("HEAD not supported")
+
+// probeHead sends a HEAD request and takes the format from Content-Type
+func probeHead(ctx context.Context, sourceURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sourceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		return "", errHeadUnsupported
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", fmt.Errorf("source returned HTTP %d", resp.StatusCode)
+	}
+	return contentTypeFormat(resp.Header.Get("Content-Type")), nil
+}
+
+// probeGet fetches the first bytes of the source and detects the format
+// from them, falling back to Content-Type
+func probeGet(ctx context.Context, sourceURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("source returned HTTP %d", resp.StatusCode)
+	}
+	buf := make([]byte, sniffSize)
+	n, err := io.ReadFull(resp.Body, buf)
+	if n == 0 {
+		return "", fmt.Errorf("source sent no data: %v", err)
+	}
+	if format := sniffFormat(buf[:n]); format != "" {
+		return format, nil
+	}
+	return contentTypeFormat(resp.Header.Get("Content-Type")), nil
+}
+
+// sniffFormat recognizes MPEG-TS, HLS playlists and FLV
+func sniffFormat(data []byte) string {
+	switch {
+	case data[0] == 0x47 && (len(data) <= 188 || data[188] == 0x47):
+		return "mpegts"
+	case bytes.HasPrefix(bytes.TrimLeft(data, "\ufeff \r\n"), []byte("#EXTM3U")):
+		return "hls"
+	case bytes.HasPrefix(data, []byte("FLV")):
+		return "flv"
+	}
+	return ""
+}
+
+// contentTypeFormat maps a Content-Type header to a format name
+func contentTypeFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "video/mp2t", "video/mpeg":
+		return "mpegts"
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl", "audio/x-mpegurl":
+		return "hls"
+	case "video/x-flv":
+		return "flv"
+	}
+	return mediaType
+}
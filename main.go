@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"iptv-panel/database"
+	"iptv-panel/epg"
 	"iptv-panel/handlers"
+	"iptv-panel/health"
+	"iptv-panel/settings"
+	"iptv-panel/streaming"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -22,6 +31,83 @@ func main() {
 	}
 	defer database.Close()
 
+	// Load settings and apply them to the streaming managers, now and
+	// whenever they are changed from the panel
+	if err := settings.Load(); err != nil {
+		log.Printf("⚠️  Failed to load settings, using defaults: %v", err)
+	}
+	settings.Subscribe(func(s settings.Settings) {
+		streaming.Configure(streaming.Config{
+			FFmpegPath:         s.FFmpegPath,
+			BufferSizeKB:       s.BufferSizeKB,
+			IdleTimeout:        s.IdleTimeout,
+			HLSSegmentDuration: s.HLSSegmentDuration,
+
+			MaxStreams:            s.MaxStreams,
+			AdmissionPolicy:       s.AdmissionPolicy,
+			AdmissionQueueTimeout: s.AdmissionQueueTimeout,
+
+			Nice:          s.Nice,
+			CPUAffinity:   s.CPUAffinity,
+			MemoryLimitMB: s.MemoryLimitMB,
+
+			TimeshiftQuotaMB: s.TimeshiftQuotaMB,
+		})
+		health.Configure(health.Config{
+			Interval:    s.HealthCheckInterval,
+			Concurrency: s.HealthCheckConcurrency,
+			Method:      s.HealthCheckMethod,
+			Timeout:     s.HealthCheckTimeout,
+		})
+	})
+
+	// Store codec and quality metadata found by inspecting channel streams
+	streaming.SetMetadataReporter(handlers.SaveChannelMetadata)
+
+	// Kill FFmpeg processes left behind by a previous run that did not
+	// shut down cleanly
+	stateFile := os.Getenv("PROCESS_STATE_FILE")
+	if stateFile == "" {
+		stateFile = "./ffmpeg_processes.json"
+	}
+	if killed, err := streaming.InitSupervisor(stateFile); err != nil {
+		log.Printf("⚠️  Failed to clean up leftover processes: %v", err)
+	} else if killed > 0 {
+		log.Printf("🧟 Killed %d leftover FFmpeg process(es) from previous session", killed)
+	}
+
+	// Keep recording the timeshift archives, including what was recorded
+	// before a restart
+	timeshiftDir := os.Getenv("TIMESHIFT_DIR")
+	if timeshiftDir == "" {
+		timeshiftDir = "./timeshift"
+	}
+	if segments, err := streaming.InitTimeshift(timeshiftDir); err != nil {
+		log.Printf("⚠️  Failed to open timeshift directory: %v", err)
+	} else if segments > 0 {
+		log.Printf("⏪ Loaded %d timeshift segment(s) from previous session", segments)
+	}
+
+	// Start scheduled recordings and resume those cut off by a restart
+	recordingsDir := os.Getenv("RECORDINGS_DIR")
+	if recordingsDir == "" {
+		recordingsDir = "./recordings"
+	}
+	if resumed, err := handlers.StartRecorder(recordingsDir); err != nil {
+		log.Printf("⚠️  Failed to start recorder: %v", err)
+	} else if resumed > 0 {
+		log.Printf("⏺️  Resumed %d recording(s) from previous session", resumed)
+	}
+
+	// Uploaded XMLTV guides are kept here and imported again on schedule
+	epgDir := os.Getenv("EPG_DIR")
+	if epgDir == "" {
+		epgDir = "./epg_guides"
+	}
+	if err := epg.Init(epgDir); err != nil {
+		log.Printf("⚠️  Failed to open EPG directory: %v", err)
+	}
+
 	// Cleanup stale connections from previous server runs
 	result, err := database.DB.Exec(`
 		UPDATE user_connections 
@@ -68,6 +154,9 @@ func main() {
 	// Proxy channel stream (public with user auth - must be before api subrouter)
 	r.HandleFunc("/api/proxy/channel/{id}", handlers.ProxyChannel).Methods("GET")
 	r.HandleFunc("/api/proxy/channel/{id}/hls", handlers.ProxyChannelHLS).Methods("GET")
+	r.HandleFunc("/api/proxy/channel/{id}/hls/{variant:v[0-9]+}/{file}", handlers.ProxyChannelHLSVariant).Methods("GET")
+	r.HandleFunc("/api/proxy/channel/{id}/hls/{segment}", handlers.ProxyChannelHLSSegment).Methods("GET")
+	r.HandleFunc("/api/proxy/recording/{id}", handlers.ProxyRecording).Methods("GET")
 
 	// API routes (protected)
 	api := r.PathPrefix("/api").Subrouter()
@@ -105,6 +194,8 @@ func main() {
 	api.HandleFunc("/channels/rename-category", handlers.RenameChannelCategory).Methods("POST")
 	api.HandleFunc("/channels/{id}", handlers.UpdateChannel).Methods("PUT")
 	api.HandleFunc("/channels/{id}/toggle", handlers.UpdateChannelStatus).Methods("POST")
+	api.HandleFunc("/channels/{id}/detail", handlers.GetChannelDetail).Methods("GET")
+	api.HandleFunc("/channels/{id}/health", handlers.GetChannelHealth).Methods("GET")
 	api.HandleFunc("/channels/{id}", handlers.DeleteChannel).Methods("DELETE")
 	api.HandleFunc("/channels/batch-delete", handlers.BatchDeleteChannels).Methods("POST")
 
@@ -116,6 +207,43 @@ func main() {
 	// Stream status
 	api.HandleFunc("/streams/status", handlers.GetStreamStatus).Methods("GET")
 	api.HandleFunc("/streams/{id}/status", handlers.GetStreamStatusByID).Methods("GET")
+	api.HandleFunc("/processes", handlers.GetProcesses).Methods("GET")
+	api.HandleFunc("/streams/blacklisted", handlers.GetBlacklistedStreams).Methods("GET")
+	api.HandleFunc("/streams/{id}/unblacklist", handlers.UnblacklistStream).Methods("POST")
+
+	// Live viewers of every engine
+	api.HandleFunc("/viewers", handlers.GetViewers).Methods("GET")
+	api.HandleFunc("/viewers/{id}", handlers.KickViewer).Methods("DELETE")
+	api.HandleFunc("/users/{id}/kick", handlers.KickUserViewers).Methods("POST")
+	api.HandleFunc("/channels/{id}/kick", handlers.KickChannelViewers).Methods("POST")
+
+	// Channel health checks
+	api.HandleFunc("/health/channels", handlers.GetChannelsHealth).Methods("GET")
+	api.HandleFunc("/health/check", handlers.RunHealthCheck).Methods("POST")
+
+	// Timeshift archives
+	api.HandleFunc("/timeshift", handlers.GetTimeshiftArchives).Methods("GET")
+
+	// Recordings
+	api.HandleFunc("/recordings", handlers.GetRecordings).Methods("GET")
+	api.HandleFunc("/recordings", handlers.CreateRecording).Methods("POST")
+	api.HandleFunc("/recordings/{id}", handlers.GetRecording).Methods("GET")
+	api.HandleFunc("/recordings/{id}", handlers.UpdateRecording).Methods("PUT")
+	api.HandleFunc("/recordings/{id}", handlers.DeleteRecording).Methods("DELETE")
+	api.HandleFunc("/recordings/{id}/stop", handlers.StopRecording).Methods("POST")
+	api.HandleFunc("/recordings/{id}/play", handlers.PlayRecording).Methods("GET")
+
+	// EPG sources and guide
+	api.HandleFunc("/epg/sources", handlers.GetEPGSources).Methods("GET")
+	api.HandleFunc("/epg/sources", handlers.CreateEPGSource).Methods("POST")
+	api.HandleFunc("/epg/sources/upload", handlers.UploadEPGSource).Methods("POST")
+	api.HandleFunc("/epg/sources/{id}", handlers.UpdateEPGSource).Methods("PUT")
+	api.HandleFunc("/epg/sources/{id}", handlers.DeleteEPGSource).Methods("DELETE")
+	api.HandleFunc("/epg/sources/{id}/upload", handlers.UploadEPGSource).Methods("POST")
+	api.HandleFunc("/epg/sources/{id}/import", handlers.ImportEPGSource).Methods("POST")
+	api.HandleFunc("/epg/channels", handlers.GetEPGChannels).Methods("GET")
+	api.HandleFunc("/channels/{id}/epg", handlers.GetChannelGuide).Methods("GET")
+	api.HandleFunc("/channels/{id}/epg/now", handlers.GetChannelNowNext).Methods("GET")
 
 	// Users
 	api.HandleFunc("/users", handlers.GetUsers).Methods("GET")
@@ -130,12 +258,24 @@ func main() {
 	api.HandleFunc("/users/{id}/set-expired", handlers.SetUserExpired).Methods("POST")
 	api.HandleFunc("/users/{id}/extend", handlers.ExtendSubscription).Methods("POST")
 
+	// MAG devices (MAC to user binding for the Stalker portal)
+	api.HandleFunc("/mag-devices", handlers.GetMAGDevices).Methods("GET")
+	api.HandleFunc("/mag-devices", handlers.CreateMAGDevice).Methods("POST")
+	api.HandleFunc("/mag-devices/{id}", handlers.UpdateMAGDevice).Methods("PUT")
+	api.HandleFunc("/mag-devices/{id}", handlers.DeleteMAGDevice).Methods("DELETE")
+
 	// Settings
 	api.HandleFunc("/settings", handlers.GetSettings).Methods("GET")
 	api.HandleFunc("/settings", handlers.UpdateSettings).Methods("POST")
 	api.HandleFunc("/settings/test-ffmpeg", handlers.TestFFmpeg).Methods("POST")
 	api.HandleFunc("/settings/clear-cache", handlers.ClearHLSCache).Methods("POST")
 
+	// Transcoding profiles
+	api.HandleFunc("/transcode-profiles", handlers.GetTranscodeProfiles).Methods("GET")
+	api.HandleFunc("/transcode-profiles", handlers.CreateTranscodeProfile).Methods("POST")
+	api.HandleFunc("/transcode-profiles/{name}", handlers.UpdateTranscodeProfile).Methods("PUT")
+	api.HandleFunc("/transcode-profiles/{name}", handlers.DeleteTranscodeProfile).Methods("DELETE")
+
 	// Generate playlist
 	api.HandleFunc("/generate-playlist", handlers.GenerateUserPlaylist).Methods("POST")
 
@@ -143,12 +283,30 @@ func main() {
 	api.HandleFunc("/generated-playlists", handlers.SaveGeneratedPlaylist).Methods("POST")
 
 	// Stream relay endpoints (on-demand, multi-client)
-	r.HandleFunc("/stream/{path:.+}", handlers.StreamRelay).Methods("GET")
+	// The catch-all relay route must come last, {path:.+} would swallow the HLS routes.
 	r.HandleFunc("/stream/{path:.+}/hls", handlers.StreamRelayHLS).Methods("GET")
 	r.HandleFunc("/stream/{path:.+}/hls/{segment}", handlers.StreamRelayHLSSegment).Methods("GET")
+	r.HandleFunc("/stream/{path:.+}/hls/{variant:v[0-9]+}/{file}", handlers.StreamRelayHLSVariant).Methods("GET")
+	// Passthrough HLS: MPEG-TS sources segmented in Go, no FFmpeg process
+	r.HandleFunc("/stream/{path:.+}/passthrough.m3u8", handlers.StreamRelayPassthroughHLS).Methods("GET")
+	r.HandleFunc("/stream/{path:.+}/passthrough/{segment}", handlers.StreamRelayPassthroughSegment).Methods("GET")
+	r.HandleFunc("/stream/{path:.+}", handlers.StreamRelay).Methods("GET")
 
 	// Serve user playlists with short URL: /mql/{user}.m3u
 	r.HandleFunc("/mql/{user:[a-zA-Z0-9_-]+}.m3u", handlers.ServeUserPlaylist).Methods("GET")
+	// XMLTV guide of a user's playlist, linked by its url-tvg header
+	r.HandleFunc("/epg/{user:[a-zA-Z0-9_-]+}.xml", handlers.ServeUserEPG).Methods("GET")
+
+	// Xtream Codes compatible API for IPTV apps, authenticated per request
+	r.HandleFunc("/player_api.php", handlers.PlayerAPI).Methods("GET", "POST")
+	r.HandleFunc("/get.php", handlers.XtreamPlaylist).Methods("GET", "POST")
+	r.HandleFunc("/xmltv.php", handlers.XtreamGuide).Methods("GET", "POST")
+	r.HandleFunc("/live/{username}/{password}/{id:[0-9]+}.{ext:ts|m3u8}", handlers.XtreamLive).Methods("GET")
+	r.HandleFunc("/timeshift/{username}/{password}/{duration:[0-9]+}/{start}/{id:[0-9]+}.ts", handlers.XtreamTimeshift).Methods("GET")
+
+	// Stalker middleware portal for MAG set-top boxes, authenticated by MAC
+	r.HandleFunc("/stalker_portal/server/load.php", handlers.StalkerPortal).Methods("GET", "POST")
+	r.HandleFunc("/portal.php", handlers.StalkerPortal).Methods("GET", "POST")
 
 	// Serve generated playlists (legacy support)
 	r.PathPrefix("/generated_playlists/").Handler(http.StripPrefix("/generated_playlists/", http.FileServer(http.Dir("./generated_playlists"))))
@@ -162,10 +320,70 @@ func main() {
 		port = "8080"
 	}
 
+	// Time given to in-flight requests on shutdown before streams are cut
+	drainTimeout := 10 * time.Second
+	if seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && seconds >= 0 {
+		drainTimeout = time.Duration(seconds) * time.Second
+	}
+
+	// Probe channels in the background, reusing running sessions
+	health.GetChecker().Start()
+	handlers.StartTimeshift()
+	epg.GetImporter().Start()
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+
 	log.Printf("🎬 IPTV Panel server started on http://localhost:%s", port)
 	log.Printf("📺 Open your browser and navigate to http://localhost:%s", port)
 
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
 		log.Fatal("Server failed:", err)
+	case sig := <-signals:
+		log.Printf("🛑 Received %v, shutting down (drain timeout %v)", sig, drainTimeout)
 	}
+
+	shutdown(srv, drainTimeout)
+}
+
+// shutdown stops accepting connections, lets in-flight requests finish
+// for up to drainTimeout, then stops every stream and closes the
+// connection rows left open by viewers
+func shutdown(srv *http.Server, drainTimeout time.Duration) {
+	started := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⏳ Drain timeout reached, stopping remaining streams: %v", err)
+	}
+
+	// Stopping the sessions ends the streaming handlers still running
+	health.GetChecker().Stop()
+	epg.GetImporter().Stop()
+	handlers.StopRecorder()
+	summary := streaming.Shutdown()
+	srv.Close()
+
+	var closedConnections int64
+	result, err := database.DB.Exec(`
+		UPDATE user_connections 
+		SET disconnected_at = CURRENT_TIMESTAMP 
+		WHERE disconnected_at IS NULL
+	`)
+	if err != nil {
+		log.Printf("⚠️  Failed to close connection rows: %v", err)
+	} else {
+		closedConnections, _ = result.RowsAffected()
+	}
+
+	log.Printf("✅ Shutdown complete in %v: stopped %s, closed %d connection(s)",
+		time.Since(started).Round(time.Millisecond), summary, closedConnections)
 }
@@ -23,6 +23,13 @@ type Channel struct {
 	Group      string    `json:"group"`
 	Active     bool      `json:"active"`
 	OnDemand   bool      `json:"on_demand"`
+	SlowClientPolicy string `json:"slow_client_policy"` // "" = stream settings default
+	TranscodeProfile string `json:"transcode_profile"`  // "" = stream settings default
+	ABRLadder        string `json:"abr_ladder"`         // Comma separated profiles, "" = no ABR
+	Priority         int    `json:"priority"`           // Admission priority, higher wins at max_streams
+	DeliveryMode     string `json:"delivery_mode"`      // ffmpeg-remux, go-passthrough or redirect
+	TimeshiftHours   int    `json:"timeshift_hours"`    // Rewind window kept on disk, 0 = no timeshift
+	TvgID            string `json:"tvg_id"`             // XMLTV channel id used to match EPG data
 	CreatedAt  time.Time `json:"created_at"`
 }
 
@@ -49,6 +56,7 @@ type User struct {
 	ExpiresAt      *time.Time `json:"expires_at"`
 	LastLogin      *time.Time `json:"last_login"`
 	Notes          string     `json:"notes"`
+	TranscodeProfile string   `json:"transcode_profile"` // "" = channel or default profile
 	DaysRemaining  int        `json:"days_remaining"`
 	IsExpired      bool       `json:"is_expired"`
 }
@@ -0,0 +1,353 @@
+package mpegts
+
+import "fmt"
+
+// Stream kinds reported by the Inspector
+const (
+	KindVideo    = "video"
+	KindAudio    = "audio"
+	KindSubtitle = "subtitle"
+	KindData     = "data"
+)
+
+// StreamKind classifies an elementary stream as video, audio, subtitle or data
+func StreamKind(es ElementaryStream) string {
+	switch {
+	case IsVideo(es.StreamType):
+		return KindVideo
+	case isAudio(es):
+		return KindAudio
+	case es.StreamType == StreamTypePrivatePES && (hasDescriptor(es.Descriptors, 0x59) || hasDescriptor(es.Descriptors, 0x56)):
+		return KindSubtitle
+	}
+	return KindData
+}
+
+// CodecName returns the codec of an elementary stream in FFmpeg's naming
+func CodecName(es ElementaryStream) string {
+	switch es.StreamType {
+	case StreamTypeMPEG1Video:
+		return "mpeg1video"
+	case StreamTypeMPEG2Video:
+		return "mpeg2video"
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio:
+		return "mp2"
+	case StreamTypeAACADTS:
+		return "aac"
+	case StreamTypeAACLATM:
+		return "aac_latm"
+	case StreamTypeH264:
+		return "h264"
+	case StreamTypeH265:
+		return "hevc"
+	case StreamTypeAC3:
+		return "ac3"
+	case StreamTypeEAC3:
+		return "eac3"
+	case 0x86:
+		return "scte_35"
+	case StreamTypePrivatePES:
+		switch {
+		case hasDescriptor(es.Descriptors, 0x6A):
+			return "ac3"
+		case hasDescriptor(es.Descriptors, 0x7A):
+			return "eac3"
+		case hasDescriptor(es.Descriptors, 0x59):
+			return "dvb_subtitle"
+		case hasDescriptor(es.Descriptors, 0x56):
+			return "dvb_teletext"
+		}
+	}
+	return fmt.Sprintf("0x%02x", es.StreamType)
+}
+
+// bitReader reads big-endian bit fields and Exp-Golomb codes
+type bitReader struct {
+	data []byte
+	pos  int  // Bit position
+	err  bool // Read past the end
+}
+
+func (b *bitReader) bits(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		if b.pos >= len(b.data)*8 {
+			b.err = true
+			return 0
+		}
+		bit := b.data[b.pos/8] >> (7 - uint(b.pos%8)) & 1
+		v = v<<1 | uint(bit)
+		b.pos++
+	}
+	return v
+}
+
+func (b *bitReader) skip(n int) {
+	b.pos += n
+	if b.pos > len(b.data)*8 {
+		b.err = true
+	}
+}
+
+// ue reads an unsigned Exp-Golomb code
+func (b *bitReader) ue() uint {
+	zeros := 0
+	for b.bits(1) == 0 {
+		if b.err || zeros > 31 {
+			b.err = true
+			return 0
+		}
+		zeros++
+	}
+	return 1<<uint(zeros) - 1 + b.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb code
+func (b *bitReader) se() int {
+	v := b.ue()
+	if v&1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+// unescapeRBSP removes emulation prevention bytes (00 00 03) from a NAL unit
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, c := range nal {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// VideoResolution scans elementary stream bytes for a sequence parameter
+// set (H.264, HEVC) or sequence header (MPEG-1/2) and returns the picture size
+func VideoResolution(streamType byte, data []byte) (width, height int, ok bool) {
+	for i := 0; i+4 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		code := data[i+3]
+		switch streamType {
+		case StreamTypeH264:
+			if code&0x1F == 7 {
+				return h264Resolution(unescapeRBSP(data[i+4:]))
+			}
+		case StreamTypeH265:
+			if (code>>1)&0x3F == 33 && i+5 < len(data) {
+				return hevcResolution(unescapeRBSP(data[i+5:]))
+			}
+		case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+			if code == 0xB3 && i+7 <= len(data) {
+				width = int(data[i+4])<<4 | int(data[i+5])>>4
+				height = int(data[i+5]&0x0F)<<8 | int(data[i+6])
+				return width, height, width > 0 && height > 0
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// h264Resolution parses an H.264 SPS (after the NAL header)
+func h264Resolution(sps []byte) (int, int, bool) {
+	b := &bitReader{data: sps}
+	profile := b.bits(8)
+	b.skip(16) // Constraint flags and level
+	b.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = b.ue()
+		if chromaFormat == 3 {
+			b.skip(1) // separate_colour_plane_flag
+		}
+		b.ue()    // bit_depth_luma_minus8
+		b.ue()    // bit_depth_chroma_minus8
+		b.skip(1) // qpprime_y_zero_transform_bypass_flag
+		if b.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if b.bits(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + b.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	b.ue() // log2_max_frame_num_minus4
+	switch b.ue() {
+	case 0:
+		b.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		b.skip(1)
+		b.se()
+		b.se()
+		for n := b.ue(); n > 0 && !b.err; n-- {
+			b.se()
+		}
+	}
+	b.ue()    // max_num_ref_frames
+	b.skip(1) // gaps_in_frame_num_value_allowed_flag
+	widthMbs := b.ue() + 1
+	heightUnits := b.ue() + 1
+	frameMbsOnly := b.bits(1)
+	if frameMbsOnly == 0 {
+		b.skip(1) // mb_adaptive_frame_field_flag
+	}
+	b.skip(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if b.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = b.ue(), b.ue(), b.ue(), b.ue()
+	}
+	if b.err {
+		return 0, 0, false
+	}
+
+	cropX, cropY := uint(1), 2-frameMbsOnly
+	switch chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+	width := int(widthMbs*16) - int((cropLeft+cropRight)*cropX)
+	height := int((2-frameMbsOnly)*heightUnits*16) - int((cropTop+cropBottom)*cropY)
+	return width, height, width > 0 && height > 0
+}
+
+// hevcResolution parses an HEVC SPS (after the two byte NAL header)
+func hevcResolution(sps []byte) (int, int, bool) {
+	b := &bitReader{data: sps}
+	b.skip(4) // sps_video_parameter_set_id
+	maxSubLayers := int(b.bits(3))
+	b.skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level: general profile (88 bits) and level (8 bits)
+	b.skip(96)
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := 0; i < maxSubLayers; i++ {
+		profilePresent[i] = b.bits(1) == 1
+		levelPresent[i] = b.bits(1) == 1
+	}
+	if maxSubLayers > 0 {
+		b.skip(2 * (8 - maxSubLayers))
+	}
+	for i := 0; i < maxSubLayers; i++ {
+		if profilePresent[i] {
+			b.skip(88)
+		}
+		if levelPresent[i] {
+			b.skip(8)
+		}
+	}
+
+	b.ue() // sps_seq_parameter_set_id
+	chromaFormat := b.ue()
+	if chromaFormat == 3 {
+		b.skip(1) // separate_colour_plane_flag
+	}
+	width := int(b.ue())
+	height := int(b.ue())
+	if b.bits(1) == 1 {
+		subWidth, subHeight := 1, 1
+		switch chromaFormat {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+		left, right, top, bottom := b.ue(), b.ue(), b.ue(), b.ue()
+		width -= subWidth * int(left+right)
+		height -= subHeight * int(top+bottom)
+	}
+	if b.err {
+		return 0, 0, false
+	}
+	return width, height, width > 0 && height > 0
+}
+
+// adtsSampleRates maps the ADTS sampling frequency index to Hz
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AudioFormat reads the sample rate and channel count from the first frame
+// header of AAC (ADTS) or AC-3 elementary stream bytes
+func AudioFormat(codec string, data []byte) (sampleRate, channels int, ok bool) {
+	switch codec {
+	case "aac":
+		if len(data) < 7 || data[0] != 0xFF || data[1]&0xF0 != 0xF0 {
+			return 0, 0, false
+		}
+		idx := int(data[2]>>2) & 0x0F
+		if idx >= len(adtsSampleRates) {
+			return 0, 0, false
+		}
+		channels = int(data[2]&0x01)<<2 | int(data[3]>>6)
+		if channels == 7 {
+			channels = 8 // 7.1
+		}
+		return adtsSampleRates[idx], channels, true
+	case "ac3":
+		if len(data) < 7 || data[0] != 0x0B || data[1] != 0x77 {
+			return 0, 0, false
+		}
+		switch data[4] >> 6 {
+		case 0:
+			sampleRate = 48000
+		case 1:
+			sampleRate = 44100
+		case 2:
+			sampleRate = 32000
+		default:
+			return 0, 0, false
+		}
+		acmod := data[6] >> 5
+		channels = []int{2, 1, 2, 3, 3, 4, 4, 5}[acmod]
+		// The LFE flag follows acmod and the mix levels it implies
+		lfeBit := 3
+		if acmod&0x01 != 0 && acmod != 1 {
+			lfeBit += 2 // cmixlev
+		}
+		if acmod&0x04 != 0 {
+			lfeBit += 2 // surmixlev
+		}
+		if acmod == 2 {
+			lfeBit += 2 // dsurmod
+		}
+		b := &bitReader{data: data[6:]}
+		b.skip(lfeBit)
+		if b.bits(1) == 1 {
+			channels++
+		}
+		return sampleRate, channels, !b.err
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,332 @@
+package mpegts
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// bitrateWindow is how long packet bytes are counted per bitrate sample
+	bitrateWindow = 5 * time.Second
+	// rescanInterval is how often codec parameters are read again, so a
+	// changed resolution is noticed
+	rescanInterval = 30 * time.Second
+	// maxScanBytes bounds the elementary stream bytes collected per scan
+	maxScanBytes = 4096
+	// maxPCRGap is the largest PCR step treated as continuous
+	maxPCRGap = 90000 // 1 second in 90 kHz units
+)
+
+// StreamStats describes one elementary stream seen by the Inspector
+type StreamStats struct {
+	PID         uint16  `json:"pid"`
+	Kind        string  `json:"kind"`
+	Codec       string  `json:"codec"`
+	StreamType  byte    `json:"stream_type"`
+	Language    string  `json:"language,omitempty"`
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	SampleRate  int     `json:"sample_rate,omitempty"`
+	Channels    int     `json:"channels,omitempty"`
+	BitrateKbps float64 `json:"bitrate_kbps"`
+	Packets     uint64  `json:"packets"`
+	CCErrors    uint64  `json:"cc_errors"`
+}
+
+// StreamInfo is a snapshot of what the Inspector learned about a stream
+type StreamInfo struct {
+	Program            uint16        `json:"program"`
+	PCRPID             uint16        `json:"pcr_pid"`
+	Streams            []StreamStats `json:"streams"`
+	BitrateKbps        float64       `json:"bitrate_kbps"`
+	Packets            uint64        `json:"packets"`
+	CCErrors           uint64        `json:"cc_errors"`
+	PCRJitterMs        float64       `json:"pcr_jitter_ms"` // Smoothed PCR arrival jitter
+	PCRJitterMaxMs     float64       `json:"pcr_jitter_max_ms"`
+	PCRIntervalMaxMs   float64       `json:"pcr_interval_max_ms"` // Longest gap between PCRs
+	PCRDiscontinuities uint64        `json:"pcr_discontinuities"`
+	Since              time.Time     `json:"since"`
+}
+
+// Video returns the first video stream, if any
+func (i StreamInfo) Video() (StreamStats, bool) {
+	for _, s := range i.Streams {
+		if s.Kind == KindVideo {
+			return s, true
+		}
+	}
+	return StreamStats{}, false
+}
+
+// Audio returns the audio streams
+func (i StreamInfo) Audio() []StreamStats {
+	var audio []StreamStats
+	for _, s := range i.Streams {
+		if s.Kind == KindAudio {
+			audio = append(audio, s)
+		}
+	}
+	return audio
+}
+
+// pidState tracks one PID
+type pidState struct {
+	stats       StreamStats
+	windowBytes uint64
+	lastCC      byte
+	hasCC       bool
+	scan        []byte // Start of the current PES, read for codec parameters
+	scanning    bool
+	lastScan    time.Time
+	scanned     bool
+}
+
+// Inspector parses MPEG-TS as it flows by and collects the elementary
+// streams with their codecs, the bitrate per PID, continuity counter errors
+// and PCR timing. Write and Snapshot may be called concurrently.
+type Inspector struct {
+	mux    sync.Mutex
+	reader Reader
+	pids   map[uint16]*pidState
+
+	windowStart time.Time
+	windowBytes uint64
+	bitrate     float64
+	packets     uint64
+	ccErrors    uint64
+	since       time.Time
+
+	lastPCR            int64
+	lastPCRAt          time.Time
+	hasPCR             bool
+	pcrJitter          float64
+	pcrJitterMax       float64
+	pcrIntervalMax     float64
+	pcrDiscontinuities uint64
+
+	now time.Time // Arrival time of the data being fed
+}
+
+// NewInspector creates an Inspector
+func NewInspector() *Inspector {
+	return &Inspector{pids: make(map[uint16]*pidState)}
+}
+
+// Write feeds stream data into the inspector
+func (i *Inspector) Write(p []byte) (int, error) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.now = time.Now()
+	if i.since.IsZero() {
+		i.since = i.now
+		i.windowStart = i.now
+	}
+	i.reader.Feed(p, i.handlePacket)
+
+	if elapsed := i.now.Sub(i.windowStart); elapsed >= bitrateWindow {
+		seconds := elapsed.Seconds()
+		i.bitrate = float64(i.windowBytes) * 8 / seconds / 1000
+		i.windowBytes = 0
+		for _, st := range i.pids {
+			st.stats.BitrateKbps = float64(st.windowBytes) * 8 / seconds / 1000
+			st.windowBytes = 0
+		}
+		i.windowStart = i.now
+	}
+	return len(p), nil
+}
+
+// Reset forgets everything, e.g. when the source restarts
+func (i *Inspector) Reset() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.reader = Reader{}
+	i.pids = make(map[uint16]*pidState)
+	i.windowBytes, i.bitrate, i.packets, i.ccErrors = 0, 0, 0, 0
+	i.since, i.windowStart = time.Time{}, time.Time{}
+	i.hasPCR = false
+	i.pcrJitter, i.pcrJitterMax, i.pcrIntervalMax, i.pcrDiscontinuities = 0, 0, 0, 0
+}
+
+// Ready reports whether the program has been parsed, the parameters of its
+// video streams are known and a bitrate has been sampled
+func (i *Inspector) Ready() bool {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	prog, ok := i.reader.Program()
+	if !ok || i.windowStart.Equal(i.since) {
+		return false
+	}
+	for _, es := range prog.Streams {
+		kind := StreamKind(es)
+		if kind != KindVideo && kind != KindAudio {
+			continue
+		}
+		st := i.pids[es.PID]
+		if st == nil || (!st.scanned && kind == KindVideo) {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns what has been learned so far
+func (i *Inspector) Snapshot() StreamInfo {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	info := StreamInfo{
+		BitrateKbps:        i.bitrate,
+		Packets:            i.packets,
+		CCErrors:           i.ccErrors,
+		PCRJitterMs:        i.pcrJitter,
+		PCRJitterMaxMs:     i.pcrJitterMax,
+		PCRIntervalMaxMs:   i.pcrIntervalMax,
+		PCRDiscontinuities: i.pcrDiscontinuities,
+		Since:              i.since,
+		Streams:            []StreamStats{},
+	}
+	if prog, ok := i.reader.Program(); ok {
+		info.Program, info.PCRPID = prog.Number, prog.PCRPID
+		for _, es := range prog.Streams {
+			if st := i.pids[es.PID]; st != nil {
+				info.Streams = append(info.Streams, st.stats)
+			}
+		}
+	}
+	sort.Slice(info.Streams, func(a, b int) bool { return info.Streams[a].PID < info.Streams[b].PID })
+	return info
+}
+
+func (i *Inspector) handlePacket(pkt []byte, info PacketInfo) {
+	i.packets++
+	i.windowBytes += PacketSize
+	if info.PID == NullPID {
+		return
+	}
+
+	st := i.pids[info.PID]
+	if st == nil {
+		st = &pidState{stats: StreamStats{PID: info.PID, Kind: KindData}}
+		i.pids[info.PID] = st
+	}
+	st.stats.Packets++
+	st.windowBytes += PacketSize
+	i.checkContinuity(st, pkt)
+
+	prog, ok := i.reader.Program()
+	if !ok {
+		return
+	}
+	if info.HasPCR && info.PID == prog.PCRPID {
+		i.checkPCR(info.PCR, Discontinuity(pkt))
+	}
+	if info.PSI {
+		return
+	}
+	for _, es := range prog.Streams {
+		if es.PID == info.PID {
+			i.inspectES(st, es, pkt)
+			return
+		}
+	}
+}
+
+// checkContinuity counts packets whose continuity counter skips a value.
+// One duplicate packet is allowed, as are jumps flagged as discontinuities.
+func (i *Inspector) checkContinuity(st *pidState, pkt []byte) {
+	cc := ContinuityCounter(pkt)
+	if !HasPayload(pkt) {
+		return
+	}
+	if st.hasCC && !Discontinuity(pkt) && cc != (st.lastCC+1)&0x0F && cc != st.lastCC {
+		st.stats.CCErrors++
+		i.ccErrors++
+	}
+	st.lastCC, st.hasCC = cc, true
+}
+
+// checkPCR compares the PCR clock with the arrival time of the packets
+// carrying it. Jitter is smoothed like RTP interarrival jitter (RFC 3550).
+func (i *Inspector) checkPCR(pcr int64, discontinuity bool) {
+	defer func() {
+		i.lastPCR, i.lastPCRAt, i.hasPCR = pcr, i.now, true
+	}()
+	if !i.hasPCR || discontinuity {
+		return
+	}
+
+	step := Elapsed(i.lastPCR, pcr)
+	if step < 0 || step > maxPCRGap {
+		i.pcrDiscontinuities++
+		return
+	}
+	intervalMs := float64(step) / 90
+	if intervalMs > i.pcrIntervalMax {
+		i.pcrIntervalMax = intervalMs
+	}
+
+	arrivalMs := float64(i.now.Sub(i.lastPCRAt)) / float64(time.Millisecond)
+	d := arrivalMs - intervalMs
+	if d < 0 {
+		d = -d
+	}
+	i.pcrJitter += (d - i.pcrJitter) / 16
+	if d > i.pcrJitterMax {
+		i.pcrJitterMax = d
+	}
+}
+
+// inspectES records the stream description and reads codec parameters from
+// the start of a PES now and then
+func (i *Inspector) inspectES(st *pidState, es ElementaryStream, pkt []byte) {
+	st.stats.Kind = StreamKind(es)
+	st.stats.Codec = CodecName(es)
+	st.stats.StreamType = es.StreamType
+	st.stats.Language = es.Language
+	if st.stats.Kind != KindVideo && st.stats.Kind != KindAudio {
+		return
+	}
+
+	payload := Payload(pkt)
+	if PayloadUnitStart(pkt) {
+		if st.scanning {
+			i.scanParameters(st, es)
+		}
+		if !st.scanned || i.now.Sub(st.lastScan) >= rescanInterval {
+			st.scan = append(st.scan[:0], PESData(payload)...)
+			st.scanning = true
+		}
+	} else if st.scanning {
+		st.scan = append(st.scan, payload...)
+	}
+
+	if st.scanning && len(st.scan) >= maxScanBytes {
+		i.scanParameters(st, es)
+	}
+}
+
+// scanParameters reads resolution or audio format from the collected bytes
+func (i *Inspector) scanParameters(st *pidState, es ElementaryStream) {
+	st.scanning = false
+	switch {
+	case st.stats.Kind == KindVideo:
+		if w, h, ok := VideoResolution(es.StreamType, st.scan); ok {
+			st.stats.Width, st.stats.Height = w, h
+			st.scanned, st.lastScan = true, i.now
+		}
+	case st.stats.Codec == "aac" || st.stats.Codec == "ac3":
+		if rate, channels, ok := AudioFormat(st.stats.Codec, st.scan); ok {
+			st.stats.SampleRate, st.stats.Channels = rate, channels
+			st.scanned, st.lastScan = true, i.now
+		}
+	default:
+		// Nothing to read for other audio codecs
+		st.scanned, st.lastScan = true, i.now
+	}
+	st.scan = st.scan[:0]
+}
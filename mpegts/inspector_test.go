@@ -0,0 +1,131 @@
+package mpegts
+
+import "testing"
+
+// withCC sets the continuity counter of a packet
+func withCC(pkt []byte, cc byte) []byte {
+	pkt[3] = pkt[3]&0xF0 | cc&0x0F
+	return pkt
+}
+
+// adtsFrame is the start of an AAC LC frame, 44.1 kHz stereo
+var adtsFrame = []byte{0xFF, 0xF1, 0x50, 0x80, 0x00, 0x1F, 0xFC}
+
+func TestInspectorStreams(t *testing.T) {
+	const subtitlePID = 0x103
+	i := NewInspector()
+	i.Write(concat(
+		programHeader(testVideoPID,
+			testVideo,
+			ElementaryStream{PID: testAudioPID, StreamType: StreamTypeAACADTS, Descriptors: []byte{0x0A, 0x04, 'i', 'n', 'd', 0x00}},
+			ElementaryStream{PID: subtitlePID, StreamType: StreamTypePrivatePES, Descriptors: []byte{0x59, 0x08, 'e', 'n', 'g', 0x10, 0, 1, 0, 1}},
+		),
+		tsPacket(testVideoPID, true, nil, pesPayload(0xE0, 0, h264Slice)),
+		tsPacket(testAudioPID, true, nil, pesPayload(0xC0, 0, adtsFrame)),
+		withCC(tsPacket(testAudioPID, true, nil, pesPayload(0xC0, 1920, adtsFrame)), 1),
+		tsPacket(subtitlePID, true, nil, pesPayload(0xBD, 0, []byte{0x20, 0x00})),
+		tsPacket(NullPID, false, nil, nil),
+	))
+
+	info := i.Snapshot()
+	if info.Program != 1 || info.PCRPID != testVideoPID || info.Packets != 7 || info.CCErrors != 0 {
+		t.Fatalf("snapshot %+v", info)
+	}
+	want := []StreamStats{
+		{PID: testVideoPID, Kind: KindVideo, Codec: "h264", StreamType: StreamTypeH264, Packets: 1},
+		{PID: testAudioPID, Kind: KindAudio, Codec: "aac", StreamType: StreamTypeAACADTS, Language: "ind", SampleRate: 44100, Channels: 2, Packets: 2},
+		{PID: subtitlePID, Kind: KindSubtitle, Codec: "dvb_subtitle", StreamType: StreamTypePrivatePES, Language: "eng", Packets: 1},
+	}
+	if len(info.Streams) != len(want) {
+		t.Fatalf("got %d streams, want %d: %+v", len(info.Streams), len(want), info.Streams)
+	}
+	for idx, st := range info.Streams {
+		if st != want[idx] {
+			t.Errorf("stream %d:\n got %+v\nwant %+v", idx, st, want[idx])
+		}
+	}
+	if v, ok := info.Video(); !ok || v.PID != testVideoPID {
+		t.Errorf("video %+v, %v", v, ok)
+	}
+	if audio := info.Audio(); len(audio) != 1 || audio[0].PID != testAudioPID {
+		t.Errorf("audio %+v", audio)
+	}
+
+	i.Reset()
+	if info := i.Snapshot(); info.Packets != 0 || len(info.Streams) != 0 {
+		t.Errorf("snapshot after reset %+v", info)
+	}
+}
+
+func TestInspectorContinuity(t *testing.T) {
+	payload := func(cc byte) []byte { return withCC(tsPacket(testVideoPID, false, nil, []byte{0x00}), cc) }
+	discontinuity := func(cc byte) []byte { return withCC(tsPacket(testVideoPID, false, []byte{0x80}, []byte{0x00}), cc) }
+	adaptationOnly := func(cc byte) []byte {
+		pkt := withCC(tsPacket(testVideoPID, false, nil, nil), cc)
+		pkt[3] &^= 0x10
+		return pkt
+	}
+
+	tests := []struct {
+		name    string
+		packets [][]byte
+		errors  uint64
+	}{
+		{"counter increments", [][]byte{payload(0), payload(1), payload(2)}, 0},
+		{"counter wraps", [][]byte{payload(14), payload(15), payload(0)}, 0},
+		{"one duplicate is allowed", [][]byte{payload(3), payload(3), payload(4)}, 0},
+		{"lost packets", [][]byte{payload(0), payload(1), payload(5), payload(9)}, 2},
+		{"flagged discontinuity", [][]byte{payload(0), discontinuity(7), payload(8)}, 0},
+		{"packets without payload keep the counter", [][]byte{payload(0), adaptationOnly(9), payload(1)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := NewInspector()
+			i.Write(concat(append([][]byte{programHeader(testVideoPID, testVideo)}, tt.packets...)...))
+
+			info := i.Snapshot()
+			if info.CCErrors != tt.errors {
+				t.Errorf("%d CC errors, want %d", info.CCErrors, tt.errors)
+			}
+			if len(info.Streams) != 1 || info.Streams[0].CCErrors != tt.errors {
+				t.Errorf("streams %+v, want %d CC errors on the video PID", info.Streams, tt.errors)
+			}
+		})
+	}
+}
+
+func TestInspectorPCR(t *testing.T) {
+	pcr := func(base int64, discontinuity bool) []byte {
+		af := pcrField(base, false)
+		if discontinuity {
+			af[0] |= 0x80
+		}
+		return tsPacket(testVideoPID, false, af, []byte{0x00})
+	}
+
+	tests := []struct {
+		name            string
+		packets         [][]byte
+		discontinuities uint64
+		intervalMaxMs   float64
+	}{
+		{"regular PCR", [][]byte{pcr(0, false), pcr(3600, false), pcr(7200, false)}, 0, 40},
+		{"gap over a second", [][]byte{pcr(0, false), pcr(3600, false), pcr(3600+2*90000, false)}, 1, 40},
+		{"clock going back", [][]byte{pcr(90000, false), pcr(9000, false)}, 1, 0},
+		{"flagged discontinuity", [][]byte{pcr(0, false), pcr(900000, true), pcr(904500, false)}, 0, 50},
+		{"PCR wrap-around", [][]byte{pcr(1<<33-4500, false), pcr(4500, false)}, 0, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := NewInspector()
+			i.Write(concat(append([][]byte{programHeader(testVideoPID, testVideo)}, tt.packets...)...))
+
+			info := i.Snapshot()
+			if info.PCRDiscontinuities != tt.discontinuities || info.PCRIntervalMaxMs != tt.intervalMaxMs {
+				t.Errorf("%d discontinuities, longest interval %vms; want %d, %vms", info.PCRDiscontinuities, info.PCRIntervalMaxMs, tt.discontinuities, tt.intervalMaxMs)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package mpegts
+
+// MPEG-TS packet helpers. All functions expect a full 188-byte packet
+// starting with the sync byte.
+
+const (
+	// PacketSize is the size of an MPEG-TS packet
+	PacketSize = 188
+	// SyncByte starts every MPEG-TS packet
+	SyncByte = 0x47
+
+	// PATPID is the PID carrying the Program Association Table
+	PATPID = 0x0000
+	// NullPID is the PID of stuffing packets
+	NullPID = 0x1FFF
+)
+
+// Stream types from the PMT (ISO/IEC 13818-1 table 2-34 plus common extensions)
+const (
+	StreamTypeMPEG1Video = 0x01
+	StreamTypeMPEG2Video = 0x02
+	StreamTypeMPEG1Audio = 0x03
+	StreamTypeMPEG2Audio = 0x04
+	StreamTypePrivatePES = 0x06
+	StreamTypeAACADTS    = 0x0F
+	StreamTypeAACLATM    = 0x11
+	StreamTypeH264       = 0x1B
+	StreamTypeH265       = 0x24
+	StreamTypeAC3        = 0x81
+	StreamTypeEAC3       = 0x87
+)
+
+// PID returns the packet identifier
+func PID(p []byte) uint16 {
+	return uint16(p[1]&0x1F)<<8 | uint16(p[2])
+}
+
+// PayloadUnitStart reports whether a PES packet or PSI section starts in this packet
+func PayloadUnitStart(p []byte) bool {
+	return p[1]&0x40 != 0
+}
+
+// ContinuityCounter returns the 4-bit continuity counter
+func ContinuityCounter(p []byte) byte {
+	return p[3] & 0x0F
+}
+
+// HasPayload reports whether the packet carries payload bytes
+func HasPayload(p []byte) bool {
+	return p[3]&0x10 != 0
+}
+
+// adaptationField returns the adaptation field body (without the length byte)
+func adaptationField(p []byte) []byte {
+	if p[3]&0x20 == 0 {
+		return nil
+	}
+	length := int(p[4])
+	if length == 0 || 5+length > PacketSize {
+		return nil
+	}
+	return p[5 : 5+length]
+}
+
+// Discontinuity reports the discontinuity indicator of the adaptation field
+func Discontinuity(p []byte) bool {
+	af := adaptationField(p)
+	return len(af) > 0 && af[0]&0x80 != 0
+}
+
+// RandomAccess reports the random access indicator of the adaptation field
+func RandomAccess(p []byte) bool {
+	af := adaptationField(p)
+	return len(af) > 0 && af[0]&0x40 != 0
+}
+
+// PCR returns the program clock reference base (90 kHz units) if present
+func PCR(p []byte) (int64, bool) {
+	af := adaptationField(p)
+	if len(af) < 7 || af[0]&0x10 == 0 {
+		return 0, false
+	}
+	base := int64(af[1])<<25 | int64(af[2])<<17 | int64(af[3])<<9 | int64(af[4])<<1 | int64(af[5])>>7
+	return base, true
+}
+
+// Payload returns the payload bytes of the packet
+func Payload(p []byte) []byte {
+	if !HasPayload(p) {
+		return nil
+	}
+	offset := 4
+	if p[3]&0x20 != 0 {
+		offset += 1 + int(p[4])
+	}
+	if offset >= PacketSize {
+		return nil
+	}
+	return p[offset:]
+}
+
+// PESTimestamp returns the PTS (90 kHz units) of a PES header at the start of payload
+func PESTimestamp(payload []byte) (int64, bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+	if payload[7]&0x80 == 0 {
+		return 0, false
+	}
+	b := payload[9:14]
+	pts := int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+	return pts, true
+}
+
+// PESData returns the elementary stream bytes that follow a PES header
+func PESData(payload []byte) []byte {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	start := 9 + int(payload[8])
+	if start > len(payload) {
+		return nil
+	}
+	return payload[start:]
+}
+
+// IsVideo reports whether the stream type carries video
+func IsVideo(streamType byte) bool {
+	switch streamType {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video, StreamTypeH264, StreamTypeH265:
+		return true
+	}
+	return false
+}
+
+// ContainsKeyframe scans elementary stream bytes for the start of a random
+// access picture (H.264 IDR, HEVC IRAP, MPEG-2 sequence header).
+func ContainsKeyframe(streamType byte, data []byte) bool {
+	for i := 0; i+3 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		code := data[i+3]
+		switch streamType {
+		case StreamTypeH264:
+			if nalType := code & 0x1F; nalType == 5 {
+				return true
+			}
+		case StreamTypeH265:
+			if nalType := (code >> 1) & 0x3F; nalType >= 16 && nalType <= 21 {
+				return true
+			}
+		case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+			if code == 0xB3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Elapsed returns the difference between two 33-bit 90 kHz timestamps,
+// accounting for wrap-around.
+func Elapsed(from, to int64) int64 {
+	const wrap = int64(1) << 33
+	d := (to - from) % wrap
+	if d < 0 {
+		d += wrap
+	}
+	// Treat huge positive deltas as small negative jumps (reordering)
+	if d > wrap/2 {
+		return d - wrap
+	}
+	return d
+}
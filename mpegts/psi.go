@@ -0,0 +1,112 @@
+package mpegts
+
+// ElementaryStream describes one stream listed in the PMT
+type ElementaryStream struct {
+	PID         uint16
+	StreamType  byte
+	Language    string
+	Descriptors []byte
+}
+
+// Program is the parsed content of a PMT section
+type Program struct {
+	Number  uint16
+	PCRPID  uint16
+	Streams []ElementaryStream
+}
+
+// psiSection returns the section that starts in a payload with a pointer field
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start >= len(payload) {
+		return nil
+	}
+	return payload[start:]
+}
+
+// sectionLength returns the full length of a PSI section including its header
+func sectionLength(section []byte) int {
+	if len(section) < 3 {
+		return -1
+	}
+	return 3 + (int(section[1]&0x0F)<<8 | int(section[2]))
+}
+
+// ParsePAT returns the PMT PID of the first program listed in a PAT section
+func ParsePAT(section []byte) (program uint16, pmtPID uint16, ok bool) {
+	length := sectionLength(section)
+	if length < 12 || len(section) < length || section[0] != 0x00 {
+		return 0, 0, false
+	}
+	// Program loop runs from byte 8 up to the CRC
+	for i := 8; i+4 <= length-4; i += 4 {
+		number := uint16(section[i])<<8 | uint16(section[i+1])
+		pid := uint16(section[i+2]&0x1F)<<8 | uint16(section[i+3])
+		if number != 0 {
+			return number, pid, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ParsePMT parses a complete PMT section
+func ParsePMT(section []byte) (Program, bool) {
+	var prog Program
+	length := sectionLength(section)
+	if length < 16 || len(section) < length || section[0] != 0x02 {
+		return prog, false
+	}
+
+	prog.Number = uint16(section[3])<<8 | uint16(section[4])
+	prog.PCRPID = uint16(section[8]&0x1F)<<8 | uint16(section[9])
+	infoLen := int(section[10]&0x0F)<<8 | int(section[11])
+
+	end := length - 4 // CRC32
+	for i := 12 + infoLen; i+5 <= end; {
+		es := ElementaryStream{
+			StreamType: section[i],
+			PID:        uint16(section[i+1]&0x1F)<<8 | uint16(section[i+2]),
+		}
+		esInfoLen := int(section[i+3]&0x0F)<<8 | int(section[i+4])
+		descEnd := i + 5 + esInfoLen
+		if descEnd > end {
+			break
+		}
+		es.Descriptors = append([]byte(nil), section[i+5:descEnd]...)
+		es.Language = descriptorLanguage(es.Descriptors)
+		prog.Streams = append(prog.Streams, es)
+		i = descEnd
+	}
+	return prog, true
+}
+
+// descriptorLanguage extracts the ISO 639 language code from a descriptor loop
+func descriptorLanguage(desc []byte) string {
+	for i := 0; i+2 <= len(desc); {
+		tag, length := desc[i], int(desc[i+1])
+		if i+2+length > len(desc) {
+			break
+		}
+		body := desc[i+2 : i+2+length]
+		// ISO_639_language_descriptor, subtitling and teletext descriptors all start with a language code
+		if (tag == 0x0A || tag == 0x59 || tag == 0x56) && len(body) >= 3 {
+			return string(body[:3])
+		}
+		i += 2 + length
+	}
+	return ""
+}
+
+// hasDescriptor reports whether a descriptor loop contains the given tag
+func hasDescriptor(desc []byte, tag byte) bool {
+	for i := 0; i+2 <= len(desc); {
+		if desc[i] == tag {
+			return true
+		}
+		i += 2 + int(desc[i+1])
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package mpegts
+
+// PacketInfo describes a packet delivered by Reader.Feed
+type PacketInfo struct {
+	PID      uint16
+	PSI      bool  // PAT or PMT packet
+	Keyframe bool  // starts a PES with a random access point on the main stream
+	PTS      int64 // PTS of a PES starting on the main stream, valid if HasPTS
+	HasPTS   bool
+	PCR      int64 // PCR base in 90 kHz units, valid if HasPCR
+	HasPCR   bool
+}
+
+// Reader frames a byte stream into MPEG-TS packets and tracks the PAT/PMT
+// and the main (first video, else first audio) elementary stream.
+// It is not safe for concurrent use.
+type Reader struct {
+	partial []byte
+
+	pmtPID     uint16
+	hasPMTPID  bool
+	program    Program
+	hasProgram bool
+
+	mainPID  uint16
+	mainType byte
+	hasMain  bool
+
+	pat        []byte
+	pmt        []byte
+	pmtPending []byte
+	pmtSection []byte
+}
+
+// Feed processes data and calls fn for every complete packet. The packet
+// slice is only valid during the callback.
+func (r *Reader) Feed(data []byte, fn func(pkt []byte, info PacketInfo)) {
+	// Complete a packet split across the previous call
+	if len(r.partial) > 0 {
+		need := PacketSize - len(r.partial)
+		if len(data) < need {
+			r.partial = append(r.partial, data...)
+			return
+		}
+		r.partial = append(r.partial, data[:need]...)
+		data = data[need:]
+		if r.partial[0] == SyncByte {
+			r.handle(r.partial, fn)
+		}
+		r.partial = r.partial[:0]
+	}
+
+	for len(data) > 0 {
+		if data[0] != SyncByte {
+			// Lost sync, skip to the next sync byte
+			i := 1
+			for i < len(data) && data[i] != SyncByte {
+				i++
+			}
+			data = data[i:]
+			continue
+		}
+		if len(data) < PacketSize {
+			r.partial = append(r.partial[:0], data...)
+			return
+		}
+		r.handle(data[:PacketSize], fn)
+		data = data[PacketSize:]
+	}
+}
+
+// Partial returns bytes of an incomplete trailing packet
+func (r *Reader) Partial() []byte {
+	return r.partial
+}
+
+// PAT returns the most recent PAT packet, or nil
+func (r *Reader) PAT() []byte {
+	return r.pat
+}
+
+// PMT returns the packets of the most recent complete PMT section, or nil
+func (r *Reader) PMT() []byte {
+	return r.pmt
+}
+
+// Program returns the most recently parsed PMT
+func (r *Reader) Program() (Program, bool) {
+	return r.program, r.hasProgram
+}
+
+// MainStream returns the PID and stream type used for keyframe detection
+func (r *Reader) MainStream() (uint16, byte, bool) {
+	return r.mainPID, r.mainType, r.hasMain
+}
+
+func (r *Reader) handle(pkt []byte, fn func(pkt []byte, info PacketInfo)) {
+	pid := PID(pkt)
+	info := PacketInfo{PID: pid}
+	if pcr, ok := PCR(pkt); ok {
+		info.PCR, info.HasPCR = pcr, true
+	}
+
+	switch {
+	case pid == PATPID:
+		info.PSI = true
+		if PayloadUnitStart(pkt) {
+			if _, pmtPID, ok := ParsePAT(psiSection(Payload(pkt))); ok {
+				r.pmtPID, r.hasPMTPID = pmtPID, true
+				r.pat = append(r.pat[:0], pkt...)
+			}
+		}
+	case r.hasPMTPID && pid == r.pmtPID:
+		info.PSI = true
+		r.collectPMT(pkt)
+	case r.hasMain && pid == r.mainPID && PayloadUnitStart(pkt):
+		payload := Payload(pkt)
+		if pts, ok := PESTimestamp(payload); ok {
+			info.PTS, info.HasPTS = pts, true
+		}
+		if IsVideo(r.mainType) {
+			info.Keyframe = RandomAccess(pkt) || ContainsKeyframe(r.mainType, PESData(payload))
+		} else {
+			// Audio-only programs can be cut at any PES boundary
+			info.Keyframe = true
+		}
+	}
+
+	fn(pkt, info)
+}
+
+// collectPMT gathers PMT packets until the section is complete
+func (r *Reader) collectPMT(pkt []byte) {
+	payload := Payload(pkt)
+	if PayloadUnitStart(pkt) {
+		r.pmtPending = append(r.pmtPending[:0], pkt...)
+		r.pmtSection = append(r.pmtSection[:0], psiSection(payload)...)
+	} else if len(r.pmtPending) > 0 {
+		r.pmtPending = append(r.pmtPending, pkt...)
+		r.pmtSection = append(r.pmtSection, payload...)
+	} else {
+		return
+	}
+
+	length := sectionLength(r.pmtSection)
+	if length < 0 || len(r.pmtSection) < length {
+		return
+	}
+
+	if prog, ok := ParsePMT(r.pmtSection); ok {
+		r.program, r.hasProgram = prog, true
+		r.pmt = append(r.pmt[:0], r.pmtPending...)
+		r.selectMainStream()
+	}
+	r.pmtPending = r.pmtPending[:0]
+}
+
+// selectMainStream picks the stream whose PES starts mark cut points
+func (r *Reader) selectMainStream() {
+	r.hasMain = false
+	for _, es := range r.program.Streams {
+		if IsVideo(es.StreamType) {
+			r.mainPID, r.mainType, r.hasMain = es.PID, es.StreamType, true
+			return
+		}
+	}
+	for _, es := range r.program.Streams {
+		if isAudio(es) {
+			r.mainPID, r.mainType, r.hasMain = es.PID, es.StreamType, true
+			return
+		}
+	}
+}
+
+// isAudio reports whether an elementary stream carries audio
+func isAudio(es ElementaryStream) bool {
+	switch es.StreamType {
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio, StreamTypeAACADTS, StreamTypeAACLATM, StreamTypeAC3, StreamTypeEAC3:
+		return true
+	case StreamTypePrivatePES:
+		return hasDescriptor(es.Descriptors, 0x6A) || hasDescriptor(es.Descriptors, 0x7A)
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package mpegts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// psiPackets splits a PSI section over as many packets as it needs
+func psiPackets(pid uint16, section []byte) []byte {
+	payload := append([]byte{0x00}, section...)
+	var stream []byte
+	for start := true; len(payload) > 0; start = false {
+		n := PacketSize - 4
+		if n > len(payload) {
+			n = len(payload)
+		}
+		chunk := append([]byte(nil), payload[:n]...)
+		for len(chunk) < PacketSize-4 {
+			chunk = append(chunk, 0xFF)
+		}
+		stream = append(stream, tsPacket(pid, start, nil, chunk)...)
+		payload = payload[n:]
+	}
+	return stream
+}
+
+// programHeader returns the PAT and PMT of a program with the given streams
+func programHeader(pcrPID uint16, streams ...ElementaryStream) []byte {
+	return append(psiPacket(PATPID, patSection()), psiPackets(testPMTPID, pmtSection(pcrPID, streams...))...)
+}
+
+var (
+	testVideo = ElementaryStream{PID: testVideoPID, StreamType: StreamTypeH264}
+	testAudio = ElementaryStream{PID: testAudioPID, StreamType: StreamTypeAACADTS}
+)
+
+func TestReader(t *testing.T) {
+	// A PMT whose descriptors do not fit in one packet
+	longDesc := bytes.Repeat([]byte{0x0A, 0x04, 'e', 'n', 'g', 0x00}, 40)
+	longPMT := programHeader(testVideoPID,
+		ElementaryStream{PID: testAudioPID, StreamType: StreamTypeMPEG2Audio, Descriptors: longDesc},
+		testVideo)
+
+	tests := []struct {
+		name       string
+		stream     []byte
+		chunk      int // Feed size, 0 = all at once
+		want       []PacketInfo
+		mainPID    uint16
+		hasMain    bool
+		pmtPackets int
+	}{
+		{
+			name: "video is the main stream, keyframes from IDR and random access",
+			stream: concat(
+				programHeader(testVideoPID, testAudio, testVideo),
+				tsPacket(testAudioPID, true, nil, pesPayload(0xC0, 100, []byte{0xFF, 0xF1})),
+				tsPacket(testVideoPID, true, pcrField(90, false), pesPayload(0xE0, 200, h264IDR)),
+				tsPacket(testVideoPID, true, nil, pesPayload(0xE0, 300, h264Slice)),
+				tsPacket(testVideoPID, false, nil, h264IDR),
+				tsPacket(testVideoPID, true, []byte{0x40}, pesPayload(0xE0, 400, h264Slice)),
+			),
+			want: []PacketInfo{
+				{PID: PATPID, PSI: true},
+				{PID: testPMTPID, PSI: true},
+				{PID: testAudioPID},
+				{PID: testVideoPID, Keyframe: true, PTS: 200, HasPTS: true, PCR: 90, HasPCR: true},
+				{PID: testVideoPID, PTS: 300, HasPTS: true},
+				{PID: testVideoPID},
+				{PID: testVideoPID, Keyframe: true, PTS: 400, HasPTS: true},
+			},
+			mainPID:    testVideoPID,
+			hasMain:    true,
+			pmtPackets: 1,
+		},
+		{
+			name: "audio-only programs cut at every PES",
+			stream: concat(
+				programHeader(testAudioPID, testAudio),
+				tsPacket(testAudioPID, true, nil, pesPayload(0xC0, 100, []byte{0xFF, 0xF1})),
+				tsPacket(testAudioPID, false, nil, []byte{0x01}),
+			),
+			want: []PacketInfo{
+				{PID: PATPID, PSI: true},
+				{PID: testPMTPID, PSI: true},
+				{PID: testAudioPID, Keyframe: true, PTS: 100, HasPTS: true},
+				{PID: testAudioPID},
+			},
+			mainPID:    testAudioPID,
+			hasMain:    true,
+			pmtPackets: 1,
+		},
+		{
+			name: "AC-3 signalled by descriptor in a private stream",
+			stream: programHeader(testAudioPID,
+				ElementaryStream{PID: 0x200, StreamType: StreamTypePrivatePES, Descriptors: []byte{0x59, 0x00}},
+				ElementaryStream{PID: testAudioPID, StreamType: StreamTypePrivatePES, Descriptors: []byte{0x6A, 0x00}},
+			),
+			want:       []PacketInfo{{PID: PATPID, PSI: true}, {PID: testPMTPID, PSI: true}},
+			mainPID:    testAudioPID,
+			hasMain:    true,
+			pmtPackets: 1,
+		},
+		{
+			name:   "PMT spanning two packets",
+			stream: longPMT,
+			want: []PacketInfo{
+				{PID: PATPID, PSI: true},
+				{PID: testPMTPID, PSI: true},
+				{PID: testPMTPID, PSI: true},
+			},
+			mainPID:    testVideoPID,
+			hasMain:    true,
+			pmtPackets: 2,
+		},
+		{
+			name:   "packets split across feeds",
+			stream: concat(programHeader(testVideoPID, testVideo), tsPacket(testVideoPID, true, nil, pesPayload(0xE0, 5, h264IDR))),
+			chunk:  7,
+			want: []PacketInfo{
+				{PID: PATPID, PSI: true},
+				{PID: testPMTPID, PSI: true},
+				{PID: testVideoPID, Keyframe: true, PTS: 5, HasPTS: true},
+			},
+			mainPID:    testVideoPID,
+			hasMain:    true,
+			pmtPackets: 1,
+		},
+		{
+			name:       "resyncs after garbage",
+			stream:     concat([]byte{0x00, 0x01, 0x02}, programHeader(testVideoPID, testVideo), []byte{0x12, 0x34}, tsPacket(testVideoPID, true, nil, pesPayload(0xE0, 5, h264IDR))),
+			want:       []PacketInfo{{PID: PATPID, PSI: true}, {PID: testPMTPID, PSI: true}, {PID: testVideoPID, Keyframe: true, PTS: 5, HasPTS: true}},
+			mainPID:    testVideoPID,
+			hasMain:    true,
+			pmtPackets: 1,
+		},
+		{
+			name:   "elementary data before the PMT is passed through",
+			stream: concat(tsPacket(testVideoPID, true, nil, pesPayload(0xE0, 5, h264IDR)), psiPacket(PATPID, patSection())),
+			want:   []PacketInfo{{PID: testVideoPID}, {PID: PATPID, PSI: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r Reader
+			var got []PacketInfo
+			collect := func(pkt []byte, info PacketInfo) { got = append(got, info) }
+			chunk := tt.chunk
+			if chunk == 0 {
+				chunk = len(tt.stream)
+			}
+			for i := 0; i < len(tt.stream); i += chunk {
+				end := i + chunk
+				if end > len(tt.stream) {
+					end = len(tt.stream)
+				}
+				r.Feed(tt.stream[i:end], collect)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("packets:\n got %+v\nwant %+v", got, tt.want)
+			}
+			if pid, _, ok := r.MainStream(); ok != tt.hasMain || pid != tt.mainPID {
+				t.Errorf("main stream %#x (%v), want %#x (%v)", pid, ok, tt.mainPID, tt.hasMain)
+			}
+			if n := len(r.PMT()) / PacketSize; n != tt.pmtPackets {
+				t.Errorf("PMT held in %d packets, want %d", n, tt.pmtPackets)
+			}
+			if len(r.Partial()) != 0 {
+				t.Errorf("%d bytes left in a partial packet", len(r.Partial()))
+			}
+		})
+	}
+}
+
+func TestParsePMT(t *testing.T) {
+	section := pmtSection(testVideoPID,
+		testVideo,
+		ElementaryStream{PID: testAudioPID, StreamType: StreamTypeAACADTS, Descriptors: []byte{0x0A, 0x04, 'i', 'n', 'd', 0x00}},
+		ElementaryStream{PID: 0x200, StreamType: StreamTypePrivatePES, Descriptors: []byte{0x59, 0x08, 'e', 'n', 'g', 0x10, 0, 1, 0, 1}},
+	)
+
+	prog, ok := ParsePMT(section)
+	if !ok {
+		t.Fatal("PMT not parsed")
+	}
+	if prog.Number != 1 || prog.PCRPID != testVideoPID || len(prog.Streams) != 3 {
+		t.Fatalf("program %+v", prog)
+	}
+	for i, want := range []struct {
+		pid      uint16
+		language string
+		kind     string
+		codec    string
+	}{
+		{testVideoPID, "", KindVideo, "h264"},
+		{testAudioPID, "ind", KindAudio, "aac"},
+		{0x200, "eng", KindSubtitle, "dvb_subtitle"},
+	} {
+		es := prog.Streams[i]
+		if es.PID != want.pid || es.Language != want.language || StreamKind(es) != want.kind || CodecName(es) != want.codec {
+			t.Errorf("stream %d: %#x %q %s %s, want %#x %q %s %s", i, es.PID, es.Language, StreamKind(es), CodecName(es), want.pid, want.language, want.kind, want.codec)
+		}
+	}
+
+	if _, ok := ParsePMT(section[:len(section)-5]); ok {
+		t.Error("truncated PMT parsed")
+	}
+}
+
+// concat joins packets into one stream
+func concat(parts ...[]byte) []byte {
+	var stream []byte
+	for _, p := range parts {
+		stream = append(stream, p...)
+	}
+	return stream
+}
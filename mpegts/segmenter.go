@@ -0,0 +1,126 @@
+package mpegts
+
+import "time"
+
+// Segment is a keyframe-aligned run of MPEG-TS packets
+type Segment struct {
+	Data     []byte
+	Duration time.Duration
+	StartPTS int64
+}
+
+// Segmenter cuts an MPEG-TS byte stream into segments that start on packet
+// boundaries at random access points. Each segment begins with the latest
+// PAT/PMT so it can be decoded on its own. Durations come from the PTS of
+// the main stream, falling back to the PCR when no PTS is available.
+type Segmenter struct {
+	target time.Duration
+	emit   func(Segment)
+	reader Reader
+
+	buf       []byte
+	started   bool
+	startTime int64 // PTS (or PCR) at segment start
+	lastTime  int64 // Latest PTS (or PCR) seen in segment
+	sawPTS    bool  // Main stream carries PTS, ignore PCR from then on
+}
+
+// maxSegmentBytes forces a cut when a source never delivers a keyframe
+const maxSegmentBytes = 8 * 1024 * 1024
+
+// NewSegmenter creates a segmenter that calls emit for each finished segment
+// of at least target duration.
+func NewSegmenter(target time.Duration, emit func(Segment)) *Segmenter {
+	return &Segmenter{target: target, emit: emit}
+}
+
+// Write feeds stream data into the segmenter
+func (s *Segmenter) Write(p []byte) (int, error) {
+	s.reader.Feed(p, s.handlePacket)
+	return len(p), nil
+}
+
+// Flush emits whatever has been collected as a final (possibly short) segment
+func (s *Segmenter) Flush() {
+	if s.started && len(s.buf) > 0 {
+		s.cut()
+	}
+	s.started = false
+	s.buf = nil
+}
+
+// Reset drops buffered data, e.g. after a source reconnect
+func (s *Segmenter) Reset() {
+	s.reader = Reader{}
+	s.sawPTS = false
+	s.started = false
+	s.buf = nil
+}
+
+func (s *Segmenter) handlePacket(pkt []byte, info PacketInfo) {
+	timestamp, hasTime := s.packetTime(info)
+
+	if info.Keyframe {
+		if !s.started {
+			s.begin(timestamp)
+		} else if hasTime && time.Duration(Elapsed(s.startTime, timestamp))*time.Second/90000 >= s.target {
+			// The next keyframe ends this segment, which gives an exact duration
+			s.lastTime = timestamp
+			s.cut()
+			s.begin(timestamp)
+		}
+	} else if s.started && len(s.buf) > maxSegmentBytes {
+		// Broken or keyframe-less source: don't grow without bound
+		s.cut()
+		s.begin(s.lastTime)
+	}
+
+	if !s.started {
+		return
+	}
+	if hasTime && Elapsed(s.lastTime, timestamp) > 0 {
+		s.lastTime = timestamp
+	}
+	s.buf = append(s.buf, pkt...)
+}
+
+// packetTime returns the timing reference carried by a packet: the main
+// stream PTS, or the PCR for sources whose main stream carries no PTS.
+func (s *Segmenter) packetTime(info PacketInfo) (int64, bool) {
+	if info.HasPTS {
+		if !s.sawPTS {
+			s.sawPTS = true
+			// Switch the running segment from PCR to PTS timing
+			s.startTime, s.lastTime = info.PTS, info.PTS
+		}
+		return info.PTS, true
+	}
+	if info.HasPCR && !s.sawPTS {
+		return info.PCR, true
+	}
+	return 0, false
+}
+
+// begin starts a new segment with the current PAT/PMT
+func (s *Segmenter) begin(timestamp int64) {
+	s.started = true
+	s.startTime = timestamp
+	s.lastTime = timestamp
+	s.buf = make([]byte, 0, 2*1024*1024)
+	s.buf = append(s.buf, s.reader.PAT()...)
+	s.buf = append(s.buf, s.reader.PMT()...)
+}
+
+// cut emits the current segment
+func (s *Segmenter) cut() {
+	ticks := Elapsed(s.startTime, s.lastTime)
+	if ticks < 0 {
+		ticks = 0
+	}
+	s.emit(Segment{
+		Data:     s.buf,
+		Duration: time.Duration(ticks) * time.Second / 90000,
+		StartPTS: s.startTime,
+	})
+	s.buf = nil
+}
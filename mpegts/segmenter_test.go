@@ -0,0 +1,264 @@
+package mpegts
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testPMTPID   = 0x100
+	testVideoPID = 0x101
+	testAudioPID = 0x102
+
+	// ticksPerSecond is the 90 kHz clock of PTS and PCR
+	ticksPerSecond = 90000
+)
+
+// tsPacket builds a 188-byte packet. The adaptation field body af, starting
+// with its flags byte, is padded with stuffing up to the payload.
+func tsPacket(pid uint16, start bool, af, payload []byte) []byte {
+	free := PacketSize - 4 - len(payload)
+	flags := byte(0x10)
+	if af != nil || free > 0 {
+		flags |= 0x20
+	}
+	b1 := byte(pid>>8) & 0x1F
+	if start {
+		b1 |= 0x40
+	}
+
+	p := append(make([]byte, 0, PacketSize), SyncByte, b1, byte(pid), flags)
+	if flags&0x20 != 0 {
+		afLen := free - 1
+		if af == nil && afLen > 0 {
+			af = []byte{0x00}
+		}
+		p = append(p, byte(afLen))
+		p = append(p, af...)
+		for len(p) < 5+afLen {
+			p = append(p, 0xFF)
+		}
+	}
+	return append(p, payload...)
+}
+
+// pcrField returns an adaptation field body carrying pcr, with the random
+// access indicator set if randomAccess
+func pcrField(pcr int64, randomAccess bool) []byte {
+	flags := byte(0x10)
+	if randomAccess {
+		flags |= 0x40
+	}
+	return []byte{flags, byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1), byte(pcr&1)<<7 | 0x7E, 0x00}
+}
+
+// psiPacket wraps a PSI section with a pointer field and 0xFF padding
+func psiPacket(pid uint16, section []byte) []byte {
+	payload := append([]byte{0x00}, section...)
+	for len(payload) < PacketSize-4 {
+		payload = append(payload, 0xFF)
+	}
+	return tsPacket(pid, true, nil, payload)
+}
+
+// patSection lists program 1 on testPMTPID
+func patSection() []byte {
+	return []byte{
+		0x00, 0xB0, 13, 0x00, 0x01, 0xC1, 0x00, 0x00,
+		0x00, 0x01, 0xE0 | byte(testPMTPID>>8), byte(testPMTPID & 0xFF),
+		0, 0, 0, 0, // CRC, not checked
+	}
+}
+
+// pmtSection describes program 1 with the given streams and PCR PID
+func pmtSection(pcrPID uint16, streams ...ElementaryStream) []byte {
+	var loop []byte
+	for _, es := range streams {
+		loop = append(loop, es.StreamType, 0xE0|byte(es.PID>>8), byte(es.PID), 0xF0|byte(len(es.Descriptors)>>8), byte(len(es.Descriptors)))
+		loop = append(loop, es.Descriptors...)
+	}
+	length := 9 + len(loop) + 4
+	section := []byte{0x02, 0xB0 | byte(length>>8), byte(length), 0x00, 0x01, 0xC1, 0x00, 0x00, 0xE0 | byte(pcrPID>>8), byte(pcrPID), 0xF0, 0x00}
+	section = append(section, loop...)
+	return append(section, 0, 0, 0, 0)
+}
+
+// pesPayload starts a PES of stream id with the given PTS (negative for
+// none) followed by data
+func pesPayload(streamID byte, pts int64, data []byte) []byte {
+	if pts < 0 {
+		return append([]byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x00, 0x00}, data...)
+	}
+	return append([]byte{
+		0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 0x05,
+		0x21 | byte(pts>>29)&0x0E, byte(pts >> 22), byte(pts>>14) | 0x01, byte(pts >> 7), byte(pts<<1) | 0x01,
+	}, data...)
+}
+
+var (
+	h264IDR   = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84}
+	h264Slice = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9A, 0x02}
+)
+
+// frame is one access unit of a test stream
+type frame struct {
+	pts int64 // Negative for a PES without PTS
+	key bool
+}
+
+// h264Stream returns PAT, PMT and one single-packet PES per frame of an
+// H.264 program. With pcr set every frame also carries a PCR at its PTS.
+func h264Stream(frames []frame, pcr bool) []byte {
+	stream := psiPacket(PATPID, patSection())
+	stream = append(stream, psiPacket(testPMTPID, pmtSection(testVideoPID, ElementaryStream{PID: testVideoPID, StreamType: StreamTypeH264}))...)
+	for _, f := range frames {
+		data := h264Slice
+		if f.key {
+			data = h264IDR
+		}
+		var af []byte
+		if pcr {
+			af = pcrField(f.pts, false)
+		}
+		pts := f.pts
+		if pcr {
+			pts = -1
+		}
+		stream = append(stream, tsPacket(testVideoPID, true, af, pesPayload(0xE0, pts, data))...)
+	}
+	return stream
+}
+
+// everySecond returns n frames one second apart starting at start, a
+// keyframe every gop frames
+func everySecond(start int64, n, gop int) []frame {
+	frames := make([]frame, n)
+	for i := range frames {
+		frames[i] = frame{pts: start + int64(i)*ticksPerSecond, key: i%gop == 0}
+	}
+	return frames
+}
+
+func TestSegmenter(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    time.Duration
+		stream    []byte
+		chunk     int // Write size, 0 = all at once
+		durations []time.Duration
+		startPTS  []int64
+	}{
+		{
+			name:      "cuts at the first keyframe past the target",
+			target:    2 * time.Second,
+			stream:    h264Stream(everySecond(0, 7, 2), false),
+			durations: []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second, 0},
+			startPTS:  []int64{0, 2 * ticksPerSecond, 4 * ticksPerSecond, 6 * ticksPerSecond},
+		},
+		{
+			name:      "keeps a short GOP in the segment until the target is reached",
+			target:    3 * time.Second,
+			stream:    h264Stream(everySecond(0, 7, 2), false),
+			durations: []time.Duration{4 * time.Second, 2 * time.Second},
+			startPTS:  []int64{0, 4 * ticksPerSecond},
+		},
+		{
+			name:      "drops frames before the first keyframe",
+			target:    2 * time.Second,
+			stream:    h264Stream(append([]frame{{pts: 0}, {pts: ticksPerSecond}}, everySecond(2*ticksPerSecond, 3, 2)...), false),
+			durations: []time.Duration{2 * time.Second, 0},
+			startPTS:  []int64{2 * ticksPerSecond, 4 * ticksPerSecond},
+		},
+		{
+			name:      "packets split across writes",
+			target:    2 * time.Second,
+			stream:    h264Stream(everySecond(0, 5, 2), false),
+			chunk:     100,
+			durations: []time.Duration{2 * time.Second, 2 * time.Second, 0},
+			startPTS:  []int64{0, 2 * ticksPerSecond, 4 * ticksPerSecond},
+		},
+		{
+			name:      "PCR timing without PTS",
+			target:    2 * time.Second,
+			stream:    h264Stream(everySecond(ticksPerSecond, 5, 2), true),
+			durations: []time.Duration{2 * time.Second, 2 * time.Second, 0},
+			startPTS:  []int64{ticksPerSecond, 3 * ticksPerSecond, 5 * ticksPerSecond},
+		},
+		{
+			name:      "PTS wrap-around",
+			target:    2 * time.Second,
+			stream:    h264Stream(wrapFrames(), false),
+			durations: []time.Duration{2 * time.Second, 0},
+			startPTS:  []int64{1<<33 - ticksPerSecond, ticksPerSecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var segments []Segment
+			s := NewSegmenter(tt.target, func(seg Segment) { segments = append(segments, seg) })
+			chunk := tt.chunk
+			if chunk == 0 {
+				chunk = len(tt.stream)
+			}
+			for i := 0; i < len(tt.stream); i += chunk {
+				end := i + chunk
+				if end > len(tt.stream) {
+					end = len(tt.stream)
+				}
+				s.Write(tt.stream[i:end])
+			}
+			s.Flush()
+
+			if len(segments) != len(tt.durations) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.durations))
+			}
+			for i, seg := range segments {
+				if seg.Duration != tt.durations[i] {
+					t.Errorf("segment %d: duration %v, want %v", i, seg.Duration, tt.durations[i])
+				}
+				if seg.StartPTS != tt.startPTS[i] {
+					t.Errorf("segment %d: start %d, want %d", i, seg.StartPTS, tt.startPTS[i])
+				}
+				if len(seg.Data)%PacketSize != 0 || len(seg.Data) < 3*PacketSize {
+					t.Fatalf("segment %d: %d bytes", i, len(seg.Data))
+				}
+				// Every segment decodes on its own: PAT, PMT, then a keyframe
+				if PID(seg.Data) != PATPID || PID(seg.Data[PacketSize:]) != testPMTPID {
+					t.Errorf("segment %d does not start with PAT and PMT", i)
+				}
+				first := seg.Data[2*PacketSize : 3*PacketSize]
+				if !ContainsKeyframe(StreamTypeH264, PESData(Payload(first))) {
+					t.Errorf("segment %d does not start on a keyframe", i)
+				}
+			}
+		})
+	}
+}
+
+// wrapFrames crosses the 33-bit PTS wrap with a keyframe every 2 seconds
+func wrapFrames() []frame {
+	return []frame{
+		{pts: 1<<33 - ticksPerSecond, key: true},
+		{pts: 0},
+		{pts: ticksPerSecond, key: true},
+	}
+}
+
+func TestSegmenterForcesCutWithoutKeyframes(t *testing.T) {
+	var segments []Segment
+	s := NewSegmenter(2*time.Second, func(seg Segment) { segments = append(segments, seg) })
+
+	frames := []frame{{pts: 0, key: true}}
+	for i := 1; i <= maxSegmentBytes/PacketSize+1; i++ {
+		frames = append(frames, frame{pts: int64(i)})
+	}
+	s.Write(h264Stream(frames, false))
+
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1 forced cut", len(segments))
+	}
+	if len(segments[0].Data) <= maxSegmentBytes {
+		t.Errorf("cut at %d bytes, before the %d byte limit", len(segments[0].Data), maxSegmentBytes)
+	}
+}
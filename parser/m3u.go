@@ -12,6 +12,7 @@ type M3UChannel struct {
 	URL   string
 	Logo  string
 	Group string
+	TvgID string
 }
 
 func ParseM3U(source io.Reader) ([]M3UChannel, error) {
@@ -32,6 +33,15 @@ func ParseM3U(source io.Reader) ([]M3UChannel, error) {
 			hasInfo = true
 			currentChannel = M3UChannel{}
 			
+			// Parse tvg-id
+			if idStart := strings.Index(line, "tvg-id=\""); idStart != -1 {
+				idStart += 8
+				idEnd := strings.Index(line[idStart:], "\"")
+				if idEnd != -1 {
+					currentChannel.TvgID = strings.TrimSpace(line[idStart : idStart+idEnd])
+				}
+			}
+			
 			// Parse tvg-logo
 			if logoStart := strings.Index(line, "tvg-logo=\""); logoStart != -1 {
 				logoStart += 10
@@ -0,0 +1,334 @@
+package settings
+
+import (
+	"fmt"
+	"iptv-panel/database"
+	"log"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings is the typed, validated view of the ffmpeg settings category
+// and the slow client, health check, timeshift and connection limit keys of
+// the stream category
+type Settings struct {
+	FFmpegPath         string
+	BufferSizeKB       int
+	IdleTimeout        time.Duration
+	MaxStreams         int
+	EnableHLS          bool
+	HLSSegmentDuration time.Duration
+
+	AdmissionPolicy       string // refuse, queue or evict once MaxStreams are running
+	AdmissionQueueTimeout time.Duration
+
+	Nice          int   // FFmpeg scheduling priority, 0 = inherit
+	CPUAffinity   []int // CPUs FFmpeg may run on, empty = all
+	MemoryLimitMB int   // Address space limit per FFmpeg, 0 = unlimited
+
+	SlowClientPolicy   string // skip, disconnect or backlog, unless the channel sets one
+	SlowClientMaxLagKB int    // How far behind a client may fall
+	SlowClientMaxDrops int    // Resyncs before disconnect (disconnect mode)
+
+	HealthCheckInterval    time.Duration // Between channel health checks, 0 = disabled
+	HealthCheckConcurrency int           // Channels probed at the same time
+	HealthCheckMethod      string        // head, get or ffprobe
+	HealthCheckTimeout     time.Duration // Per channel probe
+
+	TimeshiftQuotaMB int // Disk space of all timeshift archives, 0 = unlimited
+
+	ConnectionLimitPolicy string // reject or kick_oldest once a user has max_connections streams
+	ConnectionLimitSlate  bool   // Show refused and kicked viewers the "too many devices" video
+}
+
+// defaults are used when a row is missing or holds an invalid value. An
+// unusable ffmpeg_path falls back to ffmpeg from PATH.
+var defaults = Settings{
+	FFmpegPath:         "ffmpeg",
+	BufferSizeKB:       2048,
+	IdleTimeout:        60 * time.Second,
+	MaxStreams:         100,
+	EnableHLS:          true,
+	HLSSegmentDuration: 6 * time.Second,
+
+	AdmissionPolicy:       "refuse",
+	AdmissionQueueTimeout: 10 * time.Second,
+
+	SlowClientPolicy:   "skip",
+	SlowClientMaxLagKB: 4096,
+	SlowClientMaxDrops: 3,
+
+	HealthCheckInterval:    10 * time.Minute,
+	HealthCheckConcurrency: 5,
+	HealthCheckMethod:      "get",
+	HealthCheckTimeout:     10 * time.Second,
+
+	TimeshiftQuotaMB: 10240,
+
+	ConnectionLimitPolicy: "reject",
+}
+
+// validators check the raw value of a setting, same ranges as the panel
+var validators = map[string]func(string) error{
+	"ffmpeg_path":          validateFFmpegPath,
+	"buffer_size":          intRange(512, 10240),
+	"idle_timeout":         intRange(30, 300),
+	"max_streams":          validateMaxStreams,
+	"enable_hls":           validateBool,
+	"hls_segment_duration": intRange(2, 10),
+
+	"admission_policy":        oneOf("refuse", "queue", "evict"),
+	"admission_queue_timeout": intRange(1, 60),
+
+	"ffmpeg_nice":            intRange(0, 19),
+	"ffmpeg_cpu_affinity":    validateCPUList,
+	"ffmpeg_memory_limit_mb": validateMemoryLimit,
+
+	"slow_client_policy":     oneOf("skip", "disconnect", "backlog"),
+	"slow_client_max_lag_kb": intRange(1, 1024*1024),
+	"slow_client_max_drops":  intRange(1, 1000),
+
+	"health_check_interval":    validateHealthInterval,
+	"health_check_concurrency": intRange(1, 50),
+	"health_check_method":      oneOf("head", "get", "ffprobe"),
+	"health_check_timeout":     intRange(2, 60),
+
+	"timeshift_quota_mb": validateTimeshiftQuota,
+
+	"connection_limit_policy": oneOf("reject", "kick_oldest"),
+	"connection_limit_slate":  validateBool,
+}
+
+var (
+	current     = defaults
+	mux         sync.RWMutex
+	subscribers []func(Settings)
+)
+
+// Get returns the cached settings
+func Get() Settings {
+	mux.RLock()
+	defer mux.RUnlock()
+	return current
+}
+
+// Subscribe registers fn to be called with the new settings whenever they
+// change. fn is called once right away with the current settings.
+func Subscribe(fn func(Settings)) {
+	mux.Lock()
+	subscribers = append(subscribers, fn)
+	s := current
+	mux.Unlock()
+
+	fn(s)
+}
+
+// Validate checks a value before it is written to the settings table.
+// Keys without a validator accept any value.
+func Validate(key, value string) error {
+	if validate, ok := validators[key]; ok {
+		if err := validate(value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Load reads the settings table into the cache and notifies subscribers
+// if anything changed. Invalid values are logged and replaced by defaults.
+func Load() error {
+	rows, err := database.DB.Query("SELECT key, value FROM settings WHERE category = 'ffmpeg' OR key LIKE 'slow_client_%' OR key LIKE 'health_check_%' OR key LIKE 'timeshift_%' OR key LIKE 'connection_limit_%'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s := defaults
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		if err := Validate(key, value); err != nil {
+			log.Printf("⚠️  Invalid setting %v, using default", err)
+			continue
+		}
+		apply(&s, key, value)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	mux.Lock()
+	changed := !reflect.DeepEqual(s, current)
+	current = s
+	notify := append([]func(Settings){}, subscribers...)
+	mux.Unlock()
+
+	if changed {
+		for _, fn := range notify {
+			fn(s)
+		}
+	}
+	return nil
+}
+
+// apply stores an already validated value in s
+func apply(s *Settings, key, value string) {
+	n, _ := strconv.Atoi(value)
+	switch key {
+	case "ffmpeg_path":
+		s.FFmpegPath = value
+	case "buffer_size":
+		s.BufferSizeKB = n
+	case "idle_timeout":
+		s.IdleTimeout = time.Duration(n) * time.Second
+	case "max_streams":
+		s.MaxStreams = n
+	case "enable_hls":
+		s.EnableHLS = value == "true"
+	case "hls_segment_duration":
+		s.HLSSegmentDuration = time.Duration(n) * time.Second
+	case "admission_policy":
+		s.AdmissionPolicy = value
+	case "admission_queue_timeout":
+		s.AdmissionQueueTimeout = time.Duration(n) * time.Second
+	case "ffmpeg_nice":
+		s.Nice = n
+	case "ffmpeg_cpu_affinity":
+		s.CPUAffinity, _ = parseCPUList(value)
+	case "ffmpeg_memory_limit_mb":
+		s.MemoryLimitMB = n
+	case "slow_client_policy":
+		s.SlowClientPolicy = value
+	case "slow_client_max_lag_kb":
+		s.SlowClientMaxLagKB = n
+	case "slow_client_max_drops":
+		s.SlowClientMaxDrops = n
+	case "health_check_interval":
+		s.HealthCheckInterval = time.Duration(n) * time.Second
+	case "health_check_concurrency":
+		s.HealthCheckConcurrency = n
+	case "health_check_method":
+		s.HealthCheckMethod = value
+	case "health_check_timeout":
+		s.HealthCheckTimeout = time.Duration(n) * time.Second
+	case "timeshift_quota_mb":
+		s.TimeshiftQuotaMB = n
+	case "connection_limit_policy":
+		s.ConnectionLimitPolicy = value
+	case "connection_limit_slate":
+		s.ConnectionLimitSlate = value == "true"
+	}
+}
+
+// intRange accepts whole numbers between min and max
+func intRange(min, max int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		if n < min || n > max {
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+// oneOf accepts the listed values
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+// validateBool accepts "true" and "false"
+func validateBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	return nil
+}
+
+// validateFFmpegPath accepts a path or command name of an executable
+func validateFFmpegPath(value string) error {
+	if value == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if _, err := exec.LookPath(value); err != nil {
+		return fmt.Errorf("%s is not an executable", value)
+	}
+	return nil
+}
+
+// validateMaxStreams accepts 0 (unlimited) or 10 to 500 streams
+func validateMaxStreams(value string) error {
+	if value == "0" {
+		return nil
+	}
+	return intRange(10, 500)(value)
+}
+
+// validateMemoryLimit accepts 0 (unlimited) or 64MB to 64GB
+func validateMemoryLimit(value string) error {
+	if value == "0" {
+		return nil
+	}
+	return intRange(64, 65536)(value)
+}
+
+// validateHealthInterval accepts 0 (disabled) or 1 minute to 1 day
+func validateHealthInterval(value string) error {
+	if value == "0" {
+		return nil
+	}
+	return intRange(60, 86400)(value)
+}
+
+// validateTimeshiftQuota accepts 0 (unlimited) or 256MB to 16TB
+func validateTimeshiftQuota(value string) error {
+	if value == "0" {
+		return nil
+	}
+	return intRange(256, 16*1024*1024)(value)
+}
+
+// validateCPUList accepts "" (all CPUs) or a list like "0-3,6"
+func validateCPUList(value string) error {
+	_, err := parseCPUList(value)
+	return err
+}
+
+// parseCPUList parses a comma separated list of CPUs and CPU ranges
+func parseCPUList(value string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || from < 0 || to < from || to >= 1024 {
+			return nil, fmt.Errorf("invalid CPU list %q", value)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
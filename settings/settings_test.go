@@ -0,0 +1,112 @@
+package settings
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"ffmpeg_path", os.Args[0], true},
+		{"ffmpeg_path", "", false},
+		{"ffmpeg_path", "/nonexistent/ffmpeg", false},
+
+		{"buffer_size", "512", true},
+		{"buffer_size", "10240", true},
+		{"buffer_size", "511", false},
+		{"buffer_size", "2MB", false},
+		{"idle_timeout", "300", true},
+		{"idle_timeout", "301", false},
+		{"enable_hls", "true", true},
+		{"enable_hls", "1", false},
+		{"hls_segment_duration", "1", false},
+
+		{"max_streams", "0", true},
+		{"max_streams", "10", true},
+		{"max_streams", "500", true},
+		{"max_streams", "5", false},
+		{"max_streams", "501", false},
+		{"max_streams", "-1", false},
+
+		{"admission_policy", "queue", true},
+		{"admission_policy", "drop", false},
+		{"admission_queue_timeout", "0", false},
+
+		{"ffmpeg_nice", "19", true},
+		{"ffmpeg_nice", "-5", false},
+		{"ffmpeg_cpu_affinity", "", true},
+		{"ffmpeg_cpu_affinity", "0-3, 6", true},
+		{"ffmpeg_cpu_affinity", "3-1", false},
+		{"ffmpeg_cpu_affinity", "1024", false},
+		{"ffmpeg_cpu_affinity", "a", false},
+		{"ffmpeg_memory_limit_mb", "0", true},
+		{"ffmpeg_memory_limit_mb", "63", false},
+		{"ffmpeg_memory_limit_mb", "65536", true},
+
+		{"slow_client_policy", "backlog", true},
+		{"slow_client_policy", "", false},
+		{"slow_client_max_lag_kb", "4096", true},
+		{"slow_client_max_lag_kb", "0", false},
+		{"slow_client_max_drops", "1", true},
+		{"slow_client_max_drops", "0", false},
+
+		{"health_check_interval", "0", true},
+		{"health_check_interval", "59", false},
+		{"health_check_interval", "86400", true},
+		{"health_check_method", "ffprobe", true},
+		{"health_check_method", "ping", false},
+		{"health_check_concurrency", "51", false},
+		{"health_check_timeout", "2", true},
+
+		{"timeshift_quota_mb", "0", true},
+		{"timeshift_quota_mb", "255", false},
+
+		{"connection_limit_policy", "kick_oldest", true},
+		{"connection_limit_policy", "kick_newest", false},
+		{"connection_limit_slate", "false", true},
+		{"connection_limit_slate", "no", false},
+
+		// Keys without a validator take any value
+		{"default_format", "anything", true},
+	}
+
+	for _, tt := range tests {
+		err := Validate(tt.key, tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%q, %q) = %v, want ok %v", tt.key, tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		check func(s Settings) interface{}
+		want  interface{}
+	}{
+		{"idle_timeout", "90", func(s Settings) interface{} { return s.IdleTimeout }, 90 * time.Second},
+		{"hls_segment_duration", "4", func(s Settings) interface{} { return s.HLSSegmentDuration }, 4 * time.Second},
+		{"max_streams", "0", func(s Settings) interface{} { return s.MaxStreams }, 0},
+		{"enable_hls", "false", func(s Settings) interface{} { return s.EnableHLS }, false},
+		{"ffmpeg_cpu_affinity", "0-2,5", func(s Settings) interface{} { return s.CPUAffinity }, []int{0, 1, 2, 5}},
+		{"slow_client_policy", "disconnect", func(s Settings) interface{} { return s.SlowClientPolicy }, "disconnect"},
+		{"slow_client_max_lag_kb", "8192", func(s Settings) interface{} { return s.SlowClientMaxLagKB }, 8192},
+		{"health_check_interval", "0", func(s Settings) interface{} { return s.HealthCheckInterval }, time.Duration(0)},
+		{"connection_limit_slate", "true", func(s Settings) interface{} { return s.ConnectionLimitSlate }, true},
+	}
+
+	for _, tt := range tests {
+		s := defaults
+		apply(&s, tt.key, tt.value)
+		if got := tt.check(s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("apply(%q, %q): got %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
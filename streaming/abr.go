@@ -0,0 +1,180 @@
+package streaming
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ABRProfile names the session that encodes a stream's ABR ladder
+	ABRProfile = "abr"
+	// abrMasterPlaylist is the master playlist FFmpeg writes for a ladder
+	abrMasterPlaylist = "master.m3u8"
+	// maxLadderVariants bounds how many renditions one FFmpeg encodes
+	maxLadderVariants = 6
+)
+
+// abrVariantPattern matches variant directory names (v0, v1, ...)
+var abrVariantPattern = regexp.MustCompile(`^v[0-9]{1,2}$`)
+
+// ValidateLadder checks that profiles can be encoded together as an ABR
+// ladder. FFmpeg only lists variants with a known bitrate in the master
+// playlist, so every variant has to encode video.
+func ValidateLadder(ladder []TranscodeProfile) error {
+	if len(ladder) == 0 {
+		return fmt.Errorf("ladder needs at least one profile")
+	}
+	if len(ladder) > maxLadderVariants {
+		return fmt.Errorf("ladder can have at most %d profiles", maxLadderVariants)
+	}
+	for _, profile := range ladder {
+		if profile.VideoCodec == "copy" || profile.VideoBitrate <= 0 {
+			return fmt.Errorf("profile %s must encode video with a bitrate to be part of a ladder", profile.Name)
+		}
+	}
+	return nil
+}
+
+// GetOrCreateABRSession gets or creates the HLS session encoding every
+// profile of ladder with one FFmpeg. All clients of the stream share it.
+func (m *FFmpegManager) GetOrCreateABRSession(streamID string, sourceURLs []string, ladder []TranscodeProfile) *FFmpegSession {
+	profile := TranscodeProfile{Name: ABRProfile}
+	return m.getOrCreateSession(ProfileSessionID(streamID, ABRProfile), sourceURLs, "hls", profile, ladder)
+}
+
+// IsABR reports whether the session encodes an ABR ladder
+func (s *FFmpegSession) IsABR() bool {
+	return len(s.Ladder) > 0
+}
+
+// variantName returns the directory and var_stream_map name of variant idx
+func variantName(idx int) string {
+	return "v" + strconv.Itoa(idx)
+}
+
+// abrArgs returns the FFmpeg arguments that decode the source once and
+// encode every ladder variant into its own playlist plus a master playlist
+func (s *FFmpegSession) abrArgs(sourceURL string) []string {
+	args := inputArgs(sourceURL)
+
+	// Split the decoded picture once per variant and scale each copy
+	filter := fmt.Sprintf("[0:v:0]split=%d", len(s.Ladder))
+	for idx := range s.Ladder {
+		filter += fmt.Sprintf("[vs%d]", idx)
+	}
+	for idx, profile := range s.Ladder {
+		chain := "format=yuv420p"
+		if scale := profile.scaleFilter(); scale != "" {
+			chain = scale + "," + chain
+		}
+		filter += fmt.Sprintf(";[vs%d]%s[v%d]", idx, chain, idx)
+	}
+	args = append(args, "-filter_complex", filter)
+
+	streamMap := make([]string, 0, len(s.Ladder))
+	for idx, profile := range s.Ladder {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", idx), "-map", "0:a:0")
+		args = append(args, profile.variantArgs(idx)...)
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,a:%d,name:%s", idx, idx, variantName(idx)))
+	}
+
+	return append(args,
+		"-max_muxing_queue_size", "9999",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentSeconds()),
+		"-hls_list_size", strconv.Itoa(hlsPlaylistSize),
+		"-hls_flags", "delete_segments+independent_segments+omit_endlist",
+		"-hls_start_number_source", "epoch",
+		"-hls_segment_type", "mpegts",
+		"-master_pl_name", abrMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-hls_segment_filename", filepath.Join(s.OutputDir, "%v", "segment_%d.ts"),
+		filepath.Join(s.OutputDir, "%v", "playlist.m3u8"),
+	)
+}
+
+// variantArgs returns the codec options for output stream idx of a ladder.
+// Scaling and pixel format are done in the filter graph.
+func (p TranscodeProfile) variantArgs(idx int) []string {
+	v := ":v:" + strconv.Itoa(idx)
+	a := ":a:" + strconv.Itoa(idx)
+
+	args := []string{"-c" + v, p.VideoCodec}
+	if p.Preset != "" {
+		args = append(args, "-preset"+v, p.Preset)
+	}
+	if p.H264Profile != "" {
+		args = append(args, "-profile"+v, p.H264Profile)
+	}
+	maxRate := p.MaxRate
+	if maxRate == 0 {
+		maxRate = p.VideoBitrate
+	}
+	args = append(args,
+		"-b"+v, fmt.Sprintf("%dk", p.VideoBitrate),
+		"-maxrate"+v, fmt.Sprintf("%dk", maxRate),
+		"-bufsize"+v, fmt.Sprintf("%dk", maxRate*2),
+	)
+	if p.GOP > 0 {
+		// Same GOP on every variant keeps segments aligned for switching
+		gop := strconv.Itoa(p.GOP)
+		args = append(args, "-g"+v, gop, "-keyint_min"+v, gop, "-sc_threshold"+v, "0")
+	}
+
+	if p.AudioCodec == "" || p.AudioCodec == "copy" {
+		return append(args, "-c"+a, "copy")
+	}
+	args = append(args, "-c"+a, p.AudioCodec)
+	if p.AudioBitrate > 0 {
+		args = append(args, "-b"+a, fmt.Sprintf("%dk", p.AudioBitrate))
+	}
+	if p.AudioChannels > 0 {
+		args = append(args, "-ac"+a, strconv.Itoa(p.AudioChannels))
+	}
+	return args
+}
+
+// ladderNames returns the profile names of the ladder in variant order
+func (s *FFmpegSession) ladderNames() []string {
+	names := make([]string, 0, len(s.Ladder))
+	for _, profile := range s.Ladder {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// ABRVariant is one rendition of an ABR session. It is served like a
+// single-profile HLS session from its own directory.
+type ABRVariant struct {
+	*FFmpegSession
+	dir string
+}
+
+// Variant returns the rendition named name ("v0", "v1", ...)
+func (s *FFmpegSession) Variant(name string) (*ABRVariant, error) {
+	if !abrVariantPattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid variant name")
+	}
+	idx, err := strconv.Atoi(name[1:])
+	if err != nil || idx >= len(s.Ladder) {
+		return nil, fmt.Errorf("unknown variant %s", name)
+	}
+	return &ABRVariant{FFmpegSession: s, dir: filepath.Join(s.OutputDir, name)}, nil
+}
+
+// WaitForPlaylist waits for the variant playlist to list a segment
+func (v *ABRVariant) WaitForPlaylist(timeout time.Duration) ([]byte, error) {
+	return v.waitForFile(filepath.Join(v.dir, "playlist.m3u8"), timeout)
+}
+
+// GetSegmentPath returns the on-disk path of a segment of the variant
+func (v *ABRVariant) GetSegmentPath(name string) (string, error) {
+	if !hlsSegmentPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid segment name")
+	}
+	return filepath.Join(v.dir, name), nil
+}
@@ -0,0 +1,174 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Admission policies applied when max_streams FFmpeg processes are running
+const (
+	AdmissionRefuse = "refuse" // Refuse the new stream
+	AdmissionQueue  = "queue"  // Wait for a free slot, then refuse
+	AdmissionEvict  = "evict"  // Stop the least watched on-demand stream
+)
+
+const (
+	// reservationTTL bounds how long an admitted stream may take to start
+	// its FFmpeg before the slot is given to someone else
+	reservationTTL = 15 * time.Second
+	// admissionPollInterval is how often a queued start looks for a slot
+	admissionPollInterval = 500 * time.Millisecond
+	// AdmissionRetryAfter is the delay suggested to refused clients
+	AdmissionRetryAfter = 30 * time.Second
+)
+
+// ValidAdmissionPolicy reports whether policy is a known admission policy
+func ValidAdmissionPolicy(policy string) bool {
+	return policy == AdmissionRefuse || policy == AdmissionQueue || policy == AdmissionEvict
+}
+
+// AdmissionError is returned when a stream cannot start because max_streams
+// FFmpeg processes are already running
+type AdmissionError struct {
+	Running    int
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("server is at capacity (%d/%d streams running)", e.Running, e.Limit)
+}
+
+// Admit reserves an FFmpeg slot for the session streamID before it is
+// started. Running sessions are always admitted. When the limit is reached
+// a stream of higher priority takes the slot of the lowest priority one;
+// otherwise the admission policy decides whether to evict, queue or refuse.
+func (m *FFmpegManager) Admit(ctx context.Context, streamID string, priority int) error {
+	if isShuttingDown() {
+		return fmt.Errorf("server is shutting down")
+	}
+
+	cfg := currentConfig()
+	deadline := time.Now().Add(cfg.AdmissionQueueTimeout)
+
+	for {
+		admitted, victim, running := m.tryAdmit(streamID, priority, cfg)
+		if victim != nil {
+			log.Printf("🚫 Evicting FFmpeg stream %s (priority %d, %d clients) for %s (priority %d)",
+				victim.ID, victim.GetPriority(), victim.GetClientCount(), streamID, priority)
+			victim.Stop()
+		}
+		if admitted {
+			return nil
+		}
+
+		if cfg.AdmissionPolicy != AdmissionQueue || time.Now().After(deadline) {
+			log.Printf("⛔ Refused FFmpeg stream %s: %d/%d streams running", streamID, running, cfg.MaxStreams)
+			return &AdmissionError{Running: running, Limit: cfg.MaxStreams, RetryAfter: AdmissionRetryAfter}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(admissionPollInterval):
+		}
+	}
+}
+
+// tryAdmit reserves a slot for streamID if one is free or can be freed.
+// A returned victim has been removed from the manager and must be stopped.
+func (m *FFmpegManager) tryAdmit(streamID string, priority int, cfg Config) (bool, *FFmpegSession, int) {
+	m.sessionsMux.Lock()
+	defer m.sessionsMux.Unlock()
+
+	if session, exists := m.sessions[streamID]; exists && session.IsActive() {
+		return true, nil, 0
+	}
+	if cfg.MaxStreams <= 0 {
+		return true, nil, 0
+	}
+
+	running := m.runningLocked()
+	if running < cfg.MaxStreams {
+		m.reservations[streamID] = time.Now().Add(reservationTTL)
+		return true, nil, running
+	}
+
+	victim := m.pickVictimLocked(streamID, priority, cfg.AdmissionPolicy == AdmissionEvict)
+	if victim == nil {
+		return false, nil, running
+	}
+	delete(m.sessions, victim.ID)
+	m.reservations[streamID] = time.Now().Add(reservationTTL)
+	return true, victim, running
+}
+
+// runningLocked counts running FFmpeg processes plus admitted sessions that
+// are still starting. Callers hold sessionsMux.
+func (m *FFmpegManager) runningLocked() int {
+	running := 0
+	for _, session := range m.sessions {
+		if session.IsActive() {
+			running++
+		}
+	}
+
+	now := time.Now()
+	for streamID, expires := range m.reservations {
+		session, exists := m.sessions[streamID]
+		switch {
+		case exists && session.IsActive():
+			delete(m.reservations, streamID) // Counted above
+		case now.After(expires):
+			delete(m.reservations, streamID)
+		default:
+			running++
+		}
+	}
+	return running
+}
+
+// pickVictimLocked returns the running session to stop for a new stream of
+// the given priority: the lowest priority session below it, or with evict
+// set, the least watched on-demand session of at most the same priority.
+// Callers hold sessionsMux.
+func (m *FFmpegManager) pickVictimLocked(streamID string, priority int, evict bool) *FFmpegSession {
+	var victim *FFmpegSession
+	var victimPriority, victimClients int
+	var victimActivity time.Time
+
+	for id, session := range m.sessions {
+		if id == streamID || !session.IsActive() {
+			continue
+		}
+		p := session.GetPriority()
+		if p > priority || (p == priority && !(evict && session.IsOnDemand())) {
+			continue
+		}
+
+		clients, lastActivity := session.GetClientCount(), session.GetLastActivity()
+		if victim == nil || p < victimPriority ||
+			(p == victimPriority && (clients < victimClients ||
+				(clients == victimClients && lastActivity.Before(victimActivity)))) {
+			victim, victimPriority, victimClients, victimActivity = session, p, clients, lastActivity
+		}
+	}
+	return victim
+}
+
+// AdmissionStats returns the admission limit and current usage
+func (m *FFmpegManager) AdmissionStats() map[string]interface{} {
+	cfg := currentConfig()
+
+	m.sessionsMux.Lock()
+	running := m.runningLocked()
+	m.sessionsMux.Unlock()
+
+	return map[string]interface{}{
+		"running":     running,
+		"max_streams": cfg.MaxStreams,
+		"policy":      cfg.AdmissionPolicy,
+	}
+}
@@ -0,0 +1,158 @@
+package streaming
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// blacklistProbeMin is the first probe delay after a channel is blacklisted
+	blacklistProbeMin = 10 * time.Second
+	// blacklistProbeMax caps the exponential probe backoff
+	blacklistProbeMax = 10 * time.Minute
+)
+
+// IsBlacklisted reports whether the channel is refusing clients because
+// all of its sources failed
+func (s *FFmpegSession) IsBlacklisted() bool {
+	s.blacklistMux.RLock()
+	defer s.blacklistMux.RUnlock()
+	return s.isBlacklisted
+}
+
+// blacklist refuses new clients and probes the sources in the background
+// until one responds, then reopens the channel
+func (s *FFmpegSession) blacklist(reason, lastError string) {
+	s.blacklistMux.Lock()
+	if s.isBlacklisted {
+		s.blacklistMux.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.isBlacklisted = true
+	s.blacklistedAt = time.Now()
+	s.blacklistReason = reason
+	s.blacklistError = lastError
+	s.probeAttempts = 0
+	s.cancelProbe = cancel
+	s.blacklistMux.Unlock()
+
+	log.Printf("🚫 Channel %s blacklisted: %s. Probing sources from %v.", s.ID, reason, blacklistProbeMin)
+	go s.probeBlacklisted(ctx)
+}
+
+// probeBlacklisted probes every source with exponential backoff
+func (s *FFmpegSession) probeBlacklisted(ctx context.Context) {
+	delay := blacklistProbeMin
+	for {
+		s.blacklistMux.Lock()
+		s.nextProbe = time.Now().Add(delay)
+		s.blacklistMux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		for idx := 0; idx < s.sources.Len(); idx++ {
+			probeCtx, cancel := context.WithTimeout(ctx, sourceProbeTimeout)
+			err := ProbeSource(probeCtx, s.sources.URL(idx))
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			s.sources.ReportProbe(idx, err)
+			if err == nil {
+				s.unblacklist(idx, "source responded to probe")
+				return
+			}
+
+			s.blacklistMux.Lock()
+			s.blacklistError = err.Error()
+			s.blacklistMux.Unlock()
+		}
+
+		s.blacklistMux.Lock()
+		s.probeAttempts++
+		attempts := s.probeAttempts
+		s.blacklistMux.Unlock()
+
+		delay = nextProbeDelay(delay)
+		log.Printf("🔍 Channel %s still offline after %d probes, next in %v", s.ID, attempts, delay)
+	}
+}
+
+// nextProbeDelay doubles the delay between probe rounds, up to blacklistProbeMax
+func nextProbeDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay > blacklistProbeMax {
+		return blacklistProbeMax
+	}
+	return delay
+}
+
+// Unblacklist reopens a blacklisted channel by hand, starting again from the
+// primary source. Returns false if the channel was not blacklisted.
+func (s *FFmpegSession) Unblacklist() bool {
+	return s.unblacklist(0, "cleared by admin")
+}
+
+// unblacklist reopens the channel on source idx
+func (s *FFmpegSession) unblacklist(idx int, reason string) bool {
+	s.blacklistMux.Lock()
+	if !s.isBlacklisted {
+		s.blacklistMux.Unlock()
+		return false
+	}
+	s.isBlacklisted = false
+	if s.cancelProbe != nil {
+		s.cancelProbe()
+		s.cancelProbe = nil
+	}
+	s.blacklistMux.Unlock()
+
+	s.sources.Reset()
+	s.sources.Use(idx)
+	log.Printf("✅ Channel %s reopened: %s", s.ID, reason)
+
+	// Always-on channels come back by themselves, on-demand ones with the next client
+	if !s.IsOnDemand() {
+		go s.Start()
+	}
+	return true
+}
+
+// GetBlacklistInfo describes why and since when the channel is blacklisted
+func (s *FFmpegSession) GetBlacklistInfo() map[string]interface{} {
+	s.blacklistMux.RLock()
+	defer s.blacklistMux.RUnlock()
+
+	info := map[string]interface{}{
+		"id":          s.ID,
+		"blacklisted": s.isBlacklisted,
+	}
+	if s.isBlacklisted {
+		info["reason"] = s.blacklistReason
+		info["last_error"] = s.blacklistError
+		info["blacklisted_at"] = s.blacklistedAt
+		info["next_probe"] = s.nextProbe
+		info["probe_attempts"] = s.probeAttempts
+		info["sources"] = s.sources.GetStats()
+	}
+	return info
+}
+
+// GetBlacklistedSessions returns all sessions that are currently blacklisted
+func (m *FFmpegManager) GetBlacklistedSessions() []*FFmpegSession {
+	m.sessionsMux.RLock()
+	defer m.sessionsMux.RUnlock()
+
+	var sessions []*FFmpegSession
+	for _, session := range m.sessions {
+		if session.IsBlacklisted() {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
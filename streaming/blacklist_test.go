@@ -0,0 +1,95 @@
+package streaming
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextProbeDelay(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  time.Duration
+	}{
+		{blacklistProbeMin, 20 * time.Second},
+		{20 * time.Second, 40 * time.Second},
+		{160 * time.Second, 320 * time.Second},
+		{320 * time.Second, blacklistProbeMax},
+		{blacklistProbeMax, blacklistProbeMax},
+	}
+	for _, tt := range tests {
+		if got := nextProbeDelay(tt.delay); got != tt.want {
+			t.Errorf("nextProbeDelay(%v) = %v, want %v", tt.delay, got, tt.want)
+		}
+	}
+
+	// Probe rounds after blacklisting: 10s 20s 40s 80s 160s 320s, then every 10m
+	delay, rounds := blacklistProbeMin, 0
+	for delay < blacklistProbeMax {
+		delay = nextProbeDelay(delay)
+		rounds++
+	}
+	if rounds != 6 {
+		t.Errorf("backoff capped after %d rounds, want 6", rounds)
+	}
+}
+
+func TestBlacklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		reopen  func(s *FFmpegSession) bool
+		current int
+	}{
+		{
+			name:    "admin clears the blacklist",
+			reopen:  (*FFmpegSession).Unblacklist,
+			current: 0,
+		},
+		{
+			name:    "a probed backup reopens the channel on it",
+			reopen:  func(s *FFmpegSession) bool { return s.unblacklist(1, "source responded to probe") },
+			current: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := &FFmpegSession{
+				ID:       "test",
+				sources:  NewSourcePool([]string{"http://primary", "http://backup"}),
+				onDemand: true,
+				ctx:      ctx,
+				cancel:   cancel,
+			}
+			for idx := 0; idx < 2; idx++ {
+				s.sources.ReportFailure(idx, time.Second, "exited")
+				s.sources.ReportFailure(idx, time.Second, "exited")
+			}
+
+			s.blacklist("all 2 sources failed", "exited")
+			s.blacklist("again", "ignored")
+			info := s.GetBlacklistInfo()
+			if !s.IsBlacklisted() || info["reason"] != "all 2 sources failed" || info["probe_attempts"] != 0 {
+				t.Fatalf("blacklist info %v", info)
+			}
+
+			if !tt.reopen(s) {
+				t.Fatal("reopening a blacklisted channel returned false")
+			}
+			if s.IsBlacklisted() {
+				t.Fatal("still blacklisted after reopening")
+			}
+			if current, _ := s.sources.Current(); current != tt.current {
+				t.Errorf("reopened on source %d, want %d", current, tt.current)
+			}
+			if _, _, ok := s.sources.Next(); !ok {
+				t.Error("sources still unhealthy after reopening")
+			}
+			if s.Unblacklist() {
+				t.Error("Unblacklist of an open channel returned true")
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrStreamClosed is returned to readers once the stream has stopped
+	ErrStreamClosed = errors.New("stream closed")
+	// ErrClientClosed is returned to a reader that has been disconnected
+	ErrClientClosed = errors.New("client disconnected")
+	// ErrReaderLagging is returned by a cursor with a lag limit that has
+	// fallen too far behind; the caller decides how to recover.
+	ErrReaderLagging = errors.New("reader lagging behind stream")
+)
+
+// broadcastChunk is an immutable piece of stream data in the ring
+type broadcastChunk struct {
+	data   []byte
+	offset uint64 // Stream byte offset of data[0]
+}
+
+// BroadcastBuffer is a ring of immutable chunks shared by every reader of a
+// stream. The writer stores each chunk once; readers walk the ring with
+// their own BufferCursor, so fan-out costs no per-client copies or channels.
+// A reader that falls more than the ring size behind skips ahead and the
+// skipped data is counted as dropped on its cursor, unless the cursor has a
+// lag limit, in which case Next reports ErrReaderLagging instead.
+type BroadcastBuffer struct {
+	mux    sync.RWMutex
+	chunks []broadcastChunk
+	next   uint64        // Sequence number of the next chunk
+	total  uint64        // Total bytes written
+	wake   chan struct{} // Closed and replaced on every write
+	closed bool
+}
+
+// NewBroadcastBuffer creates a buffer that retains the last slots chunks
+func NewBroadcastBuffer(slots int) *BroadcastBuffer {
+	if slots < 1 {
+		slots = 1
+	}
+	return &BroadcastBuffer{
+		chunks: make([]broadcastChunk, slots),
+		wake:   make(chan struct{}),
+	}
+}
+
+// Write appends a chunk and wakes waiting readers. The buffer keeps a
+// reference to data, the caller must not modify it afterwards.
+func (b *BroadcastBuffer) Write(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return
+	}
+	b.chunks[b.next%uint64(len(b.chunks))] = broadcastChunk{data: data, offset: b.total}
+	b.next++
+	b.total += uint64(len(data))
+	wake := b.wake
+	b.wake = make(chan struct{})
+	b.mux.Unlock()
+
+	close(wake)
+}
+
+// Close stops the buffer; readers drain nothing further and get ErrStreamClosed
+func (b *BroadcastBuffer) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.wake)
+}
+
+// TotalBytes returns the number of bytes written so far
+func (b *BroadcastBuffer) TotalBytes() uint64 {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.total
+}
+
+// NewCursor returns a cursor positioned at the live edge. Any preface
+// chunks are delivered before the buffered data.
+func (b *BroadcastBuffer) NewCursor(preface ...[]byte) *BufferCursor {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return &BufferCursor{
+		buf:     b,
+		seq:     b.next,
+		offset:  b.total,
+		preface: preface,
+		closed:  make(chan struct{}),
+	}
+}
+
+// BufferCursor is one reader's position in a BroadcastBuffer. Next must be
+// called from a single goroutine; the counters may be read from any goroutine.
+type BufferCursor struct {
+	droppedBytes  uint64 // Accessed atomically, keep 64-bit aligned
+	droppedChunks uint64
+	bytesRead     uint64
+
+	buf       *BroadcastBuffer
+	seq       uint64 // Sequence number of the next chunk to read
+	offset    uint64 // Stream offset of the next byte to read
+	maxLag    uint64 // 0 = skip overwritten data silently
+	preface   [][]byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Next returns the next chunk, blocking until one is written, the context
+// is done, the cursor is closed or the stream ends. The returned slice is
+// shared with other readers and must not be modified.
+func (c *BufferCursor) Next(ctx context.Context) ([]byte, error) {
+	if len(c.preface) > 0 {
+		data := c.preface[0]
+		c.preface = c.preface[1:]
+		atomic.AddUint64(&c.bytesRead, uint64(len(data)))
+		return data, nil
+	}
+
+	b := c.buf
+	for {
+		b.mux.RLock()
+		if c.seq < b.next {
+			slots := uint64(len(b.chunks))
+			if c.maxLag > 0 && (b.next-c.seq > slots || b.total-c.offset > c.maxLag) {
+				b.mux.RUnlock()
+				return nil, ErrReaderLagging
+			}
+			if b.next-c.seq > slots {
+				// Overwritten while we were away: skip to the oldest chunk still held
+				oldest := b.next - slots
+				chunk := b.chunks[oldest%slots]
+				atomic.AddUint64(&c.droppedChunks, oldest-c.seq)
+				atomic.AddUint64(&c.droppedBytes, chunk.offset-c.offset)
+				c.seq, c.offset = oldest, chunk.offset
+			}
+			chunk := b.chunks[c.seq%slots]
+			b.mux.RUnlock()
+
+			c.seq++
+			c.offset = chunk.offset + uint64(len(chunk.data))
+			atomic.AddUint64(&c.bytesRead, uint64(len(chunk.data)))
+			return chunk.data, nil
+		}
+		if b.closed {
+			b.mux.RUnlock()
+			return nil, ErrStreamClosed
+		}
+		wake := b.wake
+		b.mux.RUnlock()
+
+		select {
+		case <-wake:
+		case <-c.closed:
+			return nil, ErrClientClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// SetMaxLag makes Next return ErrReaderLagging once the reader is more than
+// maxLag bytes behind or its next chunk was overwritten. Call before reading.
+func (c *BufferCursor) SetMaxLag(maxLag uint64) {
+	c.maxLag = maxLag
+}
+
+// Lag returns how many bytes the cursor is behind the live edge
+func (c *BufferCursor) Lag() uint64 {
+	b := c.buf
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if b.total < c.offset {
+		return 0
+	}
+	return b.total - c.offset
+}
+
+// SkipToLive moves the cursor to the live edge, counting skipped data as
+// dropped, and queues preface chunks (e.g. a GOP replay) before live data.
+// Like Next, it must be called from the reading goroutine.
+func (c *BufferCursor) SkipToLive(preface ...[]byte) {
+	b := c.buf
+	b.mux.RLock()
+	if c.seq < b.next {
+		atomic.AddUint64(&c.droppedChunks, b.next-c.seq)
+		atomic.AddUint64(&c.droppedBytes, b.total-c.offset)
+	}
+	c.seq, c.offset = b.next, b.total
+	b.mux.RUnlock()
+	c.preface = preface
+}
+
+// Close disconnects the cursor, a blocked Next returns ErrClientClosed
+func (c *BufferCursor) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// Dropped returns the bytes and chunks this reader missed by falling behind
+func (c *BufferCursor) Dropped() (bytes uint64, chunks uint64) {
+	return atomic.LoadUint64(&c.droppedBytes), atomic.LoadUint64(&c.droppedChunks)
+}
+
+// BytesRead returns the number of bytes delivered to this reader
+func (c *BufferCursor) BytesRead() uint64 {
+	return atomic.LoadUint64(&c.bytesRead)
+}
+
+// StreamPipe publishes one source's output to many clients through a
+// BroadcastBuffer, with a GOP cache so joining clients start on a keyframe.
+type StreamPipe struct {
+	mux    sync.Mutex // Serializes publishing with cursor creation
+	buffer *BroadcastBuffer
+	gop    *GOPCache
+}
+
+const (
+	gopCacheSize   = 12 * 1024 * 1024 // Enough for ~10s GOPs at 8 Mbps
+	gopReplayChunk = 64 * 1024
+)
+
+// NewStreamPipe creates a pipe retaining the last slots chunks
+func NewStreamPipe(slots int) *StreamPipe {
+	return &StreamPipe{
+		buffer: NewBroadcastBuffer(slots),
+		gop:    NewGOPCache(gopCacheSize),
+	}
+}
+
+// Publish hands a chunk to all readers; data must not be modified afterwards
+func (p *StreamPipe) Publish(data []byte) {
+	// Update the GOP cache and the ring under the same lock so a joining
+	// client sees a chunk either in its replay or as live data, never both.
+	p.mux.Lock()
+	p.gop.Write(data)
+	p.buffer.Write(data)
+	p.mux.Unlock()
+}
+
+// NewCursor returns a cursor that replays PAT/PMT and the current GOP
+// before live data, so the client can start decoding immediately.
+func (p *StreamPipe) NewCursor() *BufferCursor {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.buffer.NewCursor(p.replay()...)
+}
+
+// SkipToLive moves a lagging cursor to the live edge, starting again at the
+// current GOP so the client resumes on a keyframe.
+func (p *StreamPipe) SkipToLive(c *BufferCursor) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	c.SkipToLive(p.replay()...)
+}
+
+// replay splits the GOP snapshot into chunks, callers hold p.mux
+func (p *StreamPipe) replay() [][]byte {
+	snapshot := p.gop.Snapshot()
+	var chunks [][]byte
+	for len(snapshot) > 0 {
+		n := gopReplayChunk
+		if n > len(snapshot) {
+			n = len(snapshot)
+		}
+		chunks = append(chunks, snapshot[:n])
+		snapshot = snapshot[n:]
+	}
+	return chunks
+}
+
+// ResetGOP drops the GOP cache, e.g. when the source process restarts
+func (p *StreamPipe) ResetGOP() {
+	p.mux.Lock()
+	p.gop.Reset()
+	p.mux.Unlock()
+}
+
+// Close ends the stream for all readers
+func (p *StreamPipe) Close() {
+	p.buffer.Close()
+}
@@ -0,0 +1,285 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cursorRead is one expected result of BufferCursor.Next
+type cursorRead struct {
+	data string
+	err  error
+}
+
+func TestBufferCursor(t *testing.T) {
+	tests := []struct {
+		name          string
+		slots         int
+		maxLag        uint64
+		preface       []string
+		writes        []string
+		lag           uint64
+		reads         []cursorRead
+		droppedBytes  uint64
+		droppedChunks uint64
+	}{
+		{
+			name:   "reads every chunk in order",
+			slots:  4,
+			writes: []string{"a", "bb", "ccc"},
+			lag:    6,
+			reads:  []cursorRead{{data: "a"}, {data: "bb"}, {data: "ccc"}, {err: ErrStreamClosed}},
+		},
+		{
+			name:          "skips chunks overwritten while behind",
+			slots:         2,
+			writes:        []string{"a", "bb", "ccc", "dddd"},
+			lag:           10,
+			reads:         []cursorRead{{data: "ccc"}, {data: "dddd"}, {err: ErrStreamClosed}},
+			droppedBytes:  3,
+			droppedChunks: 2,
+		},
+		{
+			name:   "lag limit not reached",
+			slots:  4,
+			maxLag: 3,
+			writes: []string{"a", "bb"},
+			lag:    3,
+			reads:  []cursorRead{{data: "a"}, {data: "bb"}, {err: ErrStreamClosed}},
+		},
+		{
+			name:   "lag limit exceeded",
+			slots:  4,
+			maxLag: 4,
+			writes: []string{"a", "bb", "ccc"},
+			lag:    6,
+			reads:  []cursorRead{{err: ErrReaderLagging}, {err: ErrReaderLagging}},
+		},
+		{
+			name:   "lag limit with the next chunk overwritten",
+			slots:  2,
+			maxLag: 1024,
+			writes: []string{"a", "bb", "ccc"},
+			lag:    6,
+			reads:  []cursorRead{{err: ErrReaderLagging}},
+		},
+		{
+			name:    "preface before live data",
+			slots:   4,
+			preface: []string{"pat", "gop"},
+			writes:  []string{"a"},
+			lag:     1,
+			reads:   []cursorRead{{data: "pat"}, {data: "gop"}, {data: "a"}, {err: ErrStreamClosed}},
+		},
+		{
+			name:   "empty writes are ignored",
+			slots:  1,
+			writes: []string{"", "a", ""},
+			lag:    1,
+			reads:  []cursorRead{{data: "a"}, {err: ErrStreamClosed}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := NewBroadcastBuffer(tt.slots)
+			var preface [][]byte
+			for _, p := range tt.preface {
+				preface = append(preface, []byte(p))
+			}
+			cursor := buf.NewCursor(preface...)
+			cursor.SetMaxLag(tt.maxLag)
+			for _, w := range tt.writes {
+				buf.Write([]byte(w))
+			}
+			buf.Close()
+
+			if lag := cursor.Lag(); lag != tt.lag {
+				t.Errorf("lag %d, want %d", lag, tt.lag)
+			}
+			for i, want := range tt.reads {
+				data, err := cursor.Next(context.Background())
+				if !errors.Is(err, want.err) || string(data) != want.data {
+					t.Fatalf("read %d: got %q, %v; want %q, %v", i, data, err, want.data, want.err)
+				}
+			}
+			if bytes, chunks := cursor.Dropped(); bytes != tt.droppedBytes || chunks != tt.droppedChunks {
+				t.Errorf("dropped %d bytes in %d chunks, want %d in %d", bytes, chunks, tt.droppedBytes, tt.droppedChunks)
+			}
+		})
+	}
+}
+
+func TestBufferCursorSkipToLive(t *testing.T) {
+	buf := NewBroadcastBuffer(8)
+	cursor := buf.NewCursor()
+	buf.Write([]byte("aa"))
+	buf.Write([]byte("bbb"))
+
+	cursor.SkipToLive([]byte("gop"))
+	if lag := cursor.Lag(); lag != 0 {
+		t.Errorf("lag %d after skipping to live, want 0", lag)
+	}
+	if bytes, chunks := cursor.Dropped(); bytes != 5 || chunks != 2 {
+		t.Errorf("dropped %d bytes in %d chunks, want 5 in 2", bytes, chunks)
+	}
+
+	buf.Write([]byte("c"))
+	for _, want := range []string{"gop", "c"} {
+		data, err := cursor.Next(context.Background())
+		if err != nil || string(data) != want {
+			t.Fatalf("got %q, %v; want %q", data, err, want)
+		}
+	}
+	if n := cursor.BytesRead(); n != 4 {
+		t.Errorf("read %d bytes, want 4", n)
+	}
+}
+
+func TestBufferCursorDisconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(buf *BroadcastBuffer, cursor *BufferCursor, cancel context.CancelFunc)
+		err  error
+	}{
+		{
+			name: "cursor closed",
+			stop: func(_ *BroadcastBuffer, cursor *BufferCursor, _ context.CancelFunc) { cursor.Close() },
+			err:  ErrClientClosed,
+		},
+		{
+			name: "stream closed",
+			stop: func(buf *BroadcastBuffer, _ *BufferCursor, _ context.CancelFunc) { buf.Close() },
+			err:  ErrStreamClosed,
+		},
+		{
+			name: "context canceled",
+			stop: func(_ *BroadcastBuffer, _ *BufferCursor, cancel context.CancelFunc) { cancel() },
+			err:  context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := NewBroadcastBuffer(4)
+			cursor := buf.NewCursor()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result := make(chan error, 1)
+			go func() {
+				_, err := cursor.Next(ctx)
+				result <- err
+			}()
+
+			// Next blocks at the live edge until stopped
+			select {
+			case err := <-result:
+				t.Fatalf("Next returned %v before the reader was stopped", err)
+			case <-time.After(20 * time.Millisecond):
+			}
+			tt.stop(buf, cursor, cancel)
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("got %v, want %v", err, tt.err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Next still blocked after the reader was stopped")
+			}
+		})
+	}
+}
+
+// benchChunkSize matches the FFmpeg stdout read size
+const benchChunkSize = 8192
+
+// benchmarkBroadcast publishes chunks to one buffer read by n concurrent
+// clients. Throughput is per published chunk; drops/op shows how many
+// chunks an average reader missed because it could not keep up.
+func benchmarkBroadcast(b *testing.B, readers int) {
+	buf := NewBroadcastBuffer(ffmpegBufferSlots)
+	chunk := make([]byte, benchChunkSize)
+
+	var wg sync.WaitGroup
+	cursors := make([]*BufferCursor, readers)
+	for i := range cursors {
+		cursor := buf.NewCursor()
+		cursors[i] = cursor
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := cursor.Next(context.Background()); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	b.SetBytes(benchChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Write(chunk)
+	}
+	buf.Close()
+	wg.Wait()
+	b.StopTimer()
+
+	var dropped uint64
+	for _, cursor := range cursors {
+		_, chunks := cursor.Dropped()
+		dropped += chunks
+	}
+	b.ReportMetric(float64(dropped)/float64(readers)/float64(b.N), "drops/op")
+}
+
+func BenchmarkBroadcast1Reader(b *testing.B)    { benchmarkBroadcast(b, 1) }
+func BenchmarkBroadcast50Readers(b *testing.B)  { benchmarkBroadcast(b, 50) }
+func BenchmarkBroadcast500Readers(b *testing.B) { benchmarkBroadcast(b, 500) }
+
+// benchmarkChannelFanout is the previous design for comparison: the
+// broadcast loop copies each read and sends it to a channel per client.
+func benchmarkChannelFanout(b *testing.B, readers int) {
+	chunk := make([]byte, benchChunkSize)
+
+	var wg sync.WaitGroup
+	channels := make([]chan []byte, readers)
+	for i := range channels {
+		ch := make(chan []byte, 2000)
+		channels[i] = ch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range ch {
+			}
+		}()
+	}
+
+	b.SetBytes(benchChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data := make([]byte, len(chunk))
+		copy(data, chunk)
+		for _, ch := range channels {
+			select {
+			case ch <- data:
+			default:
+			}
+		}
+	}
+	for _, ch := range channels {
+		close(ch)
+	}
+	wg.Wait()
+}
+
+func BenchmarkChannelFanout1Reader(b *testing.B)    { benchmarkChannelFanout(b, 1) }
+func BenchmarkChannelFanout50Readers(b *testing.B)  { benchmarkChannelFanout(b, 50) }
+func BenchmarkChannelFanout500Readers(b *testing.B) { benchmarkChannelFanout(b, 500) }
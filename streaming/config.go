@@ -0,0 +1,117 @@
+package streaming
+
+import (
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the runtime settings of the streaming managers. It is
+// pushed by the settings service whenever the admin changes a value.
+type Config struct {
+	FFmpegPath         string        // FFmpeg binary; ffprobe is looked up next to it
+	BufferSizeKB       int           // Per-session buffer for clients that fall behind
+	IdleTimeout        time.Duration // Stop sessions without clients after this long
+	HLSSegmentDuration time.Duration // Target duration of HLS segments
+
+	MaxStreams            int           // Running FFmpeg processes allowed, 0 = unlimited
+	AdmissionPolicy       string        // What to do when MaxStreams is reached
+	AdmissionQueueTimeout time.Duration // How long a queued start waits for a slot
+
+	Nice          int   // Scheduling priority of FFmpeg processes, 0 = inherit
+	CPUAffinity   []int // CPUs FFmpeg processes may run on, empty = all (Linux)
+	MemoryLimitMB int   // RLIMIT_AS of FFmpeg processes, 0 = unlimited (Linux)
+
+	TimeshiftQuotaMB int // Disk space of all timeshift archives, 0 = unlimited
+}
+
+// minBufferSlots keeps a usable buffer even for tiny buffer sizes
+const minBufferSlots = 16
+
+var (
+	config = Config{
+		FFmpegPath:         "ffmpeg",
+		BufferSizeKB:       ffmpegBufferSlots * 8,
+		IdleTimeout:        60 * time.Second,
+		HLSSegmentDuration: hlsSegmentSeconds * time.Second,
+
+		AdmissionPolicy:       AdmissionRefuse,
+		AdmissionQueueTimeout: 10 * time.Second,
+	}
+	configMux sync.RWMutex
+)
+
+// Configure applies new runtime settings. Idle timeouts apply to running
+// sessions at the next monitor tick and admission settings to the next
+// start; the binary path, buffer size, segment duration and process limits
+// apply to the next FFmpeg process or session.
+func Configure(cfg Config) {
+	configMux.Lock()
+	defer configMux.Unlock()
+
+	if cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = config.FFmpegPath
+	}
+	if cfg.BufferSizeKB <= 0 {
+		cfg.BufferSizeKB = config.BufferSizeKB
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = config.IdleTimeout
+	}
+	if cfg.HLSSegmentDuration < time.Second {
+		cfg.HLSSegmentDuration = config.HLSSegmentDuration
+	}
+	if !ValidAdmissionPolicy(cfg.AdmissionPolicy) {
+		cfg.AdmissionPolicy = config.AdmissionPolicy
+	}
+	if cfg.AdmissionQueueTimeout <= 0 {
+		cfg.AdmissionQueueTimeout = config.AdmissionQueueTimeout
+	}
+
+	if !reflect.DeepEqual(cfg, config) {
+		log.Printf("⚙️  Streaming config: ffmpeg=%s buffer=%dKB idle=%v segment=%v max_streams=%d admission=%s nice=%d cpus=%v memory=%dMB timeshift_quota=%dMB",
+			cfg.FFmpegPath, cfg.BufferSizeKB, cfg.IdleTimeout, cfg.HLSSegmentDuration, cfg.MaxStreams, cfg.AdmissionPolicy,
+			cfg.Nice, cfg.CPUAffinity, cfg.MemoryLimitMB, cfg.TimeshiftQuotaMB)
+	}
+	config = cfg
+}
+
+// currentConfig returns the active runtime settings
+func currentConfig() Config {
+	configMux.RLock()
+	defer configMux.RUnlock()
+	return config
+}
+
+// ffmpegBinary returns the FFmpeg binary to run
+func ffmpegBinary() string {
+	return currentConfig().FFmpegPath
+}
+
+// ffprobeBinary returns the ffprobe next to the configured FFmpeg, or
+// ffprobe from PATH when FFmpeg is found through PATH too
+func ffprobeBinary() string {
+	path := currentConfig().FFmpegPath
+	if !strings.ContainsRune(path, filepath.Separator) {
+		return "ffprobe"
+	}
+	return filepath.Dir(path) + string(filepath.Separator) + "ffprobe"
+}
+
+// bufferSlots converts the configured buffer size into ring slots for a
+// reader that reads at most readSize bytes at a time
+func bufferSlots(readSize int) int {
+	slots := currentConfig().BufferSizeKB * 1024 / readSize
+	if slots < minBufferSlots {
+		return minBufferSlots
+	}
+	return slots
+}
+
+// segmentSeconds returns the HLS segment duration in whole seconds
+func segmentSeconds() int {
+	return int(currentConfig().HLSSegmentDuration / time.Second)
+}
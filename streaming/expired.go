@@ -2,74 +2,109 @@ package streaming
 
 import (
 	"context"
-	"crypto/md5"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"sync"
-	"time"
 )
 
+// slate is a notification video looped by one shared FFmpeg and sent to
+// every client shown the notification
+type slate struct {
+	name      string
+	videoPath string
+	message   string // Sent as text when the video is missing
+
+	mux     sync.Mutex
+	clients map[string]chan []byte
+	active  bool
+	cancel  context.CancelFunc
+	cmd     *exec.Cmd
+}
+
 var (
-	expiredStreamMux     sync.Mutex
-	expiredStreamClients = make(map[string]chan []byte)
-	expiredStreamActive  = false
-	expiredStreamCancel  context.CancelFunc
+	expiredSlate = &slate{
+		name:      "expired-notification",
+		videoPath: "./static/expired-notification.mp4",
+		message:   "SUBSCRIPTION EXPIRED\n\nLangganan Anda Telah Berakhir\nYour Subscription Has Expired\n\nHubungi Admin / Contact Admin",
+		clients:   make(map[string]chan []byte),
+	}
+	devicesSlate = &slate{
+		name:      "too-many-devices",
+		videoPath: "./static/too-many-devices.mp4",
+		message:   "TOO MANY DEVICES\n\nAkun Anda Sedang Dipakai di Perangkat Lain\nYour Account Is In Use On Too Many Devices\n\nHubungi Admin / Contact Admin",
+		clients:   make(map[string]chan []byte),
+	}
 )
 
 // StreamExpiredVideo streams the expired notification video in infinite loop
 func StreamExpiredVideo(w http.ResponseWriter, r *http.Request) {
-	videoPath := "./static/expired-notification.mp4"
-	
+	expiredSlate.serve(w, r)
+}
+
+// StreamTooManyDevicesVideo streams the "too many devices" notification to a
+// viewer over its user's connection limit, in infinite loop
+func StreamTooManyDevicesVideo(w http.ResponseWriter, r *http.Request) {
+	devicesSlate.serve(w, r)
+}
+
+// TooManyDevicesVideoAvailable reports whether the "too many devices" video
+// exists, so it can follow a stream that has already started
+func TooManyDevicesVideoAvailable() bool {
+	_, err := os.Stat(devicesSlate.videoPath)
+	return err == nil
+}
+
+// serve sends the slate until the client disconnects. Without the video it
+// replies 403 with the slate's message.
+func (s *slate) serve(w http.ResponseWriter, r *http.Request) {
 	// Check if video exists
-	if _, err := os.Stat(videoPath); err != nil {
+	if _, err := os.Stat(s.videoPath); err != nil {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("SUBSCRIPTION EXPIRED\n\nLangganan Anda Telah Berakhir\nYour Subscription Has Expired\n\nHubungi Admin / Contact Admin"))
+		w.Write([]byte(s.message))
 		return
 	}
-	
-	// Generate client ID
-	clientID := fmt.Sprintf("%x", md5.Sum([]byte(r.RemoteAddr+r.UserAgent()+fmt.Sprintf("%d", time.Now().UnixNano()))))
-	
+
+	clientID := NewViewerID()
+
 	// Create data channel for this client
 	dataChan := make(chan []byte, 2000)
-	
-	expiredStreamMux.Lock()
-	expiredStreamClients[clientID] = dataChan
-	
+
+	s.mux.Lock()
+	s.clients[clientID] = dataChan
+
 	// Start FFmpeg stream if not active
-	if !expiredStreamActive {
-		expiredStreamActive = true
-		go startExpiredStream(videoPath)
+	if !s.active && !isShuttingDown() {
+		s.active = true
+		go s.start()
 	}
-	expiredStreamMux.Unlock()
-	
+	s.mux.Unlock()
+
 	// Cleanup on disconnect
 	defer func() {
-		expiredStreamMux.Lock()
-		delete(expiredStreamClients, clientID)
+		s.mux.Lock()
+		delete(s.clients, clientID)
 		close(dataChan)
-		log.Printf("👋 Expired stream client disconnected: %s (remaining: %d)", clientID, len(expiredStreamClients))
-		expiredStreamMux.Unlock()
+		log.Printf("👋 %s client disconnected: %s (remaining: %d)", s.name, clientID, len(s.clients))
+		s.mux.Unlock()
 	}()
-	
+
 	// Set streaming headers
 	w.Header().Set("Content-Type", "video/MP2T")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
-	
-	log.Printf("👤 Expired stream client connected: %s", clientID)
-	
+
+	log.Printf("👤 %s client connected: %s", s.name, clientID)
+
 	// Stream data to client
 	for {
 		select {
@@ -87,70 +122,100 @@ func StreamExpiredVideo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func startExpiredStream(videoPath string) {
+func (s *slate) start() {
 	defer func() {
-		expiredStreamMux.Lock()
-		expiredStreamActive = false
-		expiredStreamMux.Unlock()
+		s.mux.Lock()
+		s.active = false
+		s.mux.Unlock()
 	}()
-	
+
 	ctx, cancel := context.WithCancel(context.Background())
-	expiredStreamCancel = cancel
+	s.mux.Lock()
+	s.cancel = cancel
+	s.mux.Unlock()
 	defer cancel()
-	
-	log.Printf("🎬 Starting expired notification stream (infinite loop)")
-	
+
+	log.Printf("🎬 Starting %s stream (infinite loop)", s.name)
+
 	// FFmpeg command with infinite loop
 	args := []string{
-		"-stream_loop", "-1",          // Infinite loop
-		"-re",                          // Read input at native framerate
-		"-i", videoPath,                // Input video file
-		"-c", "copy",                   // Copy without re-encoding
-		"-f", "mpegts",                 // MPEG-TS format
+		"-stream_loop", "-1", // Infinite loop
+		"-re",             // Read input at native framerate
+		"-i", s.videoPath, // Input video file
+		"-c", "copy", // Copy without re-encoding
+		"-f", "mpegts", // MPEG-TS format
 		"-avoid_negative_ts", "make_zero",
 		"-max_muxing_queue_size", "9999",
-		"pipe:1",                       // Output to stdout
+		"pipe:1", // Output to stdout
 	}
-	
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), args...)
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Printf("❌ Failed to create pipe for expired stream: %v", err)
+		log.Printf("❌ Failed to create pipe for %s stream: %v", s.name, err)
 		return
 	}
-	
-	if err := cmd.Start(); err != nil {
-		log.Printf("❌ Failed to start FFmpeg for expired stream: %v", err)
+
+	if err := GetSupervisor().Start(s.name, cmd); err != nil {
+		log.Printf("❌ Failed to start FFmpeg for %s stream: %v", s.name, err)
 		return
 	}
-	
+	s.mux.Lock()
+	s.cmd = cmd
+	s.mux.Unlock()
+
 	// Read from FFmpeg and broadcast to all clients
 	buffer := make([]byte, 188*7) // MPEG-TS packet size (188 bytes) * 7
 	for {
 		n, err := stdout.Read(buffer)
 		if err != nil {
-			log.Printf("⚠️ Expired stream ended: %v", err)
+			log.Printf("⚠️ %s stream ended: %v", s.name, err)
 			break
 		}
-		
+
 		if n > 0 {
 			data := make([]byte, n)
 			copy(data, buffer[:n])
-			
+
 			// Broadcast to all connected clients
-			expiredStreamMux.Lock()
-			for clientID, ch := range expiredStreamClients {
+			s.mux.Lock()
+			for clientID, ch := range s.clients {
 				select {
 				case ch <- data:
 				default:
 					log.Printf("⚠️ Client %s buffer full, skipping packet", clientID)
 				}
 			}
-			expiredStreamMux.Unlock()
+			s.mux.Unlock()
+		}
+	}
+
+	GetSupervisor().Wait(cmd)
+	log.Printf("🛑 %s stream stopped", s.name)
+}
+
+// stop stops the slate's FFmpeg if it is running
+func (s *slate) stop() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.active || s.cancel == nil {
+		return false
+	}
+	s.cancel()
+	GetSupervisor().Kill(s.cmd)
+	return true
+}
+
+// stopSlateStreams stops the notification FFmpegs that are running and
+// returns how many were
+func stopSlateStreams() int {
+	stopped := 0
+	for _, s := range []*slate{expiredSlate, devicesSlate} {
+		if s.stop() {
+			stopped++
 		}
 	}
-	
-	cmd.Wait()
-	log.Printf("🛑 Expired notification stream stopped")
+	return stopped
 }
@@ -3,8 +3,13 @@ package streaming
 import (
 	"context"
 	"fmt"
+	"io"
+	"iptv-panel/mpegts"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,8 +20,14 @@ type FFmpegSession struct {
 	ID            string
 	SourceURLs    []string
 	OutputFormat  string // "mpegts", "hls", "copy"
+	OutputDir     string // Playlist and segment directory for "hls" sessions
+	Profile       TranscodeProfile
+	Ladder        []TranscodeProfile // ABR variants, encoded by one FFmpeg into v0, v1, ...
 	onDemand      bool
 	onDemandMux   sync.RWMutex
+	slowPolicy    SlowClientPolicy
+	priority      int // Higher priority streams may take the slot of lower ones
+	policyMux     sync.RWMutex // Guards slowPolicy and priority
 	ctx           context.Context
 	cancel        context.CancelFunc
 	cmd           *exec.Cmd
@@ -29,10 +40,24 @@ type FFmpegSession struct {
 	pipeWriter    *StreamPipe
 	bytesRead     uint64 // Total bytes from source
 	bytesWritten  uint64 // Total bytes to clients
+	lastRead      time.Time // When FFmpeg last produced output
 	bytesMux      sync.RWMutex
-	retryCount    int       // Number of consecutive failures
-	lastFailTime  time.Time // Last time FFmpeg failed
-	isBlacklisted bool      // If true, stop trying to restart
+	sources       *SourcePool // Health of SourceURLs, picks the one in use
+	procMux       sync.Mutex  // Guards cmd, switching, watchingPrimary and lastError
+	switching     bool        // Process was stopped to change source
+	watchingPrimary bool      // Primary probe goroutine is running
+	lastError     string      // Last error line printed by FFmpeg
+	progress      *ffmpegProgress // Live -progress telemetry and recent errors
+	inspector     *streamInspector // Codecs and quality of the MPEG-TS output
+	timeshift     timeshiftTap     // Archive recording the output, if any
+	isBlacklisted bool        // If true, refuse clients until a source responds
+	blacklistMux  sync.RWMutex
+	blacklistedAt time.Time
+	blacklistReason string
+	blacklistError  string    // Last FFmpeg or probe error while blacklisted
+	nextProbe     time.Time
+	probeAttempts int
+	cancelProbe   context.CancelFunc
 	
 	// Real-time bandwidth tracking with sliding window
 	lastBytesRead     uint64
@@ -48,18 +73,19 @@ type FFmpegSession struct {
 	timeHistory       []time.Time
 }
 
-// StreamPipe handles FFmpeg output piping to multiple clients
-type StreamPipe struct {
-	readers    map[string]chan []byte
-	readersMux sync.RWMutex
-	buffer     *RingBuffer
-}
+const (
+	// ffmpegReadSize is the largest chunk read from FFmpeg stdout
+	ffmpegReadSize = 8192
+	// ffmpegBufferSlots is the default number of stdout reads kept for
+	// clients that fall behind, about 8MB per session
+	ffmpegBufferSlots = 1024
+)
 
 // FFmpegManager manages all FFmpeg sessions
 type FFmpegManager struct {
-	sessions    map[string]*FFmpegSession
-	sessionsMux sync.RWMutex
-	idleTimeout time.Duration
+	sessions     map[string]*FFmpegSession
+	reservations map[string]time.Time // Admitted sessions not running yet, by expiry
+	sessionsMux  sync.RWMutex         // Guards sessions and reservations
 }
 
 var (
@@ -71,8 +97,8 @@ var (
 func GetFFmpegManager() *FFmpegManager {
 	ffmpegManagerOnce.Do(func() {
 		ffmpegManager = &FFmpegManager{
-			sessions:    make(map[string]*FFmpegSession),
-			idleTimeout: 60 * time.Second,
+			sessions:     make(map[string]*FFmpegSession),
+			reservations: make(map[string]time.Time),
 		}
 		go ffmpegManager.monitorSessions()
 	})
@@ -81,21 +107,49 @@ func GetFFmpegManager() *FFmpegManager {
 
 // GetOrCreateFFmpegSession gets or creates FFmpeg session
 func (m *FFmpegManager) GetOrCreateFFmpegSession(streamID string, sourceURLs []string, format string) *FFmpegSession {
+	return m.GetOrCreateTranscodeSession(streamID, sourceURLs, format, BuiltinProfiles[PassthroughProfile])
+}
+
+// GetOrCreateTranscodeSession gets or creates the session encoding a stream
+// with profile. Its ID is ProfileSessionID(streamID, profile.Name).
+func (m *FFmpegManager) GetOrCreateTranscodeSession(streamID string, sourceURLs []string, format string, profile TranscodeProfile) *FFmpegSession {
+	return m.getOrCreateSession(ProfileSessionID(streamID, profile.Name), sourceURLs, format, profile, nil)
+}
+
+// getOrCreateSession returns the session streamID, creating it with the
+// given profile or ABR ladder
+func (m *FFmpegManager) getOrCreateSession(streamID string, sourceURLs []string, format string, profile TranscodeProfile, ladder []TranscodeProfile) *FFmpegSession {
 	m.sessionsMux.Lock()
 	defer m.sessionsMux.Unlock()
 
 	if session, exists := m.sessions[streamID]; exists {
+		session.clientsMux.Lock()
 		session.lastActivity = time.Now()
+		session.clientsMux.Unlock()
 		return session
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	now := time.Now()
+	var outputDir string
+	if format == "hls" {
+		outputDir = filepath.Join(hlsCacheDir, "ffmpeg", sanitizeSessionDir(streamID))
+		os.RemoveAll(outputDir) // Drop stale segments from a previous session
+		os.MkdirAll(outputDir, 0755)
+		for idx := range ladder {
+			os.MkdirAll(filepath.Join(outputDir, variantName(idx)), 0755)
+		}
+	}
 	session := &FFmpegSession{
 		ID:           streamID,
 		SourceURLs:   sourceURLs,
+		sources:      NewSourcePool(sourceURLs),
 		OutputFormat: format,
+		OutputDir:    outputDir,
+		Profile:      profile,
+		Ladder:       ladder,
 		onDemand:     true,
+		slowPolicy:   DefaultSlowClientPolicy,
 		ctx:          ctx,
 		cancel:       cancel,
 		clients:      make(map[string]*StreamClient),
@@ -106,14 +160,13 @@ func (m *FFmpegManager) GetOrCreateFFmpegSession(streamID string, sourceURLs []s
 		bytesHistory:     make([]uint64, 0, 10),
 		bytesWriteHistory: make([]uint64, 0, 10),
 		timeHistory:      make([]time.Time, 0, 10),
-		pipeWriter: &StreamPipe{
-			readers: make(map[string]chan []byte),
-			buffer:  NewRingBuffer(5 * 1024 * 1024), // 5MB buffer
-		},
+		pipeWriter:       NewStreamPipe(bufferSlots(ffmpegReadSize)),
+		progress:         &ffmpegProgress{},
+		inspector:        newStreamInspector(),
 	}
 
 	m.sessions[streamID] = session
-	log.Printf("🎬 Created FFmpeg session: %s (format: %s)", streamID, format)
+	log.Printf("🎬 Created FFmpeg session: %s (format: %s, profile: %s)", streamID, format, profile.Name)
 
 	return session
 }
@@ -134,27 +187,65 @@ func (s *FFmpegSession) IsOnDemand() bool {
 	return s.onDemand
 }
 
-// AddClient adds a client to FFmpeg session
-func (s *FFmpegSession) AddClient(clientID, remoteAddr string) (chan []byte, error) {
+// SetSlowClientPolicy sets how clients joining afterwards are treated when
+// they cannot keep up with the stream.
+func (s *FFmpegSession) SetSlowClientPolicy(policy SlowClientPolicy) {
+	s.policyMux.Lock()
+	s.slowPolicy = policy
+	s.policyMux.Unlock()
+}
+
+// SetPriority sets the admission priority of the session
+func (s *FFmpegSession) SetPriority(priority int) {
+	s.policyMux.Lock()
+	s.priority = priority
+	s.policyMux.Unlock()
+}
+
+// GetPriority returns the admission priority of the session
+func (s *FFmpegSession) GetPriority() int {
+	s.policyMux.RLock()
+	defer s.policyMux.RUnlock()
+	return s.priority
+}
+
+// SetChannelID marks the session as the unmodified stream of a channel, whose
+// metadata is then reported from stream inspection
+func (s *FFmpegSession) SetChannelID(channelID int) {
+	s.inspector.setChannel(channelID)
+}
+
+// SetTimeshift records the session output into archive, nil stops recording
+func (s *FFmpegSession) SetTimeshift(archive *TimeshiftArchive) {
+	s.timeshift.set(archive)
+}
+
+// Inspection returns what inspecting the MPEG-TS output has found so far
+func (s *FFmpegSession) Inspection() mpegts.StreamInfo {
+	return s.inspector.Snapshot()
+}
+
+// GetSlowClientPolicy returns the current slow client policy
+func (s *FFmpegSession) GetSlowClientPolicy() SlowClientPolicy {
+	s.policyMux.RLock()
+	defer s.policyMux.RUnlock()
+	return s.slowPolicy
+}
+
+// AddClient adds a client to FFmpeg session. The client reads the stream
+// with Next, starting with PAT/PMT and the current GOP.
+func (s *FFmpegSession) AddClient(clientID, remoteAddr, userAgent string) (*StreamClient, error) {
 	// Check if blacklisted
-	if s.isBlacklisted {
+	if s.IsBlacklisted() {
 		return nil, fmt.Errorf("channel is offline or unavailable")
 	}
 	
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
-	dataChan := make(chan []byte, 2000) // Large buffer to prevent packet drops
-	
-	s.pipeWriter.readersMux.Lock()
-	s.pipeWriter.readers[clientID] = dataChan
-	s.pipeWriter.readersMux.Unlock()
-
-	client := &StreamClient{
-		ID:         clientID,
-		Connected:  time.Now(),
-		RemoteAddr: remoteAddr,
-		Done:       make(chan bool, 1),
+	client := newPipeClient(s.ID, clientID, remoteAddr, userAgent, s.pipeWriter, s.GetSlowClientPolicy())
+	if old, exists := s.clients[clientID]; exists {
+		s.retireClient(old)
 	}
 	s.clients[clientID] = client
 	s.lastActivity = time.Now()
@@ -166,7 +257,122 @@ func (s *FFmpegSession) AddClient(clientID, remoteAddr string) (chan []byte, err
 		go s.Start()
 	}
 
-	return dataChan, nil
+	return client, nil
+}
+
+// TouchClient registers or refreshes an HLS client. HLS players poll the
+// playlist instead of holding a connection open, so clients are tracked by
+// last request time and expired by the session monitor.
+func (s *FFmpegSession) TouchClient(clientID, remoteAddr, userAgent string) error {
+	if s.IsBlacklisted() {
+		return fmt.Errorf("channel is offline or unavailable")
+	}
+
+	s.clientsMux.Lock()
+	now := time.Now()
+	client, exists := s.clients[clientID]
+	if !exists {
+		client = newHLSClient(clientID, remoteAddr, userAgent)
+		s.clients[clientID] = client
+		log.Printf("👤 HLS client connected to FFmpeg stream %s: %s (total: %d)", s.ID, clientID, len(s.clients))
+	}
+	client.LastSeen = now
+	s.lastActivity = now
+	s.clientsMux.Unlock()
+
+	if !s.IsActive() {
+		go s.Start()
+	}
+	return nil
+}
+
+// pruneHLSClients drops HLS clients that have not requested anything recently
+func (s *FFmpegSession) pruneHLSClients(maxIdle time.Duration) {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+
+	for clientID, client := range s.clients {
+		if time.Since(client.LastSeen) > maxIdle {
+			delete(s.clients, clientID)
+			s.retireClient(client)
+			log.Printf("👋 HLS client timed out from FFmpeg stream %s: %s (remaining: %d)", s.ID, clientID, len(s.clients))
+		}
+	}
+}
+
+// GetPlaylistPath returns the path of the HLS playlist written by FFmpeg,
+// the master playlist for ABR sessions
+func (s *FFmpegSession) GetPlaylistPath() string {
+	if s.IsABR() {
+		return filepath.Join(s.OutputDir, abrMasterPlaylist)
+	}
+	return filepath.Join(s.OutputDir, "playlist.m3u8")
+}
+
+// GetSegmentPath returns the on-disk path of an HLS segment, or an error if
+// the name is not a segment produced by this session.
+func (s *FFmpegSession) GetSegmentPath(name string) (string, error) {
+	if s.OutputFormat != "hls" || !hlsSegmentPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid segment name")
+	}
+	return filepath.Join(s.OutputDir, name), nil
+}
+
+// WaitForPlaylist waits until FFmpeg has written a playlist containing at
+// least one segment and returns its contents.
+func (s *FFmpegSession) WaitForPlaylist(timeout time.Duration) ([]byte, error) {
+	return s.waitForFile(s.GetPlaylistPath(), timeout)
+}
+
+// waitForFile waits until FFmpeg has written the playlist at path with at
+// least one segment. A master playlist is ready once its variants have
+// segments.
+func (s *FFmpegSession) waitForFile(path string, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if data, err := os.ReadFile(path); err == nil && s.playlistReady(data) {
+			return data, nil
+		}
+		if s.IsBlacklisted() {
+			return nil, fmt.Errorf("channel is offline or unavailable")
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("playlist not ready")
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil, fmt.Errorf("session stopped")
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// playlistReady reports whether a playlist read from disk can be served
+func (s *FFmpegSession) playlistReady(data []byte) bool {
+	if strings.Contains(string(data), "#EXTINF") {
+		return true
+	}
+	if !s.IsABR() || !strings.Contains(string(data), "#EXT-X-STREAM-INF") {
+		return false
+	}
+	variant, err := os.ReadFile(filepath.Join(s.OutputDir, variantName(0), "playlist.m3u8"))
+	return err == nil && strings.Contains(string(variant), "#EXTINF")
+}
+
+// AddBytesWritten records bytes served to a client that does not read the
+// stream through a cursor (e.g. HLS segments served from disk).
+func (s *FFmpegSession) AddBytesWritten(clientID string, n int64) {
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	if client, exists := s.clients[clientID]; exists {
+		client.addServed(n)
+		return
+	}
+
+	s.bytesMux.Lock()
+	s.bytesWritten += uint64(n)
+	s.bytesMux.Unlock()
 }
 
 // RemoveClient removes client from FFmpeg session
@@ -174,22 +380,24 @@ func (s *FFmpegSession) RemoveClient(clientID string) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
-	if _, exists := s.clients[clientID]; exists {
+	if client, exists := s.clients[clientID]; exists {
 		delete(s.clients, clientID)
-		
-		s.pipeWriter.readersMux.Lock()
-		if ch, ok := s.pipeWriter.readers[clientID]; ok {
-			close(ch)
-			delete(s.pipeWriter.readers, clientID)
-		}
-		s.pipeWriter.readersMux.Unlock()
-		
+		s.retireClient(client)
 		log.Printf("👋 Client disconnected from FFmpeg stream %s: %s (remaining: %d)", s.ID, clientID, len(s.clients))
 	}
 
 	s.lastActivity = time.Now()
 }
 
+// retireClient disconnects a client and keeps its bytes in the session
+// total, callers hold clientsMux
+func (s *FFmpegSession) retireClient(client *StreamClient) {
+	client.Close()
+	s.bytesMux.Lock()
+	s.bytesWritten += client.BytesSent()
+	s.bytesMux.Unlock()
+}
+
 // GetClientCount returns number of connected clients
 func (s *FFmpegSession) GetClientCount() int {
 	s.clientsMux.RLock()
@@ -197,6 +405,13 @@ func (s *FFmpegSession) GetClientCount() int {
 	return len(s.clients)
 }
 
+// GetLastActivity returns when a client last connected, polled or left
+func (s *FFmpegSession) GetLastActivity() time.Time {
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	return s.lastActivity
+}
+
 // IsActive checks if FFmpeg is running
 func (s *FFmpegSession) IsActive() bool {
 	s.activeMux.RLock()
@@ -206,6 +421,10 @@ func (s *FFmpegSession) IsActive() bool {
 
 // Start starts FFmpeg streaming
 func (s *FFmpegSession) Start() {
+	if isShuttingDown() {
+		return
+	}
+
 	s.activeMux.Lock()
 	if s.isActive {
 		s.activeMux.Unlock()
@@ -217,126 +436,179 @@ func (s *FFmpegSession) Start() {
 
 	log.Printf("▶️  Starting FFmpeg stream: %s", s.ID)
 
-	// Try each source until one works
-	for _, url := range s.SourceURLs {
-		if s.startFFmpeg(url) {
-			return
-		}
-		log.Printf("⚠️  FFmpeg failed for source: %s, trying next...", url)
+	if !s.launch() {
+		log.Printf("❌ All sources failed for FFmpeg stream: %s", s.ID)
+		s.setInactive()
 	}
+}
 
-	log.Printf("❌ All sources failed for FFmpeg stream: %s", s.ID)
+// setInactive marks the session as not running
+func (s *FFmpegSession) setInactive() {
 	s.activeMux.Lock()
 	s.isActive = false
 	s.activeMux.Unlock()
 }
 
-// startFFmpeg starts FFmpeg process
-func (s *FFmpegSession) startFFmpeg(sourceURL string) bool {
-	// Build FFmpeg command optimized for multiple concurrent streams
-	args := []string{
-		"-threads", "1",               // Limit to 1 thread per stream
-		"-reconnect", "1",             // Enable auto reconnect
-		"-reconnect_streamed", "1",    // Reconnect for streamed protocols
-		"-reconnect_delay_max", "5",   // Max 5 seconds between reconnects
-		"-timeout", "10000000",        // 10 second timeout (in microseconds)
-		"-fflags", "+genpts+discardcorrupt", // Generate PTS + discard corrupt packets
-		"-flags", "low_delay",         // Low delay flag
-		"-analyzeduration", "5000000", // 5 seconds analysis (detect all streams)
-		"-probesize", "5000000",       // 5MB probe (ensure video detected)
-		"-i", sourceURL,               // Input URL
-		"-map", "0:v?",                // Map video stream (optional, won't fail if missing)
-		"-map", "0:a?",                // Map audio stream (optional, won't fail if missing)
-		"-c", "copy",                  // Copy codec (no transcoding)
-		"-f", "mpegts",                // Output format MPEG-TS
-		"-avoid_negative_ts", "make_zero", // Avoid timestamp issues
-		"-max_muxing_queue_size", "9999", // Large muxing queue for stability
-		"-bsf:v", "h264_mp4toannexb,dump_extra", // H264 conversion + dump extra data (SPS/PPS)
-		"-async", "1",                 // Audio sync method (resample)
-		"-vsync", "cfr",               // Video sync constant frame rate
-		"-start_at_zero",              // Start timestamps at zero
-		"-copytb", "1",                // Copy input timebase
-		"pipe:1",                      // Output to stdout
-	}
-
-	// If HLS output is requested
-	if s.OutputFormat == "hls" {
-		args = []string{
-			"-threads", "1",
-			"-reconnect", "1",
-			"-reconnect_streamed", "1",
-			"-reconnect_delay_max", "5",
-			"-timeout", "10000000",
-			"-fflags", "+genpts+discardcorrupt",
-			"-flags", "low_delay",
-			"-analyzeduration", "5000000", // 5 seconds analysis
-			"-probesize", "5000000",       // 5MB probe
-			"-i", sourceURL,
-			"-map", "0:v?",                // Map video (optional)
-			"-map", "0:a?",                // Map audio (optional)
-			"-c", "copy",
-			"-f", "mpegts",
-			"-avoid_negative_ts", "make_zero",
-			"-max_muxing_queue_size", "9999",
-			"-bsf:v", "h264_mp4toannexb,dump_extra",
-			"-async", "1",
-			"-vsync", "cfr",
-			"-start_at_zero",
-			"-copytb", "1",
-			"pipe:1",
+// launch starts FFmpeg on the current source, failing over to the next
+// healthy source when the process cannot be started
+func (s *FFmpegSession) launch() bool {
+	s.procMux.Lock()
+	s.switching = false
+	s.procMux.Unlock()
+
+	for i := 0; i < s.sources.Len(); i++ {
+		idx, url := s.sources.Current()
+		if s.startFFmpeg(idx, url) {
+			return true
+		}
+		s.sources.ReportFailure(idx, 0, "failed to start FFmpeg")
+		if _, _, ok := s.sources.Next(); !ok {
+			break
+		}
+		log.Printf("⚠️  FFmpeg failed for source: %s, trying next...", url)
+	}
+	return false
+}
+
+// inputArgs returns the FFmpeg input options shared by encoding sessions
+func inputArgs(sourceURL string) []string {
+	return []string{
+		"-threads", "1",
+		"-reconnect", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", "5",
+		"-timeout", "10000000",
+		"-fflags", "+genpts+discardcorrupt",
+		"-flags", "low_delay",
+		"-analyzeduration", "5000000", // 5 seconds analysis
+		"-probesize", "5000000",       // 5MB probe
+		"-i", sourceURL,
+	}
+}
+
+// buildArgs returns the FFmpeg arguments for the session format and profile
+func (s *FFmpegSession) buildArgs(sourceURL string) []string {
+	if s.IsABR() {
+		return s.abrArgs(sourceURL)
+	}
+	if s.OutputFormat != "hls" && s.Profile.IsPassthrough() {
+		// Build FFmpeg command optimized for multiple concurrent streams
+		return []string{
+			"-threads", "1",               // Limit to 1 thread per stream
+			"-reconnect", "1",             // Enable auto reconnect
+			"-reconnect_streamed", "1",    // Reconnect for streamed protocols
+			"-reconnect_delay_max", "5",   // Max 5 seconds between reconnects
+			"-timeout", "10000000",        // 10 second timeout (in microseconds)
+			"-fflags", "+genpts+discardcorrupt", // Generate PTS + discard corrupt packets
+			"-flags", "low_delay",         // Low delay flag
+			"-analyzeduration", "5000000", // 5 seconds analysis (detect all streams)
+			"-probesize", "5000000",       // 5MB probe (ensure video detected)
+			"-i", sourceURL,               // Input URL
+			"-map", "0:v?",                // Map video stream (optional, won't fail if missing)
+			"-map", "0:a?",                // Map audio stream (optional, won't fail if missing)
+			"-c", "copy",                  // Copy codec (no transcoding)
+			"-f", "mpegts",                // Output format MPEG-TS
+			"-avoid_negative_ts", "make_zero", // Avoid timestamp issues
+			"-max_muxing_queue_size", "9999", // Large muxing queue for stability
+			"-bsf:v", "h264_mp4toannexb,dump_extra", // H264 conversion + dump extra data (SPS/PPS)
+			"-async", "1",                 // Audio sync method (resample)
+			"-vsync", "cfr",               // Video sync constant frame rate
+			"-start_at_zero",              // Start timestamps at zero
+			"-copytb", "1",                // Copy input timebase
+			"pipe:1",                      // Output to stdout
 		}
 	}
 
-	s.cmd = exec.CommandContext(s.ctx, "ffmpeg", args...)
-	
-	stdout, err := s.cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("❌ Failed to create stdout pipe: %v", err)
-		return false
+	args := inputArgs(sourceURL)
+	if s.Profile.IsPassthrough() {
+		args = append(args, "-map", "0:v?", "-map", "0:a?") // Map video and audio (optional)
+	} else {
+		args = append(args, "-map", "0:v:0?", "-map", "0:a:0?") // Encode the main video and audio only
+	}
+	args = append(args, s.Profile.CodecArgs()...)
+	args = append(args,
+		"-avoid_negative_ts", "make_zero",
+		"-max_muxing_queue_size", "9999",
+	)
+
+	if s.OutputFormat != "hls" {
+		return append(args, "-f", "mpegts", "pipe:1")
+	}
+
+	// Let FFmpeg write a real playlist and numbered segments into the
+	// session directory instead of stdout.
+	return append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentSeconds()),
+		"-hls_list_size", strconv.Itoa(hlsPlaylistSize),
+		"-hls_flags", "delete_segments+independent_segments+omit_endlist",
+		"-hls_start_number_source", "epoch", // Keep media sequence increasing across restarts
+		"-hls_segment_type", "mpegts",
+		"-hls_segment_filename", filepath.Join(s.OutputDir, "segment_%d.ts"),
+		s.GetPlaylistPath(),
+	)
+}
+
+// startFFmpeg starts FFmpeg process for source idx
+func (s *FFmpegSession) startFFmpeg(idx int, sourceURL string) bool {
+	args := append(append([]string{}, progressArgs...), s.buildArgs(sourceURL)...)
+	cmd := exec.CommandContext(s.ctx, ffmpegBinary(), args...)
+
+	// HLS sessions write to disk, only MPEG-TS sessions are piped to clients
+	var stdout io.ReadCloser
+	if s.OutputFormat != "hls" {
+		pipe, err := cmd.StdoutPipe()
+		if err != nil {
+			log.Printf("❌ Failed to create stdout pipe: %v", err)
+			return false
+		}
+		stdout = pipe
 	}
 
-	stderr, err := s.cmd.StderrPipe()
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		log.Printf("❌ Failed to create stderr pipe: %v", err)
 		return false
 	}
 
-	if err := s.cmd.Start(); err != nil {
+	if err := GetSupervisor().Start(s.ID, cmd); err != nil {
 		log.Printf("❌ Failed to start FFmpeg: %v", err)
 		return false
 	}
 
+	s.procMux.Lock()
+	s.cmd = cmd
+	s.lastError = ""
+	s.procMux.Unlock()
+	s.progress.start(sourceURL)
+	started := time.Now()
+
 	log.Printf("✅ FFmpeg started for source: %s", sourceURL)
 
-	// Read FFmpeg stderr in background (for logging errors)
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				errMsg := string(buf[:n])
-				// Log FFmpeg errors/warnings
-				if strings.Contains(errMsg, "error") || strings.Contains(errMsg, "Error") || 
-				   strings.Contains(errMsg, "Invalid") || strings.Contains(errMsg, "failed") {
-					log.Printf("🔴 FFmpeg error for %s: %s", s.ID, errMsg)
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+	// A source that keeps running for a while is healthy again
+	stable := time.AfterFunc(stableRunTime, func() { s.sources.ReportRunning(idx) })
+	if idx != 0 {
+		s.watchPrimary()
+	}
+
+	// A new process starts a new stream, cached data from the old one is stale
+	s.pipeWriter.ResetGOP()
+	s.inspector.reset()
+	s.timeshift.discontinue()
+
+	// Parse FFmpeg stderr in background (progress telemetry and errors)
+	go s.readStderr(stderr)
 
 	// Read FFmpeg stdout and broadcast to all clients (non-blocking)
 	go func() {
-		defer func() {
-			s.activeMux.Lock()
-			s.isActive = false
-			s.activeMux.Unlock()
-			log.Printf("⏹️  FFmpeg reader stopped: %s", s.ID)
-		}()
+		if stdout == nil {
+			return
+		}
+		// The wait goroutine below owns isActive, so clients joining while
+		// a replacement process starts don't launch a second one.
+		defer log.Printf("⏹️  FFmpeg reader stopped: %s", s.ID)
 
-		buffer := make([]byte, 8192) // 8KB buffer - smaller chunks for better distribution
+		buffer := make([]byte, ffmpegReadSize) // 8KB buffer - smaller chunks for better distribution
 		for {
 			select {
 			case <-s.ctx.Done():
@@ -359,131 +631,191 @@ func (s *FFmpegSession) startFFmpeg(sourceURL string) bool {
 					// Track bytes read from source
 					s.bytesMux.Lock()
 					s.bytesRead += uint64(n)
+					s.lastRead = time.Now()
 					s.bytesMux.Unlock()
 
-					// Write to buffer
-					s.pipeWriter.buffer.Write(data)
-
-					// Broadcast to all clients (blocking with large buffer prevents drops)
-					s.pipeWriter.readersMux.RLock()
-					clientCount := len(s.pipeWriter.readers)
-					for _, ch := range s.pipeWriter.readers {
-						// Blocking send - buffer is large enough (2000)
-						// If buffer fills up, client is too slow and will experience lag
-						select {
-						case ch <- data:
-							// Sent successfully
-						default:
-							// Buffer full - skip this packet for this client
-							// With 2000 buffer, this should be rare
-						}
-					}
-					s.pipeWriter.readersMux.RUnlock()
-
-					// Track bytes written to clients (n * client_count)
-					s.bytesMux.Lock()
-					s.bytesWritten += uint64(n * clientCount)
-					s.bytesMux.Unlock()
+					// Stored once, each client reads it through its own cursor
+					s.pipeWriter.Publish(data)
+					s.inspector.Write(data)
+					s.timeshift.write(data)
 				}
 			}
 		}
 	}()
 
-	// Wait for FFmpeg to finish and handle restart
+	// Wait for FFmpeg to finish and handle restart or failover. Clients stay
+	// attached to the pipe while the replacement process starts.
 	go func() {
-		s.cmd.Wait()
-		
-		// Check if we still have clients
-		s.clientsMux.RLock()
-		hasClients := len(s.clients) > 0
-		s.clientsMux.RUnlock()
-		
-		// If we should keep the stream running (clients exist OR on-demand disabled)
-		// and context not cancelled, try to restart.
+		GetSupervisor().Wait(cmd)
+		stable.Stop()
+		runDuration := time.Since(started)
+
 		select {
 		case <-s.ctx.Done():
 			// Context cancelled, normal shutdown
 			log.Printf("⏹️  FFmpeg stopped (shutdown): %s", s.ID)
+			s.setInactive()
+			return
 		default:
-			keepRunning := hasClients || !s.IsOnDemand()
-			if keepRunning {
-				// Check if stream is running for less than 30 seconds (likely offline/bad stream)
-				runDuration := time.Since(s.startTime)
-				
-				// Increment retry count if failed quickly (< 30 seconds)
-				if runDuration < 30*time.Second {
-					s.retryCount++
-					s.lastFailTime = time.Now()
-					log.Printf("⚠️  FFmpeg died after %v for %s (retry: %d/2)", runDuration, s.ID, s.retryCount)
-				} else {
-					// Reset retry count if stream ran successfully for > 30 seconds
-					s.retryCount = 0
-				}
-				
-				// Blacklist if failed 2 times in a row
-				if s.retryCount >= 2 {
-					s.isBlacklisted = true
-					log.Printf("🚫 Channel %s blacklisted after %d consecutive failures. Source likely offline.", s.ID, s.retryCount)
-					
-					// Disconnect all clients
-					s.clientsMux.Lock()
-					for clientID := range s.clients {
-						delete(s.clients, clientID)
-					}
-					s.clientsMux.Unlock()
-					
-					return
-				}
-				
-				time.Sleep(2 * time.Second)
-				
-				// Mark as inactive and try to restart
-				s.activeMux.Lock()
-				s.isActive = false
-				s.activeMux.Unlock()
-				
-				// Restart if should keep running and not blacklisted.
-				s.clientsMux.RLock()
-				stillHasClients := len(s.clients) > 0
-				s.clientsMux.RUnlock()
-				if (stillHasClients || !s.IsOnDemand()) && !s.isBlacklisted {
-					log.Printf("🔄 Auto-restarting FFmpeg: %s (attempt %d/5)", s.ID, s.retryCount+1)
-					go s.Start()
-				}
-			} else {
-				log.Printf("⏹️  FFmpeg stopped (no clients): %s", s.ID)
+		}
+
+		// Deliberate switch back to a recovered primary
+		if s.takeSwitch() {
+			_, url := s.sources.Current()
+			log.Printf("🔀 Switching FFmpeg stream %s to source: %s", s.ID, url)
+			if !s.launch() {
+				s.setInactive()
 			}
+			return
+		}
+
+		// Keep the stream running while clients exist or on-demand is disabled
+		if !s.shouldRun() {
+			log.Printf("⏹️  FFmpeg stopped (no clients): %s", s.ID)
+			s.setInactive()
+			return
+		}
+
+		s.procMux.Lock()
+		reason := s.lastError
+		s.procMux.Unlock()
+		if reason == "" {
+			reason = fmt.Sprintf("FFmpeg exited after %v", runDuration.Round(time.Second))
+		}
+		s.sources.ReportFailure(idx, runDuration, reason)
+		log.Printf("⚠️  FFmpeg died after %v for %s (source: %s)", runDuration.Round(time.Second), s.ID, sourceURL)
+
+		next, nextURL, ok := s.sources.Next()
+		if !ok {
+			// Every source failed twice in a row: blacklist until a probe succeeds
+			s.blacklist(fmt.Sprintf("all %d sources failed", s.sources.Len()), reason)
+
+			// Disconnect all clients
+			s.clientsMux.Lock()
+			for clientID, client := range s.clients {
+				delete(s.clients, clientID)
+				s.retireClient(client)
+			}
+			s.clientsMux.Unlock()
+
+			s.setInactive()
+			return
+		}
+
+		if next == idx {
+			time.Sleep(2 * time.Second)
+			log.Printf("🔄 Auto-restarting FFmpeg: %s", s.ID)
+		} else {
+			log.Printf("🔀 Failing over FFmpeg stream %s to source: %s", s.ID, nextURL)
+		}
+
+		// Clients may have left, or the session stopped, while we waited
+		if s.ctx.Err() != nil || !s.shouldRun() || !s.launch() {
+			s.setInactive()
 		}
-		
-		s.activeMux.Lock()
-		s.isActive = false
-		s.activeMux.Unlock()
 	}()
 
 	return true
 }
 
+// shouldRun reports whether the process should be kept running
+func (s *FFmpegSession) shouldRun() bool {
+	return s.GetClientCount() > 0 || !s.IsOnDemand()
+}
+
+// takeSwitch reports and clears a pending deliberate source switch
+func (s *FFmpegSession) takeSwitch() bool {
+	s.procMux.Lock()
+	defer s.procMux.Unlock()
+	switching := s.switching
+	s.switching = false
+	return switching
+}
+
+// watchPrimary probes the primary source while a backup is in use and
+// switches back once it has answered twice in a row
+func (s *FFmpegSession) watchPrimary() {
+	s.procMux.Lock()
+	if s.watchingPrimary {
+		s.procMux.Unlock()
+		return
+	}
+	s.watchingPrimary = true
+	s.procMux.Unlock()
+
+	go func() {
+		defer func() {
+			s.procMux.Lock()
+			s.watchingPrimary = false
+			s.procMux.Unlock()
+		}()
+
+		ticker := time.NewTicker(primaryProbeInterval)
+		defer ticker.Stop()
+
+		successes := 0
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if idx, _ := s.sources.Current(); idx == 0 || s.IsBlacklisted() {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(s.ctx, sourceProbeTimeout)
+			err := ProbeSource(ctx, s.sources.URL(0))
+			cancel()
+			s.sources.ReportProbe(0, err)
+			if err != nil {
+				successes = 0
+				continue
+			}
+			if successes++; successes < 2 {
+				continue
+			}
+
+			log.Printf("💚 Primary source recovered for %s, switching back", s.ID)
+			s.switchSource(0)
+			return
+		}
+	}()
+}
+
+// switchSource moves a running session to source idx. The current process
+// is stopped and the wait goroutine starts the new one.
+func (s *FFmpegSession) switchSource(idx int) {
+	s.procMux.Lock()
+	s.sources.Use(idx)
+	s.switching = true
+	cmd := s.cmd
+	s.procMux.Unlock()
+
+	GetSupervisor().Kill(cmd)
+}
+
 // Stop stops FFmpeg session
 func (s *FFmpegSession) Stop() {
 	log.Printf("🛑 Stopping FFmpeg stream: %s", s.ID)
 	
 	s.cancel()
 	
-	if s.cmd != nil && s.cmd.Process != nil {
-		s.cmd.Process.Kill()
-	}
+	s.procMux.Lock()
+	cmd := s.cmd
+	s.procMux.Unlock()
+	GetSupervisor().Kill(cmd)
 
-	// Close all client channels
-	s.pipeWriter.readersMux.Lock()
-	for _, ch := range s.pipeWriter.readers {
-		close(ch)
-	}
-	s.pipeWriter.readers = make(map[string]chan []byte)
-	s.pipeWriter.readersMux.Unlock()
+	// Ends Next for every client
+	s.pipeWriter.Close()
 
 	s.clientsMux.Lock()
 	s.clients = make(map[string]*StreamClient)
 	s.clientsMux.Unlock()
+
+	if s.OutputDir != "" {
+		os.RemoveAll(s.OutputDir)
+	}
 }
 
 // monitorSessions monitors and stops idle sessions
@@ -494,13 +826,16 @@ func (m *FFmpegManager) monitorSessions() {
 	for range ticker.C {
 		m.sessionsMux.Lock()
 		for streamID, session := range m.sessions {
+			if session.OutputFormat == "hls" {
+				session.pruneHLSClients(hlsClientTimeout)
+			}
 			if session.GetClientCount() == 0 {
 				// If on-demand is disabled, keep the FFmpeg session running.
 				if !session.IsOnDemand() {
 					continue
 				}
-				idleTime := time.Since(session.lastActivity)
-				if idleTime > m.idleTimeout {
+				idleTime := time.Since(session.GetLastActivity())
+				if idleTime > currentConfig().IdleTimeout {
 					log.Printf("⏰ FFmpeg session idle for %v, stopping: %s", idleTime, streamID)
 					session.Stop()
 					delete(m.sessions, streamID)
@@ -517,8 +852,15 @@ func (s *FFmpegSession) GetStats() map[string]interface{} {
 	bytesRead := s.bytesRead
 	bytesWritten := s.bytesWritten
 	s.bytesMux.RUnlock()
+	s.clientsMux.RLock()
+	for _, client := range s.clients {
+		bytesWritten += client.BytesSent()
+	}
+	clientDetails, droppedBytes, droppedChunks := clientStats(s.clients)
+	s.clientsMux.RUnlock()
 
 	uptime := time.Since(s.startTime).Seconds()
+	_, currentSource := s.sources.Current()
 	now := time.Now()
 	
 	// Add current sample to history
@@ -554,18 +896,36 @@ func (s *FFmpegSession) GetStats() map[string]interface{} {
 		}
 	}
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"id":              s.ID,
+		"engine":          DeliveryFFmpeg,
 		"active":          s.IsActive(),
 		"clients":         s.GetClientCount(),
 		"output_format":   s.OutputFormat,
 		"uptime_seconds":  uptime,
-		"last_activity":   s.lastActivity,
+		"last_activity":   s.GetLastActivity(),
 		"bytes_read":      bytesRead,
 		"bytes_written":   bytesWritten,
 		"download_mbps":   downloadMbps,
 		"upload_mbps":     uploadMbps,
+		"dropped_bytes":   droppedBytes,
+		"dropped_chunks":  droppedChunks,
+		"slow_client_policy": s.GetSlowClientPolicy().Mode,
+		"on_demand":       s.IsOnDemand(),
+		"priority":        s.GetPriority(),
+		"clients_detail":  clientDetails,
+		"blacklisted":     s.IsBlacklisted(),
+		"profile":         s.Profile.Name,
+		"ladder":          s.ladderNames(),
+		"progress":        s.progress.snapshot(),
+		"current_source":  currentSource,
+		"sources":         s.sources.GetStats(),
 	}
+	// HLS output goes to segment files, not through the inspector
+	if s.OutputFormat != "hls" {
+		stats["inspection"] = s.Inspection()
+	}
+	return stats
 }
 
 // GetAllSessions returns all FFmpeg sessions
@@ -586,3 +946,18 @@ func (m *FFmpegManager) GetSession(streamID string) *FFmpegSession {
 	defer m.sessionsMux.RUnlock()
 	return m.sessions[streamID]
 }
+
+// GetSessionVariants returns the session for streamID and every profile
+// session encoded from it
+func (m *FFmpegManager) GetSessionVariants(streamID string) []*FFmpegSession {
+	m.sessionsMux.RLock()
+	defer m.sessionsMux.RUnlock()
+
+	var sessions []*FFmpegSession
+	for id, session := range m.sessions {
+		if id == streamID || strings.HasPrefix(id, streamID+"@") {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
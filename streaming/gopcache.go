@@ -0,0 +1,64 @@
+package streaming
+
+import (
+	"iptv-panel/mpegts"
+)
+
+// GOPCache keeps the latest PAT/PMT and the MPEG-TS data since the last
+// keyframe, so a client joining a running stream can start decoding at once.
+// It is not safe for concurrent use; callers serialize Write and Snapshot.
+type GOPCache struct {
+	reader  mpegts.Reader
+	gop     []byte // Complete packets since the last keyframe
+	valid   bool   // gop starts at a keyframe
+	maxSize int
+}
+
+// NewGOPCache creates a GOP cache holding at most maxSize bytes
+func NewGOPCache(maxSize int) *GOPCache {
+	return &GOPCache{maxSize: maxSize}
+}
+
+// Write feeds stream data into the cache
+func (c *GOPCache) Write(data []byte) {
+	c.reader.Feed(data, func(pkt []byte, info mpegts.PacketInfo) {
+		if info.Keyframe {
+			c.gop = c.gop[:0]
+			c.valid = true
+		}
+		if !c.valid {
+			return
+		}
+		if len(c.gop)+len(pkt) > c.maxSize {
+			// GOP too long to cache, wait for the next keyframe
+			c.gop = c.gop[:0]
+			c.valid = false
+			return
+		}
+		c.gop = append(c.gop, pkt...)
+	})
+}
+
+// Snapshot returns PAT + PMT + the current GOP, followed by the bytes of
+// the trailing partial packet so that live data written after the snapshot
+// continues it seamlessly. Returns nil until a keyframe has been seen.
+func (c *GOPCache) Snapshot() []byte {
+	if !c.valid || len(c.gop) == 0 {
+		return nil
+	}
+
+	pat, pmt, partial := c.reader.PAT(), c.reader.PMT(), c.reader.Partial()
+	snapshot := make([]byte, 0, len(pat)+len(pmt)+len(c.gop)+len(partial))
+	snapshot = append(snapshot, pat...)
+	snapshot = append(snapshot, pmt...)
+	snapshot = append(snapshot, c.gop...)
+	snapshot = append(snapshot, partial...)
+	return snapshot
+}
+
+// Reset drops all cached data, e.g. when the source process restarts
+func (c *GOPCache) Reset() {
+	c.reader = mpegts.Reader{}
+	c.gop = c.gop[:0]
+	c.valid = false
+}
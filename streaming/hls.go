@@ -1,14 +1,17 @@
 package streaming
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"iptv-panel/mpegts"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,16 +23,29 @@ type HLSSession struct {
 	OutputDir     string
 	ctx           context.Context
 	cancel        context.CancelFunc
-	clients       map[string]time.Time
+	clients       map[string]*StreamClient
 	clientsMux    sync.RWMutex
 	isActive      bool
 	activeMux     sync.RWMutex
 	lastActivity  time.Time
 	segmentIndex  int
 	playlistFile  string
-	segments      []string
+	segments      []hlsSegment
+	segmentsMux   sync.Mutex // Guards segmentIndex and the playlist window
 	maxSegments   int
 	segmentDur    time.Duration
+	discontinuity bool // Next segment follows a source reconnect
+	discSequence  int  // EXT-X-DISCONTINUITY-SEQUENCE of the playlist window
+	bytesRead     int64
+	bytesWritten  int64
+	bytesMux      sync.Mutex
+}
+
+// hlsSegment is a segment listed in the sliding-window playlist
+type hlsSegment struct {
+	name          string
+	duration      time.Duration
+	discontinuity bool
 }
 
 // HLSManager manages all HLS sessions
@@ -37,24 +53,43 @@ type HLSManager struct {
 	sessions    map[string]*HLSSession
 	sessionsMux sync.RWMutex
 	baseDir     string
-	idleTimeout time.Duration
 }
 
+const (
+	hlsCacheDir       = "./hls_cache"
+	hlsSegmentSeconds = 4
+	hlsPlaylistSize   = 6
+	hlsClientTimeout  = 30 * time.Second
+)
+
+// hlsSegmentPattern matches segment names written into session directories
+var hlsSegmentPattern = regexp.MustCompile(`^segment_[0-9]+\.ts$`)
+
 var (
 	hlsManager     *HLSManager
 	hlsManagerOnce sync.Once
 )
 
+// sanitizeSessionDir turns a session ID (which may contain a relay path)
+// into a safe single directory name.
+func sanitizeSessionDir(id string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
 // GetHLSManager returns the global HLS manager instance
 func GetHLSManager() *HLSManager {
 	hlsManagerOnce.Do(func() {
-		baseDir := "./hls_cache"
+		baseDir := hlsCacheDir
 		os.MkdirAll(baseDir, 0755)
 
 		hlsManager = &HLSManager{
-			sessions:    make(map[string]*HLSSession),
-			baseDir:     baseDir,
-			idleTimeout: 60 * time.Second,
+			sessions: make(map[string]*HLSSession),
+			baseDir:  baseDir,
 		}
 		go hlsManager.monitorSessions()
 		go hlsManager.cleanupOldFiles()
@@ -74,7 +109,8 @@ func (m *HLSManager) GetOrCreateHLSSession(streamID string, sourceURLs []string)
 
 	// Create new HLS session
 	ctx, cancel := context.WithCancel(context.Background())
-	outputDir := filepath.Join(m.baseDir, streamID)
+	outputDir := filepath.Join(m.baseDir, sanitizeSessionDir(streamID))
+	os.RemoveAll(outputDir) // Drop stale segments from a previous session
 	os.MkdirAll(outputDir, 0755)
 
 	session := &HLSSession{
@@ -83,11 +119,11 @@ func (m *HLSManager) GetOrCreateHLSSession(streamID string, sourceURLs []string)
 		OutputDir:    outputDir,
 		ctx:          ctx,
 		cancel:       cancel,
-		clients:      make(map[string]time.Time),
+		clients:      make(map[string]*StreamClient),
 		lastActivity: time.Now(),
 		playlistFile: filepath.Join(outputDir, "playlist.m3u8"),
-		maxSegments:  6,
-		segmentDur:   4 * time.Second,
+		maxSegments:  hlsPlaylistSize,
+		segmentDur:   currentConfig().HLSSegmentDuration,
 	}
 
 	m.sessions[streamID] = session
@@ -106,23 +142,25 @@ func (s *HLSSession) UpdateActivity() {
 	s.lastActivity = time.Now()
 }
 
-// AddClient adds a client to the HLS session
-func (s *HLSSession) AddClient(clientID string) {
-	s.clientsMux.Lock()
-	defer s.clientsMux.Unlock()
-	s.clients[clientID] = time.Now()
-	s.lastActivity = time.Now()
-	log.Printf("👤 HLS client added to %s: %s (total: %d)", s.ID, clientID, len(s.clients))
-}
-
 // RemoveClient removes a client from the HLS session
 func (s *HLSSession) RemoveClient(clientID string) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
-	delete(s.clients, clientID)
+	if client, exists := s.clients[clientID]; exists {
+		delete(s.clients, clientID)
+		s.retireClient(client)
+	}
 	log.Printf("👋 HLS client removed from %s: %s (remaining: %d)", s.ID, clientID, len(s.clients))
 }
 
+// retireClient keeps the bytes served to a client in the session total,
+// callers hold clientsMux
+func (s *HLSSession) retireClient(client *StreamClient) {
+	s.bytesMux.Lock()
+	s.bytesWritten += int64(client.BytesSent())
+	s.bytesMux.Unlock()
+}
+
 // GetClientCount returns the number of active clients
 func (s *HLSSession) GetClientCount() int {
 	s.clientsMux.RLock()
@@ -130,6 +168,39 @@ func (s *HLSSession) GetClientCount() int {
 	return len(s.clients)
 }
 
+// TouchClient registers or refreshes a polling HLS client and restarts
+// the source if it has dropped.
+func (s *HLSSession) TouchClient(clientID, remoteAddr, userAgent string) error {
+	select {
+	case <-s.ctx.Done():
+		return fmt.Errorf("session stopped")
+	default:
+	}
+
+	s.clientsMux.Lock()
+	client, exists := s.clients[clientID]
+	if !exists {
+		client = newHLSClient(clientID, remoteAddr, userAgent)
+		s.clients[clientID] = client
+		log.Printf("👤 HLS client added to %s: %s (%s)", s.ID, clientID, remoteAddr)
+	}
+	client.LastSeen = time.Now()
+	s.lastActivity = time.Now()
+	s.clientsMux.Unlock()
+
+	if !s.IsActive() {
+		go s.Start()
+	}
+	return nil
+}
+
+// IsActive checks if the source is currently being segmented
+func (s *HLSSession) IsActive() bool {
+	s.activeMux.RLock()
+	defer s.activeMux.RUnlock()
+	return s.isActive
+}
+
 // Start starts the HLS stream processing
 func (s *HLSSession) Start() {
 	s.activeMux.Lock()
@@ -140,6 +211,12 @@ func (s *HLSSession) Start() {
 	s.isActive = true
 	s.activeMux.Unlock()
 
+	defer func() {
+		s.activeMux.Lock()
+		s.isActive = false
+		s.activeMux.Unlock()
+	}()
+
 	log.Printf("▶️  Starting HLS stream: %s", s.ID)
 
 	// Connect to source
@@ -147,35 +224,36 @@ func (s *HLSSession) Start() {
 	var err error
 
 	for _, url := range s.SourceURLs {
-		sourceResp, err = http.Get(url)
+		req, reqErr := http.NewRequestWithContext(s.ctx, "GET", url, nil)
+		if reqErr != nil {
+			continue
+		}
+		sourceResp, err = http.DefaultClient.Do(req)
 		if err == nil && sourceResp.StatusCode == http.StatusOK {
 			log.Printf("✅ HLS connected to source: %s", url)
 			break
 		}
 		if sourceResp != nil {
 			sourceResp.Body.Close()
+			sourceResp = nil
 		}
 	}
 
-	if sourceResp == nil || sourceResp.StatusCode != http.StatusOK {
+	if sourceResp == nil {
 		log.Printf("❌ All sources failed for HLS stream: %s", s.ID)
-		s.activeMux.Lock()
-		s.isActive = false
-		s.activeMux.Unlock()
 		return
 	}
 
 	defer sourceResp.Body.Close()
 
-	// Create segments from stream
-	go s.createSegments(sourceResp.Body)
+	// Create segments from stream (blocks until the source ends)
+	s.createSegments(sourceResp.Body)
 }
 
-// createSegments creates HLS segments from the stream
+// createSegments cuts the MPEG-TS stream into keyframe-aligned segments
 func (s *HLSSession) createSegments(reader io.Reader) {
-	buffer := new(bytes.Buffer)
-	segmentBuffer := make([]byte, 188*7) // MPEG-TS packet size
-	lastSegmentTime := time.Now()
+	segmenter := mpegts.NewSegmenter(s.segmentDur, s.writeSegment)
+	buffer := make([]byte, mpegts.PacketSize*64)
 
 	for {
 		select {
@@ -183,21 +261,20 @@ func (s *HLSSession) createSegments(reader io.Reader) {
 			log.Printf("⏹️  HLS stream stopped: %s", s.ID)
 			return
 		default:
-			n, err := reader.Read(segmentBuffer)
+			n, err := reader.Read(buffer)
 			if n > 0 {
-				buffer.Write(segmentBuffer[:n])
-
-				// Create segment every segmentDur seconds or when buffer is large enough
-				if time.Since(lastSegmentTime) >= s.segmentDur || buffer.Len() > 1024*1024 {
-					s.writeSegment(buffer.Bytes())
-					buffer.Reset()
-					lastSegmentTime = time.Now()
-				}
+				s.bytesMux.Lock()
+				s.bytesRead += int64(n)
+				s.bytesMux.Unlock()
+				segmenter.Write(buffer[:n])
 			}
 			if err != nil {
 				if err != io.EOF {
 					log.Printf("⚠️  HLS stream read error: %s - %v", s.ID, err)
 				}
+				// Keep the partial segment, the next one follows a reconnect
+				segmenter.Flush()
+				s.discontinuity = true
 				return
 			}
 		}
@@ -205,8 +282,8 @@ func (s *HLSSession) createSegments(reader io.Reader) {
 }
 
 // writeSegment writes a segment to disk and updates playlist
-func (s *HLSSession) writeSegment(data []byte) {
-	if len(data) == 0 {
+func (s *HLSSession) writeSegment(seg mpegts.Segment) {
+	if len(seg.Data) == 0 {
 		return
 	}
 
@@ -214,18 +291,34 @@ func (s *HLSSession) writeSegment(data []byte) {
 	segmentPath := filepath.Join(s.OutputDir, segmentName)
 
 	// Write segment file
-	if err := os.WriteFile(segmentPath, data, 0644); err != nil {
+	if err := os.WriteFile(segmentPath, seg.Data, 0644); err != nil {
 		log.Printf("❌ Failed to write segment: %v", err)
 		return
 	}
 
+	duration := seg.Duration
+	if duration <= 0 {
+		duration = s.segmentDur
+	}
+
+	s.segmentsMux.Lock()
+	defer s.segmentsMux.Unlock()
+
 	// Update segment list
-	s.segments = append(s.segments, segmentName)
+	s.segments = append(s.segments, hlsSegment{
+		name:          segmentName,
+		duration:      duration,
+		discontinuity: s.discontinuity && s.segmentIndex > 0,
+	})
+	s.discontinuity = false
 	if len(s.segments) > s.maxSegments {
 		// Remove old segment
 		oldSegment := s.segments[0]
 		s.segments = s.segments[1:]
-		os.Remove(filepath.Join(s.OutputDir, oldSegment))
+		if oldSegment.discontinuity {
+			s.discSequence++
+		}
+		os.Remove(filepath.Join(s.OutputDir, oldSegment.name))
 	}
 
 	s.segmentIndex++
@@ -234,23 +327,114 @@ func (s *HLSSession) writeSegment(data []byte) {
 	s.updatePlaylist()
 }
 
-// updatePlaylist updates the HLS playlist file
+// updatePlaylist updates the HLS playlist file; callers hold segmentsMux
 func (s *HLSSession) updatePlaylist() {
-	playlist := "#EXTM3U\n"
-	playlist += "#EXT-X-VERSION:3\n"
-	playlist += fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(s.segmentDur.Seconds()))
-	playlist += fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", s.segmentIndex-len(s.segments))
+	// Target duration must be >= every EXTINF rounded to the nearest second
+	target := int(s.segmentDur.Seconds())
+	for _, segment := range s.segments {
+		if d := int(math.Round(segment.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", target))
+	b.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", s.segmentIndex-len(s.segments)))
+	if s.discSequence > 0 {
+		b.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.discSequence))
+	}
 
 	for _, segment := range s.segments {
-		playlist += fmt.Sprintf("#EXTINF:%.3f,\n", s.segmentDur.Seconds())
-		playlist += segment + "\n"
+		if segment.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		b.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", segment.duration.Seconds()))
+		b.WriteString(segment.name + "\n")
 	}
 
-	if err := os.WriteFile(s.playlistFile, []byte(playlist), 0644); err != nil {
+	// Write to a temp file and rename so readers never see a partial playlist
+	tmpFile := s.playlistFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(b.String()), 0644); err != nil {
+		log.Printf("❌ Failed to write playlist: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFile, s.playlistFile); err != nil {
 		log.Printf("❌ Failed to write playlist: %v", err)
 	}
 }
 
+// WaitForPlaylist waits until the playlist lists at least one segment and
+// returns its contents.
+func (s *HLSSession) WaitForPlaylist(timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if data, err := os.ReadFile(s.playlistFile); err == nil && strings.Contains(string(data), "#EXTINF") {
+			return data, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("playlist not ready")
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil, fmt.Errorf("session stopped")
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// GetSegmentPath returns the on-disk path of a segment of this session
+func (s *HLSSession) GetSegmentPath(name string) (string, error) {
+	if !hlsSegmentPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid segment name")
+	}
+	return filepath.Join(s.OutputDir, name), nil
+}
+
+// AddBytesWritten records bytes of segments served to a client
+func (s *HLSSession) AddBytesWritten(clientID string, n int64) {
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	if client, exists := s.clients[clientID]; exists {
+		client.addServed(n)
+		return
+	}
+
+	s.bytesMux.Lock()
+	s.bytesWritten += n
+	s.bytesMux.Unlock()
+}
+
+// GetStats returns session statistics
+func (s *HLSSession) GetStats() map[string]interface{} {
+	s.bytesMux.Lock()
+	bytesRead, bytesWritten := uint64(s.bytesRead), uint64(s.bytesWritten)
+	s.bytesMux.Unlock()
+	s.clientsMux.RLock()
+	for _, client := range s.clients {
+		bytesWritten += client.BytesSent()
+	}
+	clientDetails, _, _ := clientStats(s.clients)
+	s.clientsMux.RUnlock()
+	s.segmentsMux.Lock()
+	segments := s.segmentIndex
+	s.segmentsMux.Unlock()
+
+	return map[string]interface{}{
+		"id":             s.ID,
+		"engine":         DeliveryPassthrough,
+		"active":         s.IsActive(),
+		"clients":        s.GetClientCount(),
+		"output_format":  "hls",
+		"segments":       segments,
+		"bytes_read":     bytesRead,
+		"bytes_written":  bytesWritten,
+		"clients_detail": clientDetails,
+	}
+}
+
 // Stop stops the HLS session
 func (s *HLSSession) Stop() {
 	log.Printf("🛑 Stopping HLS stream: %s", s.ID)
@@ -270,17 +454,18 @@ func (m *HLSManager) monitorSessions() {
 		for streamID, session := range m.sessions {
 			// Clean up old clients
 			session.clientsMux.Lock()
-			for clientID, lastSeen := range session.clients {
-				if time.Since(lastSeen) > 30*time.Second {
+			for clientID, client := range session.clients {
+				if time.Since(client.LastSeen) > hlsClientTimeout {
 					delete(session.clients, clientID)
+					session.retireClient(client)
 				}
 			}
 			clientCount := len(session.clients)
 			session.clientsMux.Unlock()
 
 			// Stop idle sessions
-			if clientCount == 0 && time.Since(session.lastActivity) > m.idleTimeout {
-				log.Printf("⏰ HLS session idle for %v, stopping: %s", m.idleTimeout, streamID)
+			if idleTimeout := currentConfig().IdleTimeout; clientCount == 0 && time.Since(session.lastActivity) > idleTimeout {
+				log.Printf("⏰ HLS session idle for %v, stopping: %s", idleTimeout, streamID)
 				session.Stop()
 				delete(m.sessions, streamID)
 			}
@@ -299,13 +484,17 @@ func (m *HLSManager) cleanupOldFiles() {
 		m.sessionsMux.RLock()
 		activeDirs := make(map[string]bool)
 		for streamID := range m.sessions {
-			activeDirs[streamID] = true
+			activeDirs[sanitizeSessionDir(streamID)] = true
 		}
 		m.sessionsMux.RUnlock()
 
 		// Clean up inactive directories
 		dirs, _ := os.ReadDir(m.baseDir)
 		for _, dir := range dirs {
+			// FFmpeg HLS sessions remove their own directories on stop
+			if dir.Name() == "ffmpeg" {
+				continue
+			}
 			if dir.IsDir() && !activeDirs[dir.Name()] {
 				dirPath := filepath.Join(m.baseDir, dir.Name())
 				if info, err := os.Stat(dirPath); err == nil {
@@ -328,3 +517,22 @@ func (s *HLSSession) GetPlaylistPath() string {
 func (s *HLSSession) GetOutputDir() string {
 	return s.OutputDir
 }
+
+// GetSession returns a specific HLS session by ID
+func (m *HLSManager) GetSession(streamID string) *HLSSession {
+	m.sessionsMux.RLock()
+	defer m.sessionsMux.RUnlock()
+	return m.sessions[streamID]
+}
+
+// GetAllSessions returns all HLS sessions
+func (m *HLSManager) GetAllSessions() []*HLSSession {
+	m.sessionsMux.RLock()
+	defer m.sessionsMux.RUnlock()
+
+	sessions := make([]*HLSSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
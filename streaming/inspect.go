@@ -0,0 +1,82 @@
+package streaming
+
+import (
+	"iptv-panel/mpegts"
+	"sync"
+	"time"
+)
+
+// metadataReportInterval is how often a running channel reports its
+// metadata again after the first report
+const metadataReportInterval = 5 * time.Minute
+
+// MetadataReporter receives what stream inspection learned about a channel
+type MetadataReporter func(channelID int, info mpegts.StreamInfo)
+
+var (
+	metadataReporter MetadataReporter
+	reporterMux      sync.RWMutex
+)
+
+// SetMetadataReporter sets the function that stores channel metadata found
+// by inspecting the streams of channel sessions
+func SetMetadataReporter(fn MetadataReporter) {
+	reporterMux.Lock()
+	metadataReporter = fn
+	reporterMux.Unlock()
+}
+
+// streamInspector inspects the MPEG-TS flowing through a session and
+// reports it as metadata of the session's channel
+type streamInspector struct {
+	*mpegts.Inspector
+	mux        sync.Mutex // Guards channelID, reported and lastReport
+	channelID  int        // 0 = session is not the plain stream of a channel
+	reported   bool
+	lastReport time.Time
+}
+
+func newStreamInspector() *streamInspector {
+	return &streamInspector{Inspector: mpegts.NewInspector()}
+}
+
+// setChannel makes the inspector report metadata for channelID
+func (i *streamInspector) setChannel(channelID int) {
+	i.mux.Lock()
+	i.channelID = channelID
+	i.mux.Unlock()
+}
+
+// Write inspects data and reports the metadata once the stream parameters
+// are known, then every metadataReportInterval
+func (i *streamInspector) Write(data []byte) {
+	i.Inspector.Write(data)
+
+	i.mux.Lock()
+	if i.channelID == 0 || (i.reported && time.Since(i.lastReport) < metadataReportInterval) {
+		i.mux.Unlock()
+		return
+	}
+	channelID := i.channelID
+	i.mux.Unlock()
+
+	reporterMux.RLock()
+	report := metadataReporter
+	reporterMux.RUnlock()
+	if report == nil || !i.Ready() {
+		return
+	}
+
+	i.mux.Lock()
+	i.reported, i.lastReport = true, time.Now()
+	i.mux.Unlock()
+	go report(channelID, i.Snapshot())
+}
+
+// reset starts inspecting a new stream, e.g. after a source switch
+func (i *streamInspector) reset() {
+	i.Inspector.Reset()
+	i.mux.Lock()
+	i.reported = false
+	i.mux.Unlock()
+}
@@ -2,44 +2,217 @@ package streaming
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"iptv-panel/mpegts"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Delivery modes of a channel, chosen per channel by the handlers
+const (
+	DeliveryFFmpeg      = "ffmpeg-remux"   // Remux (or transcode) through a shared FFmpeg
+	DeliveryPassthrough = "go-passthrough" // Pull the source over HTTP and fan it out in Go
+	DeliveryRedirect    = "redirect"       // Redirect the player to the source URL
+)
+
+// ValidDeliveryMode reports whether mode is a known delivery mode
+func ValidDeliveryMode(mode string) bool {
+	return mode == DeliveryFFmpeg || mode == DeliveryPassthrough || mode == DeliveryRedirect
+}
+
 // StreamSession represents an active stream session
 type StreamSession struct {
 	ID            string
-	SourceURL     string
 	SourceURLs    []string // For failover
 	ctx           context.Context
 	cancel        context.CancelFunc
 	clients       map[string]*StreamClient
 	clientsMux    sync.RWMutex
-	buffer        *RingBuffer
+	pipe          *StreamPipe
 	isActive      bool
 	activeMux     sync.RWMutex
 	lastActivity  time.Time
 	startTime     time.Time
-	bytesStreamed int64
+	bytesStreamed uint64 // Bytes read from the source
+	bytesWritten  uint64 // Bytes delivered to clients that have left
+	lastRead      time.Time // When the source last sent data
+	bytesMux      sync.Mutex
+	slowPolicy    SlowClientPolicy
+	onDemand      bool
+	policyMux     sync.RWMutex // Guards slowPolicy and onDemand
+	sources       *SourcePool  // Health of SourceURLs, picks the one in use
+	connMux       sync.Mutex   // Guards cancelConn, switching, watchingPrimary and lastError
+	cancelConn    context.CancelFunc // Closes the current source connection
+	switching     bool         // Connection was closed to change source
+	watchingPrimary bool       // Primary probe goroutine is running
+	lastError     string       // Why the last source connection ended
+	bandwidth     bandwidthWindow
+	inspector     *streamInspector // Codecs and quality of the stream
+	timeshift     timeshiftTap     // Archive recording the stream, if any
 }
 
-// StreamClient represents a connected client
+// StreamClient represents a connected client. ID identifies one playback
+// (see Viewer), so players sharing an address and user agent stay apart.
 type StreamClient struct {
 	ID         string
 	Connected  time.Time
 	RemoteAddr string
-	Writer     io.Writer
-	Done       chan bool
+	UserAgent  string
+	LastSeen   time.Time // Last request time, used for polling (HLS) clients
+	cursor     *BufferCursor // Read position in the session pipe, nil for HLS clients
+	pipe       *StreamPipe
+	streamID   string
+	policy     SlowClientPolicy
+	resyncs    int32 // Accessed atomically
+	served     uint64 // Bytes of HLS segments served, accessed atomically
+}
+
+// newHLSClient creates a client that polls playlists and segments
+func newHLSClient(clientID, remoteAddr, userAgent string) *StreamClient {
+	return &StreamClient{
+		ID:         clientID,
+		Connected:  time.Now(),
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+		LastSeen:   time.Now(),
+	}
+}
+
+// newPipeClient creates a client reading pipe under the given slow client policy
+func newPipeClient(streamID, clientID, remoteAddr, userAgent string, pipe *StreamPipe, policy SlowClientPolicy) *StreamClient {
+	maxLag := policy.MaxLagBytes
+	if maxLag == 0 {
+		maxLag = ^uint64(0) // Only react once the ring has wrapped
+	}
+	cursor := pipe.NewCursor()
+	cursor.SetMaxLag(maxLag)
+	return &StreamClient{
+		ID:         clientID,
+		Connected:  time.Now(),
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+		cursor:     cursor,
+		pipe:       pipe,
+		streamID:   streamID,
+		policy:     policy,
+	}
+}
+
+// Next returns the next chunk of stream data for this client, blocking
+// until data is available. The slice is shared and must not be modified.
+// A client that falls behind is handled by its slow client policy.
+func (c *StreamClient) Next(ctx context.Context) ([]byte, error) {
+	if c.cursor == nil {
+		return nil, ErrClientClosed
+	}
+
+	for {
+		data, err := c.cursor.Next(ctx)
+		if err != ErrReaderLagging {
+			return data, err
+		}
+
+		lag := c.cursor.Lag()
+		resyncs := atomic.AddInt32(&c.resyncs, 1)
+		if c.policy.Mode == SlowClientBacklog ||
+			(c.policy.Mode == SlowClientDisconnect && c.policy.MaxDrops > 0 && int(resyncs) >= c.policy.MaxDrops) {
+			log.Printf("🐢 Slow client disconnected from %s: %s (%d KB behind, %d drops)", c.streamID, c.ID, lag/1024, resyncs)
+			c.cursor.Close()
+			return nil, ErrClientTooSlow
+		}
+
+		// Resume on the current GOP so the viewer's decoder never sees a gap mid-GOP
+		c.pipe.SkipToLive(c.cursor)
+		log.Printf("🐢 Slow client on %s skipped %d KB to keyframe: %s (drops: %d)", c.streamID, lag/1024, c.ID, resyncs)
+	}
+}
+
+// Close disconnects the client, a pending Next returns ErrClientClosed
+func (c *StreamClient) Close() {
+	if c.cursor != nil {
+		c.cursor.Close()
+	}
+}
+
+// BytesSent returns the number of bytes delivered to this client
+func (c *StreamClient) BytesSent() uint64 {
+	if c.cursor == nil {
+		return atomic.LoadUint64(&c.served)
+	}
+	return c.cursor.BytesRead()
+}
+
+// addServed records bytes of a segment served to an HLS client
+func (c *StreamClient) addServed(n int64) {
+	atomic.AddUint64(&c.served, uint64(n))
+}
+
+// GetStats returns delivery statistics for this client. Clients that are
+// viewers of a user report the user and their user_connections row.
+func (c *StreamClient) GetStats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"id":          c.ID,
+		"remote_addr": c.RemoteAddr,
+		"user_agent":  c.UserAgent,
+		"connected":   c.Connected,
+		"bytes_sent":  c.BytesSent(),
+	}
+	if viewer := GetViewerRegistry().Get(c.ID); viewer != nil {
+		stats["user_id"] = viewer.UserID
+		stats["connection_id"] = viewer.ConnectionID
+	}
+	if c.cursor != nil {
+		droppedBytes, droppedChunks := c.cursor.Dropped()
+		stats["lag_bytes"] = c.cursor.Lag()
+		stats["dropped_bytes"] = droppedBytes
+		stats["dropped_chunks"] = droppedChunks
+		stats["drops"] = atomic.LoadInt32(&c.resyncs)
+	} else {
+		stats["last_seen"] = c.LastSeen
+	}
+	return stats
+}
+
+// clientStats collects per-client statistics and dropped data totals
+func clientStats(clients map[string]*StreamClient) ([]map[string]interface{}, uint64, uint64) {
+	details := make([]map[string]interface{}, 0, len(clients))
+	var droppedBytes, droppedChunks uint64
+	for _, client := range clients {
+		details = append(details, client.GetStats())
+		if client.cursor != nil {
+			b, c := client.cursor.Dropped()
+			droppedBytes += b
+			droppedChunks += c
+		}
+	}
+	return details, droppedBytes, droppedChunks
+}
+
+const (
+	// sessionReadSize is the largest chunk read from a source
+	sessionReadSize = 32 * 1024
+	// sourceStallTimeout is how long a source may send nothing before its
+	// connection is treated as failed
+	sourceStallTimeout = 15 * time.Second
+)
+
+// passthroughClient pulls sources for passthrough sessions. The response
+// header must arrive in time; the body is read for as long as the stream runs.
+var passthroughClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: sourceProbeTimeout,
+	},
 }
 
 // StreamManager manages all active streams
 type StreamManager struct {
 	sessions    map[string]*StreamSession
 	sessionsMux sync.RWMutex
-	idleTimeout time.Duration
 }
 
 var (
@@ -51,8 +224,7 @@ var (
 func GetManager() *StreamManager {
 	managerOnce.Do(func() {
 		globalManager = &StreamManager{
-			sessions:    make(map[string]*StreamSession),
-			idleTimeout: 30 * time.Second, // Stop stream after 30s idle
+			sessions: make(map[string]*StreamSession),
 		}
 		go globalManager.monitorSessions()
 	})
@@ -74,10 +246,14 @@ func (m *StreamManager) GetOrCreateSession(streamID string, sourceURLs []string)
 	session := &StreamSession{
 		ID:           streamID,
 		SourceURLs:   sourceURLs,
+		sources:      NewSourcePool(sourceURLs),
 		ctx:          ctx,
 		cancel:       cancel,
 		clients:      make(map[string]*StreamClient),
-		buffer:       NewRingBuffer(2 * 1024 * 1024), // 2MB buffer
+		pipe:         NewStreamPipe(bufferSlots(sessionReadSize)),
+		slowPolicy:   DefaultSlowClientPolicy,
+		onDemand:     true,
+		inspector:    newStreamInspector(),
 		lastActivity: time.Now(),
 		startTime:    time.Now(),
 	}
@@ -88,17 +264,19 @@ func (m *StreamManager) GetOrCreateSession(streamID string, sourceURLs []string)
 	return session
 }
 
-// AddClient adds a client to the stream session
-func (s *StreamSession) AddClient(clientID, remoteAddr string, writer io.Writer) *StreamClient {
+// AddClient adds a client to the stream session. The client reads the
+// stream with Next, starting at the current GOP.
+func (s *StreamSession) AddClient(clientID, remoteAddr, userAgent string) (*StreamClient, error) {
+	if isShuttingDown() {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
-	client := &StreamClient{
-		ID:         clientID,
-		Connected:  time.Now(),
-		RemoteAddr: remoteAddr,
-		Writer:     writer,
-		Done:       make(chan bool, 1),
+	client := newPipeClient(s.ID, clientID, remoteAddr, userAgent, s.pipe, s.GetSlowClientPolicy())
+	if old, exists := s.clients[clientID]; exists {
+		s.retireClient(old)
 	}
 
 	s.clients[clientID] = client
@@ -111,7 +289,51 @@ func (s *StreamSession) AddClient(clientID, remoteAddr string, writer io.Writer)
 		go s.Start()
 	}
 
-	return client
+	return client, nil
+}
+
+// SetSlowClientPolicy sets the policy for clients joining afterwards
+func (s *StreamSession) SetSlowClientPolicy(policy SlowClientPolicy) {
+	s.policyMux.Lock()
+	s.slowPolicy = policy
+	s.policyMux.Unlock()
+}
+
+// GetSlowClientPolicy returns the current slow client policy
+func (s *StreamSession) GetSlowClientPolicy() SlowClientPolicy {
+	s.policyMux.RLock()
+	defer s.policyMux.RUnlock()
+	return s.slowPolicy
+}
+
+// SetOnDemand configures whether this session should auto-stop when idle
+func (s *StreamSession) SetOnDemand(onDemand bool) {
+	s.policyMux.Lock()
+	s.onDemand = onDemand
+	s.policyMux.Unlock()
+}
+
+// IsOnDemand returns current on-demand mode
+func (s *StreamSession) IsOnDemand() bool {
+	s.policyMux.RLock()
+	defer s.policyMux.RUnlock()
+	return s.onDemand
+}
+
+// SetChannelID marks the session as the stream of a channel, whose metadata
+// is then reported from stream inspection
+func (s *StreamSession) SetChannelID(channelID int) {
+	s.inspector.setChannel(channelID)
+}
+
+// SetTimeshift records the stream into archive, nil stops recording
+func (s *StreamSession) SetTimeshift(archive *TimeshiftArchive) {
+	s.timeshift.set(archive)
+}
+
+// Inspection returns what inspecting the stream has found so far
+func (s *StreamSession) Inspection() mpegts.StreamInfo {
+	return s.inspector.Snapshot()
 }
 
 // RemoveClient removes a client from the stream session
@@ -119,14 +341,24 @@ func (s *StreamSession) RemoveClient(clientID string) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
-	if _, exists := s.clients[clientID]; exists {
+	if client, exists := s.clients[clientID]; exists {
 		delete(s.clients, clientID)
+		s.retireClient(client)
 		log.Printf("👋 Client disconnected from stream %s: %s (remaining: %d)", s.ID, clientID, len(s.clients))
 	}
 
 	s.lastActivity = time.Now()
 }
 
+// retireClient disconnects a client and keeps its bytes in the session
+// total, callers hold clientsMux
+func (s *StreamSession) retireClient(client *StreamClient) {
+	client.Close()
+	s.bytesMux.Lock()
+	s.bytesWritten += client.BytesSent()
+	s.bytesMux.Unlock()
+}
+
 // GetClientCount returns the number of active clients
 func (s *StreamSession) GetClientCount() int {
 	s.clientsMux.RLock()
@@ -141,93 +373,242 @@ func (s *StreamSession) IsActive() bool {
 	return s.isActive
 }
 
-// Start starts the stream from source
+// setInactive marks the session as not running
+func (s *StreamSession) setInactive() {
+	s.activeMux.Lock()
+	s.isActive = false
+	s.activeMux.Unlock()
+}
+
+// shouldRun reports whether the source should stay connected
+func (s *StreamSession) shouldRun() bool {
+	return s.GetClientCount() > 0 || !s.IsOnDemand()
+}
+
+// Start pulls the stream from the current source, reconnecting and failing
+// over to the next healthy source like an FFmpeg session does
 func (s *StreamSession) Start() {
+	if isShuttingDown() {
+		return
+	}
+
 	s.activeMux.Lock()
 	if s.isActive {
 		s.activeMux.Unlock()
 		return
 	}
 	s.isActive = true
+	s.startTime = time.Now()
 	s.activeMux.Unlock()
+	defer s.setInactive()
 
 	log.Printf("▶️  Starting stream: %s", s.ID)
 
-	// Try each source URL until one works
-	var sourceResp *http.Response
-	var sourceURL string
-	var err error
-
-	for _, url := range s.SourceURLs {
-		sourceResp, err = http.Get(url)
-		if err == nil && sourceResp.StatusCode == http.StatusOK {
-			sourceURL = url
-			log.Printf("✅ Connected to source: %s", url)
-			break
+	for {
+		idx, url := s.sources.Current()
+		started := time.Now()
+		err := s.pull(idx, url)
+		runDuration := time.Since(started)
+
+		if s.ctx.Err() != nil {
+			log.Printf("⏹️  Stream stopped: %s", s.ID)
+			return
 		}
-		if sourceResp != nil {
-			sourceResp.Body.Close()
+
+		// Deliberate switch back to a recovered primary
+		if s.takeSwitch() {
+			_, url := s.sources.Current()
+			log.Printf("🔀 Switching stream %s to source: %s", s.ID, url)
+			continue
 		}
-		log.Printf("❌ Failed to connect to source: %s - %v", url, err)
+
+		if !s.shouldRun() {
+			log.Printf("⏹️  Stream stopped (no clients): %s", s.ID)
+			return
+		}
+
+		s.connMux.Lock()
+		s.lastError = err.Error()
+		s.connMux.Unlock()
+		s.sources.ReportFailure(idx, runDuration, err.Error())
+		log.Printf("⚠️  Stream %s lost source after %v: %s - %v", s.ID, runDuration.Round(time.Second), url, err)
+
+		next, nextURL, ok := s.sources.Next()
+		if !ok {
+			log.Printf("❌ All sources failed for stream: %s", s.ID)
+			s.disconnectClients()
+			// The next client starts over from the primary
+			s.sources.Reset()
+			return
+		}
+
+		if next == idx {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+			log.Printf("🔄 Reconnecting stream: %s", s.ID)
+		} else {
+			log.Printf("🔀 Failing over stream %s to source: %s", s.ID, nextURL)
+		}
+	}
+}
+
+// pull connects to source idx and publishes its data until the connection
+// ends, then returns why it ended
+func (s *StreamSession) pull(idx int, sourceURL string) error {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	s.connMux.Lock()
+	s.cancelConn = cancel
+	s.connMux.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := passthroughClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("source returned HTTP %d", resp.StatusCode)
+	}
+	log.Printf("✅ Connected to source: %s", sourceURL)
 
-	if sourceResp == nil || sourceResp.StatusCode != http.StatusOK {
-		log.Printf("❌ All sources failed for stream: %s", s.ID)
-		s.activeMux.Lock()
-		s.isActive = false
-		s.activeMux.Unlock()
-		return
+	// A source that keeps running for a while is healthy again
+	stable := time.AfterFunc(stableRunTime, func() { s.sources.ReportRunning(idx) })
+	defer stable.Stop()
+	if idx != 0 {
+		s.watchPrimary()
 	}
 
-	defer sourceResp.Body.Close()
-	s.SourceURL = sourceURL
+	// A source that stays silent is dropped like one that disconnects
+	var stalled int32
+	stall := time.AfterFunc(sourceStallTimeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer stall.Stop()
+
+	// A new connection starts a new stream, cached data from the old one is stale
+	s.pipe.ResetGOP()
+	s.inspector.reset()
+	s.timeshift.discontinue()
 
-	// Read from source and broadcast to all clients
-	buffer := make([]byte, 32*1024)
+	buffer := make([]byte, sessionReadSize)
 	for {
-		select {
-		case <-s.ctx.Done():
-			log.Printf("⏹️  Stream stopped: %s", s.ID)
-			s.activeMux.Lock()
-			s.isActive = false
-			s.activeMux.Unlock()
-			return
-		default:
-			n, err := sourceResp.Body.Read(buffer)
-			if n > 0 {
-				s.bytesStreamed += int64(n)
-				data := make([]byte, n)
-				copy(data, buffer[:n])
-
-				// Write to ring buffer
-				s.buffer.Write(data)
-
-				// Broadcast to all clients
-				s.broadcastToClients(data)
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			stall.Reset(sourceStallTimeout)
+			s.bytesMux.Lock()
+			s.bytesStreamed += uint64(n)
+			s.lastRead = time.Now()
+			s.bytesMux.Unlock()
+
+			data := make([]byte, n)
+			copy(data, buffer[:n])
+
+			// Clients read the shared chunk through their own cursor
+			s.pipe.Publish(data)
+			s.inspector.Write(data)
+			s.timeshift.write(data)
+		}
+		if err != nil {
+			if atomic.LoadInt32(&stalled) == 1 {
+				return fmt.Errorf("source sent no data for %v", sourceStallTimeout)
 			}
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("⚠️  Stream read error: %s - %v", s.ID, err)
-				}
-				s.activeMux.Lock()
-				s.isActive = false
-				s.activeMux.Unlock()
-				return
+			if err == io.EOF {
+				return fmt.Errorf("source closed the stream")
 			}
+			return err
 		}
 	}
 }
 
-// broadcastToClients sends data to all connected clients
-func (s *StreamSession) broadcastToClients(data []byte) {
-	s.clientsMux.RLock()
-	defer s.clientsMux.RUnlock()
+// disconnectClients disconnects every client, e.g. when no source works
+func (s *StreamSession) disconnectClients() {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
 
 	for clientID, client := range s.clients {
-		if _, err := client.Writer.Write(data); err != nil {
-			log.Printf("⚠️  Error writing to client %s: %v", clientID, err)
-			// Client will be removed on next check
+		delete(s.clients, clientID)
+		s.retireClient(client)
+	}
+}
+
+// takeSwitch reports and clears a pending deliberate source switch
+func (s *StreamSession) takeSwitch() bool {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	switching := s.switching
+	s.switching = false
+	return switching
+}
+
+// watchPrimary probes the primary source while a backup is in use and
+// switches back once it has answered twice in a row
+func (s *StreamSession) watchPrimary() {
+	s.connMux.Lock()
+	if s.watchingPrimary {
+		s.connMux.Unlock()
+		return
+	}
+	s.watchingPrimary = true
+	s.connMux.Unlock()
+
+	go func() {
+		defer func() {
+			s.connMux.Lock()
+			s.watchingPrimary = false
+			s.connMux.Unlock()
+		}()
+
+		ticker := time.NewTicker(primaryProbeInterval)
+		defer ticker.Stop()
+
+		successes := 0
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if idx, _ := s.sources.Current(); idx == 0 || !s.IsActive() {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(s.ctx, sourceProbeTimeout)
+			err := ProbeSource(ctx, s.sources.URL(0))
+			cancel()
+			s.sources.ReportProbe(0, err)
+			if err != nil {
+				successes = 0
+				continue
+			}
+			if successes++; successes < 2 {
+				continue
+			}
+
+			log.Printf("💚 Primary source recovered for %s, switching back", s.ID)
+			s.switchSource(0)
+			return
 		}
+	}()
+}
+
+// switchSource moves a running session to source idx. The current
+// connection is closed and Start opens the new one.
+func (s *StreamSession) switchSource(idx int) {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+
+	s.sources.Use(idx)
+	s.switching = true
+	if s.cancelConn != nil {
+		s.cancelConn()
 	}
 }
 
@@ -236,15 +617,11 @@ func (s *StreamSession) Stop() {
 	log.Printf("🛑 Stopping stream: %s", s.ID)
 	s.cancel()
 
-	// Close all clients
+	// Ends Next for every client
+	s.pipe.Close()
+
 	s.clientsMux.Lock()
-	for clientID, client := range s.clients {
-		select {
-		case client.Done <- true:
-		default:
-		}
-		delete(s.clients, clientID)
-	}
+	s.clients = make(map[string]*StreamClient)
 	s.clientsMux.Unlock()
 }
 
@@ -256,10 +633,10 @@ func (m *StreamManager) monitorSessions() {
 	for range ticker.C {
 		m.sessionsMux.Lock()
 		for streamID, session := range m.sessions {
-			// Check if idle
-			if session.GetClientCount() == 0 {
+			// Check if idle, unless the channel is kept running
+			if session.GetClientCount() == 0 && session.IsOnDemand() {
 				idleTime := time.Since(session.lastActivity)
-				if idleTime > m.idleTimeout {
+				if idleTime > currentConfig().IdleTimeout {
 					log.Printf("⏰ Stream idle for %v, stopping: %s", idleTime, streamID)
 					session.Stop()
 					delete(m.sessions, streamID)
@@ -272,15 +649,81 @@ func (m *StreamManager) monitorSessions() {
 
 // GetSessionStats returns statistics for a session
 func (s *StreamSession) GetStats() map[string]interface{} {
+	s.bytesMux.Lock()
+	bytesRead := s.bytesStreamed
+	bytesWritten := s.bytesWritten
+	s.bytesMux.Unlock()
+	s.clientsMux.RLock()
+	for _, client := range s.clients {
+		bytesWritten += client.BytesSent()
+	}
+	clientDetails, droppedBytes, droppedChunks := clientStats(s.clients)
+	s.clientsMux.RUnlock()
+
+	downloadMbps, uploadMbps := s.bandwidth.sample(bytesRead, bytesWritten)
+	_, currentSource := s.sources.Current()
+	s.connMux.Lock()
+	lastError := s.lastError
+	s.connMux.Unlock()
+
 	return map[string]interface{}{
 		"id":              s.ID,
+		"engine":          DeliveryPassthrough,
 		"active":          s.IsActive(),
 		"clients":         s.GetClientCount(),
-		"source_url":      s.SourceURL,
+		"source_url":      currentSource,
 		"uptime_seconds":  time.Since(s.startTime).Seconds(),
-		"bytes_streamed":  s.bytesStreamed,
 		"last_activity":   s.lastActivity,
+		"bytes_streamed":  bytesRead,
+		"bytes_read":      bytesRead,
+		"bytes_written":   bytesWritten,
+		"download_mbps":   downloadMbps,
+		"upload_mbps":     uploadMbps,
+		"dropped_bytes":   droppedBytes,
+		"dropped_chunks":  droppedChunks,
+		"slow_client_policy": s.GetSlowClientPolicy().Mode,
+		"on_demand":       s.IsOnDemand(),
+		"clients_detail":  clientDetails,
+		"last_error":      lastError,
+		"current_source":  currentSource,
+		"sources":         s.sources.GetStats(),
+		"inspection":      s.Inspection(),
+	}
+}
+
+// bandwidthWindow turns byte counters sampled on every stats request into
+// rates averaged over the last samples (~30 seconds at a 3s poll interval)
+type bandwidthWindow struct {
+	mux     sync.Mutex
+	read    []uint64
+	written []uint64
+	times   []time.Time
+}
+
+// sample records the current counters and returns the average download and
+// upload rate in Mbps (megabits/sec)
+func (b *bandwidthWindow) sample(read, written uint64) (float64, float64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.read = append(b.read, read)
+	b.written = append(b.written, written)
+	b.times = append(b.times, time.Now())
+	if len(b.times) > 10 {
+		b.read, b.written, b.times = b.read[1:], b.written[1:], b.times[1:]
+	}
+	if len(b.times) < 2 {
+		return 0, 0
+	}
+
+	last := len(b.times) - 1
+	seconds := b.times[last].Sub(b.times[0]).Seconds()
+	if seconds <= 0 {
+		return 0, 0
 	}
+	download := float64(b.read[last]-b.read[0]) * 8 / seconds / 1024 / 1024
+	upload := float64(b.written[last]-b.written[0]) * 8 / seconds / 1024 / 1024
+	return download, upload
 }
 
 // GetAllSessions returns all active sessions
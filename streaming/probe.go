@@ -0,0 +1,60 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// ProbeSource checks whether a source is reachable and delivering data.
+// HTTP sources are fetched directly, anything else is opened with ffprobe.
+func ProbeSource(ctx context.Context, sourceURL string) error {
+	if strings.HasPrefix(sourceURL, "http://") || strings.HasPrefix(sourceURL, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("source returned HTTP %d", resp.StatusCode)
+		}
+		buf := make([]byte, 188)
+		if _, err := io.ReadAtLeast(resp.Body, buf, 1); err != nil {
+			return fmt.Errorf("source sent no data: %v", err)
+		}
+		return nil
+	}
+
+	_, err := ProbeFormat(ctx, sourceURL)
+	return err
+}
+
+// ProbeFormat opens a source with ffprobe and returns its container format,
+// e.g. "mpegts" or "hls"
+func ProbeFormat(ctx context.Context, sourceURL string) (string, error) {
+	var out, errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobeBinary(), "-v", "error", "-show_entries", "format=format_name", "-of", "default=nw=1:nk=1", sourceURL)
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err := GetSupervisor().Start("probe", cmd)
+	if err == nil {
+		err = GetSupervisor().Wait(cmd)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(errOut.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("ffprobe failed: %s", msg)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package streaming
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSetWords sizes the affinity mask for up to 1024 CPUs
+const cpuSetWords = 1024 / 64
+
+// setAffinity pins a process to the given CPUs
+func setAffinity(pid int, cpus []int) error {
+	var mask [cpuSetWords]uint64
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= cpuSetWords*64 {
+			return fmt.Errorf("cpu %d out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setMemoryLimit caps the address space (RLIMIT_AS) of a running process
+func setMemoryLimit(pid int, bytes uint64) error {
+	limit := syscall.Rlimit{Cur: bytes, Max: bytes}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), syscall.RLIMIT_AS, uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// processRSS returns the resident memory of a process in KB
+func processRSS(pid int) int64 {
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "VmRSS:") {
+			value := strings.TrimSpace(strings.TrimPrefix(line, "VmRSS:"))
+			kb, _ := strconv.ParseInt(strings.TrimSuffix(value, " kB"), 10, 64)
+			return kb
+		}
+	}
+	return 0
+}
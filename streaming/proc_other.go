@@ -0,0 +1,23 @@
+//go:build !linux
+
+package streaming
+
+import "errors"
+
+// errNoLinux is returned for process limits only Linux supports
+var errNoLinux = errors.New("only supported on linux")
+
+// setAffinity is only supported on Linux
+func setAffinity(pid int, cpus []int) error {
+	return errNoLinux
+}
+
+// setMemoryLimit is only supported on Linux
+func setMemoryLimit(pid int, bytes uint64) error {
+	return errNoLinux
+}
+
+// processRSS is only reported on Linux
+func processRSS(pid int) int64 {
+	return 0
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package streaming
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errUnsupported is returned for process controls Windows does not have
+var errUnsupported = errors.New("not supported on windows")
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills a started command. Windows has no process groups
+// to signal, children of FFmpeg are not tracked.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
+
+// killGroup is not supported on Windows
+func killGroup(pgid int) error {
+	return errUnsupported
+}
+
+// processCommand cannot verify leftover processes on Windows, so they
+// are never killed
+func processCommand(pid int) string {
+	return ""
+}
+
+// setNice is not supported on Windows
+func setNice(pid, nice int) error {
+	return errUnsupported
+}
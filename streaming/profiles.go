@@ -0,0 +1,203 @@
+package streaming
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// PassthroughProfile is the name of the profile that copies the source as is
+const PassthroughProfile = "passthrough"
+
+// TranscodeProfile describes how FFmpeg encodes a stream for clients
+type TranscodeProfile struct {
+	Name          string `json:"name"`
+	VideoCodec    string `json:"video_codec"`    // "copy" or an encoder such as libx264
+	Width         int    `json:"width"`          // 0 keeps the aspect ratio from Height
+	Height        int    `json:"height"`         // 0 keeps the source size
+	VideoBitrate  int    `json:"video_bitrate"`  // kbps
+	MaxRate       int    `json:"max_rate"`       // kbps, 0 = VideoBitrate
+	GOP           int    `json:"gop"`            // Frames between keyframes
+	Preset        string `json:"preset"`         // Encoder speed preset
+	H264Profile   string `json:"h264_profile"`   // baseline, main or high
+	AudioCodec    string `json:"audio_codec"`    // "copy" or an encoder such as aac
+	AudioBitrate  int    `json:"audio_bitrate"`  // kbps
+	AudioChannels int    `json:"audio_channels"` // 0 keeps the source layout
+	Builtin       bool   `json:"builtin"`
+}
+
+// BuiltinProfiles are always available and cannot be changed
+var BuiltinProfiles = map[string]TranscodeProfile{
+	PassthroughProfile: {
+		Name:       PassthroughProfile,
+		VideoCodec: "copy",
+		AudioCodec: "copy",
+		Builtin:    true,
+	},
+	"720p-2500k": {
+		Name:          "720p-2500k",
+		VideoCodec:    "libx264",
+		Height:        720,
+		VideoBitrate:  2500,
+		GOP:           50,
+		Preset:        "veryfast",
+		H264Profile:   "main",
+		AudioCodec:    "aac",
+		AudioBitrate:  128,
+		AudioChannels: 2,
+		Builtin:       true,
+	},
+	"480p-mobile": {
+		Name:          "480p-mobile",
+		VideoCodec:    "libx264",
+		Height:        480,
+		VideoBitrate:  800,
+		GOP:           50,
+		Preset:        "veryfast",
+		H264Profile:   "baseline",
+		AudioCodec:    "aac",
+		AudioBitrate:  64,
+		AudioChannels: 2,
+		Builtin:       true,
+	},
+}
+
+var (
+	profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+	presetPattern      = regexp.MustCompile(`^[a-z]+$`)
+
+	allowedVideoCodecs  = map[string]bool{"copy": true, "libx264": true, "libx265": true, "h264_nvenc": true, "h264_qsv": true, "h264_vaapi": true}
+	allowedAudioCodecs  = map[string]bool{"copy": true, "aac": true, "libmp3lame": true, "ac3": true}
+	allowedH264Profiles = map[string]bool{"": true, "baseline": true, "main": true, "high": true}
+)
+
+// Validate checks that the profile only produces safe, known FFmpeg options
+func (p TranscodeProfile) Validate() error {
+	if !profileNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("profile name must be 1-32 lowercase letters, digits, '.', '_' or '-'")
+	}
+	if p.Name == ABRProfile {
+		return fmt.Errorf("profile name %q is reserved", ABRProfile)
+	}
+	if !allowedVideoCodecs[p.VideoCodec] {
+		return fmt.Errorf("unsupported video codec %q", p.VideoCodec)
+	}
+	if p.AudioCodec != "" && !allowedAudioCodecs[p.AudioCodec] {
+		return fmt.Errorf("unsupported audio codec %q", p.AudioCodec)
+	}
+	if p.Preset != "" && !presetPattern.MatchString(p.Preset) {
+		return fmt.Errorf("invalid preset %q", p.Preset)
+	}
+	if !allowedH264Profiles[p.H264Profile] {
+		return fmt.Errorf("invalid h264 profile %q", p.H264Profile)
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > 7680 || p.Height > 4320 {
+		return fmt.Errorf("invalid resolution %dx%d", p.Width, p.Height)
+	}
+	if p.VideoBitrate < 0 || p.MaxRate < 0 || p.AudioBitrate < 0 {
+		return fmt.Errorf("bitrates must not be negative")
+	}
+	if p.GOP < 0 || p.GOP > 600 {
+		return fmt.Errorf("gop must be between 0 and 600 frames")
+	}
+	if p.AudioChannels < 0 || p.AudioChannels > 8 {
+		return fmt.Errorf("audio channels must be between 0 and 8")
+	}
+	return nil
+}
+
+// IsPassthrough reports whether the profile copies both video and audio
+func (p TranscodeProfile) IsPassthrough() bool {
+	return p.VideoCodec == "copy" && (p.AudioCodec == "" || p.AudioCodec == "copy")
+}
+
+// WithBitrateCap returns the profile with its video bitrate limited to maxKbps
+func (p TranscodeProfile) WithBitrateCap(maxKbps int) TranscodeProfile {
+	if maxKbps <= 0 || p.VideoCodec == "copy" {
+		return p
+	}
+	if p.VideoBitrate == 0 || p.VideoBitrate > maxKbps {
+		p.VideoBitrate = maxKbps
+	}
+	if p.MaxRate > maxKbps {
+		p.MaxRate = maxKbps
+	}
+	return p
+}
+
+// CodecArgs returns the FFmpeg codec options for the profile
+func (p TranscodeProfile) CodecArgs() []string {
+	if p.IsPassthrough() {
+		return []string{"-c", "copy"}
+	}
+
+	var args []string
+	if p.VideoCodec == "copy" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", p.VideoCodec)
+		if p.Preset != "" {
+			args = append(args, "-preset", p.Preset)
+		}
+		if p.H264Profile != "" {
+			args = append(args, "-profile:v", p.H264Profile)
+		}
+		if scale := p.scaleFilter(); scale != "" {
+			args = append(args, "-vf", scale)
+		}
+		if p.VideoBitrate > 0 {
+			maxRate := p.MaxRate
+			if maxRate == 0 {
+				maxRate = p.VideoBitrate
+			}
+			args = append(args,
+				"-b:v", fmt.Sprintf("%dk", p.VideoBitrate),
+				"-maxrate", fmt.Sprintf("%dk", maxRate),
+				"-bufsize", fmt.Sprintf("%dk", maxRate*2),
+			)
+		}
+		if p.GOP > 0 {
+			// Fixed GOP so HLS segments and joining clients start on a keyframe
+			args = append(args, "-g", strconv.Itoa(p.GOP), "-keyint_min", strconv.Itoa(p.GOP), "-sc_threshold", "0")
+		}
+		args = append(args, "-pix_fmt", "yuv420p")
+	}
+
+	if p.AudioCodec == "" || p.AudioCodec == "copy" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", p.AudioCodec)
+		if p.AudioBitrate > 0 {
+			args = append(args, "-b:a", fmt.Sprintf("%dk", p.AudioBitrate))
+		}
+		if p.AudioChannels > 0 {
+			args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+		}
+	}
+	return args
+}
+
+// scaleFilter returns the scale filter for the profile resolution, or ""
+// to keep the source size
+func (p TranscodeProfile) scaleFilter() string {
+	if p.Width <= 0 && p.Height <= 0 {
+		return ""
+	}
+	width, height := "-2", "-2" // -2 keeps the aspect ratio with an even size
+	if p.Width > 0 {
+		width = strconv.Itoa(p.Width)
+	}
+	if p.Height > 0 {
+		height = strconv.Itoa(p.Height)
+	}
+	return "scale=" + width + ":" + height
+}
+
+// ProfileSessionID returns the session ID for a stream encoded with a
+// profile. Passthrough keeps the plain ID so existing sessions are shared.
+func ProfileSessionID(baseID, profile string) string {
+	if profile == "" || profile == PassthroughProfile {
+		return baseID
+	}
+	return baseID + "@" + profile
+}
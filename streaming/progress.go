@@ -0,0 +1,178 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProgressErrors is how many FFmpeg error lines a session keeps
+const maxProgressErrors = 20
+
+// progressArgs make FFmpeg write machine readable progress to stderr
+// instead of the interactive stats line
+var progressArgs = []string{"-progress", "pipe:2", "-nostats"}
+
+// streamQualityKey matches the per-stream quantizer keys of -progress output
+var streamQualityKey = regexp.MustCompile(`^stream_[0-9]+_[0-9]+_q$`)
+
+// progressError is an error line printed by FFmpeg
+type progressError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// ffmpegProgress holds the live telemetry of a session's FFmpeg process
+type ffmpegProgress struct {
+	mux         sync.RWMutex
+	inputURL    string
+	started     time.Time
+	updated     time.Time // Last complete -progress block
+	frames      int64
+	fps         float64
+	bitrateKbps float64
+	speed       float64
+	dropFrames  int64
+	dupFrames   int64
+	outTime     string
+	totalSize   int64
+	errors      []progressError // Kept across restarts, oldest first
+}
+
+// start resets the counters for a new FFmpeg process reading inputURL
+func (p *ffmpegProgress) start(inputURL string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.inputURL = inputURL
+	p.started = time.Now()
+	p.updated = time.Time{}
+	p.frames, p.fps, p.bitrateKbps, p.speed = 0, 0, 0, 0
+	p.dropFrames, p.dupFrames = 0, 0
+	p.outTime, p.totalSize = "", 0
+}
+
+// update applies a key=value line of -progress output. Returns false for
+// anything else, such as log lines.
+func (p *ffmpegProgress) update(line string) bool {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok || strings.ContainsAny(key, " \t") {
+		return false
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	switch key {
+	case "frame":
+		p.frames, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		p.fps, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		// "2489.3kbits/s" or "N/A"
+		p.bitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "speed":
+		// "1.01x" or "N/A"
+		p.speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "drop_frames":
+		p.dropFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		p.dupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time":
+		p.outTime = value
+	case "total_size":
+		p.totalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "progress":
+		p.updated = time.Now()
+	case "out_time_us", "out_time_ms":
+	default:
+		return streamQualityKey.MatchString(key)
+	}
+	return true
+}
+
+// addError keeps the last maxProgressErrors error lines
+func (p *ffmpegProgress) addError(message string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.errors = append(p.errors, progressError{Time: time.Now(), Message: message})
+	if len(p.errors) > maxProgressErrors {
+		p.errors = append([]progressError(nil), p.errors[len(p.errors)-maxProgressErrors:]...)
+	}
+}
+
+// snapshot returns the telemetry for GetStats
+func (p *ffmpegProgress) snapshot() map[string]interface{} {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	stats := map[string]interface{}{
+		"input_url":         p.inputURL,
+		"fps":               p.fps,
+		"bitrate_kbps":      p.bitrateKbps,
+		"speed":             p.speed,
+		"frames":            p.frames,
+		"dropped_frames":    p.dropFrames,
+		"duplicated_frames": p.dupFrames,
+		"out_time":          p.outTime,
+		"total_size":        p.totalSize,
+		"errors":            append([]progressError{}, p.errors...),
+	}
+	if !p.started.IsZero() {
+		stats["process_started_at"] = p.started
+	}
+	if !p.updated.IsZero() {
+		stats["updated_at"] = p.updated
+	}
+	return stats
+}
+
+// isFFmpegError reports whether a stderr line reports a problem
+func isFFmpegError(line string) bool {
+	return strings.Contains(line, "error") || strings.Contains(line, "Error") ||
+		strings.Contains(line, "Invalid") || strings.Contains(line, "failed")
+}
+
+// readStderr parses FFmpeg stderr until the process exits: progress blocks
+// update the telemetry, error lines are logged and kept
+func (s *FFmpegSession) readStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 4096), 64*1024)
+	scanner.Split(scanFFmpegLines)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || s.progress.update(line) {
+			continue
+		}
+		if isFFmpegError(line) {
+			log.Printf("🔴 FFmpeg error for %s: %s", s.ID, line)
+			s.progress.addError(line)
+			s.procMux.Lock()
+			s.lastError = line
+			s.procMux.Unlock()
+		}
+	}
+}
+
+// scanFFmpegLines splits stderr on '\n' and on the '\r' FFmpeg uses to
+// redraw status lines
+func scanFFmpegLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,31 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RemuxMP4 copies the streams of an MPEG-TS file into an MP4 file without
+// re-encoding. The index is moved to the front so players can seek before
+// the whole file has been downloaded.
+func RemuxMP4(ctx context.Context, src, dst string) error {
+	var errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), "-hide_banner", "-loglevel", "error", "-y",
+		"-i", src, "-c", "copy", "-movflags", "+faststart", dst)
+	cmd.Stderr = &errOut
+	err := GetSupervisor().Start("remux", cmd)
+	if err == nil {
+		err = GetSupervisor().Wait(cmd)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(errOut.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("remux failed: %s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package streaming
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// processExitTimeout bounds how long Shutdown waits for killed FFmpeg
+// processes to be reaped
+const processExitTimeout = 3 * time.Second
+
+// shuttingDown is set once Shutdown starts, no new streams start afterwards
+var shuttingDown int32
+
+// isShuttingDown reports whether Shutdown has been called
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// ShutdownSummary counts what Shutdown stopped
+type ShutdownSummary struct {
+	FFmpegSessions  int
+	FFmpegProcesses int
+	HLSSessions     int
+	RelaySessions   int
+	Clients         int
+	SlateStreams    int
+	OtherProcesses  int
+}
+
+func (s ShutdownSummary) String() string {
+	summary := fmt.Sprintf("%d FFmpeg sessions (%d processes), %d HLS sessions, %d relay sessions, %d clients",
+		s.FFmpegSessions, s.FFmpegProcesses, s.HLSSessions, s.RelaySessions, s.Clients)
+	if s.SlateStreams > 0 {
+		summary += fmt.Sprintf(", %d notification streams", s.SlateStreams)
+	}
+	if s.OtherProcesses > 0 {
+		summary += fmt.Sprintf(", %d other processes", s.OtherProcesses)
+	}
+	return summary
+}
+
+// Shutdown stops every streaming session, kills the FFmpeg process groups
+// and disconnects all clients. Sessions are not started again afterwards.
+func Shutdown() ShutdownSummary {
+	atomic.StoreInt32(&shuttingDown, 1)
+
+	var summary ShutdownSummary
+	summary.FFmpegSessions, summary.FFmpegProcesses, summary.Clients = GetFFmpegManager().stopAll()
+
+	hlsSessions, hlsClients := GetHLSManager().stopAll()
+	summary.HLSSessions = hlsSessions
+	summary.Clients += hlsClients
+
+	relaySessions, relayClients := GetManager().stopAll()
+	summary.RelaySessions = relaySessions
+	summary.Clients += relayClients
+
+	summary.SlateStreams = stopSlateStreams()
+
+	// Anything still running, such as probes, is killed too
+	summary.OtherProcesses = GetSupervisor().killAll()
+	return summary
+}
+
+// stopAll stops and removes every FFmpeg session, then waits for the
+// killed processes to exit
+func (m *FFmpegManager) stopAll() (sessions, processes, clients int) {
+	m.sessionsMux.Lock()
+	stopping := make([]*FFmpegSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		stopping = append(stopping, session)
+	}
+	m.sessions = make(map[string]*FFmpegSession)
+	m.reservations = make(map[string]time.Time)
+	m.sessionsMux.Unlock()
+
+	for _, session := range stopping {
+		if session.IsActive() {
+			processes++
+		}
+		clients += session.GetClientCount()
+		session.Stop()
+	}
+
+	deadline := time.Now().Add(processExitTimeout)
+	for _, session := range stopping {
+		for session.IsActive() && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if session.IsActive() {
+			log.Printf("⚠️  FFmpeg process for %s did not exit in time", session.ID)
+		}
+	}
+	return len(stopping), processes, clients
+}
+
+// stopAll stops and removes every HLS session
+func (m *HLSManager) stopAll() (sessions, clients int) {
+	m.sessionsMux.Lock()
+	defer m.sessionsMux.Unlock()
+
+	for streamID, session := range m.sessions {
+		session.clientsMux.RLock()
+		clients += len(session.clients)
+		session.clientsMux.RUnlock()
+		session.Stop()
+		delete(m.sessions, streamID)
+		sessions++
+	}
+	return sessions, clients
+}
+
+// stopAll stops and removes every relay session
+func (m *StreamManager) stopAll() (sessions, clients int) {
+	m.sessionsMux.Lock()
+	defer m.sessionsMux.Unlock()
+
+	for streamID, session := range m.sessions {
+		clients += session.GetClientCount()
+		session.Stop()
+		delete(m.sessions, streamID)
+		sessions++
+	}
+	return sessions, clients
+}
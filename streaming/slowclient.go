@@ -0,0 +1,54 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Slow client policies, chosen per channel or from the stream settings
+const (
+	SlowClientSkip       = "skip"       // Resync at the current keyframe, never disconnect
+	SlowClientDisconnect = "disconnect" // Resync, disconnect after MaxDrops resyncs
+	SlowClientBacklog    = "backlog"    // Deliver everything up to MaxLagBytes late, then disconnect
+)
+
+// ErrClientTooSlow is returned to a client disconnected by its slow client policy
+var ErrClientTooSlow = errors.New("client too slow for stream")
+
+// SlowClientPolicy decides what happens to a client that falls behind the
+// live edge. Dropping chunks mid-GOP corrupts the TS stream for that viewer,
+// so clients either resume on a keyframe or are disconnected.
+type SlowClientPolicy struct {
+	Mode        string `json:"mode"`
+	MaxLagBytes uint64 `json:"max_lag_bytes"` // How far behind a client may fall
+	MaxDrops    int    `json:"max_drops"`     // Resyncs before disconnect (disconnect mode)
+}
+
+// DefaultSlowClientPolicy is used when neither the channel nor the settings choose one
+var DefaultSlowClientPolicy = SlowClientPolicy{
+	Mode:        SlowClientSkip,
+	MaxLagBytes: 4 * 1024 * 1024,
+	MaxDrops:    3,
+}
+
+// ValidSlowClientMode reports whether mode names a slow client policy
+func ValidSlowClientMode(mode string) bool {
+	switch mode {
+	case SlowClientSkip, SlowClientDisconnect, SlowClientBacklog:
+		return true
+	}
+	return false
+}
+
+// WithMode returns a copy of the policy using mode, or an error for an unknown mode.
+// An empty mode keeps the current one.
+func (p SlowClientPolicy) WithMode(mode string) (SlowClientPolicy, error) {
+	if mode == "" {
+		return p, nil
+	}
+	if !ValidSlowClientMode(mode) {
+		return p, fmt.Errorf("unknown slow client policy %q", mode)
+	}
+	p.Mode = mode
+	return p, nil
+}
@@ -0,0 +1,173 @@
+package streaming
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxSourceFailures is how many failures in a row mark a source unhealthy
+	maxSourceFailures = 2
+	// stableRunTime is how long a source must run before a failure no longer
+	// counts against its earlier ones
+	stableRunTime = 30 * time.Second
+	// primaryProbeInterval is how often the primary is probed while a backup is in use
+	primaryProbeInterval = 30 * time.Second
+	// sourceProbeTimeout bounds a single source probe
+	sourceProbeTimeout = 10 * time.Second
+)
+
+// sourceState is the health record of one upstream URL
+type sourceState struct {
+	url         string
+	score       int // 0-100, halved on failure, restored by stable runs and probes
+	failures    int // Consecutive failures
+	lastError   string
+	lastFailure time.Time
+	lastOK      time.Time
+}
+
+// SourcePool tracks the health of a session's source URLs and chooses the
+// one to use. Index 0 is the primary source.
+type SourcePool struct {
+	mux     sync.Mutex
+	sources []*sourceState
+	current int
+}
+
+// NewSourcePool creates a pool for urls, starting on the primary
+func NewSourcePool(urls []string) *SourcePool {
+	pool := &SourcePool{}
+	for _, url := range urls {
+		pool.sources = append(pool.sources, &sourceState{url: url, score: 100})
+	}
+	return pool
+}
+
+// Len returns the number of sources
+func (p *SourcePool) Len() int {
+	return len(p.sources)
+}
+
+// URL returns the URL of source idx
+func (p *SourcePool) URL(idx int) string {
+	return p.sources[idx].url
+}
+
+// Current returns the source in use
+func (p *SourcePool) Current() (int, string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if len(p.sources) == 0 {
+		return 0, ""
+	}
+	return p.current, p.sources[p.current].url
+}
+
+// Use switches to source idx
+func (p *SourcePool) Use(idx int) {
+	p.mux.Lock()
+	p.current = idx
+	p.mux.Unlock()
+}
+
+// ReportFailure records that source idx stopped after running for runTime.
+// A source that ran stably starts a new failure count.
+func (p *SourcePool) ReportFailure(idx int, runTime time.Duration, reason string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	src := p.sources[idx]
+	if runTime >= stableRunTime {
+		src.failures = 0
+		src.lastOK = time.Now()
+	}
+	src.failures++
+	src.score /= 2
+	src.lastError = reason
+	src.lastFailure = time.Now()
+}
+
+// ReportProbe records the result of a background probe of source idx
+func (p *SourcePool) ReportProbe(idx int, err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	src := p.sources[idx]
+	if err != nil {
+		src.score /= 2
+		src.lastError = err.Error()
+		return
+	}
+	src.failures = 0
+	src.lastOK = time.Now()
+	if src.score += 25; src.score > 100 {
+		src.score = 100
+	}
+}
+
+// ReportRunning records that source idx is delivering data
+func (p *SourcePool) ReportRunning(idx int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	src := p.sources[idx]
+	src.failures = 0
+	src.score = 100
+	src.lastOK = time.Now()
+}
+
+// Next moves to the next healthy source after the current one, wrapping
+// around to the current source itself. Returns false when no source is healthy.
+func (p *SourcePool) Next() (int, string, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for i := 1; i <= len(p.sources); i++ {
+		idx := (p.current + i) % len(p.sources)
+		if p.sources[idx].failures < maxSourceFailures {
+			p.current = idx
+			return idx, p.sources[idx].url, true
+		}
+	}
+	return p.current, "", false
+}
+
+// Reset clears all failure records and returns to the primary source
+func (p *SourcePool) Reset() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, src := range p.sources {
+		src.failures = 0
+		src.score = 100
+	}
+	p.current = 0
+}
+
+// GetStats returns the health of every source
+func (p *SourcePool) GetStats() []map[string]interface{} {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	stats := make([]map[string]interface{}, 0, len(p.sources))
+	for idx, src := range p.sources {
+		stat := map[string]interface{}{
+			"url":        src.url,
+			"primary":    idx == 0,
+			"active":     idx == p.current,
+			"healthy":    src.failures < maxSourceFailures,
+			"score":      src.score,
+			"failures":   src.failures,
+			"last_error": src.lastError,
+		}
+		if !src.lastFailure.IsZero() {
+			stat["last_failure"] = src.lastFailure
+		}
+		if !src.lastOK.IsZero() {
+			stat["last_ok"] = src.lastOK
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
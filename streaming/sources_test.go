@@ -0,0 +1,154 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sourceStep is one event applied to a SourcePool. current is the source in
+// use afterwards; for a Next step ok is what Next must return.
+type sourceStep struct {
+	name    string
+	do      func(p *SourcePool) bool
+	current int
+	ok      bool
+}
+
+func failed(idx int, runTime time.Duration, current int) sourceStep {
+	return sourceStep{
+		name:    "failure",
+		do:      func(p *SourcePool) bool { p.ReportFailure(idx, runTime, "exited"); return true },
+		current: current,
+		ok:      true,
+	}
+}
+
+func next(current int, ok bool) sourceStep {
+	return sourceStep{
+		name:    "next",
+		do:      func(p *SourcePool) bool { _, _, ok := p.Next(); return ok },
+		current: current,
+		ok:      ok,
+	}
+}
+
+func probed(idx int, err error, current int) sourceStep {
+	return sourceStep{
+		name:    "probe",
+		do:      func(p *SourcePool) bool { p.ReportProbe(idx, err); return true },
+		current: current,
+		ok:      true,
+	}
+}
+
+func used(idx int) sourceStep {
+	return sourceStep{
+		name:    "use",
+		do:      func(p *SourcePool) bool { p.Use(idx); return true },
+		current: idx,
+		ok:      true,
+	}
+}
+
+func TestSourcePool(t *testing.T) {
+	errProbe := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		urls    int
+		steps   []sourceStep
+		healthy []bool
+		scores  []int
+	}{
+		{
+			name: "fails over in order until every source failed twice",
+			urls: 3,
+			steps: []sourceStep{
+				failed(0, time.Second, 0), next(1, true),
+				failed(1, time.Second, 1), next(2, true),
+				failed(2, time.Second, 2), next(0, true),
+				failed(0, time.Second, 0), next(1, true),
+				failed(1, time.Second, 1), next(2, true),
+				failed(2, time.Second, 2), next(2, false),
+			},
+			healthy: []bool{false, false, false},
+			scores:  []int{25, 25, 25},
+		},
+		{
+			name: "single source restarts itself",
+			urls: 1,
+			steps: []sourceStep{
+				failed(0, time.Second, 0), next(0, true),
+				failed(0, time.Second, 0), next(0, false),
+			},
+			healthy: []bool{false},
+			scores:  []int{25},
+		},
+		{
+			name: "skips an unhealthy source",
+			urls: 3,
+			steps: []sourceStep{
+				failed(1, time.Second, 0), failed(1, time.Second, 0),
+				failed(0, time.Second, 0), next(2, true),
+			},
+			healthy: []bool{true, false, true},
+			scores:  []int{50, 25, 100},
+		},
+		{
+			name: "a stable run starts a new failure count",
+			urls: 2,
+			steps: []sourceStep{
+				failed(0, time.Second, 0), next(1, true),
+				failed(1, time.Second, 1), next(0, true),
+				failed(0, time.Minute, 0), next(1, true),
+				failed(1, time.Second, 1), next(0, true),
+			},
+			healthy: []bool{true, false},
+			scores:  []int{25, 25},
+		},
+		{
+			name: "switches back once probes recover the primary",
+			urls: 2,
+			steps: []sourceStep{
+				failed(0, time.Second, 0), failed(0, time.Second, 0), next(1, true),
+				next(1, true),
+				probed(0, errProbe, 1), next(1, true),
+				probed(0, nil, 1), probed(0, nil, 1), used(0),
+			},
+			healthy: []bool{true, true},
+			scores:  []int{62, 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls := make([]string, tt.urls)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("http://source%d", i)
+			}
+			pool := NewSourcePool(urls)
+
+			for i, step := range tt.steps {
+				ok := step.do(pool)
+				if current, url := pool.Current(); ok != step.ok || current != step.current || url != urls[current] {
+					t.Fatalf("step %d (%s): current %d %q, ok %v; want %d, ok %v", i, step.name, current, url, ok, step.current, step.ok)
+				}
+			}
+
+			for i, stat := range pool.GetStats() {
+				if stat["healthy"] != tt.healthy[i] || stat["score"] != tt.scores[i] {
+					t.Errorf("source %d: healthy %v score %v, want %v %d", i, stat["healthy"], stat["score"], tt.healthy[i], tt.scores[i])
+				}
+			}
+
+			pool.Reset()
+			if current, _ := pool.Current(); current != 0 {
+				t.Errorf("current %d after reset, want the primary", current)
+			}
+			if _, _, ok := pool.Next(); !ok {
+				t.Error("no healthy source after reset")
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package streaming
+
+import (
+	"fmt"
+	"time"
+)
+
+// liveDataWindow is how recently a running session must have received data
+// to count as up
+const liveDataWindow = 30 * time.Second
+
+// Live states of a channel's running session
+const (
+	LiveUp       = "up"
+	LiveDown     = "down"
+	LiveStarting = "starting" // Running, but no data yet
+)
+
+// LiveStatus is the state of a running session serving a channel
+type LiveStatus struct {
+	SessionID string
+	Engine    string
+	State     string
+	Format    string // "mpegts" once the output has been parsed
+	Error     string
+}
+
+// ChannelLiveStatus reports the state of the sessions serving a channel,
+// so its source does not have to be probed separately. ok is false when no
+// session of the channel is running.
+func ChannelLiveStatus(channelID int) (status LiveStatus, ok bool) {
+	var statuses []LiveStatus
+	for _, id := range []string{
+		fmt.Sprintf("channel_%d", channelID),
+		fmt.Sprintf("channel_%d_hls", channelID),
+		fmt.Sprintf("channel-%d", channelID),
+		fmt.Sprintf("channel-%d_hls", channelID),
+	} {
+		for _, session := range GetFFmpegManager().GetSessionVariants(id) {
+			if st, ok := session.liveStatus(); ok {
+				statuses = append(statuses, st)
+			}
+		}
+	}
+	for _, id := range []string{fmt.Sprintf("channel_%d", channelID), fmt.Sprintf("channel-%d", channelID)} {
+		if session := GetManager().GetSession(id); session != nil {
+			if st, ok := session.liveStatus(); ok {
+				statuses = append(statuses, st)
+			}
+		}
+	}
+
+	// One working session is enough, a failing one beats one still starting
+	for _, state := range []string{LiveUp, LiveDown, LiveStarting} {
+		for _, st := range statuses {
+			if st.State == state {
+				return st, true
+			}
+		}
+	}
+	return LiveStatus{}, false
+}
+
+// liveState classifies a running session by when it last received data
+func liveState(lastData, started time.Time) string {
+	switch {
+	case time.Since(lastData) < liveDataWindow:
+		return LiveUp
+	case time.Since(started) < liveDataWindow:
+		return LiveStarting
+	}
+	return LiveDown
+}
+
+// inspectedFormat returns "mpegts" once the inspector found a program
+func inspectedFormat(inspector *streamInspector) string {
+	if inspector.Snapshot().Program == 0 {
+		return ""
+	}
+	return "mpegts"
+}
+
+// liveStatus returns the state of a running FFmpeg session. HLS sessions
+// write to files, so -progress updates count as data too.
+func (s *FFmpegSession) liveStatus() (LiveStatus, bool) {
+	st := LiveStatus{SessionID: s.ID, Engine: DeliveryFFmpeg}
+	if s.IsBlacklisted() {
+		s.blacklistMux.RLock()
+		st.State, st.Error = LiveDown, s.blacklistReason
+		if s.blacklistError != "" {
+			st.Error += ": " + s.blacklistError
+		}
+		s.blacklistMux.RUnlock()
+		return st, true
+	}
+	if !s.IsActive() {
+		return st, false
+	}
+
+	s.bytesMux.RLock()
+	lastData := s.lastRead
+	s.bytesMux.RUnlock()
+	s.progress.mux.RLock()
+	started, updated := s.progress.started, s.progress.updated
+	s.progress.mux.RUnlock()
+	if updated.After(lastData) {
+		lastData = updated
+	}
+	if started.IsZero() {
+		// Waiting for its first process
+		started = time.Now()
+	}
+
+	st.State = liveState(lastData, started)
+	if s.OutputFormat != "hls" {
+		st.Format = inspectedFormat(s.inspector)
+	}
+	if st.State == LiveDown {
+		s.procMux.Lock()
+		st.Error = s.lastError
+		s.procMux.Unlock()
+	}
+	return st, true
+}
+
+// liveStatus returns the state of a running passthrough session
+func (s *StreamSession) liveStatus() (LiveStatus, bool) {
+	st := LiveStatus{SessionID: s.ID, Engine: DeliveryPassthrough}
+	if !s.IsActive() {
+		return st, false
+	}
+
+	s.bytesMux.Lock()
+	lastData := s.lastRead
+	s.bytesMux.Unlock()
+	s.activeMux.RLock()
+	started := s.startTime
+	s.activeMux.RUnlock()
+
+	st.State = liveState(lastData, started)
+	st.Format = inspectedFormat(s.inspector)
+	if st.State == LiveDown {
+		s.connMux.Lock()
+		st.Error = s.lastError
+		s.connMux.Unlock()
+	}
+	return st, true
+}
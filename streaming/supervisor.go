@@ -0,0 +1,240 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessInfo describes a child process started by the supervisor
+type ProcessInfo struct {
+	PID           int       `json:"pid"`
+	Owner         string    `json:"owner"` // Session or task that started the process
+	Binary        string    `json:"binary"`
+	Args          []string  `json:"args"`
+	StartedAt     time.Time `json:"started_at"`
+	Nice          int       `json:"nice"`
+	CPUAffinity   []int     `json:"cpu_affinity,omitempty"`
+	MemoryLimitMB int       `json:"memory_limit_mb"`
+	LimitErrors   []string  `json:"limit_errors,omitempty"` // Limits that could not be applied
+}
+
+// ProcessSupervisor owns every child process of the streaming package. It
+// starts them in their own process group, applies the resource limits from
+// Config and records their PIDs in a state file, so that a panel killed
+// with SIGKILL can clean up after itself on the next start.
+type ProcessSupervisor struct {
+	processes map[int]*ProcessInfo
+	mux       sync.Mutex // Guards processes and writes of the state file
+	stateFile string     // "" = do not persist
+}
+
+var (
+	supervisor     *ProcessSupervisor
+	supervisorOnce sync.Once
+)
+
+// GetSupervisor returns the global process supervisor
+func GetSupervisor() *ProcessSupervisor {
+	supervisorOnce.Do(func() {
+		supervisor = &ProcessSupervisor{processes: make(map[int]*ProcessInfo)}
+	})
+	return supervisor
+}
+
+// InitSupervisor sets the state file, kills the processes a previous run
+// left behind in it and returns how many were killed
+func InitSupervisor(stateFile string) (int, error) {
+	p := GetSupervisor()
+
+	p.mux.Lock()
+	p.stateFile = stateFile
+	p.mux.Unlock()
+
+	killed, err := killOrphans(stateFile)
+	p.mux.Lock()
+	p.saveLocked()
+	p.mux.Unlock()
+	return killed, err
+}
+
+// killOrphans kills the process groups recorded in stateFile. A PID that
+// now belongs to a different program is left alone.
+func killOrphans(stateFile string) (int, error) {
+	data, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var orphans []ProcessInfo
+	if err := json.Unmarshal(data, &orphans); err != nil {
+		return 0, fmt.Errorf("invalid state file %s: %v", stateFile, err)
+	}
+
+	killed := 0
+	for _, orphan := range orphans {
+		command := processCommand(orphan.PID)
+		if command != "" && !strings.Contains(command, filepath.Base(orphan.Binary)) {
+			// PID reused by another program
+			continue
+		}
+		// With the leader gone the group may still hold FFmpeg's children
+		if err := killGroup(orphan.PID); err != nil {
+			continue
+		}
+		log.Printf("🧟 Killed leftover process %d (%s) of %s", orphan.PID, filepath.Base(orphan.Binary), orphan.Owner)
+		killed++
+	}
+	return killed, nil
+}
+
+// Start starts cmd in its own process group on behalf of owner, applies the
+// configured limits and records it in the process table
+func (p *ProcessSupervisor) Start(owner string, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	cfg := currentConfig()
+	info := &ProcessInfo{
+		PID:           cmd.Process.Pid,
+		Owner:         owner,
+		Binary:        cmd.Path,
+		Args:          cmd.Args[1:],
+		StartedAt:     time.Now(),
+		Nice:          cfg.Nice,
+		CPUAffinity:   cfg.CPUAffinity,
+		MemoryLimitMB: cfg.MemoryLimitMB,
+	}
+	info.LimitErrors = applyLimits(info.PID, cfg)
+	for _, limitErr := range info.LimitErrors {
+		log.Printf("⚠️  Process %d of %s: %s", info.PID, owner, limitErr)
+	}
+
+	p.mux.Lock()
+	p.processes[info.PID] = info
+	p.saveLocked()
+	p.mux.Unlock()
+	return nil
+}
+
+// Wait waits for a command started with Start and removes it from the
+// process table
+func (p *ProcessSupervisor) Wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	p.mux.Lock()
+	delete(p.processes, cmd.Process.Pid)
+	p.saveLocked()
+	p.mux.Unlock()
+	return err
+}
+
+// Kill kills a command started with Start together with its process group
+func (p *ProcessSupervisor) Kill(cmd *exec.Cmd) {
+	killProcessGroup(cmd)
+}
+
+// killAll kills every process still in the table and returns how many
+func (p *ProcessSupervisor) killAll() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for pid, info := range p.processes {
+		log.Printf("🔪 Killing process %d of %s", pid, info.Owner)
+		killGroup(pid)
+	}
+	return len(p.processes)
+}
+
+// Processes returns the process table, oldest first
+func (p *ProcessSupervisor) Processes() []map[string]interface{} {
+	p.mux.Lock()
+	infos := make([]ProcessInfo, 0, len(p.processes))
+	for _, info := range p.processes {
+		infos = append(infos, *info)
+	}
+	p.mux.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.Before(infos[j].StartedAt) })
+
+	table := make([]map[string]interface{}, 0, len(infos))
+	for _, info := range infos {
+		table = append(table, map[string]interface{}{
+			"pid":             info.PID,
+			"owner":           info.Owner,
+			"binary":          info.Binary,
+			"args":            info.Args,
+			"started_at":      info.StartedAt,
+			"uptime_seconds":  int64(time.Since(info.StartedAt).Seconds()),
+			"rss_kb":          processRSS(info.PID),
+			"nice":            info.Nice,
+			"cpu_affinity":    info.CPUAffinity,
+			"memory_limit_mb": info.MemoryLimitMB,
+			"limit_errors":    info.LimitErrors,
+		})
+	}
+	return table
+}
+
+// StateFile returns the path of the PID state file, "" if not persisted
+func (p *ProcessSupervisor) StateFile() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.stateFile
+}
+
+// saveLocked writes the process table to the state file. Callers hold mux.
+func (p *ProcessSupervisor) saveLocked() {
+	if p.stateFile == "" {
+		return
+	}
+
+	infos := make([]ProcessInfo, 0, len(p.processes))
+	for _, info := range p.processes {
+		infos = append(infos, *info)
+	}
+	data, _ := json.MarshalIndent(infos, "", "  ")
+
+	// Write and rename so a crash never leaves a truncated file behind
+	tmp := p.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("⚠️  Failed to write process state file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, p.stateFile); err != nil {
+		log.Printf("⚠️  Failed to write process state file: %v", err)
+	}
+}
+
+// applyLimits applies the configured nice level, CPU affinity and memory
+// limit to a started process and returns the ones that failed
+func applyLimits(pid int, cfg Config) []string {
+	var failed []string
+	if cfg.Nice != 0 {
+		if err := setNice(pid, cfg.Nice); err != nil {
+			failed = append(failed, fmt.Sprintf("nice %d: %v", cfg.Nice, err))
+		}
+	}
+	if len(cfg.CPUAffinity) > 0 {
+		if err := setAffinity(pid, cfg.CPUAffinity); err != nil {
+			failed = append(failed, fmt.Sprintf("cpu affinity %v: %v", cfg.CPUAffinity, err))
+		}
+	}
+	if cfg.MemoryLimitMB > 0 {
+		if err := setMemoryLimit(pid, uint64(cfg.MemoryLimitMB)*1024*1024); err != nil {
+			failed = append(failed, fmt.Sprintf("memory limit %dMB: %v", cfg.MemoryLimitMB, err))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,461 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iptv-panel/mpegts"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxTimeshiftHours bounds the rewind window of a channel
+	MaxTimeshiftHours = 168
+	// timeshiftSegmentDuration is the target length of archive segments
+	timeshiftSegmentDuration = 10 * time.Second
+	// timeshiftLead is how far playback may run ahead of real time, enough
+	// for players to fill their buffer without downloading the archive
+	timeshiftLead = 30 * time.Second
+	// timeshiftDefaultDir holds the archives unless InitTimeshift is called
+	timeshiftDefaultDir = "./timeshift"
+)
+
+// timeshiftSegmentPattern matches archive files: start and duration in ms
+var timeshiftSegmentPattern = regexp.MustCompile(`^([0-9]+)_([0-9]+)\.ts$`)
+
+// timeshiftSegment is one keyframe-aligned file of an archive
+type timeshiftSegment struct {
+	start    time.Time
+	duration time.Duration
+	size     int64
+	path     string
+}
+
+func (s timeshiftSegment) end() time.Time {
+	return s.start.Add(s.duration)
+}
+
+// TimeshiftStore keeps the rolling archives of the channels with timeshift
+// enabled and evicts the oldest segments beyond each channel's window or
+// the total disk quota
+type TimeshiftStore struct {
+	mux      sync.Mutex
+	dir      string
+	archives map[int]*TimeshiftArchive
+}
+
+// TimeshiftArchive is the rolling on-disk window of one channel, written
+// from the channel's shared session output
+type TimeshiftArchive struct {
+	ChannelID int
+	dir       string
+	store     *TimeshiftStore
+
+	mux      sync.RWMutex // Guards window, segments and added
+	window   time.Duration
+	segments []timeshiftSegment
+	added    chan struct{} // Closed when a segment is added
+
+	writeMux  sync.Mutex // Guards segmenter
+	segmenter *mpegts.Segmenter
+}
+
+var (
+	timeshiftStore     *TimeshiftStore
+	timeshiftStoreOnce sync.Once
+)
+
+// GetTimeshiftStore returns the timeshift store
+func GetTimeshiftStore() *TimeshiftStore {
+	timeshiftStoreOnce.Do(func() {
+		timeshiftStore = &TimeshiftStore{
+			dir:      timeshiftDefaultDir,
+			archives: make(map[int]*TimeshiftArchive),
+		}
+	})
+	return timeshiftStore
+}
+
+// InitTimeshift sets the archive directory and loads the segments recorded
+// before a restart, so rewinding keeps working across restarts. Returns the
+// number of segments found.
+func InitTimeshift(dir string) (int, error) {
+	store := GetTimeshiftStore()
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	store.dir = dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, entry := range entries {
+		channelID, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		archive := store.newArchiveLocked(channelID)
+		archive.load()
+		found += len(archive.segments)
+	}
+	return found, nil
+}
+
+func (t *TimeshiftStore) newArchiveLocked(channelID int) *TimeshiftArchive {
+	archive := &TimeshiftArchive{
+		ChannelID: channelID,
+		dir:       filepath.Join(t.dir, strconv.Itoa(channelID)),
+		store:     t,
+		added:     make(chan struct{}),
+	}
+	archive.segmenter = mpegts.NewSegmenter(timeshiftSegmentDuration, archive.addSegment)
+	t.archives[channelID] = archive
+	return archive
+}
+
+// Archive returns the archive of a channel, created if needed, keeping
+// the last hours of the stream
+func (t *TimeshiftStore) Archive(channelID, hours int) *TimeshiftArchive {
+	t.mux.Lock()
+	archive, exists := t.archives[channelID]
+	if !exists {
+		archive = t.newArchiveLocked(channelID)
+		os.MkdirAll(archive.dir, 0755)
+	}
+	t.mux.Unlock()
+
+	archive.mux.Lock()
+	archive.window = time.Duration(hours) * time.Hour
+	archive.mux.Unlock()
+	return archive
+}
+
+// Get returns the archive of a channel, nil if it has none
+func (t *TimeshiftStore) Get(channelID int) *TimeshiftArchive {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.archives[channelID]
+}
+
+// ChannelIDs returns the channels that have an archive
+func (t *TimeshiftStore) ChannelIDs() []int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	ids := make([]int, 0, len(t.archives))
+	for id := range t.archives {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Remove deletes the archive of a channel and its files
+func (t *TimeshiftStore) Remove(channelID int) {
+	t.mux.Lock()
+	archive := t.archives[channelID]
+	delete(t.archives, channelID)
+	t.mux.Unlock()
+
+	if archive != nil {
+		os.RemoveAll(archive.dir)
+		log.Printf("🗑️  Removed timeshift archive of channel %d", channelID)
+	}
+}
+
+// evict drops segments older than each archive's window, then the oldest
+// segments of any archive while the total size exceeds the quota
+func (t *TimeshiftStore) evict() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	var total int64
+	for _, archive := range t.archives {
+		archive.mux.Lock()
+		if archive.window > 0 {
+			limit := time.Now().Add(-archive.window)
+			for len(archive.segments) > 0 && archive.segments[0].end().Before(limit) {
+				archive.dropOldestLocked()
+			}
+		}
+		for _, segment := range archive.segments {
+			total += segment.size
+		}
+		archive.mux.Unlock()
+	}
+
+	quota := int64(currentConfig().TimeshiftQuotaMB) * 1024 * 1024
+	if quota <= 0 {
+		return
+	}
+	evicted := 0
+	for total > quota {
+		var oldest *TimeshiftArchive
+		var oldestStart time.Time
+		for _, archive := range t.archives {
+			archive.mux.RLock()
+			if len(archive.segments) > 0 && (oldest == nil || archive.segments[0].start.Before(oldestStart)) {
+				oldest, oldestStart = archive, archive.segments[0].start
+			}
+			archive.mux.RUnlock()
+		}
+		if oldest == nil {
+			break
+		}
+		oldest.mux.Lock()
+		total -= oldest.dropOldestLocked()
+		oldest.mux.Unlock()
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("💾 Timeshift quota of %dMB reached, evicted %d oldest segment(s)", quota/1024/1024, evicted)
+	}
+}
+
+// Stats describes the archives for the panel
+func (t *TimeshiftStore) Stats() map[string]interface{} {
+	t.mux.Lock()
+	archives := make([]*TimeshiftArchive, 0, len(t.archives))
+	for _, archive := range t.archives {
+		archives = append(archives, archive)
+	}
+	dir := t.dir
+	t.mux.Unlock()
+
+	var total int64
+	channels := make([]map[string]interface{}, 0, len(archives))
+	for _, archive := range archives {
+		info := archive.Info()
+		total += info["size_bytes"].(int64)
+		channels = append(channels, info)
+	}
+	sort.Slice(channels, func(a, b int) bool { return channels[a]["channel_id"].(int) < channels[b]["channel_id"].(int) })
+	return map[string]interface{}{
+		"dir":        dir,
+		"quota_mb":   currentConfig().TimeshiftQuotaMB,
+		"size_bytes": total,
+		"channels":   channels,
+	}
+}
+
+// load reads the segment files of the archive directory
+func (a *TimeshiftArchive) load() {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		match := timeshiftSegmentPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		startMs, _ := strconv.ParseInt(match[1], 10, 64)
+		durationMs, _ := strconv.ParseInt(match[2], 10, 64)
+		a.segments = append(a.segments, timeshiftSegment{
+			start:    time.Unix(0, startMs*int64(time.Millisecond)),
+			duration: time.Duration(durationMs) * time.Millisecond,
+			size:     info.Size(),
+			path:     filepath.Join(a.dir, entry.Name()),
+		})
+	}
+	sort.Slice(a.segments, func(i, j int) bool { return a.segments[i].start.Before(a.segments[j].start) })
+}
+
+// Write records session output
+func (a *TimeshiftArchive) Write(data []byte) {
+	a.writeMux.Lock()
+	a.segmenter.Write(data)
+	a.writeMux.Unlock()
+}
+
+// Discontinue keeps what was recorded of the current segment and starts
+// over, e.g. when the session switches source
+func (a *TimeshiftArchive) Discontinue() {
+	a.writeMux.Lock()
+	a.segmenter.Flush()
+	a.segmenter.Reset()
+	a.writeMux.Unlock()
+}
+
+// addSegment writes a finished segment to disk. It is emitted when the next
+// keyframe arrives, so it started its duration ago.
+func (a *TimeshiftArchive) addSegment(seg mpegts.Segment) {
+	if len(seg.Data) == 0 {
+		return
+	}
+	duration := seg.Duration
+	if duration <= 0 {
+		duration = timeshiftSegmentDuration
+	}
+	start := time.Now().Add(-duration)
+
+	a.mux.RLock()
+	if n := len(a.segments); n > 0 {
+		// Close small gaps so consecutive segments line up
+		if end := a.segments[n-1].end(); start.Sub(end) < time.Second && start.Sub(end) > -time.Second {
+			start = end
+		}
+	}
+	a.mux.RUnlock()
+
+	name := fmt.Sprintf("%d_%d.ts", start.UnixNano()/int64(time.Millisecond), duration.Milliseconds())
+	path := filepath.Join(a.dir, name)
+	if err := os.WriteFile(path, seg.Data, 0644); err != nil {
+		log.Printf("❌ Failed to write timeshift segment of channel %d: %v", a.ChannelID, err)
+		return
+	}
+
+	a.mux.Lock()
+	a.segments = append(a.segments, timeshiftSegment{start: start, duration: duration, size: int64(len(seg.Data)), path: path})
+	close(a.added)
+	a.added = make(chan struct{})
+	a.mux.Unlock()
+
+	a.store.evict()
+}
+
+// dropOldestLocked deletes the oldest segment and returns its size
+func (a *TimeshiftArchive) dropOldestLocked() int64 {
+	oldest := a.segments[0]
+	a.segments = a.segments[1:]
+	os.Remove(oldest.path)
+	return oldest.size
+}
+
+// Range returns the time span available for playback
+func (a *TimeshiftArchive) Range() (from, to time.Time, ok bool) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	if len(a.segments) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	return a.segments[0].start, a.segments[len(a.segments)-1].end(), true
+}
+
+// Info describes the archive for the panel
+func (a *TimeshiftArchive) Info() map[string]interface{} {
+	a.mux.RLock()
+	var size int64
+	for _, segment := range a.segments {
+		size += segment.size
+	}
+	info := map[string]interface{}{
+		"channel_id":   a.ChannelID,
+		"window_hours": int(a.window.Hours()),
+		"segments":     len(a.segments),
+		"size_bytes":   size,
+	}
+	a.mux.RUnlock()
+
+	if from, to, ok := a.Range(); ok {
+		info["available_from"] = from
+		info["available_to"] = to
+	}
+	return info
+}
+
+// next returns the first segment ending after position, or a channel that
+// is closed when the archive grows
+func (a *TimeshiftArchive) next(position time.Time) (timeshiftSegment, bool, <-chan struct{}) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	for _, segment := range a.segments {
+		if segment.end().After(position) {
+			return segment, true, nil
+		}
+	}
+	return timeshiftSegment{}, false, a.added
+}
+
+// Serve writes the archive to w from position on, following the archive as
+// it grows, until ctx ends or a write fails. Playback starts at the segment
+// containing position and runs at most timeshiftLead ahead of real time.
+func (a *TimeshiftArchive) Serve(ctx context.Context, w io.Writer, position time.Time, flush func()) error {
+	started := time.Now()
+	var sent time.Duration // Media time written so far
+
+	for {
+		segment, ok, added := a.next(position)
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-added:
+				continue
+			}
+		}
+
+		if ahead := sent - time.Since(started); ahead > timeshiftLead {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(ahead - timeshiftLead):
+			}
+		}
+
+		data, err := os.ReadFile(segment.path)
+		if err != nil {
+			// Evicted while playing, continue with the next segment
+			position = segment.end()
+			continue
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+		sent += segment.duration
+		position = segment.end()
+	}
+}
+
+// timeshiftTap feeds a session's output into a timeshift archive
+type timeshiftTap struct {
+	mux     sync.RWMutex
+	archive *TimeshiftArchive
+}
+
+func (t *timeshiftTap) set(archive *TimeshiftArchive) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.archive != nil && t.archive != archive {
+		t.archive.Discontinue()
+	}
+	t.archive = archive
+}
+
+func (t *timeshiftTap) write(data []byte) {
+	t.mux.RLock()
+	archive := t.archive
+	t.mux.RUnlock()
+	if archive != nil {
+		archive.Write(data)
+	}
+}
+
+func (t *timeshiftTap) discontinue() {
+	t.mux.RLock()
+	archive := t.archive
+	t.mux.RUnlock()
+	if archive != nil {
+		archive.Discontinue()
+	}
+}
@@ -0,0 +1,403 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policies applied when a user starts more streams than max_connections
+const (
+	ConnectionLimitReject     = "reject"      // Refuse the new stream
+	ConnectionLimitKickOldest = "kick_oldest" // Disconnect the user's oldest stream
+)
+
+// Why a viewer was kicked, see KickReason
+const (
+	KickConnectionLimit = "connection_limit" // Made room for a newer stream of the user
+	KickAdmin           = "admin"            // Disconnected by an administrator
+)
+
+// kickedViewerTTL is how long the sid of a kicked HLS viewer is refused,
+// so its player cannot come back as a new viewer
+const kickedViewerTTL = 10 * time.Minute
+
+// viewerSampleInterval is how often the throughput of viewers is sampled
+const viewerSampleInterval = 10 * time.Second
+
+// Viewer is one playback counted against its user's max_connections: an
+// MPEG-TS response, or an HLS player identified by its session id. Its ID is
+// also the client id in the sessions it reads.
+type Viewer struct {
+	ID           string
+	UserID       int
+	ChannelID    int // 0 for relays that are not a channel
+	RemoteAddr   string
+	UserAgent    string
+	ConnectionID int64 // Row in user_connections, 0 for slates
+	HLS          bool
+	Slate        bool // Shown a notification video, not counted against max_connections
+	StartedAt    time.Time
+
+	mux        sync.Mutex
+	lastSeen   time.Time
+	bytes      uint64 // Accessed atomically
+	sampled    uint64 // bytes at the last sample
+	throughput float64
+	kickReason string
+	kicked     chan struct{}
+	once       sync.Once
+	onClose    func()
+}
+
+// NewViewer returns a viewer with a new random id
+func NewViewer(userID, channelID int, remoteAddr string, hls bool) *Viewer {
+	return NewViewerWithID(NewViewerID(), userID, channelID, remoteAddr, hls)
+}
+
+// NewViewerWithID returns a viewer for an existing id, such as the session
+// id an HLS player sends back
+func NewViewerWithID(id string, userID, channelID int, remoteAddr string, hls bool) *Viewer {
+	now := time.Now()
+	return &Viewer{
+		ID:         id,
+		UserID:     userID,
+		ChannelID:  channelID,
+		RemoteAddr: remoteAddr,
+		HLS:        hls,
+		StartedAt:  now,
+		lastSeen:   now,
+		kicked:     make(chan struct{}),
+	}
+}
+
+// NewViewerID returns a random viewer id
+func NewViewerID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// OnClose sets fn to run once when the viewer ends or is kicked
+func (v *Viewer) OnClose(fn func()) {
+	v.onClose = fn
+}
+
+// Kicked is closed when the viewer is disconnected to make room for
+// another stream of its user
+func (v *Viewer) Kicked() <-chan struct{} {
+	return v.kicked
+}
+
+// IsKicked reports whether the viewer was disconnected
+func (v *Viewer) IsKicked() bool {
+	select {
+	case <-v.kicked:
+		return true
+	default:
+		return false
+	}
+}
+
+// KickReason returns why the viewer was kicked, "" if it was not
+func (v *Viewer) KickReason() string {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.kickReason
+}
+
+// AddBytes records n bytes sent to the viewer
+func (v *Viewer) AddBytes(n int) {
+	atomic.AddUint64(&v.bytes, uint64(n))
+}
+
+// BytesSent returns the bytes sent to the viewer so far
+func (v *Viewer) BytesSent() uint64 {
+	return atomic.LoadUint64(&v.bytes)
+}
+
+// Throughput returns the rate the viewer was sent data at over the last
+// sample interval, in Mbps (megabits/sec)
+func (v *Viewer) Throughput() float64 {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.throughput
+}
+
+// sample updates the throughput from the bytes sent since the last call
+func (v *Viewer) sample(elapsed time.Duration) {
+	bytes := v.BytesSent()
+	v.mux.Lock()
+	v.throughput = float64(bytes-v.sampled) * 8 / elapsed.Seconds() / 1024 / 1024
+	v.sampled = bytes
+	v.mux.Unlock()
+}
+
+func (v *Viewer) touch() {
+	v.mux.Lock()
+	v.lastSeen = time.Now()
+	v.mux.Unlock()
+}
+
+func (v *Viewer) idleSince() time.Time {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.lastSeen
+}
+
+// close runs the OnClose hook the first time it is called
+func (v *Viewer) close() {
+	v.once.Do(func() {
+		if v.onClose != nil {
+			v.onClose()
+		}
+	})
+}
+
+// ConnectionLimitError is returned when a user already has max_connections
+// streams open and the policy is to refuse new ones
+type ConnectionLimitError struct {
+	Active int
+	Limit  int
+}
+
+func (e *ConnectionLimitError) Error() string {
+	return fmt.Sprintf("too many devices (%d/%d connections in use)", e.Active, e.Limit)
+}
+
+// kickedSession is the sid of a kicked HLS viewer, refused until the time
+type kickedSession struct {
+	until  time.Time
+	reason string
+}
+
+// ViewerRegistry holds the live viewers of all users
+type ViewerRegistry struct {
+	mux     sync.Mutex
+	viewers map[string]*Viewer
+	kicked  map[string]kickedSession
+}
+
+var (
+	viewerRegistry     *ViewerRegistry
+	viewerRegistryOnce sync.Once
+)
+
+// GetViewerRegistry returns the singleton viewer registry. HLS viewers that
+// stop polling are removed after hlsClientTimeout.
+func GetViewerRegistry() *ViewerRegistry {
+	viewerRegistryOnce.Do(func() {
+		viewerRegistry = &ViewerRegistry{
+			viewers: make(map[string]*Viewer),
+			kicked:  make(map[string]kickedSession),
+		}
+		go viewerRegistry.reapLoop()
+	})
+	return viewerRegistry
+}
+
+// Admit registers v for its user. When the user already has limit live
+// viewers the policy decides: kick_oldest disconnects the oldest of them,
+// reject returns a *ConnectionLimitError. A limit of 0 or less is unlimited.
+func (reg *ViewerRegistry) Admit(v *Viewer, limit int, policy string) error {
+	reg.mux.Lock()
+	var active []*Viewer
+	for _, other := range reg.viewers {
+		if other.UserID == v.UserID && !other.Slate {
+			active = append(active, other)
+		}
+	}
+
+	var victims []*Viewer
+	if limit > 0 && len(active) >= limit {
+		if policy != ConnectionLimitKickOldest {
+			reg.mux.Unlock()
+			return &ConnectionLimitError{Active: len(active), Limit: limit}
+		}
+		for len(active) >= limit {
+			oldest := 0
+			for i := range active {
+				if active[i].StartedAt.Before(active[oldest].StartedAt) {
+					oldest = i
+				}
+			}
+			victims = append(victims, active[oldest])
+			reg.removeLocked(active[oldest], KickConnectionLimit)
+			active = append(active[:oldest], active[oldest+1:]...)
+		}
+	}
+	reg.viewers[v.ID] = v
+	reg.mux.Unlock()
+
+	for _, victim := range victims {
+		log.Printf("🚫 Kicked viewer %s of user %d (channel %d, %s) for a new stream from %s",
+			victim.ID, victim.UserID, victim.ChannelID, victim.RemoteAddr, v.RemoteAddr)
+		victim.close()
+	}
+	return nil
+}
+
+// Add registers a slate viewer, which is listed and can be kicked but does
+// not count against max_connections
+func (reg *ViewerRegistry) Add(v *Viewer) {
+	v.Slate = true
+	reg.mux.Lock()
+	reg.viewers[v.ID] = v
+	reg.mux.Unlock()
+}
+
+// removeLocked drops v from the live viewers. A kickReason marks it kicked.
+// Callers hold mux.
+func (reg *ViewerRegistry) removeLocked(v *Viewer, kickReason string) {
+	if reg.viewers[v.ID] == v {
+		delete(reg.viewers, v.ID)
+	}
+	if kickReason != "" && !v.IsKicked() {
+		v.mux.Lock()
+		v.kickReason = kickReason
+		v.mux.Unlock()
+		close(v.kicked)
+		if v.HLS {
+			reg.kicked[v.ID] = kickedSession{until: time.Now().Add(kickedViewerTTL), reason: kickReason}
+		}
+	}
+}
+
+// Remove ends a viewer whose stream is over
+func (reg *ViewerRegistry) Remove(v *Viewer) {
+	reg.mux.Lock()
+	reg.removeLocked(v, "")
+	reg.mux.Unlock()
+	v.close()
+}
+
+// Touch returns the live viewer with id and refreshes it. kickReason is set
+// when the id belongs to a viewer that was disconnected recently.
+func (reg *ViewerRegistry) Touch(id string) (v *Viewer, kickReason string) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	if v, ok := reg.viewers[id]; ok {
+		v.touch()
+		return v, ""
+	}
+	if kicked, ok := reg.kicked[id]; ok && time.Now().Before(kicked.until) {
+		return nil, kicked.reason
+	}
+	return nil, ""
+}
+
+// Get returns the live viewer with id, or nil
+func (reg *ViewerRegistry) Get(id string) *Viewer {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	return reg.viewers[id]
+}
+
+// Count returns the number of live viewers of a user that count against
+// max_connections
+func (reg *ViewerRegistry) Count(userID int) int {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	count := 0
+	for _, v := range reg.viewers {
+		if v.UserID == userID && !v.Slate {
+			count++
+		}
+	}
+	return count
+}
+
+// List returns the live viewers, oldest first
+func (reg *ViewerRegistry) List() []*Viewer {
+	reg.mux.Lock()
+	viewers := make([]*Viewer, 0, len(reg.viewers))
+	for _, v := range reg.viewers {
+		viewers = append(viewers, v)
+	}
+	reg.mux.Unlock()
+
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].StartedAt.Before(viewers[j].StartedAt)
+	})
+	return viewers
+}
+
+// Kick disconnects the viewer with id. It reports whether it was live.
+func (reg *ViewerRegistry) Kick(id, reason string) bool {
+	return reg.kickWhere(func(v *Viewer) bool { return v.ID == id }, reason) > 0
+}
+
+// KickUser disconnects every viewer of a user and returns how many there were
+func (reg *ViewerRegistry) KickUser(userID int, reason string) int {
+	return reg.kickWhere(func(v *Viewer) bool { return v.UserID == userID }, reason)
+}
+
+// KickChannel disconnects every viewer of a channel and returns how many
+// there were
+func (reg *ViewerRegistry) KickChannel(channelID int, reason string) int {
+	return reg.kickWhere(func(v *Viewer) bool { return v.ChannelID == channelID }, reason)
+}
+
+// kickWhere disconnects the viewers matching match and returns how many
+func (reg *ViewerRegistry) kickWhere(match func(v *Viewer) bool, reason string) int {
+	var victims []*Viewer
+	reg.mux.Lock()
+	for _, v := range reg.viewers {
+		if match(v) {
+			victims = append(victims, v)
+			reg.removeLocked(v, reason)
+		}
+	}
+	reg.mux.Unlock()
+
+	for _, v := range victims {
+		log.Printf("🚫 Kicked viewer %s of user %d (channel %d, %s): %s", v.ID, v.UserID, v.ChannelID, v.RemoteAddr, reason)
+		v.close()
+	}
+	return len(victims)
+}
+
+// reapLoop ends HLS viewers whose player stopped polling and samples the
+// throughput of the others
+func (reg *ViewerRegistry) reapLoop() {
+	ticker := time.NewTicker(viewerSampleInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for range ticker.C {
+		now := time.Now()
+		elapsed := now.Sub(last)
+		last = now
+
+		var idle []*Viewer
+		reg.mux.Lock()
+		for _, v := range reg.viewers {
+			if v.HLS && now.Sub(v.idleSince()) > hlsClientTimeout {
+				idle = append(idle, v)
+				reg.removeLocked(v, "")
+				continue
+			}
+			v.sample(elapsed)
+		}
+		for id, kicked := range reg.kicked {
+			if now.After(kicked.until) {
+				delete(reg.kicked, id)
+			}
+		}
+		reg.mux.Unlock()
+
+		for _, v := range idle {
+			log.Printf("👋 HLS viewer %s of user %d stopped polling", v.ID, v.UserID)
+			v.close()
+		}
+	}
+}
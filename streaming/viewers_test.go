@@ -0,0 +1,236 @@
+package streaming
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestRegistry returns a registry without the reap loop
+func newTestRegistry() *ViewerRegistry {
+	return &ViewerRegistry{
+		viewers: make(map[string]*Viewer),
+		kicked:  make(map[string]kickedSession),
+	}
+}
+
+// testViewer is a viewer of user that started age ago
+type testViewer struct {
+	id    string
+	user  int
+	age   time.Duration
+	hls   bool
+	slate bool
+}
+
+// viewer returns the viewer on channel 1
+func (tv testViewer) viewer(now time.Time) *Viewer {
+	v := NewViewerWithID(tv.id, tv.user, 1, "10.0.0.1:5000", tv.hls)
+	v.StartedAt = now.Add(-tv.age)
+	return v
+}
+
+// viewerIDs returns the sorted ids of the live viewers
+func viewerIDs(reg *ViewerRegistry) []string {
+	var ids []string
+	for _, v := range reg.List() {
+		ids = append(ids, v.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestViewerRegistryAdmit(t *testing.T) {
+	existing := []testViewer{
+		{id: "a", user: 1, age: 3 * time.Minute},
+		{id: "b", user: 1, age: time.Minute, hls: true},
+		{id: "c", user: 1, age: 2 * time.Minute},
+		{id: "other", user: 2, age: 5 * time.Minute},
+		{id: "slate", user: 1, age: 10 * time.Minute, slate: true},
+	}
+
+	tests := []struct {
+		name   string
+		limit  int
+		policy string
+		err    *ConnectionLimitError
+		live   []string
+		kicked []string
+	}{
+		{
+			name:   "unlimited",
+			limit:  0,
+			policy: ConnectionLimitReject,
+			live:   []string{"a", "b", "c", "new", "other", "slate"},
+		},
+		{
+			name:   "below the limit",
+			limit:  4,
+			policy: ConnectionLimitReject,
+			live:   []string{"a", "b", "c", "new", "other", "slate"},
+		},
+		{
+			name:   "reject at the limit",
+			limit:  3,
+			policy: ConnectionLimitReject,
+			err:    &ConnectionLimitError{Active: 3, Limit: 3},
+			live:   []string{"a", "b", "c", "other", "slate"},
+		},
+		{
+			name:   "unknown policies reject",
+			limit:  3,
+			policy: "",
+			err:    &ConnectionLimitError{Active: 3, Limit: 3},
+			live:   []string{"a", "b", "c", "other", "slate"},
+		},
+		{
+			name:   "kick the oldest at the limit",
+			limit:  3,
+			policy: ConnectionLimitKickOldest,
+			live:   []string{"b", "c", "new", "other", "slate"},
+			kicked: []string{"a"},
+		},
+		{
+			name:   "kick as many as needed after the limit was lowered",
+			limit:  1,
+			policy: ConnectionLimitKickOldest,
+			live:   []string{"new", "other", "slate"},
+			kicked: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			now := time.Now()
+			viewers := map[string]*Viewer{}
+			closed := map[string]int{}
+			for _, tv := range existing {
+				v := tv.viewer(now)
+				id := tv.id
+				v.OnClose(func() { closed[id]++ })
+				viewers[id] = v
+				if tv.slate {
+					reg.Add(v)
+				} else if err := reg.Admit(v, 0, ConnectionLimitReject); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := reg.Admit(testViewer{id: "new", user: 1}.viewer(now), tt.limit, tt.policy)
+			if tt.err == nil && err != nil || tt.err != nil && !reflect.DeepEqual(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if got := viewerIDs(reg); !reflect.DeepEqual(got, tt.live) {
+				t.Errorf("live viewers %v, want %v", got, tt.live)
+			}
+
+			var kicked []string
+			for id, v := range viewers {
+				if v.IsKicked() {
+					kicked = append(kicked, id)
+					if v.KickReason() != KickConnectionLimit || closed[id] != 1 {
+						t.Errorf("viewer %s kicked for %q, closed %d times", id, v.KickReason(), closed[id])
+					}
+				}
+			}
+			sort.Strings(kicked)
+			if !reflect.DeepEqual(kicked, tt.kicked) {
+				t.Errorf("kicked %v, want %v", kicked, tt.kicked)
+			}
+
+			// A kicked HLS player is told why when it polls again
+			if _, reason := reg.Touch("b"); viewers["b"].IsKicked() != (reason == KickConnectionLimit) {
+				t.Errorf("touching kicked HLS viewer: reason %q", reason)
+			}
+		})
+	}
+}
+
+func TestViewerRegistryKick(t *testing.T) {
+	now := time.Now()
+	kick := func(id string) func(reg *ViewerRegistry) int {
+		return func(reg *ViewerRegistry) int {
+			if reg.Kick(id, KickAdmin) {
+				return 1
+			}
+			return 0
+		}
+	}
+	tests := []struct {
+		name   string
+		kick   func(reg *ViewerRegistry) int
+		count  int
+		live   []string
+		user1  int    // Viewers of user 1 left
+		reason string // Touch result for the HLS viewer "hls"
+	}{
+		{
+			name:   "one viewer",
+			kick:   kick("hls"),
+			count:  1,
+			live:   []string{"other", "ts"},
+			user1:  1,
+			reason: KickAdmin,
+		},
+		{
+			name:  "unknown viewer",
+			kick:  kick("gone"),
+			count: 0,
+			live:  []string{"hls", "other", "ts"},
+			user1: 2,
+		},
+		{
+			name:   "every viewer of a user",
+			kick:   func(reg *ViewerRegistry) int { return reg.KickUser(1, KickAdmin) },
+			count:  2,
+			live:   []string{"other"},
+			reason: KickAdmin,
+		},
+		{
+			name:  "viewers of a channel",
+			kick:  func(reg *ViewerRegistry) int { return reg.KickChannel(2, KickAdmin) },
+			count: 1,
+			live:  []string{"hls", "ts"},
+			user1: 2,
+		},
+		{
+			name: "a stream that ended is not kicked",
+			kick: func(reg *ViewerRegistry) int {
+				reg.Remove(reg.Get("hls"))
+				return 0
+			},
+			count: 0,
+			live:  []string{"other", "ts"},
+			user1: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			for _, tv := range []testViewer{
+				{id: "hls", user: 1, age: time.Minute, hls: true},
+				{id: "ts", user: 1, age: 2 * time.Minute},
+			} {
+				reg.Admit(tv.viewer(now), 0, ConnectionLimitReject)
+			}
+			other := NewViewerWithID("other", 2, 2, "10.0.0.2:5000", false)
+			reg.Admit(other, 0, ConnectionLimitReject)
+
+			if n := tt.kick(reg); n != tt.count {
+				t.Errorf("kicked %d viewers, want %d", n, tt.count)
+			}
+			if got := viewerIDs(reg); !reflect.DeepEqual(got, tt.live) {
+				t.Errorf("live viewers %v, want %v", got, tt.live)
+			}
+			if v, reason := reg.Touch("hls"); reason != tt.reason || (v != nil) != (reg.Get("hls") != nil) {
+				t.Errorf("touch: viewer %v, reason %q; want reason %q", v, reason, tt.reason)
+			}
+			if n := reg.Count(1); n != tt.user1 {
+				t.Errorf("user 1 has %d viewers, want %d", n, tt.user1)
+			}
+		})
+	}
+}